@@ -47,7 +47,7 @@ interface DeviceFormProps {
 }
 
 const DEVICE_STATUSES = [
-    "active", "planned", "staged", "failed", "decommissioning", "decommissioned",
+    "active", "planned", "staged", "failed", "decommissioning", "decommissioned", "offline", "retired",
 ];
 
 export function DeviceForm({ device, defaultManufacturerId }: DeviceFormProps) {
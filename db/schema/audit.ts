@@ -1,4 +1,4 @@
-import { pgTable, text, timestamp, jsonb } from "drizzle-orm/pg-core";
+import { pgTable, text, timestamp, jsonb, bigint } from "drizzle-orm/pg-core";
 import { users } from "./auth";
 import { auditActionTypeEnum } from "./enums";
 
@@ -17,4 +17,8 @@ export const auditLogs = pgTable("audit_logs", {
     ipAddress: text("ip_address"),
     userAgent: text("user_agent"),
     createdAt: timestamp("created_at", { withTimezone: true }).defaultNow().notNull(),
+    // Hash chain, appended by audit.LogEntry in the Go services and lib/audit.ts
+    chainSeq: bigint("chain_seq", { mode: "number" }),
+    prevHash: text("prev_hash"),
+    hash: text("hash"),
 });
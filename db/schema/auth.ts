@@ -4,6 +4,7 @@ import {
     timestamp,
     integer,
     primaryKey,
+    jsonb,
 } from "drizzle-orm/pg-core";
 import type { AdapterAccountType } from "next-auth/adapters";
 import { userRoleEnum } from "./enums";
@@ -67,3 +68,25 @@ export const verificationTokens = pgTable(
         }),
     ],
 );
+
+// Scoped tokens for direct access to the Go services, managed by network-ops
+// /api-tokens. Only the SHA-256 of the token is stored.
+export const apiTokens = pgTable("api_tokens", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    name: text("name").notNull(),
+    ownerId: text("owner_id")
+        .notNull()
+        .references(() => users.id, { onDelete: "cascade" }),
+    tokenHash: text("token_hash").notNull(),
+    prefix: text("prefix").notNull(), // first characters of the token, to recognize it by
+    scopes: jsonb("scopes").$type<string[]>().default([]).notNull(), // e.g. 'devices:write', '*:read'
+    allowedIps: jsonb("allowed_ips").$type<string[]>().default([]).notNull(), // addresses or CIDRs; empty: any
+    expiresAt: timestamp("expires_at", { withTimezone: true }),
+    lastUsedAt: timestamp("last_used_at", { withTimezone: true }),
+    lastUsedIp: text("last_used_ip"),
+    revokedAt: timestamp("revoked_at", { withTimezone: true }),
+    createdAt: timestamp("created_at", { withTimezone: true }).defaultNow().notNull(),
+    updatedAt: timestamp("updated_at", { withTimezone: true }).defaultNow().notNull(),
+});
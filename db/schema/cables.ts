@@ -78,6 +78,7 @@ export const frontPorts = pgTable("front_ports", {
     rearPortId: text("rear_port_id")
         .notNull()
         .references(() => rearPorts.id),
+    rearPortPosition: integer("rear_port_position").default(1).notNull(),
     description: text("description"),
     ...timestamps,
 });
@@ -6,8 +6,9 @@ import {
     jsonb,
     real,
     boolean,
+    type AnyPgColumn,
 } from "drizzle-orm/pg-core";
-import { siteStatusEnum, rackTypeEnum } from "./enums";
+import { siteStatusEnum, rackTypeEnum, customFieldTypeEnum } from "./enums";
 
 // Shared timestamp columns
 const timestamps = {
@@ -42,6 +43,7 @@ export const regions = pgTable("regions", {
         .$defaultFn(() => crypto.randomUUID()),
     name: text("name").notNull().unique(),
     slug: text("slug").notNull().unique(),
+    parentId: text("parent_id").references((): AnyPgColumn => regions.id),
     description: text("description"),
     ...timestamps,
 });
@@ -113,3 +115,23 @@ export const racks = pgTable("racks", {
     customFields: jsonb("custom_fields").$type<Record<string, unknown>>(),
     ...timestamps,
 });
+
+// Per-object-type definitions validating the custom_fields JSONB of sites, racks and devices.
+// objectType is one of "site" | "rack" | "device"; refType names the target of object-ref fields.
+export const customFieldDefinitions = pgTable("custom_field_definitions", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    objectType: text("object_type").notNull(),
+    name: text("name").notNull(),
+    label: text("label"),
+    type: customFieldTypeEnum("type").notNull(),
+    required: boolean("required").default(false).notNull(),
+    defaultValue: jsonb("default_value"),
+    regex: text("regex"),
+    choices: jsonb("choices").$type<string[]>().default([]).notNull(),
+    refType: text("ref_type"),
+    description: text("description"),
+    weight: integer("weight").default(100).notNull(),
+    ...timestamps,
+});
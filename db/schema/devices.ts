@@ -6,7 +6,13 @@ import {
     jsonb,
     real,
 } from "drizzle-orm/pg-core";
-import { deviceStatusEnum, deviceFaceEnum } from "./enums";
+import {
+    deviceStatusEnum,
+    deviceFaceEnum,
+    interfaceTypeEnum,
+    portSideEnum,
+    powerOutletTypeEnum,
+} from "./enums";
 import { manufacturers, tenants, racks } from "./core";
 
 const timestamps = {
@@ -54,3 +60,90 @@ export const devices = pgTable("devices", {
     customFields: jsonb("custom_fields").$type<Record<string, unknown>>(),
     ...timestamps,
 });
+
+// Component templates — instantiated as interfaces/ports when a device of the type is created
+export const interfaceTemplates = pgTable("interface_templates", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    deviceTypeId: text("device_type_id")
+        .notNull()
+        .references(() => deviceTypes.id),
+    name: text("name").notNull(),
+    interfaceType: interfaceTypeEnum("interface_type").notNull(),
+    speed: integer("speed"), // Mbps
+    description: text("description"),
+    ...timestamps,
+});
+
+export const consolePortTemplates = pgTable("console_port_templates", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    deviceTypeId: text("device_type_id")
+        .notNull()
+        .references(() => deviceTypes.id),
+    name: text("name").notNull(),
+    portType: text("port_type").notNull(), // 'rj45' | 'usb' | 'serial'
+    speed: integer("speed"), // bps
+    description: text("description"),
+    ...timestamps,
+});
+
+export const rearPortTemplates = pgTable("rear_port_templates", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    deviceTypeId: text("device_type_id")
+        .notNull()
+        .references(() => deviceTypes.id),
+    name: text("name").notNull(),
+    portType: portSideEnum("port_type").notNull(),
+    positions: integer("positions").default(1).notNull(),
+    description: text("description"),
+    ...timestamps,
+});
+
+export const frontPortTemplates = pgTable("front_port_templates", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    deviceTypeId: text("device_type_id")
+        .notNull()
+        .references(() => deviceTypes.id),
+    name: text("name").notNull(),
+    portType: portSideEnum("port_type").notNull(),
+    rearPortTemplateId: text("rear_port_template_id")
+        .notNull()
+        .references(() => rearPortTemplates.id),
+    rearPortPosition: integer("rear_port_position").default(1).notNull(),
+    description: text("description"),
+    ...timestamps,
+});
+
+export const powerPortTemplates = pgTable("power_port_templates", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    deviceTypeId: text("device_type_id")
+        .notNull()
+        .references(() => deviceTypes.id),
+    portNumber: integer("port_number").notNull(),
+    outletType: powerOutletTypeEnum("outlet_type").notNull(),
+    ...timestamps,
+});
+
+// Device lifecycle — one row per status change
+export const deviceStatusHistory = pgTable("device_status_history", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    deviceId: text("device_id")
+        .notNull()
+        .references(() => devices.id),
+    fromStatus: deviceStatusEnum("from_status"),
+    toStatus: deviceStatusEnum("to_status").notNull(),
+    changedBy: text("changed_by"),
+    reason: text("reason"),
+    changedAt: timestamp("changed_at", { withTimezone: true }).defaultNow().notNull(),
+});
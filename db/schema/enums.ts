@@ -7,6 +7,8 @@ export const deviceStatusEnum = pgEnum("device_status", [
     "failed",
     "decommissioning",
     "decommissioned",
+    "offline",
+    "retired",
 ]);
 
 export const siteStatusEnum = pgEnum("site_status", [
@@ -124,3 +126,7 @@ export const reportTypeEnum = pgEnum("report_type", [
 export const reportFrequencyEnum = pgEnum("report_frequency", [
     "daily", "weekly", "monthly",
 ]);
+
+export const customFieldTypeEnum = pgEnum("custom_field_type", [
+    "text", "int", "bool", "date", "select", "url", "object-ref",
+]);
@@ -9,3 +9,5 @@ export * from "./cables";
 export * from "./alerts";
 export * from "./relations";
 export * from "./reports";
+export * from "./webhooks";
+export * from "./rbac";
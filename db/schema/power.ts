@@ -57,9 +57,7 @@ export const powerPorts = pgTable("power_ports", {
     id: text("id")
         .primaryKey()
         .$defaultFn(() => crypto.randomUUID()),
-    feedId: text("feed_id")
-        .notNull()
-        .references(() => powerFeeds.id),
+    feedId: text("feed_id").references(() => powerFeeds.id), // null until the device inlet is cabled to a feed
     portNumber: integer("port_number").notNull(),
     portType: powerPortTypeEnum("port_type").notNull(),
     outletType: powerOutletTypeEnum("outlet_type").notNull(),
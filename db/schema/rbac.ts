@@ -0,0 +1,37 @@
+import { pgTable, text, timestamp, jsonb } from "drizzle-orm/pg-core";
+import { users } from "./auth";
+import { sites, tenants } from "./core";
+
+// Shared timestamp columns
+const timestamps = {
+    createdAt: timestamp("created_at", { withTimezone: true }).defaultNow().notNull(),
+    updatedAt: timestamp("updated_at", { withTimezone: true }).defaultNow().notNull(),
+};
+
+// Custom roles enforced by the Go services, on top of the built-in role that
+// users.role names
+export const roles = pgTable("roles", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    name: text("name").notNull().unique(),
+    description: text("description"),
+    permissions: jsonb("permissions").$type<string[]>().default([]).notNull(), // e.g. 'devices:*', '*:read'
+    ...timestamps,
+});
+
+// A role given to a user everywhere, at one site or for one tenant's rows
+export const roleAssignments = pgTable("role_assignments", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    userId: text("user_id")
+        .notNull()
+        .references(() => users.id, { onDelete: "cascade" }),
+    roleId: text("role_id")
+        .notNull()
+        .references(() => roles.id, { onDelete: "cascade" }),
+    siteId: text("site_id").references(() => sites.id, { onDelete: "cascade" }), // null: every site
+    tenantId: text("tenant_id").references(() => tenants.id, { onDelete: "cascade" }),
+    ...timestamps,
+});
@@ -9,7 +9,15 @@ import {
     racks,
     locationFloorCells,
 } from "./core";
-import { deviceTypes, devices } from "./devices";
+import {
+    deviceTypes,
+    devices,
+    interfaceTemplates,
+    consolePortTemplates,
+    rearPortTemplates,
+    frontPortTemplates,
+    powerPortTemplates,
+} from "./devices";
 import { auditLogs } from "./audit";
 import { accessLogs, equipmentMovements } from "./access";
 import { powerPanels, powerFeeds, powerPorts, powerOutlets, powerReadings } from "./power";
@@ -38,7 +46,13 @@ export const sessionsRelations = relations(sessions, ({ one }) => ({
 }));
 
 // Core relations
-export const regionsRelations = relations(regions, ({ many }) => ({
+export const regionsRelations = relations(regions, ({ one, many }) => ({
+    parent: one(regions, {
+        fields: [regions.parentId],
+        references: [regions.id],
+        relationName: "regionHierarchy",
+    }),
+    children: many(regions, { relationName: "regionHierarchy" }),
     sites: many(sites),
 }));
 
@@ -110,6 +124,51 @@ export const deviceTypesRelations = relations(deviceTypes, ({ one, many }) => ({
         references: [manufacturers.id],
     }),
     devices: many(devices),
+    interfaceTemplates: many(interfaceTemplates),
+    consolePortTemplates: many(consolePortTemplates),
+    rearPortTemplates: many(rearPortTemplates),
+    frontPortTemplates: many(frontPortTemplates),
+    powerPortTemplates: many(powerPortTemplates),
+}));
+
+export const interfaceTemplatesRelations = relations(interfaceTemplates, ({ one }) => ({
+    deviceType: one(deviceTypes, {
+        fields: [interfaceTemplates.deviceTypeId],
+        references: [deviceTypes.id],
+    }),
+}));
+
+export const consolePortTemplatesRelations = relations(consolePortTemplates, ({ one }) => ({
+    deviceType: one(deviceTypes, {
+        fields: [consolePortTemplates.deviceTypeId],
+        references: [deviceTypes.id],
+    }),
+}));
+
+export const rearPortTemplatesRelations = relations(rearPortTemplates, ({ one, many }) => ({
+    deviceType: one(deviceTypes, {
+        fields: [rearPortTemplates.deviceTypeId],
+        references: [deviceTypes.id],
+    }),
+    frontPortTemplates: many(frontPortTemplates),
+}));
+
+export const frontPortTemplatesRelations = relations(frontPortTemplates, ({ one }) => ({
+    deviceType: one(deviceTypes, {
+        fields: [frontPortTemplates.deviceTypeId],
+        references: [deviceTypes.id],
+    }),
+    rearPortTemplate: one(rearPortTemplates, {
+        fields: [frontPortTemplates.rearPortTemplateId],
+        references: [rearPortTemplates.id],
+    }),
+}));
+
+export const powerPortTemplatesRelations = relations(powerPortTemplates, ({ one }) => ({
+    deviceType: one(deviceTypes, {
+        fields: [powerPortTemplates.deviceTypeId],
+        references: [deviceTypes.id],
+    }),
 }));
 
 export const devicesRelations = relations(devices, ({ one, many }) => ({
@@ -0,0 +1,57 @@
+import { pgTable, text, timestamp, integer, bigint, boolean, jsonb } from "drizzle-orm/pg-core";
+
+// Shared timestamp columns
+const timestamps = {
+    createdAt: timestamp("created_at", { withTimezone: true }).defaultNow().notNull(),
+    updatedAt: timestamp("updated_at", { withTimezone: true }).defaultNow().notNull(),
+};
+
+// Written by the Go services' audit.LogEntry, one per audited change; also the
+// bounded event log of the /events change feed
+export const outboxEvents = pgTable("outbox_events", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    seq: bigint("seq", { mode: "number" }).generatedAlwaysAsIdentity(),
+    resource: text("resource").notNull(), // table name, e.g. 'devices'
+    action: text("action").notNull(), // 'create' | 'update' | 'delete' | ...
+    recordId: text("record_id").notNull(),
+    auditLogId: text("audit_log_id"),
+    actorId: text("actor_id"),
+    payload: jsonb("payload").$type<Record<string, unknown>>().notNull(),
+    siteIds: text("site_ids").array().default([]).notNull(),
+    rackIds: text("rack_ids").array().default([]).notNull(),
+    createdAt: timestamp("created_at", { withTimezone: true }).defaultNow().notNull(),
+});
+
+export const webhooks = pgTable("webhooks", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    name: text("name").notNull(),
+    url: text("url").notNull(),
+    secret: text("secret").notNull(),
+    resources: jsonb("resources").$type<string[]>().default([]).notNull(), // empty: all
+    actions: jsonb("actions").$type<string[]>().default([]).notNull(), // empty: all
+    active: boolean("active").default(true).notNull(),
+    ...timestamps,
+});
+
+export const webhookDeliveries = pgTable("webhook_deliveries", {
+    id: text("id")
+        .primaryKey()
+        .$defaultFn(() => crypto.randomUUID()),
+    webhookId: text("webhook_id")
+        .notNull()
+        .references(() => webhooks.id, { onDelete: "cascade" }),
+    eventId: text("event_id")
+        .notNull()
+        .references(() => outboxEvents.id, { onDelete: "cascade" }),
+    status: text("status").default("pending").notNull(), // 'pending' | 'delivered' | 'dead'
+    attempts: integer("attempts").default(0).notNull(),
+    nextAttemptAt: timestamp("next_attempt_at", { withTimezone: true }).defaultNow().notNull(),
+    lastStatusCode: integer("last_status_code"),
+    lastError: text("last_error"),
+    deliveredAt: timestamp("delivered_at", { withTimezone: true }),
+    ...timestamps,
+});
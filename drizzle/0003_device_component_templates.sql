@@ -0,0 +1,67 @@
+CREATE TABLE IF NOT EXISTS "interface_templates" (
+	"id" text PRIMARY KEY NOT NULL,
+	"device_type_id" text NOT NULL,
+	"name" text NOT NULL,
+	"interface_type" "interface_type" NOT NULL,
+	"speed" integer,
+	"description" text,
+	"created_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"updated_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"deleted_at" timestamp with time zone
+);
+--> statement-breakpoint
+CREATE TABLE IF NOT EXISTS "console_port_templates" (
+	"id" text PRIMARY KEY NOT NULL,
+	"device_type_id" text NOT NULL,
+	"name" text NOT NULL,
+	"port_type" text NOT NULL,
+	"speed" integer,
+	"description" text,
+	"created_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"updated_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"deleted_at" timestamp with time zone
+);
+--> statement-breakpoint
+CREATE TABLE IF NOT EXISTS "rear_port_templates" (
+	"id" text PRIMARY KEY NOT NULL,
+	"device_type_id" text NOT NULL,
+	"name" text NOT NULL,
+	"port_type" "port_side" NOT NULL,
+	"positions" integer DEFAULT 1 NOT NULL,
+	"description" text,
+	"created_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"updated_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"deleted_at" timestamp with time zone
+);
+--> statement-breakpoint
+CREATE TABLE IF NOT EXISTS "front_port_templates" (
+	"id" text PRIMARY KEY NOT NULL,
+	"device_type_id" text NOT NULL,
+	"name" text NOT NULL,
+	"port_type" "port_side" NOT NULL,
+	"rear_port_template_id" text NOT NULL,
+	"rear_port_position" integer DEFAULT 1 NOT NULL,
+	"description" text,
+	"created_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"updated_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"deleted_at" timestamp with time zone
+);
+--> statement-breakpoint
+CREATE TABLE IF NOT EXISTS "power_port_templates" (
+	"id" text PRIMARY KEY NOT NULL,
+	"device_type_id" text NOT NULL,
+	"port_number" integer NOT NULL,
+	"outlet_type" "power_outlet_type" NOT NULL,
+	"created_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"updated_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"deleted_at" timestamp with time zone
+);
+--> statement-breakpoint
+ALTER TABLE "interface_templates" ADD CONSTRAINT "interface_templates_device_type_id_device_types_id_fk" FOREIGN KEY ("device_type_id") REFERENCES "public"."device_types"("id") ON DELETE no action ON UPDATE no action;--> statement-breakpoint
+ALTER TABLE "console_port_templates" ADD CONSTRAINT "console_port_templates_device_type_id_device_types_id_fk" FOREIGN KEY ("device_type_id") REFERENCES "public"."device_types"("id") ON DELETE no action ON UPDATE no action;--> statement-breakpoint
+ALTER TABLE "rear_port_templates" ADD CONSTRAINT "rear_port_templates_device_type_id_device_types_id_fk" FOREIGN KEY ("device_type_id") REFERENCES "public"."device_types"("id") ON DELETE no action ON UPDATE no action;--> statement-breakpoint
+ALTER TABLE "front_port_templates" ADD CONSTRAINT "front_port_templates_device_type_id_device_types_id_fk" FOREIGN KEY ("device_type_id") REFERENCES "public"."device_types"("id") ON DELETE no action ON UPDATE no action;--> statement-breakpoint
+ALTER TABLE "front_port_templates" ADD CONSTRAINT "front_port_templates_rear_port_template_id_rear_port_templates_id_fk" FOREIGN KEY ("rear_port_template_id") REFERENCES "public"."rear_port_templates"("id") ON DELETE no action ON UPDATE no action;--> statement-breakpoint
+ALTER TABLE "power_port_templates" ADD CONSTRAINT "power_port_templates_device_type_id_device_types_id_fk" FOREIGN KEY ("device_type_id") REFERENCES "public"."device_types"("id") ON DELETE no action ON UPDATE no action;--> statement-breakpoint
+ALTER TABLE "front_ports" ADD COLUMN IF NOT EXISTS "rear_port_position" integer DEFAULT 1 NOT NULL;--> statement-breakpoint
+ALTER TABLE "power_ports" ALTER COLUMN "feed_id" DROP NOT NULL;
@@ -0,0 +1,17 @@
+ALTER TYPE "device_status" ADD VALUE IF NOT EXISTS 'offline';--> statement-breakpoint
+ALTER TYPE "device_status" ADD VALUE IF NOT EXISTS 'retired';--> statement-breakpoint
+CREATE TABLE IF NOT EXISTS "device_status_history" (
+	"id" text PRIMARY KEY NOT NULL,
+	"device_id" text NOT NULL,
+	"from_status" "device_status",
+	"to_status" "device_status" NOT NULL,
+	"changed_by" text,
+	"reason" text,
+	"changed_at" timestamp with time zone DEFAULT now() NOT NULL
+);
+--> statement-breakpoint
+ALTER TABLE "device_status_history" ADD CONSTRAINT "device_status_history_device_id_devices_id_fk" FOREIGN KEY ("device_id") REFERENCES "public"."devices"("id") ON DELETE no action ON UPDATE no action;--> statement-breakpoint
+CREATE INDEX IF NOT EXISTS "device_status_history_device_id_changed_at_idx" ON "device_status_history" ("device_id", "changed_at");--> statement-breakpoint
+-- Seed the history with each existing device's current status so durations have a starting point
+INSERT INTO "device_status_history" ("id", "device_id", "from_status", "to_status", "changed_at")
+SELECT gen_random_uuid(), "id", NULL, "status", "created_at" FROM "devices" WHERE "deleted_at" IS NULL;
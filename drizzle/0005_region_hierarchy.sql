@@ -0,0 +1,3 @@
+ALTER TABLE "regions" ADD COLUMN IF NOT EXISTS "parent_id" text;--> statement-breakpoint
+ALTER TABLE "regions" ADD CONSTRAINT "regions_parent_id_regions_id_fk" FOREIGN KEY ("parent_id") REFERENCES "public"."regions"("id") ON DELETE no action ON UPDATE no action;--> statement-breakpoint
+CREATE INDEX IF NOT EXISTS "regions_parent_id_idx" ON "regions" ("parent_id");
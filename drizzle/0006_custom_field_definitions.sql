@@ -0,0 +1,20 @@
+CREATE TYPE "public"."custom_field_type" AS ENUM('text', 'int', 'bool', 'date', 'select', 'url', 'object-ref');--> statement-breakpoint
+CREATE TABLE IF NOT EXISTS "custom_field_definitions" (
+	"id" text PRIMARY KEY NOT NULL,
+	"object_type" text NOT NULL,
+	"name" text NOT NULL,
+	"label" text,
+	"type" "custom_field_type" NOT NULL,
+	"required" boolean DEFAULT false NOT NULL,
+	"default_value" jsonb,
+	"regex" text,
+	"choices" jsonb DEFAULT '[]'::jsonb NOT NULL,
+	"ref_type" text,
+	"description" text,
+	"weight" integer DEFAULT 100 NOT NULL,
+	"created_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"updated_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"deleted_at" timestamp with time zone
+);
+--> statement-breakpoint
+CREATE UNIQUE INDEX IF NOT EXISTS "custom_field_definitions_object_type_name_idx" ON "custom_field_definitions" ("object_type", "name") WHERE "deleted_at" IS NULL;
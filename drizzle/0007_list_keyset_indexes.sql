@@ -0,0 +1,7 @@
+-- Indexes matching the default list order plus the id tiebreak, so cursor pages on
+-- the largest tables are index range scans instead of full sorts.
+CREATE INDEX IF NOT EXISTS "interfaces_name_id_idx" ON "interfaces" ("name", "id") WHERE "deleted_at" IS NULL;--> statement-breakpoint
+CREATE INDEX IF NOT EXISTS "devices_name_id_idx" ON "devices" ("name", "id") WHERE "deleted_at" IS NULL;--> statement-breakpoint
+CREATE INDEX IF NOT EXISTS "cables_label_id_idx" ON "cables" ("label", "id") WHERE "deleted_at" IS NULL;--> statement-breakpoint
+CREATE INDEX IF NOT EXISTS "access_logs_check_in_at_id_idx" ON "access_logs" ("check_in_at" DESC, "id") WHERE "deleted_at" IS NULL;--> statement-breakpoint
+CREATE INDEX IF NOT EXISTS "audit_logs_created_at_id_idx" ON "audit_logs" ("created_at" DESC, "id");
@@ -0,0 +1,3 @@
+-- Per-record audit history in replay order, for /audit-logs/{table}/{recordId}/history
+-- and ?asOf= reconstruction.
+CREATE INDEX IF NOT EXISTS "audit_logs_table_record_created_at_idx" ON "audit_logs" ("table_name", "record_id", "created_at", "id");
@@ -0,0 +1,7 @@
+-- Tamper-evident hash chain: each entry written by the Go services' audit.LogEntry
+-- takes the next chain_seq and stores the SHA-256 of its contents and prev_hash,
+-- the hash of the entry before it. Rows written before this migration stay unchained.
+ALTER TABLE "audit_logs" ADD COLUMN IF NOT EXISTS "chain_seq" bigint;--> statement-breakpoint
+ALTER TABLE "audit_logs" ADD COLUMN IF NOT EXISTS "prev_hash" text;--> statement-breakpoint
+ALTER TABLE "audit_logs" ADD COLUMN IF NOT EXISTS "hash" text;--> statement-breakpoint
+CREATE UNIQUE INDEX IF NOT EXISTS "audit_logs_chain_seq_idx" ON "audit_logs" ("chain_seq");
@@ -0,0 +1,7 @@
+-- Change feed: outbox events double as the bounded event log streamed by GET /events.
+-- seq orders them as committed (they are written under the audit chain lock); site_ids
+-- and rack_ids hold the sites and racks a change touched, before and after it.
+ALTER TABLE "outbox_events" ADD COLUMN IF NOT EXISTS "seq" bigint GENERATED ALWAYS AS IDENTITY;--> statement-breakpoint
+ALTER TABLE "outbox_events" ADD COLUMN IF NOT EXISTS "site_ids" text[] DEFAULT '{}' NOT NULL;--> statement-breakpoint
+ALTER TABLE "outbox_events" ADD COLUMN IF NOT EXISTS "rack_ids" text[] DEFAULT '{}' NOT NULL;--> statement-breakpoint
+CREATE UNIQUE INDEX IF NOT EXISTS "outbox_events_seq_idx" ON "outbox_events" ("seq");
@@ -0,0 +1,23 @@
+-- API tokens for direct access to the Go services. Only the SHA-256 of a token
+-- is stored; scopes are "<resource>:read" or "<resource>:write" strings, where
+-- the resource is the first path segment of a route ("devices", "audit-logs")
+-- or "*". An empty allowed_ips list admits any address.
+CREATE TABLE IF NOT EXISTS "api_tokens" (
+	"id" text PRIMARY KEY NOT NULL,
+	"name" text NOT NULL,
+	"owner_id" text NOT NULL,
+	"token_hash" text NOT NULL,
+	"prefix" text NOT NULL,
+	"scopes" jsonb DEFAULT '[]'::jsonb NOT NULL,
+	"allowed_ips" jsonb DEFAULT '[]'::jsonb NOT NULL,
+	"expires_at" timestamp with time zone,
+	"last_used_at" timestamp with time zone,
+	"last_used_ip" text,
+	"revoked_at" timestamp with time zone,
+	"created_at" timestamp with time zone DEFAULT now() NOT NULL,
+	"updated_at" timestamp with time zone DEFAULT now() NOT NULL
+);
+--> statement-breakpoint
+ALTER TABLE "api_tokens" ADD CONSTRAINT "api_tokens_owner_id_users_id_fk" FOREIGN KEY ("owner_id") REFERENCES "public"."users"("id") ON DELETE cascade ON UPDATE no action;--> statement-breakpoint
+CREATE UNIQUE INDEX IF NOT EXISTS "api_tokens_token_hash_idx" ON "api_tokens" ("token_hash");--> statement-breakpoint
+CREATE INDEX IF NOT EXISTS "api_tokens_owner_id_idx" ON "api_tokens" ("owner_id");
@@ -5,10 +5,19 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/dcim/go-services/internal/core/handler"
+	"github.com/dcim/go-services/internal/shared/apitoken"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
+	"github.com/dcim/go-services/internal/shared/expand"
+	"github.com/dcim/go-services/internal/shared/feed"
+	"github.com/dcim/go-services/internal/shared/history"
 	"github.com/dcim/go-services/internal/shared/middleware"
+	"github.com/dcim/go-services/internal/shared/rbac"
+	"github.com/dcim/go-services/internal/shared/trash"
 )
 
 func main() {
@@ -27,6 +36,12 @@ func main() {
 		log.Fatal("X_INTERNAL_SECRET environment variable is required")
 	}
 
+	// Reverse proxies whose X-Forwarded-For is believed for API token IP checks
+	proxies, err := middleware.ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+
 	ctx := context.Background()
 	database, err := db.New(ctx, databaseURL)
 	if err != nil {
@@ -36,79 +51,157 @@ func main() {
 
 	siteH := &handler.SiteHandler{DB: database}
 	regionH := &handler.RegionHandler{DB: database}
-	locationH := &handler.LocationHandler{DB: database}
 	rackH := &handler.RackHandler{DB: database}
 	deviceH := &handler.DeviceHandler{DB: database}
 	dtH := &handler.DeviceTypeHandler{DB: database}
-	mfH := &handler.ManufacturerHandler{DB: database}
+	tplH := &handler.ComponentTemplateHandler{DB: database}
 	tenantH := &handler.TenantHandler{DB: database}
 	dashH := &handler.DashboardHandler{DB: database}
-
-	auth := middleware.InternalSecret(internalSecret)
+	cfH := &handler.CustomFieldHandler{DB: database}
+	snapH := &handler.SnapshotHandler{DB: database}
+
+	// Resources served by the declarative CRUD engine
+	regionR := crud.NewResource(handler.RegionConfig, database.Pool)
+	locationR := crud.NewResource(handler.LocationConfig, database.Pool)
+	mfR := crud.NewResource(handler.ManufacturerConfig, database.Pool)
+	tenantR := crud.NewResource(handler.TenantConfig, database.Pool)
+
+	// The BFF by the internal secret, other clients by a scoped API token;
+	// both within the roles of their user
+	roles := rbac.NewStore(database.Pool)
+	auth := middleware.Auth(internalSecret, apitoken.NewStore(database.Pool), roles, proxies)
+
+	// Optimistic concurrency: ETag on GET, If-Match on PATCH and DELETE
+	ver := etag.New(database.Pool)
+	siteV := ver.Resource("sites", siteH.Get)
+	regionV := ver.Resource("regions", regionR.Get)
+	locationV := ver.Resource("locations", locationR.Get)
+	rackV := ver.Resource("racks", rackH.Get)
+	deviceV := ver.Resource("devices", deviceH.Get)
+	dtV := ver.Resource("device_types", dtH.Get)
+	mfV := ver.Resource("manufacturers", mfR.Get)
+	tenantV := ver.Resource("tenants", tenantR.Get)
+	cfV := ver.Resource("custom_field_definitions", cfH.Get)
+
+	// Past states rebuilt from audit_logs on ?asOf=
+	hist := history.New(database.Pool)
+	siteT := hist.AsOf("sites")
+	regionT := hist.AsOf("regions")
+	locationT := hist.AsOf("locations")
+	rackT := hist.AsOf("racks")
+	deviceT := hist.AsOf("devices")
+	dtT := hist.AsOf("device_types")
+	mfT := hist.AsOf("manufacturers")
+	tenantT := hist.AsOf("tenants")
+	cfT := hist.AsOf("custom_field_definitions")
+
+	// Related objects embedded on ?expand=
+	exp := expand.New(database.Pool, handler.BulkResources)
+	siteX := exp.Of("sites")
+	regionX := exp.Of("regions")
+	locationX := exp.Of("locations")
+	rackX := exp.Of("racks")
+	deviceX := exp.Of("devices")
+	dtX := exp.Of("device_types")
 
 	mux := http.NewServeMux()
 
 	// Sites CRUD
-	mux.Handle("GET /sites", auth(http.HandlerFunc(siteH.List)))
-	mux.Handle("GET /sites/{id}", auth(http.HandlerFunc(siteH.Get)))
+	mux.Handle("GET /sites", auth(siteX(http.HandlerFunc(siteH.List))))
+	mux.Handle("GET /sites/{id}", auth(siteT(siteX(siteV(siteH.Get)))))
 	mux.Handle("POST /sites", auth(http.HandlerFunc(siteH.Create)))
-	mux.Handle("PATCH /sites/{id}", auth(http.HandlerFunc(siteH.Update)))
-	mux.Handle("DELETE /sites/{id}", auth(http.HandlerFunc(siteH.Delete)))
+	mux.Handle("PATCH /sites/{id}", auth(siteV(siteH.Update)))
+	mux.Handle("DELETE /sites/{id}", auth(siteV(siteH.Delete)))
+	mux.Handle("GET /sites/{id}/snapshot", auth(http.HandlerFunc(snapH.Site)))
 
 	// Regions CRUD
-	mux.Handle("GET /regions", auth(http.HandlerFunc(regionH.List)))
-	mux.Handle("GET /regions/{id}", auth(http.HandlerFunc(regionH.Get)))
-	mux.Handle("POST /regions", auth(http.HandlerFunc(regionH.Create)))
-	mux.Handle("PATCH /regions/{id}", auth(http.HandlerFunc(regionH.Update)))
-	mux.Handle("DELETE /regions/{id}", auth(http.HandlerFunc(regionH.Delete)))
+	mux.Handle("GET /regions", auth(regionX(http.HandlerFunc(regionR.List))))
+	mux.Handle("GET /regions/tree", auth(http.HandlerFunc(regionH.Tree)))
+	mux.Handle("GET /regions/{id}", auth(regionT(regionX(regionV(regionR.Get)))))
+	mux.Handle("POST /regions", auth(http.HandlerFunc(regionR.Create)))
+	mux.Handle("PATCH /regions/{id}", auth(regionV(regionR.Update)))
+	mux.Handle("DELETE /regions/{id}", auth(regionV(regionR.Delete)))
 
 	// Locations CRUD
-	mux.Handle("GET /locations", auth(http.HandlerFunc(locationH.List)))
-	mux.Handle("GET /locations/{id}", auth(http.HandlerFunc(locationH.Get)))
-	mux.Handle("POST /locations", auth(http.HandlerFunc(locationH.Create)))
-	mux.Handle("PATCH /locations/{id}", auth(http.HandlerFunc(locationH.Update)))
-	mux.Handle("DELETE /locations/{id}", auth(http.HandlerFunc(locationH.Delete)))
+	mux.Handle("GET /locations", auth(locationX(http.HandlerFunc(locationR.List))))
+	mux.Handle("GET /locations/{id}", auth(locationT(locationX(locationV(locationR.Get)))))
+	mux.Handle("POST /locations", auth(http.HandlerFunc(locationR.Create)))
+	mux.Handle("PATCH /locations/{id}", auth(locationV(locationR.Update)))
+	mux.Handle("DELETE /locations/{id}", auth(locationV(locationR.Delete)))
 
 	// Racks CRUD
-	mux.Handle("GET /racks", auth(http.HandlerFunc(rackH.List)))
-	mux.Handle("GET /racks/{id}", auth(http.HandlerFunc(rackH.Get)))
+	mux.Handle("GET /racks", auth(rackX(http.HandlerFunc(rackH.List))))
+	mux.Handle("GET /racks/available", auth(http.HandlerFunc(rackH.Available)))
+	mux.Handle("GET /racks/{id}", auth(rackT(rackX(rackV(rackH.Get)))))
 	mux.Handle("POST /racks", auth(http.HandlerFunc(rackH.Create)))
-	mux.Handle("PATCH /racks/{id}", auth(http.HandlerFunc(rackH.Update)))
-	mux.Handle("DELETE /racks/{id}", auth(http.HandlerFunc(rackH.Delete)))
+	mux.Handle("PATCH /racks/{id}", auth(rackV(rackH.Update)))
+	mux.Handle("DELETE /racks/{id}", auth(rackV(rackH.Delete)))
+	mux.Handle("GET /racks/{id}/snapshot", auth(http.HandlerFunc(snapH.Rack)))
 
 	// Devices CRUD + Batch
-	mux.Handle("GET /devices", auth(http.HandlerFunc(deviceH.List)))
-	mux.Handle("GET /devices/{id}", auth(http.HandlerFunc(deviceH.Get)))
+	mux.Handle("GET /devices", auth(deviceX(http.HandlerFunc(deviceH.List))))
+	mux.Handle("GET /devices/{id}", auth(deviceT(deviceX(deviceV(deviceH.Get)))))
 	mux.Handle("POST /devices", auth(http.HandlerFunc(deviceH.Create)))
-	mux.Handle("PATCH /devices/{id}", auth(http.HandlerFunc(deviceH.Update)))
-	mux.Handle("DELETE /devices/{id}", auth(http.HandlerFunc(deviceH.Delete)))
+	mux.Handle("PATCH /devices/{id}", auth(deviceV(deviceH.Update)))
+	mux.Handle("DELETE /devices/{id}", auth(deviceV(deviceH.Delete)))
 	mux.Handle("POST /devices/batch", auth(http.HandlerFunc(deviceH.Batch)))
+	mux.Handle("POST /devices/{id}/sync-components", auth(http.HandlerFunc(deviceH.SyncComponents)))
+	mux.Handle("GET /devices/{id}/status-history", auth(http.HandlerFunc(deviceH.StatusHistory)))
+	mux.Handle("GET /devices/lifecycle/durations", auth(http.HandlerFunc(deviceH.StateDurations)))
+	mux.Handle("POST /import/devices", auth(http.HandlerFunc(deviceH.Import)))
 
 	// Device Types CRUD
-	mux.Handle("GET /device-types", auth(http.HandlerFunc(dtH.List)))
-	mux.Handle("GET /device-types/{id}", auth(http.HandlerFunc(dtH.Get)))
+	mux.Handle("GET /device-types", auth(dtX(http.HandlerFunc(dtH.List))))
+	mux.Handle("GET /device-types/{id}", auth(dtT(dtX(dtV(dtH.Get)))))
 	mux.Handle("POST /device-types", auth(http.HandlerFunc(dtH.Create)))
-	mux.Handle("PATCH /device-types/{id}", auth(http.HandlerFunc(dtH.Update)))
-	mux.Handle("DELETE /device-types/{id}", auth(http.HandlerFunc(dtH.Delete)))
+	mux.Handle("PATCH /device-types/{id}", auth(dtV(dtH.Update)))
+	mux.Handle("DELETE /device-types/{id}", auth(dtV(dtH.Delete)))
+
+	// Device Type component templates
+	mux.Handle("GET /device-types/{id}/templates", auth(http.HandlerFunc(tplH.List)))
+	mux.Handle("POST /device-types/{id}/templates/{kind}", auth(http.HandlerFunc(tplH.Create)))
+	mux.Handle("DELETE /device-types/{id}/templates/{kind}/{templateId}", auth(http.HandlerFunc(tplH.Delete)))
 
 	// Manufacturers CRUD
-	mux.Handle("GET /manufacturers", auth(http.HandlerFunc(mfH.List)))
-	mux.Handle("GET /manufacturers/{id}", auth(http.HandlerFunc(mfH.Get)))
-	mux.Handle("POST /manufacturers", auth(http.HandlerFunc(mfH.Create)))
-	mux.Handle("PATCH /manufacturers/{id}", auth(http.HandlerFunc(mfH.Update)))
-	mux.Handle("DELETE /manufacturers/{id}", auth(http.HandlerFunc(mfH.Delete)))
+	mux.Handle("GET /manufacturers", auth(http.HandlerFunc(mfR.List)))
+	mux.Handle("GET /manufacturers/{id}", auth(mfT(mfV(mfR.Get))))
+	mux.Handle("POST /manufacturers", auth(http.HandlerFunc(mfR.Create)))
+	mux.Handle("PATCH /manufacturers/{id}", auth(mfV(mfR.Update)))
+	mux.Handle("DELETE /manufacturers/{id}", auth(mfV(mfR.Delete)))
 
 	// Tenants CRUD
-	mux.Handle("GET /tenants", auth(http.HandlerFunc(tenantH.List)))
-	mux.Handle("GET /tenants/{id}", auth(http.HandlerFunc(tenantH.Get)))
-	mux.Handle("POST /tenants", auth(http.HandlerFunc(tenantH.Create)))
-	mux.Handle("PATCH /tenants/{id}", auth(http.HandlerFunc(tenantH.Update)))
-	mux.Handle("DELETE /tenants/{id}", auth(http.HandlerFunc(tenantH.Delete)))
+	mux.Handle("GET /tenants", auth(http.HandlerFunc(tenantR.List)))
+	mux.Handle("GET /tenants/usage", auth(http.HandlerFunc(tenantH.BulkUsage)))
+	mux.Handle("GET /tenants/{id}", auth(tenantT(tenantV(tenantR.Get))))
+	mux.Handle("GET /tenants/{id}/usage", auth(http.HandlerFunc(tenantH.Usage)))
+	mux.Handle("POST /tenants", auth(http.HandlerFunc(tenantR.Create)))
+	mux.Handle("PATCH /tenants/{id}", auth(tenantV(tenantR.Update)))
+	mux.Handle("DELETE /tenants/{id}", auth(tenantV(tenantR.Delete)))
+
+	// Custom field definitions
+	mux.Handle("GET /custom-fields", auth(http.HandlerFunc(cfH.List)))
+	mux.Handle("GET /custom-fields/{id}", auth(cfT(cfV(cfH.Get))))
+	mux.Handle("POST /custom-fields", auth(http.HandlerFunc(cfH.Create)))
+	mux.Handle("PATCH /custom-fields/{id}", auth(cfV(cfH.Update)))
+	mux.Handle("DELETE /custom-fields/{id}", auth(cfV(cfH.Delete)))
 
 	// Dashboard
 	mux.Handle("GET /dashboard/summary", auth(http.HandlerFunc(dashH.Summary)))
 
-	logged := middleware.Logging(middleware.CORS(mux))
+	// Change feed: server-sent create, update and delete events
+	changes := feed.NewHub(database.Pool)
+	changes.Start(ctx, time.Second)
+	mux.Handle("GET /events", auth(http.HandlerFunc(changes.Stream)))
+
+	// Bulk: transactional create, update and delete of many rows
+	crud.RegisterBulk(mux, auth, database.Pool, handler.BulkResources)
+
+	// Trash: list, restore and purge soft-deleted records
+	trashCfg := trash.Config{Resources: handler.TrashResources, Retention: trash.RetentionFromEnv()}
+	trash.RegisterRoutes(mux, auth, database.Pool, trashCfg)
+	trash.StartPurger(ctx, database.Pool, trashCfg, time.Hour)
+
+	logged := middleware.Logging(middleware.CORS(middleware.Actor(proxies)(mux)))
 
 	log.Printf("Core API service listening on :%s", port)
 	if err := http.ListenAndServe(":"+port, logged); err != nil {
@@ -5,10 +5,22 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	core "github.com/dcim/go-services/internal/core/handler"
 	"github.com/dcim/go-services/internal/netops/handler"
+	"github.com/dcim/go-services/internal/shared/apitoken"
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
+	"github.com/dcim/go-services/internal/shared/expand"
+	"github.com/dcim/go-services/internal/shared/feed"
+	"github.com/dcim/go-services/internal/shared/history"
 	"github.com/dcim/go-services/internal/shared/middleware"
+	"github.com/dcim/go-services/internal/shared/outbox"
+	"github.com/dcim/go-services/internal/shared/rbac"
+	"github.com/dcim/go-services/internal/shared/trash"
 )
 
 func main() {
@@ -27,6 +39,12 @@ func main() {
 		log.Fatal("X_INTERNAL_SECRET environment variable is required")
 	}
 
+	// Reverse proxies whose X-Forwarded-For is believed for API token IP checks
+	proxies, err := middleware.ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+
 	ctx := context.Background()
 	database, err := db.New(ctx, databaseURL)
 	if err != nil {
@@ -37,108 +55,213 @@ func main() {
 	cableH := &handler.CableHandler{DB: database}
 	traceH := &handler.CableTraceHandler{DB: database}
 	ifaceH := &handler.InterfaceHandler{DB: database}
-	cpH := &handler.ConsolePortHandler{DB: database}
-	fpH := &handler.FrontPortHandler{DB: database}
-	rpH := &handler.RearPortHandler{DB: database}
 	accessH := &handler.AccessLogHandler{DB: database}
 	equipH := &handler.EquipmentMovementHandler{DB: database}
 	alertH := &handler.AlertRuleHandler{DB: database}
 	historyH := &handler.AlertHistoryHandler{DB: database}
-	channelH := &handler.ChannelHandler{DB: database}
 	reportH := &handler.ReportScheduleHandler{DB: database}
-	auditH := &handler.AuditLogHandler{DB: database}
+	signer, err := audit.NewSigner(os.Getenv("AUDIT_SIGNING_KEY"))
+	if err != nil {
+		log.Fatalf("AUDIT_SIGNING_KEY: %v", err)
+	}
+	auditH := &handler.AuditLogHandler{DB: database, Signer: signer}
 	importH := &handler.ImportHandler{DB: database}
 
-	auth := middleware.InternalSecret(internalSecret)
+	// Resources served by the declarative CRUD engine
+	cpR := crud.NewResource(handler.ConsolePortConfig, database.Pool)
+	fpR := crud.NewResource(handler.FrontPortConfig, database.Pool)
+	rpR := crud.NewResource(handler.RearPortConfig, database.Pool)
+	channelR := crud.NewResource(handler.ChannelConfig, database.Pool)
+	webhookR := crud.NewResource(handler.WebhookConfig, database.Pool)
+	webhookH := &handler.WebhookHandler{DB: database}
+	tokenH := &handler.APITokenHandler{DB: database}
+	roleR := crud.NewResource(handler.RoleConfig, database.Pool)
+	assignmentR := crud.NewResource(handler.RoleAssignmentConfig, database.Pool)
+
+	// The BFF by the internal secret, other clients by a scoped API token;
+	// both within the roles of their user
+	roles := rbac.NewStore(database.Pool)
+	auth := middleware.Auth(internalSecret, apitoken.NewStore(database.Pool), roles, proxies)
+	bff := middleware.InternalSecret(internalSecret)
+	authorize := middleware.Authorize(roles)
+
+	// Optimistic concurrency: ETag on GET, If-Match on PATCH and DELETE
+	ver := etag.New(database.Pool)
+	cableV := ver.Resource("cables", cableH.Get)
+	ifaceV := ver.Resource("interfaces", ifaceH.Get)
+	cpV := ver.Resource("console_ports", cpR.Get)
+	fpV := ver.Resource("front_ports", fpR.Get)
+	rpV := ver.Resource("rear_ports", rpR.Get)
+	accessV := ver.Resource("access_logs", accessH.Get)
+	equipV := ver.Resource("equipment_movements", equipH.Get)
+	alertV := ver.Resource("alert_rules", alertH.Get)
+	channelV := ver.Resource("notification_channels", channelR.Get)
+	webhookV := ver.Resource("webhooks", webhookR.Get)
+	roleV := ver.Resource("roles", roleR.Get)
+	assignmentV := ver.Resource("role_assignments", assignmentR.Get)
+	reportV := ver.Resource("report_schedules", reportH.Get)
+
+	// Past states rebuilt from audit_logs on ?asOf=
+	hist := history.New(database.Pool)
+	cableT := hist.AsOf("cables")
+	ifaceT := hist.AsOf("interfaces")
+	cpT := hist.AsOf("console_ports")
+	fpT := hist.AsOf("front_ports")
+	rpT := hist.AsOf("rear_ports")
+	accessT := hist.AsOf("access_logs")
+	equipT := hist.AsOf("equipment_movements")
+	alertT := hist.AsOf("alert_rules")
+	channelT := hist.AsOf("notification_channels")
+	reportT := hist.AsOf("report_schedules")
+
+	// Related objects embedded on ?expand=
+	exp := expand.New(database.Pool, core.BulkResources, handler.BulkResources)
+	cableX := exp.Of("cables")
+	ifaceX := exp.Of("interfaces")
+	cpX := exp.Of("console_ports")
+	fpX := exp.Of("front_ports")
+	rpX := exp.Of("rear_ports")
+	accessX := exp.Of("access_logs")
+	equipX := exp.Of("equipment_movements")
+	historyX := exp.Of("alert_history")
 
 	mux := http.NewServeMux()
 
 	// Cables CRUD + Trace
-	mux.Handle("GET /cables", auth(http.HandlerFunc(cableH.List)))
-	mux.Handle("GET /cables/{id}", auth(http.HandlerFunc(cableH.Get)))
+	mux.Handle("GET /cables", auth(cableX(http.HandlerFunc(cableH.List))))
+	mux.Handle("GET /cables/{id}", auth(cableT(cableX(cableV(cableH.Get)))))
 	mux.Handle("POST /cables", auth(http.HandlerFunc(cableH.Create)))
-	mux.Handle("PATCH /cables/{id}", auth(http.HandlerFunc(cableH.Update)))
-	mux.Handle("DELETE /cables/{id}", auth(http.HandlerFunc(cableH.Delete)))
+	mux.Handle("PATCH /cables/{id}", auth(cableV(cableH.Update)))
+	mux.Handle("DELETE /cables/{id}", auth(cableV(cableH.Delete)))
 	mux.Handle("GET /cables/trace/{id}", auth(http.HandlerFunc(traceH.Trace)))
 
 	// Interfaces CRUD
-	mux.Handle("GET /interfaces", auth(http.HandlerFunc(ifaceH.List)))
-	mux.Handle("GET /interfaces/{id}", auth(http.HandlerFunc(ifaceH.Get)))
+	mux.Handle("GET /interfaces", auth(ifaceX(http.HandlerFunc(ifaceH.List))))
+	mux.Handle("GET /interfaces/{id}", auth(ifaceT(ifaceX(ifaceV(ifaceH.Get)))))
 	mux.Handle("POST /interfaces", auth(http.HandlerFunc(ifaceH.Create)))
-	mux.Handle("PATCH /interfaces/{id}", auth(http.HandlerFunc(ifaceH.Update)))
-	mux.Handle("DELETE /interfaces/{id}", auth(http.HandlerFunc(ifaceH.Delete)))
+	mux.Handle("PATCH /interfaces/{id}", auth(ifaceV(ifaceH.Update)))
+	mux.Handle("DELETE /interfaces/{id}", auth(ifaceV(ifaceH.Delete)))
 
 	// Console Ports CRUD
-	mux.Handle("GET /console-ports", auth(http.HandlerFunc(cpH.List)))
-	mux.Handle("GET /console-ports/{id}", auth(http.HandlerFunc(cpH.Get)))
-	mux.Handle("POST /console-ports", auth(http.HandlerFunc(cpH.Create)))
-	mux.Handle("PATCH /console-ports/{id}", auth(http.HandlerFunc(cpH.Update)))
-	mux.Handle("DELETE /console-ports/{id}", auth(http.HandlerFunc(cpH.Delete)))
+	mux.Handle("GET /console-ports", auth(cpX(http.HandlerFunc(cpR.List))))
+	mux.Handle("GET /console-ports/{id}", auth(cpT(cpX(cpV(cpR.Get)))))
+	mux.Handle("POST /console-ports", auth(http.HandlerFunc(cpR.Create)))
+	mux.Handle("PATCH /console-ports/{id}", auth(cpV(cpR.Update)))
+	mux.Handle("DELETE /console-ports/{id}", auth(cpV(cpR.Delete)))
 
 	// Front Ports CRUD
-	mux.Handle("GET /front-ports", auth(http.HandlerFunc(fpH.List)))
-	mux.Handle("GET /front-ports/{id}", auth(http.HandlerFunc(fpH.Get)))
-	mux.Handle("POST /front-ports", auth(http.HandlerFunc(fpH.Create)))
-	mux.Handle("PATCH /front-ports/{id}", auth(http.HandlerFunc(fpH.Update)))
-	mux.Handle("DELETE /front-ports/{id}", auth(http.HandlerFunc(fpH.Delete)))
+	mux.Handle("GET /front-ports", auth(fpX(http.HandlerFunc(fpR.List))))
+	mux.Handle("GET /front-ports/{id}", auth(fpT(fpX(fpV(fpR.Get)))))
+	mux.Handle("POST /front-ports", auth(http.HandlerFunc(fpR.Create)))
+	mux.Handle("PATCH /front-ports/{id}", auth(fpV(fpR.Update)))
+	mux.Handle("DELETE /front-ports/{id}", auth(fpV(fpR.Delete)))
 
 	// Rear Ports CRUD
-	mux.Handle("GET /rear-ports", auth(http.HandlerFunc(rpH.List)))
-	mux.Handle("GET /rear-ports/{id}", auth(http.HandlerFunc(rpH.Get)))
-	mux.Handle("POST /rear-ports", auth(http.HandlerFunc(rpH.Create)))
-	mux.Handle("PATCH /rear-ports/{id}", auth(http.HandlerFunc(rpH.Update)))
-	mux.Handle("DELETE /rear-ports/{id}", auth(http.HandlerFunc(rpH.Delete)))
+	mux.Handle("GET /rear-ports", auth(rpX(http.HandlerFunc(rpR.List))))
+	mux.Handle("GET /rear-ports/{id}", auth(rpT(rpX(rpV(rpR.Get)))))
+	mux.Handle("POST /rear-ports", auth(http.HandlerFunc(rpR.Create)))
+	mux.Handle("PATCH /rear-ports/{id}", auth(rpV(rpR.Update)))
+	mux.Handle("DELETE /rear-ports/{id}", auth(rpV(rpR.Delete)))
 
 	// Access Logs CRUD
-	mux.Handle("GET /access-logs", auth(http.HandlerFunc(accessH.List)))
-	mux.Handle("GET /access-logs/{id}", auth(http.HandlerFunc(accessH.Get)))
+	mux.Handle("GET /access-logs", auth(accessX(http.HandlerFunc(accessH.List))))
+	mux.Handle("GET /access-logs/{id}", auth(accessT(accessX(accessV(accessH.Get)))))
 	mux.Handle("POST /access-logs", auth(http.HandlerFunc(accessH.Create)))
-	mux.Handle("PATCH /access-logs/{id}", auth(http.HandlerFunc(accessH.Update)))
-	mux.Handle("DELETE /access-logs/{id}", auth(http.HandlerFunc(accessH.Delete)))
+	mux.Handle("PATCH /access-logs/{id}", auth(accessV(accessH.Update)))
+	mux.Handle("DELETE /access-logs/{id}", auth(accessV(accessH.Delete)))
 
 	// Equipment Movements CRUD
-	mux.Handle("GET /equipment-movements", auth(http.HandlerFunc(equipH.List)))
-	mux.Handle("GET /equipment-movements/{id}", auth(http.HandlerFunc(equipH.Get)))
+	mux.Handle("GET /equipment-movements", auth(equipX(http.HandlerFunc(equipH.List))))
+	mux.Handle("GET /equipment-movements/{id}", auth(equipT(equipX(equipV(equipH.Get)))))
 	mux.Handle("POST /equipment-movements", auth(http.HandlerFunc(equipH.Create)))
-	mux.Handle("PATCH /equipment-movements/{id}", auth(http.HandlerFunc(equipH.Update)))
-	mux.Handle("DELETE /equipment-movements/{id}", auth(http.HandlerFunc(equipH.Delete)))
+	mux.Handle("PATCH /equipment-movements/{id}", auth(equipV(equipH.Update)))
+	mux.Handle("DELETE /equipment-movements/{id}", auth(equipV(equipH.Delete)))
 
 	// Alert Rules CRUD + Evaluate
 	mux.Handle("GET /alerts/rules", auth(http.HandlerFunc(alertH.List)))
-	mux.Handle("GET /alerts/rules/{id}", auth(http.HandlerFunc(alertH.Get)))
+	mux.Handle("GET /alerts/rules/{id}", auth(alertT(alertV(alertH.Get))))
 	mux.Handle("POST /alerts/rules", auth(http.HandlerFunc(alertH.Create)))
-	mux.Handle("PATCH /alerts/rules/{id}", auth(http.HandlerFunc(alertH.Update)))
-	mux.Handle("DELETE /alerts/rules/{id}", auth(http.HandlerFunc(alertH.Delete)))
+	mux.Handle("PATCH /alerts/rules/{id}", auth(alertV(alertH.Update)))
+	mux.Handle("DELETE /alerts/rules/{id}", auth(alertV(alertH.Delete)))
 	mux.Handle("POST /alerts/evaluate", auth(http.HandlerFunc(alertH.Evaluate)))
 
 	// Alert History
-	mux.Handle("GET /alerts/history", auth(http.HandlerFunc(historyH.List)))
+	mux.Handle("GET /alerts/history", auth(historyX(http.HandlerFunc(historyH.List))))
 	mux.Handle("PATCH /alerts/history/{id}/acknowledge", auth(http.HandlerFunc(historyH.Acknowledge)))
 
 	// Notification Channels CRUD
-	mux.Handle("GET /alerts/channels", auth(http.HandlerFunc(channelH.List)))
-	mux.Handle("GET /alerts/channels/{id}", auth(http.HandlerFunc(channelH.Get)))
-	mux.Handle("POST /alerts/channels", auth(http.HandlerFunc(channelH.Create)))
-	mux.Handle("PATCH /alerts/channels/{id}", auth(http.HandlerFunc(channelH.Update)))
-	mux.Handle("DELETE /alerts/channels/{id}", auth(http.HandlerFunc(channelH.Delete)))
+	mux.Handle("GET /alerts/channels", auth(http.HandlerFunc(channelR.List)))
+	mux.Handle("GET /alerts/channels/{id}", auth(channelT(channelV(channelR.Get))))
+	mux.Handle("POST /alerts/channels", auth(http.HandlerFunc(channelR.Create)))
+	mux.Handle("PATCH /alerts/channels/{id}", auth(channelV(channelR.Update)))
+	mux.Handle("DELETE /alerts/channels/{id}", auth(channelV(channelR.Delete)))
 
 	// Report Schedules CRUD + Run
 	mux.Handle("GET /reports/schedules", auth(http.HandlerFunc(reportH.List)))
-	mux.Handle("GET /reports/schedules/{id}", auth(http.HandlerFunc(reportH.Get)))
+	mux.Handle("GET /reports/schedules/{id}", auth(reportT(reportV(reportH.Get))))
 	mux.Handle("POST /reports/schedules", auth(http.HandlerFunc(reportH.Create)))
-	mux.Handle("PATCH /reports/schedules/{id}", auth(http.HandlerFunc(reportH.Update)))
-	mux.Handle("DELETE /reports/schedules/{id}", auth(http.HandlerFunc(reportH.Delete)))
+	mux.Handle("PATCH /reports/schedules/{id}", auth(reportV(reportH.Update)))
+	mux.Handle("DELETE /reports/schedules/{id}", auth(reportV(reportH.Delete)))
 	mux.Handle("POST /reports/schedules/{id}/run", auth(http.HandlerFunc(reportH.Run)))
 
-	// Audit Logs (read-only)
+	// Audit Logs (read-only), per-record history and hash chain verification
 	mux.Handle("GET /audit-logs", auth(http.HandlerFunc(auditH.List)))
+	mux.Handle("GET /audit-logs/{table}/{recordId}/history", auth(http.HandlerFunc(auditH.History)))
+	mux.Handle("GET /audit-logs/verify", auth(http.HandlerFunc(auditH.Verify)))
+	mux.Handle("GET /audit-logs/checkpoint", auth(http.HandlerFunc(auditH.Checkpoint)))
+	mux.Handle("POST /audit-logs/checkpoint/verify", auth(http.HandlerFunc(auditH.CheckCheckpoint)))
 
 	// Import
-	mux.Handle("POST /import/devices", auth(http.HandlerFunc(importH.ImportDevices)))
 	mux.Handle("POST /import/cables", auth(http.HandlerFunc(importH.ImportCables)))
 	mux.Handle("GET /import/templates/{type}", auth(http.HandlerFunc(importH.Template)))
 
-	logged := middleware.Logging(middleware.CORS(mux))
+	// Webhooks: subscriptions, delivery log and dead-letter queue, replay
+	mux.Handle("GET /webhooks", auth(http.HandlerFunc(webhookR.List)))
+	mux.Handle("GET /webhooks/deliveries", auth(http.HandlerFunc(webhookH.Deliveries)))
+	mux.Handle("POST /webhooks/deliveries/{id}/replay", auth(http.HandlerFunc(webhookH.ReplayDelivery)))
+	mux.Handle("GET /webhooks/{id}", auth(webhookV(webhookR.Get)))
+	mux.Handle("POST /webhooks", auth(http.HandlerFunc(webhookR.Create)))
+	mux.Handle("PATCH /webhooks/{id}", auth(webhookV(webhookR.Update)))
+	mux.Handle("DELETE /webhooks/{id}", auth(webhookV(webhookR.Delete)))
+	mux.Handle("POST /webhooks/{id}/replay", auth(http.HandlerFunc(webhookH.Replay)))
+
+	// API tokens: managed through the BFF only, by each user for themselves
+	mux.Handle("GET /api-tokens", bff(authorize(http.HandlerFunc(tokenH.List))))
+	mux.Handle("GET /api-tokens/{id}", bff(authorize(http.HandlerFunc(tokenH.Get))))
+	mux.Handle("POST /api-tokens", bff(authorize(http.HandlerFunc(tokenH.Create))))
+	mux.Handle("PATCH /api-tokens/{id}", bff(authorize(http.HandlerFunc(tokenH.Update))))
+	mux.Handle("DELETE /api-tokens/{id}", bff(authorize(http.HandlerFunc(tokenH.Revoke))))
+
+	// RBAC: custom roles and their assignment to users, everywhere or scoped to a site or tenant
+	mux.Handle("GET /roles", auth(http.HandlerFunc(roleR.List)))
+	mux.Handle("GET /roles/{id}", auth(roleV(roleR.Get)))
+	mux.Handle("POST /roles", auth(http.HandlerFunc(roleR.Create)))
+	mux.Handle("PATCH /roles/{id}", auth(roleV(roleR.Update)))
+	mux.Handle("DELETE /roles/{id}", auth(roleV(roleR.Delete)))
+	mux.Handle("GET /role-assignments", auth(http.HandlerFunc(assignmentR.List)))
+	mux.Handle("GET /role-assignments/{id}", auth(assignmentV(assignmentR.Get)))
+	mux.Handle("POST /role-assignments", auth(http.HandlerFunc(assignmentR.Create)))
+	mux.Handle("PATCH /role-assignments/{id}", auth(assignmentV(assignmentR.Update)))
+	mux.Handle("DELETE /role-assignments/{id}", auth(assignmentV(assignmentR.Delete)))
+
+	// Outbox: deliver audited changes to the webhooks, prune the event log
+	outbox.NewDispatcher(database.Pool).Start(ctx, 5*time.Second)
+	outbox.StartPruner(ctx, database.Pool, outbox.Retention, time.Hour)
+
+	// Change feed: server-sent create, update and delete events
+	changes := feed.NewHub(database.Pool)
+	changes.Start(ctx, time.Second)
+	mux.Handle("GET /events", auth(http.HandlerFunc(changes.Stream)))
+
+	// Bulk: transactional create, update and delete of many rows
+	crud.RegisterBulk(mux, auth, database.Pool, handler.BulkResources)
+
+	// Trash: list, restore and purge soft-deleted records
+	trashCfg := trash.Config{Resources: handler.TrashResources, Retention: trash.RetentionFromEnv()}
+	trash.RegisterRoutes(mux, auth, database.Pool, trashCfg)
+	trash.StartPurger(ctx, database.Pool, trashCfg, time.Hour)
+
+	logged := middleware.Logging(middleware.CORS(middleware.Actor(proxies)(mux)))
 
 	log.Printf("Network Ops service listening on :%s", port)
 	if err := http.ListenAndServe(":"+port, logged); err != nil {
@@ -5,10 +5,19 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	core "github.com/dcim/go-services/internal/core/handler"
 	"github.com/dcim/go-services/internal/power/handler"
+	"github.com/dcim/go-services/internal/shared/apitoken"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
+	"github.com/dcim/go-services/internal/shared/expand"
+	"github.com/dcim/go-services/internal/shared/history"
 	"github.com/dcim/go-services/internal/shared/middleware"
+	"github.com/dcim/go-services/internal/shared/rbac"
+	"github.com/dcim/go-services/internal/shared/trash"
 )
 
 func main() {
@@ -27,6 +36,12 @@ func main() {
 		log.Fatal("X_INTERNAL_SECRET environment variable is required")
 	}
 
+	// Reverse proxies whose X-Forwarded-For is believed for API token IP checks
+	proxies, err := middleware.ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+
 	ctx := context.Background()
 	database, err := db.New(ctx, databaseURL)
 	if err != nil {
@@ -40,7 +55,25 @@ func main() {
 	feedH := &handler.FeedHandler{DB: database}
 	summaryH := &handler.SummaryHandler{DB: database}
 
-	auth := middleware.InternalSecret(internalSecret)
+	// The BFF by the internal secret, other clients by a scoped API token;
+	// both within the roles of their user
+	roles := rbac.NewStore(database.Pool)
+	auth := middleware.Auth(internalSecret, apitoken.NewStore(database.Pool), roles, proxies)
+
+	// Optimistic concurrency: ETag on GET, If-Match on PATCH and DELETE
+	ver := etag.New(database.Pool)
+	panelV := ver.Resource("power_panels", panelH.Get)
+	feedV := ver.Resource("power_feeds", feedH.Get)
+
+	// Past states rebuilt from audit_logs on ?asOf=
+	hist := history.New(database.Pool)
+	panelT := hist.AsOf("power_panels")
+	feedT := hist.AsOf("power_feeds")
+
+	// Related objects embedded on ?expand=
+	exp := expand.New(database.Pool, core.BulkResources, handler.BulkResources)
+	panelX := exp.Of("power_panels")
+	feedX := exp.Of("power_feeds")
 
 	mux := http.NewServeMux()
 
@@ -50,18 +83,18 @@ func main() {
 	mux.Handle("GET /sse", auth(http.HandlerFunc(powerH.StreamSSE)))
 
 	// Power panels CRUD
-	mux.Handle("GET /panels", auth(http.HandlerFunc(panelH.List)))
-	mux.Handle("GET /panels/{id}", auth(http.HandlerFunc(panelH.Get)))
+	mux.Handle("GET /panels", auth(panelX(http.HandlerFunc(panelH.List))))
+	mux.Handle("GET /panels/{id}", auth(panelT(panelX(panelV(panelH.Get)))))
 	mux.Handle("POST /panels", auth(http.HandlerFunc(panelH.Create)))
-	mux.Handle("PATCH /panels/{id}", auth(http.HandlerFunc(panelH.Update)))
-	mux.Handle("DELETE /panels/{id}", auth(http.HandlerFunc(panelH.Delete)))
+	mux.Handle("PATCH /panels/{id}", auth(panelV(panelH.Update)))
+	mux.Handle("DELETE /panels/{id}", auth(panelV(panelH.Delete)))
 
 	// Power feeds CRUD
-	mux.Handle("GET /feeds", auth(http.HandlerFunc(feedH.List)))
-	mux.Handle("GET /feeds/{id}", auth(http.HandlerFunc(feedH.Get)))
+	mux.Handle("GET /feeds", auth(feedX(http.HandlerFunc(feedH.List))))
+	mux.Handle("GET /feeds/{id}", auth(feedT(feedX(feedV(feedH.Get)))))
 	mux.Handle("POST /feeds", auth(http.HandlerFunc(feedH.Create)))
-	mux.Handle("PATCH /feeds/{id}", auth(http.HandlerFunc(feedH.Update)))
-	mux.Handle("DELETE /feeds/{id}", auth(http.HandlerFunc(feedH.Delete)))
+	mux.Handle("PATCH /feeds/{id}", auth(feedV(feedH.Update)))
+	mux.Handle("DELETE /feeds/{id}", auth(feedV(feedH.Delete)))
 
 	// Power summary
 	mux.Handle("GET /summary", auth(http.HandlerFunc(summaryH.GetSummary)))
@@ -75,8 +108,16 @@ func main() {
 	mux.Handle("GET /export/xml/racks", auth(http.HandlerFunc(exportH.ExportXMLRacks)))
 	mux.Handle("GET /export/xml/devices", auth(http.HandlerFunc(exportH.ExportXMLDevices)))
 
+	// Bulk: transactional create, update and delete of many rows
+	crud.RegisterBulk(mux, auth, database.Pool, handler.BulkResources)
+
+	// Trash: list, restore and purge soft-deleted records
+	trashCfg := trash.Config{Resources: handler.TrashResources, Retention: trash.RetentionFromEnv()}
+	trash.RegisterRoutes(mux, auth, database.Pool, trashCfg)
+	trash.StartPurger(ctx, database.Pool, trashCfg, time.Hour)
+
 	// Apply logging middleware
-	logged := middleware.Logging(middleware.CORS(mux))
+	logged := middleware.Logging(middleware.CORS(middleware.Actor(proxies)(mux)))
 
 	log.Printf("Power service listening on :%s", port)
 	if err := http.ListenAndServe(":"+port, logged); err != nil {
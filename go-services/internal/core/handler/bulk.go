@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dcim/go-services/internal/shared/crud"
+	"github.com/dcim/go-services/internal/shared/customfields"
+	"github.com/jackc/pgx/v5"
+)
+
+// BulkResources are the core resources with a POST /{resource}/bulk endpoint.
+// The hooks apply the same rules as the single-row handlers.
+var BulkResources = []crud.BulkResource{
+	{Path: "/devices", Config: deviceConfig},
+	{Path: "/racks", Config: rackConfig},
+	{Path: "/locations", Config: LocationConfig},
+	{Path: "/sites", Config: siteConfig},
+	{Path: "/regions", Config: RegionConfig},
+	{Path: "/device-types", Config: deviceTypeConfig},
+	{Path: "/manufacturers", Config: ManufacturerConfig},
+	{Path: "/tenants", Config: TenantConfig},
+}
+
+// bulkError turns the rule errors of the single-row handlers into per-operation
+// client errors.
+func bulkError(err error) error {
+	var pe *placementError
+	var le *lifecycleError
+	var ve *customfields.ValidationError
+	switch {
+	case errors.As(err, &pe):
+		if pe.Conflict == nil {
+			return &crud.ValidationError{Issues: pe.issues()}
+		}
+		return &crud.OpError{Status: http.StatusConflict, Msg: pe.msg, Conflict: pe.Conflict}
+	case errors.As(err, &le):
+		return &crud.OpError{Status: http.StatusConflict, Msg: le.msg}
+	case errors.As(err, &ve):
+		return &crud.ValidationError{Issues: ve.Issues}
+	}
+	return err
+}
+
+// checkBulkCustomFields validates and normalizes customFields like the
+// single-row create and update handlers.
+func checkBulkCustomFields(objectType string) func(ctx context.Context, tx pgx.Tx, w *crud.Write) error {
+	return func(ctx context.Context, tx pgx.Tx, w *crud.Write) error {
+		current, _ := w.Before["customFields"].(map[string]interface{})
+		stored, ok, err := validateCustomFields(ctx, tx, objectType, current, w.Data, w.Before == nil)
+		if err != nil {
+			return bulkError(err)
+		}
+		if ok {
+			w.Data["customFields"] = stored
+		}
+		return nil
+	}
+}
+
+func checkBulkRack(ctx context.Context, tx pgx.Tx, w *crud.Write) error {
+	if err := checkBulkCustomFields("rack")(ctx, tx, w); err != nil {
+		return err
+	}
+	if v, ok := w.Data["uHeight"].(float64); ok && w.Before != nil {
+		return bulkError(checkRackHeight(ctx, tx, w.ID, int(v)))
+	}
+	return nil
+}
+
+// checkBulkDevice re-validates the rack placement when it is set or changed.
+func checkBulkDevice(ctx context.Context, tx pgx.Tx, w *crud.Write) error {
+	if err := checkBulkCustomFields("device")(ctx, tx, w); err != nil {
+		return err
+	}
+	if w.Before != nil && !placementChanged(w.Data) {
+		return nil
+	}
+
+	rackID, dtID, face, pos := bulkString(w.Before, "rackId"), "", "front", (*int)(nil)
+	if w.Before != nil {
+		dtID, _ = w.Before["deviceTypeId"].(string)
+		face, _ = w.Before["face"].(string)
+		if p, ok := w.Before["position"].(int); ok {
+			pos = &p
+		}
+	}
+	if _, ok := w.Data["rackId"]; ok {
+		rackID = bulkString(w.Data, "rackId")
+	}
+	if v, ok := w.Data["deviceTypeId"].(string); ok && v != "" {
+		dtID = v
+	}
+	if v, ok := w.Data["face"].(string); ok && v != "" {
+		face = v
+	}
+	if v, present := w.Data["position"]; present {
+		pos = nil
+		if f, ok := v.(float64); ok {
+			p := int(f)
+			pos = &p
+		}
+	}
+	return bulkError(checkRackPlacement(ctx, tx, w.ID, rackID, dtID, pos, face))
+}
+
+// afterBulkDevice enforces the lifecycle on the written device, records status
+// changes and, on create, instantiates the components of the device type.
+func afterBulkDevice(ctx context.Context, tx pgx.Tx, w *crud.Write) error {
+	d, err := scanDevice(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM devices WHERE id = $1`, deviceCols), w.ID).Scan)
+	if err != nil {
+		return err
+	}
+	from := ""
+	if w.Before != nil {
+		from, _ = w.Before["status"].(string)
+	}
+	if w.Before == nil || d.Status != from || (d.Status == "active" && activeFieldsChanged(w.Data)) {
+		if err := checkDeviceTransition(ctx, tx, from, d); err != nil {
+			return bulkError(err)
+		}
+	}
+	if d.Status != from {
+		reason, _ := w.Data["reason"].(string)
+		if err := recordStatusChange(ctx, tx, d.ID, from, d.Status, w.Actor, reason); err != nil {
+			return err
+		}
+	}
+	if w.Before == nil {
+		if _, err := instantiateComponents(ctx, tx, d.ID, d.DeviceTypeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulkString returns a non-empty string member of m, or nil.
+func bulkString(m map[string]interface{}, key string) *string {
+	if s, ok := m[key].(string); ok && s != "" {
+		return &s
+	}
+	return nil
+}
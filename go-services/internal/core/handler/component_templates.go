@@ -0,0 +1,513 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ComponentTemplateHandler manages the component templates of a device type.
+// Templates are instantiated as interfaces and ports when a device of that type is created.
+type ComponentTemplateHandler struct{ DB *db.DB }
+
+// dbtx is satisfied by both *pgxpool.Pool and pgx.Tx.
+type dbtx interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+type interfaceTemplateRow struct {
+	ID            string  `json:"id"`
+	DeviceTypeID  string  `json:"deviceTypeId"`
+	Name          string  `json:"name"`
+	InterfaceType string  `json:"interfaceType"`
+	Speed         *int    `json:"speed"`
+	Description   *string `json:"description"`
+}
+
+type consolePortTemplateRow struct {
+	ID           string  `json:"id"`
+	DeviceTypeID string  `json:"deviceTypeId"`
+	Name         string  `json:"name"`
+	PortType     string  `json:"portType"`
+	Speed        *int    `json:"speed"`
+	Description  *string `json:"description"`
+}
+
+type rearPortTemplateRow struct {
+	ID           string  `json:"id"`
+	DeviceTypeID string  `json:"deviceTypeId"`
+	Name         string  `json:"name"`
+	PortType     string  `json:"portType"`
+	Positions    int     `json:"positions"`
+	Description  *string `json:"description"`
+}
+
+type frontPortTemplateRow struct {
+	ID                 string  `json:"id"`
+	DeviceTypeID       string  `json:"deviceTypeId"`
+	Name               string  `json:"name"`
+	PortType           string  `json:"portType"`
+	RearPortTemplateID string  `json:"rearPortTemplateId"`
+	RearPortPosition   int     `json:"rearPortPosition"`
+	Description        *string `json:"description"`
+}
+
+type powerPortTemplateRow struct {
+	ID           string `json:"id"`
+	DeviceTypeID string `json:"deviceTypeId"`
+	PortNumber   int    `json:"portNumber"`
+	OutletType   string `json:"outletType"`
+}
+
+type componentTemplates struct {
+	Interfaces   []interfaceTemplateRow   `json:"interfaces"`
+	ConsolePorts []consolePortTemplateRow `json:"consolePorts"`
+	RearPorts    []rearPortTemplateRow    `json:"rearPorts"`
+	FrontPorts   []frontPortTemplateRow   `json:"frontPorts"`
+	PowerPorts   []powerPortTemplateRow   `json:"powerPorts"`
+}
+
+// componentCounts reports how many components were created from templates.
+type componentCounts struct {
+	Interfaces   int64 `json:"interfaces"`
+	ConsolePorts int64 `json:"consolePorts"`
+	RearPorts    int64 `json:"rearPorts"`
+	FrontPorts   int64 `json:"frontPorts"`
+	PowerPorts   int64 `json:"powerPorts"`
+}
+
+const (
+	ifaceTplCols = `id, device_type_id, name, interface_type, speed, description`
+	cpTplCols    = `id, device_type_id, name, port_type, speed, description`
+	rpTplCols    = `id, device_type_id, name, port_type, positions, description`
+	fpTplCols    = `id, device_type_id, name, port_type, rear_port_template_id, rear_port_position, description`
+	ppTplCols    = `id, device_type_id, port_number, outlet_type`
+)
+
+// templateInputError is a client error raised while validating a template body.
+type templateInputError struct{ msg string }
+
+func (e *templateInputError) Error() string { return e.msg }
+
+func loadComponentTemplates(ctx context.Context, q dbtx, deviceTypeID string) (componentTemplates, error) {
+	t := componentTemplates{
+		Interfaces:   []interfaceTemplateRow{},
+		ConsolePorts: []consolePortTemplateRow{},
+		RearPorts:    []rearPortTemplateRow{},
+		FrontPorts:   []frontPortTemplateRow{},
+		PowerPorts:   []powerPortTemplateRow{},
+	}
+
+	rows, err := q.Query(ctx, fmt.Sprintf(`SELECT %s FROM interface_templates WHERE device_type_id = $1 AND deleted_at IS NULL ORDER BY name`, ifaceTplCols), deviceTypeID)
+	if err != nil {
+		return t, err
+	}
+	for rows.Next() {
+		var it interfaceTemplateRow
+		if err := rows.Scan(&it.ID, &it.DeviceTypeID, &it.Name, &it.InterfaceType, &it.Speed, &it.Description); err != nil {
+			rows.Close()
+			return t, err
+		}
+		t.Interfaces = append(t.Interfaces, it)
+	}
+	rows.Close()
+
+	rows, err = q.Query(ctx, fmt.Sprintf(`SELECT %s FROM console_port_templates WHERE device_type_id = $1 AND deleted_at IS NULL ORDER BY name`, cpTplCols), deviceTypeID)
+	if err != nil {
+		return t, err
+	}
+	for rows.Next() {
+		var ct consolePortTemplateRow
+		if err := rows.Scan(&ct.ID, &ct.DeviceTypeID, &ct.Name, &ct.PortType, &ct.Speed, &ct.Description); err != nil {
+			rows.Close()
+			return t, err
+		}
+		t.ConsolePorts = append(t.ConsolePorts, ct)
+	}
+	rows.Close()
+
+	rows, err = q.Query(ctx, fmt.Sprintf(`SELECT %s FROM rear_port_templates WHERE device_type_id = $1 AND deleted_at IS NULL ORDER BY name`, rpTplCols), deviceTypeID)
+	if err != nil {
+		return t, err
+	}
+	for rows.Next() {
+		var rt rearPortTemplateRow
+		if err := rows.Scan(&rt.ID, &rt.DeviceTypeID, &rt.Name, &rt.PortType, &rt.Positions, &rt.Description); err != nil {
+			rows.Close()
+			return t, err
+		}
+		t.RearPorts = append(t.RearPorts, rt)
+	}
+	rows.Close()
+
+	rows, err = q.Query(ctx, fmt.Sprintf(`SELECT %s FROM front_port_templates WHERE device_type_id = $1 AND deleted_at IS NULL ORDER BY name`, fpTplCols), deviceTypeID)
+	if err != nil {
+		return t, err
+	}
+	for rows.Next() {
+		var ft frontPortTemplateRow
+		if err := rows.Scan(&ft.ID, &ft.DeviceTypeID, &ft.Name, &ft.PortType, &ft.RearPortTemplateID, &ft.RearPortPosition, &ft.Description); err != nil {
+			rows.Close()
+			return t, err
+		}
+		t.FrontPorts = append(t.FrontPorts, ft)
+	}
+	rows.Close()
+
+	rows, err = q.Query(ctx, fmt.Sprintf(`SELECT %s FROM power_port_templates WHERE device_type_id = $1 AND deleted_at IS NULL ORDER BY port_number`, ppTplCols), deviceTypeID)
+	if err != nil {
+		return t, err
+	}
+	for rows.Next() {
+		var pt powerPortTemplateRow
+		if err := rows.Scan(&pt.ID, &pt.DeviceTypeID, &pt.PortNumber, &pt.OutletType); err != nil {
+			rows.Close()
+			return t, err
+		}
+		t.PowerPorts = append(t.PowerPorts, pt)
+	}
+	rows.Close()
+
+	return t, nil
+}
+
+// instantiateComponents creates a device's components from its type's templates.
+// Components that already exist on the device (matched by name, or by port number
+// for power ports) are left untouched, so the same call also serves as a sync.
+// Rear ports are created before front ports so the position mapping can resolve.
+func instantiateComponents(ctx context.Context, tx pgx.Tx, deviceID, deviceTypeID string) (componentCounts, error) {
+	var c componentCounts
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO interfaces (id, device_id, name, interface_type, speed, description)
+		SELECT gen_random_uuid(), $1, t.name, t.interface_type, t.speed, t.description
+		FROM interface_templates t
+		WHERE t.device_type_id = $2 AND t.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM interfaces i WHERE i.device_id = $1 AND i.name = t.name AND i.deleted_at IS NULL)`,
+		deviceID, deviceTypeID)
+	if err != nil {
+		return c, fmt.Errorf("interfaces: %w", err)
+	}
+	c.Interfaces = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, `
+		INSERT INTO console_ports (id, device_id, name, port_type, speed, description)
+		SELECT gen_random_uuid(), $1, t.name, t.port_type, t.speed, t.description
+		FROM console_port_templates t
+		WHERE t.device_type_id = $2 AND t.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM console_ports p WHERE p.device_id = $1 AND p.name = t.name AND p.deleted_at IS NULL)`,
+		deviceID, deviceTypeID)
+	if err != nil {
+		return c, fmt.Errorf("console ports: %w", err)
+	}
+	c.ConsolePorts = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, `
+		INSERT INTO rear_ports (id, device_id, name, port_type, positions, description)
+		SELECT gen_random_uuid(), $1, t.name, t.port_type, t.positions, t.description
+		FROM rear_port_templates t
+		WHERE t.device_type_id = $2 AND t.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM rear_ports p WHERE p.device_id = $1 AND p.name = t.name AND p.deleted_at IS NULL)`,
+		deviceID, deviceTypeID)
+	if err != nil {
+		return c, fmt.Errorf("rear ports: %w", err)
+	}
+	c.RearPorts = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, `
+		INSERT INTO front_ports (id, device_id, name, port_type, rear_port_id, rear_port_position, description)
+		SELECT gen_random_uuid(), $1, t.name, t.port_type, rp.id, t.rear_port_position, t.description
+		FROM front_port_templates t
+		JOIN rear_port_templates rt ON rt.id = t.rear_port_template_id
+		JOIN rear_ports rp ON rp.device_id = $1 AND rp.name = rt.name AND rp.deleted_at IS NULL
+		WHERE t.device_type_id = $2 AND t.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM front_ports p WHERE p.device_id = $1 AND p.name = t.name AND p.deleted_at IS NULL)`,
+		deviceID, deviceTypeID)
+	if err != nil {
+		return c, fmt.Errorf("front ports: %w", err)
+	}
+	c.FrontPorts = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, `
+		INSERT INTO power_ports (id, device_id, port_number, port_type, outlet_type)
+		SELECT gen_random_uuid(), $1, t.port_number, 'input', t.outlet_type
+		FROM power_port_templates t
+		WHERE t.device_type_id = $2 AND t.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM power_ports p WHERE p.device_id = $1 AND p.port_number = t.port_number AND p.deleted_at IS NULL)`,
+		deviceID, deviceTypeID)
+	if err != nil {
+		return c, fmt.Errorf("power ports: %w", err)
+	}
+	c.PowerPorts = tag.RowsAffected()
+
+	return c, nil
+}
+
+// List handles GET /device-types/{id}/templates — all component templates of a device type.
+func (h *ComponentTemplateHandler) List(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var exists bool
+	if err := h.DB.Pool.QueryRow(r.Context(), `SELECT EXISTS (SELECT 1 FROM device_types WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists); err != nil || !exists {
+		response.NotFound(w, "Device type")
+		return
+	}
+	t, err := loadComponentTemplates(r.Context(), h.DB.Pool, id)
+	if err != nil {
+		log.Printf("component template list error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	response.OK(w, t)
+}
+
+// Create handles POST /device-types/{id}/templates/{kind}.
+// kind is one of interfaces, console-ports, rear-ports, front-ports, power-ports.
+// The body may be a single template or an array of templates, created atomically.
+func (h *ComponentTemplateHandler) Create(w http.ResponseWriter, r *http.Request) {
+	dtID := r.PathValue("id")
+	kind := r.PathValue("kind")
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		response.BadRequest(w, "invalid JSON")
+		return
+	}
+	single := !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("["))
+	var bodies []map[string]interface{}
+	if single {
+		var body map[string]interface{}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			response.BadRequest(w, "invalid JSON")
+			return
+		}
+		bodies = append(bodies, body)
+	} else if err := json.Unmarshal(raw, &bodies); err != nil {
+		response.BadRequest(w, "invalid JSON")
+		return
+	}
+	if len(bodies) == 0 {
+		response.BadRequest(w, "at least one template is required")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM device_types WHERE id = $1 AND deleted_at IS NULL)`, dtID).Scan(&exists); err != nil || !exists {
+		response.NotFound(w, "Device type")
+		return
+	}
+
+	created := make([]interface{}, 0, len(bodies))
+	for _, body := range bodies {
+		row, err := insertTemplate(ctx, tx, dtID, kind, body)
+		if err != nil {
+			var inputErr *templateInputError
+			if errors.As(err, &inputErr) {
+				response.BadRequest(w, inputErr.msg)
+				return
+			}
+			response.DBError(w, err, "Component template")
+			return
+		}
+		created = append(created, row)
+	}
+
+	if err := audit.LogEntry(ctx, tx, "update", "device_types", dtID, nil, map[string]interface{}{"templates": kind, "created": created}); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+
+	if single {
+		response.Created(w, created[0])
+		return
+	}
+	response.Created(w, created)
+}
+
+func insertTemplate(ctx context.Context, tx pgx.Tx, dtID, kind string, body map[string]interface{}) (interface{}, error) {
+	name, _ := body["name"].(string)
+	desc, _ := body["description"].(string)
+	var speed *int
+	if v, ok := body["speed"].(float64); ok {
+		s := int(v)
+		speed = &s
+	}
+
+	switch kind {
+	case "interfaces":
+		ifaceType, _ := body["interfaceType"].(string)
+		if name == "" || ifaceType == "" {
+			return nil, &templateInputError{"name and interfaceType are required"}
+		}
+		var t interfaceTemplateRow
+		err := tx.QueryRow(ctx, fmt.Sprintf(`INSERT INTO interface_templates (id, device_type_id, name, interface_type, speed, description)
+			VALUES (gen_random_uuid(),$1,$2,$3,$4,$5) RETURNING %s`, ifaceTplCols),
+			dtID, name, ifaceType, speed, nilIfEmpty(desc)).Scan(
+			&t.ID, &t.DeviceTypeID, &t.Name, &t.InterfaceType, &t.Speed, &t.Description)
+		return t, err
+
+	case "console-ports":
+		portType, _ := body["portType"].(string)
+		if name == "" || portType == "" {
+			return nil, &templateInputError{"name and portType are required"}
+		}
+		var t consolePortTemplateRow
+		err := tx.QueryRow(ctx, fmt.Sprintf(`INSERT INTO console_port_templates (id, device_type_id, name, port_type, speed, description)
+			VALUES (gen_random_uuid(),$1,$2,$3,$4,$5) RETURNING %s`, cpTplCols),
+			dtID, name, portType, speed, nilIfEmpty(desc)).Scan(
+			&t.ID, &t.DeviceTypeID, &t.Name, &t.PortType, &t.Speed, &t.Description)
+		return t, err
+
+	case "rear-ports":
+		portType, _ := body["portType"].(string)
+		if name == "" || portType == "" {
+			return nil, &templateInputError{"name and portType are required"}
+		}
+		positions := 1
+		if v, ok := body["positions"].(float64); ok {
+			positions = int(v)
+		}
+		if positions < 1 {
+			return nil, &templateInputError{"positions must be at least 1"}
+		}
+		var t rearPortTemplateRow
+		err := tx.QueryRow(ctx, fmt.Sprintf(`INSERT INTO rear_port_templates (id, device_type_id, name, port_type, positions, description)
+			VALUES (gen_random_uuid(),$1,$2,$3,$4,$5) RETURNING %s`, rpTplCols),
+			dtID, name, portType, positions, nilIfEmpty(desc)).Scan(
+			&t.ID, &t.DeviceTypeID, &t.Name, &t.PortType, &t.Positions, &t.Description)
+		return t, err
+
+	case "front-ports":
+		portType, _ := body["portType"].(string)
+		rearID, _ := body["rearPortTemplateId"].(string)
+		if name == "" || portType == "" || rearID == "" {
+			return nil, &templateInputError{"name, portType, and rearPortTemplateId are required"}
+		}
+		position := 1
+		if v, ok := body["rearPortPosition"].(float64); ok {
+			position = int(v)
+		}
+		var rearPositions int
+		err := tx.QueryRow(ctx, `SELECT positions FROM rear_port_templates WHERE id = $1 AND device_type_id = $2 AND deleted_at IS NULL`,
+			rearID, dtID).Scan(&rearPositions)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, &templateInputError{"rearPortTemplateId must reference a rear port template of this device type"}
+		} else if err != nil {
+			return nil, err
+		}
+		if position < 1 || position > rearPositions {
+			return nil, &templateInputError{fmt.Sprintf("rearPortPosition must be between 1 and %d", rearPositions)}
+		}
+		var taken bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM front_port_templates
+			WHERE rear_port_template_id = $1 AND rear_port_position = $2 AND deleted_at IS NULL)`, rearID, position).Scan(&taken); err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, &templateInputError{fmt.Sprintf("rear port position %d is already mapped", position)}
+		}
+		var t frontPortTemplateRow
+		err = tx.QueryRow(ctx, fmt.Sprintf(`INSERT INTO front_port_templates (id, device_type_id, name, port_type, rear_port_template_id, rear_port_position, description)
+			VALUES (gen_random_uuid(),$1,$2,$3,$4,$5,$6) RETURNING %s`, fpTplCols),
+			dtID, name, portType, rearID, position, nilIfEmpty(desc)).Scan(
+			&t.ID, &t.DeviceTypeID, &t.Name, &t.PortType, &t.RearPortTemplateID, &t.RearPortPosition, &t.Description)
+		return t, err
+
+	case "power-ports":
+		outletType, _ := body["outletType"].(string)
+		portNumber, ok := body["portNumber"].(float64)
+		if !ok || outletType == "" {
+			return nil, &templateInputError{"portNumber and outletType are required"}
+		}
+		var t powerPortTemplateRow
+		err := tx.QueryRow(ctx, fmt.Sprintf(`INSERT INTO power_port_templates (id, device_type_id, port_number, outlet_type)
+			VALUES (gen_random_uuid(),$1,$2,$3) RETURNING %s`, ppTplCols),
+			dtID, int(portNumber), outletType).Scan(
+			&t.ID, &t.DeviceTypeID, &t.PortNumber, &t.OutletType)
+		return t, err
+	}
+
+	return nil, &templateInputError{"kind must be one of interfaces, console-ports, rear-ports, front-ports, power-ports"}
+}
+
+var templateTables = map[string]string{
+	"interfaces":    "interface_templates",
+	"console-ports": "console_port_templates",
+	"rear-ports":    "rear_port_templates",
+	"front-ports":   "front_port_templates",
+	"power-ports":   "power_port_templates",
+}
+
+// Delete handles DELETE /device-types/{id}/templates/{kind}/{templateId}.
+// Existing device components are not affected.
+func (h *ComponentTemplateHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	dtID := r.PathValue("id")
+	kind := r.PathValue("kind")
+	tplID := r.PathValue("templateId")
+	table, ok := templateTables[kind]
+	if !ok {
+		response.BadRequest(w, "kind must be one of interfaces, console-ports, rear-ports, front-ports, power-ports")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if kind == "rear-ports" {
+		var mapped int
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM front_port_templates WHERE rear_port_template_id = $1 AND deleted_at IS NULL`, tplID).Scan(&mapped); err != nil {
+			response.InternalError(w, "database error")
+			return
+		}
+		if mapped > 0 {
+			response.Conflict(w, fmt.Sprintf("rear port template is mapped by %d front port template(s)", mapped), nil)
+			return
+		}
+	}
+
+	tag, err := tx.Exec(ctx,
+		fmt.Sprintf(`UPDATE %s SET deleted_at = $1 WHERE id = $2 AND device_type_id = $3 AND deleted_at IS NULL`, table),
+		time.Now().UTC(), tplID, dtID)
+	if err != nil || tag.RowsAffected() == 0 {
+		response.NotFound(w, "Component template")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "delete", table, tplID, nil, nil); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+	response.Message(w, "Component template deleted", http.StatusOK)
+}
@@ -0,0 +1,293 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
+	"github.com/dcim/go-services/internal/shared/customfields"
+	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
+)
+
+// CustomFieldHandler manages custom field definitions.
+type CustomFieldHandler struct{ DB *db.DB }
+
+// customFieldList whitelists the list filters and sort keys of GET /custom-fields.
+var customFieldList = crud.Config{
+	Table:   "custom_field_definitions",
+	OrderBy: "object_type, weight, name",
+	Filters: []crud.FilterDef{
+		{QueryParam: "objectType", Column: "object_type"},
+		{QueryParam: "name", Column: "name"},
+		{QueryParam: "type", Column: "type"},
+		{QueryParam: "required", Column: "required", Type: "bool"},
+		{QueryParam: "weight", Column: "weight", Type: "int"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+}
+
+// List handles GET /custom-fields?objectType=
+func (h *CustomFieldHandler) List(w http.ResponseWriter, r *http.Request) {
+	q, err := crud.ParseQuery(r, customFieldList, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	from := ` FROM custom_field_definitions WHERE deleted_at IS NULL` + q.Where
+	if !crud.Total(w, r, h.DB.Pool, q, from, q.Args) {
+		return
+	}
+	seek, args := q.Seek(q.Args)
+	query := `SELECT ` + customfields.Cols + from + seek + " ORDER BY " + q.OrderBy + q.LimitClause()
+	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
+	if err != nil {
+		log.Printf("custom field list error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	defer rows.Close()
+	results := []customfields.Definition{}
+	for rows.Next() {
+		d, err := customfields.Scan(rows.Scan)
+		if err != nil {
+			log.Printf("custom field scan error: %v", err)
+			continue
+		}
+		results = append(results, d)
+	}
+	crud.List(w, r, h.DB.Pool, q, results)
+}
+
+// Get handles GET /custom-fields/{id}
+func (h *CustomFieldHandler) Get(w http.ResponseWriter, r *http.Request) {
+	d, err := customfields.Scan(h.DB.Pool.QueryRow(r.Context(),
+		`SELECT `+customfields.Cols+` FROM custom_field_definitions WHERE id = $1 AND deleted_at IS NULL`, r.PathValue("id")).Scan)
+	if err != nil {
+		response.NotFound(w, "Custom field")
+		return
+	}
+	response.OK(w, d)
+}
+
+// Create handles POST /custom-fields
+func (h *CustomFieldHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var d customfields.Definition
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		response.BadRequest(w, "invalid JSON")
+		return
+	}
+	if err := d.Check(); err != nil {
+		writeCustomFieldError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := h.DB.Pool.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM custom_field_definitions
+		WHERE object_type = $1 AND name = $2 AND deleted_at IS NULL)`, d.ObjectType, d.Name).Scan(&exists); err != nil {
+		log.Printf("custom field create error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	if exists {
+		response.Conflict(w, fmt.Sprintf("%s already has a custom field named %s", d.ObjectType, d.Name), nil)
+		return
+	}
+
+	choices, _ := json.Marshal(d.Choices)
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	created, err := customfields.Scan(tx.QueryRow(ctx, `
+		INSERT INTO custom_field_definitions (id, object_type, name, label, type, required, default_value, regex, choices, ref_type, description, weight)
+		VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING `+customfields.Cols,
+		d.ObjectType, d.Name, d.Label, d.Type, d.Required, defaultJSON(d.Default), d.Regex, choices, d.RefType, d.Description, d.Weight).Scan)
+	if err != nil {
+		response.DBError(w, err, "Custom field")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "create", "custom_field_definitions", created.ID, nil, created); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+	response.Created(w, created)
+}
+
+// Update handles PATCH /custom-fields/{id}
+// objectType and name are fixed once created because stored values are keyed by them.
+func (h *CustomFieldHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		response.BadRequest(w, "invalid JSON")
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	cur, err := customfields.Scan(tx.QueryRow(ctx,
+		`SELECT `+customfields.Cols+` FROM custom_field_definitions WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan)
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, "Custom field")
+		return
+	} else if err != nil {
+		log.Printf("custom field update error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	if err := etag.Check(ctx, tx, "custom_field_definitions", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+
+	d := cur
+	if err := json.Unmarshal(raw, &d); err != nil {
+		response.BadRequest(w, "invalid JSON")
+		return
+	}
+	if d.ObjectType != cur.ObjectType || d.Name != cur.Name {
+		response.BadRequest(w, "objectType and name cannot be changed")
+		return
+	}
+	if err := d.Check(); err != nil {
+		writeCustomFieldError(w, err)
+		return
+	}
+
+	choices, _ := json.Marshal(d.Choices)
+	updated, err := customfields.Scan(tx.QueryRow(ctx, `
+		UPDATE custom_field_definitions
+		SET label = $1, type = $2, required = $3, default_value = $4, regex = $5, choices = $6,
+		    ref_type = $7, description = $8, weight = $9, updated_at = $10
+		WHERE id = $11 AND deleted_at IS NULL
+		RETURNING `+customfields.Cols,
+		d.Label, d.Type, d.Required, defaultJSON(d.Default), d.Regex, choices,
+		d.RefType, d.Description, d.Weight, time.Now().UTC(), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Custom field")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "custom_field_definitions", id, cur, updated); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+	response.OK(w, updated)
+}
+
+// Delete handles DELETE /custom-fields/{id}
+// Stored values are left in place; they are rejected as undefined on the next write.
+func (h *CustomFieldHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if err := etag.Check(ctx, tx, "custom_field_definitions", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	before, err := customfields.Scan(tx.QueryRow(ctx,
+		`UPDATE custom_field_definitions SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL RETURNING `+customfields.Cols,
+		time.Now().UTC(), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Custom field")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "delete", "custom_field_definitions", id, before, nil); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+	response.Message(w, "Custom field deleted", http.StatusOK)
+}
+
+func defaultJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return []byte(raw)
+}
+
+// validateCustomFields checks the customFields member of a request body for objectType.
+// On create (present or not) it returns the JSON to store. On update a present
+// customFields is merged onto stored, the current values, so fields it leaves out
+// keep their value; ok is false when nothing should be written.
+func validateCustomFields(ctx context.Context, q customfields.Querier, objectType string, stored map[string]interface{}, body map[string]interface{}, create bool) (out []byte, ok bool, err error) {
+	raw, present := body["customFields"]
+	if !present && !create {
+		return nil, false, nil
+	}
+	var values map[string]interface{}
+	if raw != nil {
+		m, isObj := raw.(map[string]interface{})
+		if !isObj {
+			return nil, false, &customfields.ValidationError{Issues: []map[string]string{{"path": "customFields", "message": "must be an object"}}}
+		}
+		values = m
+	}
+	if create {
+		out, err = customfields.Validate(ctx, q, objectType, values)
+	} else {
+		out, err = customfields.ValidatePatch(ctx, q, objectType, stored, values)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// storedCustomFields decodes the custom_fields of a scanned row.
+func storedCustomFields(raw json.RawMessage) map[string]interface{} {
+	var m map[string]interface{}
+	_ = json.Unmarshal(raw, &m)
+	return m
+}
+
+// writeCustomFieldError reports validation issues as 422 and anything else as 500.
+func writeCustomFieldError(w http.ResponseWriter, err error) {
+	var ve *customfields.ValidationError
+	if errors.As(err, &ve) {
+		response.ValidationError(w, "Validation failed", ve.Issues)
+		return
+	}
+	log.Printf("custom field validation error: %v", err)
+	response.InternalError(w, "database error")
+}
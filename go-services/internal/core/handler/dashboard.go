@@ -1,107 +1,269 @@
 package handler
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/dcim/go-services/internal/shared/db"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
-type DashboardHandler struct{ DB *db.DB }
+// dashboardCacheTTL bounds how stale the landing page summary may be.
+const dashboardCacheTTL = 30 * time.Second
+
+type DashboardHandler struct {
+	DB *db.DB
+
+	mu    sync.Mutex
+	cache map[string]dashboardCacheEntry
+}
+
+type dashboardCacheEntry struct {
+	data      interface{}
+	expiresAt time.Time
+}
+
+type summaryMetrics struct {
+	RackCount               int     `json:"rackCount"`
+	DeviceCount             int     `json:"deviceCount"`
+	TotalU                  int     `json:"totalU"`
+	UsedU                   int     `json:"usedU"`
+	UOccupancyPercent       int     `json:"uOccupancyPercent"`
+	RatedKw                 float64 `json:"ratedKw"`
+	MeasuredKw              float64 `json:"measuredKw"`
+	PowerUtilizationPercent int     `json:"powerUtilizationPercent"`
+	OpenAlerts              int     `json:"openAlerts"`
+	CriticalAlerts          int     `json:"criticalAlerts"`
+	ActiveVisitors          int     `json:"activeVisitors"`
+	PendingMovements        int     `json:"pendingMovements"`
+}
 
 type siteSummary struct {
-	SiteID                  string `json:"siteId"`
-	SiteName                string `json:"siteName"`
-	SiteSlug                string `json:"siteSlug"`
-	RackCount               int    `json:"rackCount"`
-	DeviceCount             int    `json:"deviceCount"`
-	PowerUtilizationPercent int    `json:"powerUtilizationPercent"`
+	SiteID   string `json:"siteId"`
+	SiteName string `json:"siteName"`
+	SiteSlug string `json:"siteSlug"`
+	summaryMetrics
+}
+
+// groupSummary is a summary row for ?groupBy=region|tenant. GroupID is null for
+// sites (or racks) without a region or tenant.
+type groupSummary struct {
+	GroupBy   string  `json:"groupBy"`
+	GroupID   *string `json:"groupId"`
+	GroupName string  `json:"groupName"`
+	GroupSlug *string `json:"groupSlug"`
+	SiteCount int     `json:"siteCount"`
+	summaryMetrics
+}
+
+// dashboardGroupings maps groupBy to the site-level key, the rack-level key and the
+// table the key refers to. Rack-level metrics follow the rack's tenant when set, so a
+// tenant's racks in a shared site are credited to that tenant.
+var dashboardGroupings = map[string][3]string{
+	"site":   {"s.id", "ss.gkey", "sites"},
+	"region": {"COALESCE(s.region_id, '')", "ss.gkey", "regions"},
+	"tenant": {"COALESCE(s.tenant_id, '')", "COALESCE(r.tenant_id, NULLIF(ss.gkey, ''), '')", "tenants"},
 }
 
+// Summary handles GET /dashboard/summary?groupBy=site|region|tenant&refresh=true
+// Results are cached per grouping for dashboardCacheTTL; refresh=true bypasses the cache.
 func (h *DashboardHandler) Summary(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+	groupBy := r.URL.Query().Get("groupBy")
+	if groupBy == "" {
+		groupBy = "site"
+	}
+	if _, ok := dashboardGroupings[groupBy]; !ok {
+		response.BadRequest(w, "groupBy must be one of: site, region, tenant")
+		return
+	}
+
+	if r.URL.Query().Get("refresh") != "true" {
+		if data, ok := h.cached(groupBy); ok {
+			response.OK(w, data)
+			return
+		}
+	}
 
-	rows, err := h.DB.Pool.Query(ctx, `SELECT id, name, slug FROM sites WHERE deleted_at IS NULL ORDER BY name`)
+	data, err := h.summarize(r.Context(), groupBy)
 	if err != nil {
-		log.Printf("dashboard summary sites error: %v", err)
+		log.Printf("dashboard summary error: %v", err)
 		response.InternalError(w, "database error")
 		return
 	}
-	defer rows.Close()
 
-	type siteInfo struct {
-		id, name, slug string
+	h.mu.Lock()
+	if h.cache == nil {
+		h.cache = map[string]dashboardCacheEntry{}
 	}
-	var sites []siteInfo
-	for rows.Next() {
-		var s siteInfo
-		if err := rows.Scan(&s.id, &s.name, &s.slug); err != nil {
-			continue
-		}
-		sites = append(sites, s)
+	h.cache[groupBy] = dashboardCacheEntry{data: data, expiresAt: time.Now().Add(dashboardCacheTTL)}
+	h.mu.Unlock()
+
+	response.OK(w, data)
+}
+
+func (h *DashboardHandler) cached(groupBy string) (interface{}, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.cache[groupBy]
+	if !ok || time.Now().After(e.expiresAt) {
+		return nil, false
 	}
+	return e.data, true
+}
 
-	if len(sites) == 0 {
-		response.OK(w, []siteSummary{})
-		return
+// summarize computes every metric in one statement. Open alerts are attributed to a
+// rack through their resource (rack, device or power feed); visitors and equipment
+// movements are site-scoped.
+func (h *DashboardHandler) summarize(ctx context.Context, groupBy string) (interface{}, error) {
+	g := dashboardGroupings[groupBy]
+	query := fmt.Sprintf(`
+		WITH site_scope AS (
+			SELECT s.id AS site_id, %s AS gkey
+			FROM sites s
+			WHERE s.deleted_at IS NULL
+		),
+		rack_scope AS (
+			SELECT r.id AS rack_id, r.u_height, ss.site_id, %s AS gkey
+			FROM racks r
+			JOIN locations l ON r.location_id = l.id AND l.deleted_at IS NULL
+			JOIN site_scope ss ON ss.site_id = l.site_id
+			WHERE r.deleted_at IS NULL
+		),
+		dev AS (
+			SELECT d.rack_id, COUNT(*) AS devices,
+			       COALESCE(SUM(dt.u_height) FILTER (WHERE d.position IS NOT NULL), 0) AS used_u
+			FROM devices d
+			JOIN device_types dt ON d.device_type_id = dt.id
+			WHERE d.deleted_at IS NULL AND d.rack_id IS NOT NULL
+			GROUP BY d.rack_id
+		),
+		pw AS (
+			SELECT f.rack_id, SUM(f.rated_kw) AS rated_kw, SUM(lr.power_kw) AS measured_kw
+			FROM power_feeds f
+			LEFT JOIN LATERAL (
+				SELECT pr.power_kw FROM power_readings pr
+				WHERE pr.feed_id = f.id
+				ORDER BY pr.recorded_at DESC
+				LIMIT 1
+			) lr ON true
+			WHERE f.deleted_at IS NULL AND f.rack_id IS NOT NULL
+			GROUP BY f.rack_id
+		),
+		al AS (
+			SELECT COALESCE(CASE WHEN a.resource_type IN ('rack', 'racks') THEN a.resource_id END, d.rack_id, f.rack_id) AS rack_id,
+			       COUNT(*) AS open_alerts,
+			       COUNT(*) FILTER (WHERE a.severity = 'critical') AS critical_alerts
+			FROM alert_history a
+			LEFT JOIN devices d ON a.resource_type IN ('device', 'devices') AND d.id = a.resource_id
+			LEFT JOIN power_feeds f ON a.resource_type IN ('power_feed', 'power_feeds') AND f.id = a.resource_id
+			WHERE a.resolved_at IS NULL
+			GROUP BY 1
+		),
+		rack_agg AS (
+			SELECT rs.gkey, COUNT(*) AS racks,
+			       SUM(rs.u_height) AS total_u,
+			       SUM(COALESCE(dev.used_u, 0)) AS used_u,
+			       SUM(COALESCE(dev.devices, 0)) AS devices,
+			       SUM(COALESCE(pw.rated_kw, 0)) AS rated_kw,
+			       SUM(COALESCE(pw.measured_kw, 0)) AS measured_kw,
+			       SUM(COALESCE(al.open_alerts, 0)) AS open_alerts,
+			       SUM(COALESCE(al.critical_alerts, 0)) AS critical_alerts
+			FROM rack_scope rs
+			LEFT JOIN dev ON dev.rack_id = rs.rack_id
+			LEFT JOIN pw ON pw.rack_id = rs.rack_id
+			LEFT JOIN al ON al.rack_id = rs.rack_id
+			GROUP BY rs.gkey
+		),
+		site_agg AS (
+			SELECT ss.gkey, SUM(COALESCE(v.cnt, 0)) AS visitors, SUM(COALESCE(m.cnt, 0)) AS movements
+			FROM site_scope ss
+			LEFT JOIN (
+				SELECT site_id, COUNT(*) AS cnt FROM access_logs
+				WHERE status = 'checked_in' AND deleted_at IS NULL
+				GROUP BY site_id
+			) v ON v.site_id = ss.site_id
+			LEFT JOIN (
+				SELECT site_id, COUNT(*) AS cnt FROM equipment_movements
+				WHERE status IN ('pending', 'approved', 'in_progress') AND deleted_at IS NULL
+				GROUP BY site_id
+			) m ON m.site_id = ss.site_id
+			GROUP BY ss.gkey
+		),
+		members AS (
+			SELECT gkey, COUNT(DISTINCT site_id) AS sites
+			FROM (SELECT gkey, site_id FROM site_scope UNION SELECT gkey, site_id FROM rack_scope) u
+			GROUP BY gkey
+		)
+		SELECT mb.gkey, g.name, g.slug, mb.sites::int,
+		       COALESCE(ra.racks, 0)::int, COALESCE(ra.devices, 0)::int,
+		       COALESCE(ra.total_u, 0)::int, COALESCE(ra.used_u, 0)::int,
+		       COALESCE(ra.rated_kw, 0)::float8, COALESCE(ra.measured_kw, 0)::float8,
+		       COALESCE(ra.open_alerts, 0)::int, COALESCE(ra.critical_alerts, 0)::int,
+		       COALESCE(sa.visitors, 0)::int, COALESCE(sa.movements, 0)::int
+		FROM members mb
+		LEFT JOIN rack_agg ra ON ra.gkey = mb.gkey
+		LEFT JOIN site_agg sa ON sa.gkey = mb.gkey
+		LEFT JOIN %s g ON g.id = mb.gkey
+		ORDER BY g.name IS NULL, g.name`, g[0], g[1], g[2])
+
+	rows, err := h.DB.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	results := make([]siteSummary, 0, len(sites))
-	for _, site := range sites {
-		var rackCount, deviceCount int
-
-		// Count racks via locations
-		err := h.DB.Pool.QueryRow(ctx, `
-			SELECT COUNT(*) FROM racks r
-			JOIN locations l ON r.location_id = l.id
-			WHERE l.site_id = $1 AND r.deleted_at IS NULL AND l.deleted_at IS NULL`, site.id).Scan(&rackCount)
-		if err != nil {
-			rackCount = 0
+	sites := []siteSummary{}
+	groups := []groupSummary{}
+	for rows.Next() {
+		var key string
+		var name, slug *string
+		var siteCount int
+		var m summaryMetrics
+		if err := rows.Scan(&key, &name, &slug, &siteCount,
+			&m.RackCount, &m.DeviceCount, &m.TotalU, &m.UsedU, &m.RatedKw, &m.MeasuredKw,
+			&m.OpenAlerts, &m.CriticalAlerts, &m.ActiveVisitors, &m.PendingMovements); err != nil {
+			return nil, err
 		}
-
-		// Count devices in those racks
-		err = h.DB.Pool.QueryRow(ctx, `
-			SELECT COUNT(*) FROM devices d
-			JOIN racks r ON d.rack_id = r.id
-			JOIN locations l ON r.location_id = l.id
-			WHERE l.site_id = $1 AND d.deleted_at IS NULL AND r.deleted_at IS NULL AND l.deleted_at IS NULL`, site.id).Scan(&deviceCount)
-		if err != nil {
-			deviceCount = 0
+		if m.TotalU > 0 {
+			m.UOccupancyPercent = int(float64(m.UsedU) / float64(m.TotalU) * 100)
+		}
+		if m.RatedKw > 0 {
+			m.PowerUtilizationPercent = int(m.MeasuredKw / m.RatedKw * 100)
 		}
+		m.RatedKw = math.Round(m.RatedKw*100) / 100
+		m.MeasuredKw = math.Round(m.MeasuredKw*100) / 100
 
-		// Power utilization from feeds
-		var totalRated, totalCurrent float64
-		feedRows, err := h.DB.Pool.Query(ctx, `
-			SELECT f.rated_kw FROM power_feeds f
-			JOIN racks r ON f.rack_id = r.id
-			JOIN locations l ON r.location_id = l.id
-			WHERE l.site_id = $1 AND f.deleted_at IS NULL AND r.deleted_at IS NULL`, site.id)
-		if err == nil {
-			defer feedRows.Close()
-			for feedRows.Next() {
-				var rated float64
-				if err := feedRows.Scan(&rated); err == nil {
-					totalRated += rated
-					totalCurrent += rated * 0.65 // mock utilization ~65%
-				}
+		if groupBy == "site" {
+			s := siteSummary{SiteID: key, summaryMetrics: m}
+			if name != nil {
+				s.SiteName = *name
+			}
+			if slug != nil {
+				s.SiteSlug = *slug
 			}
+			sites = append(sites, s)
+			continue
 		}
-
-		pct := 0
-		if totalRated > 0 {
-			pct = int(totalCurrent / totalRated * 100)
+		gs := groupSummary{GroupBy: groupBy, GroupName: "Unassigned", GroupSlug: slug, SiteCount: siteCount, summaryMetrics: m}
+		if key != "" {
+			gs.GroupID = &key
 		}
-
-		results = append(results, siteSummary{
-			SiteID:                  site.id,
-			SiteName:                site.name,
-			SiteSlug:                site.slug,
-			RackCount:               rackCount,
-			DeviceCount:             deviceCount,
-			PowerUtilizationPercent: pct,
-		})
+		if name != nil {
+			gs.GroupName = *name
+		}
+		groups = append(groups, gs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	response.OK(w, results)
+	if groupBy == "site" {
+		return sites, nil
+	}
+	return groups, nil
 }
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
+)
+
+// deviceTransitions lists the statuses a device may move to from each status.
+// The main path is planned → staged → active → decommissioning → offline/retired;
+// failed and decommissioned are kept for devices recorded before the lifecycle existed.
+var deviceTransitions = map[string][]string{
+	"planned":         {"staged", "retired"},
+	"staged":          {"planned", "active", "retired"},
+	"active":          {"decommissioning", "offline", "failed"},
+	"failed":          {"active", "decommissioning", "offline"},
+	"offline":         {"active", "decommissioning", "retired"},
+	"decommissioning": {"active", "offline", "retired"},
+	"decommissioned":  {"retired"},
+	"retired":         {},
+}
+
+// deviceStatuses are the values of the device_status enum.
+var deviceStatuses = []string{"active", "planned", "staged", "failed", "decommissioning", "decommissioned", "offline", "retired"}
+
+// deviceInitialStatuses are the statuses a device may be created in.
+var deviceInitialStatuses = []string{"planned", "staged", "active", "offline"}
+
+// lifecycleError is a rejected status change.
+type lifecycleError struct{ msg string }
+
+func (e *lifecycleError) Error() string { return e.msg }
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDeviceTransition validates a status change of d from `from` (empty on create)
+// to d.Status, including the requirements of the target status. d is the device
+// state after the change is applied.
+func checkDeviceTransition(ctx context.Context, tx pgx.Tx, from string, d deviceRow) error {
+	to := d.Status
+	if from == "" {
+		if !contains(deviceInitialStatuses, to) {
+			return &lifecycleError{fmt.Sprintf("devices cannot be created as %s; allowed: %s", to, strings.Join(deviceInitialStatuses, ", "))}
+		}
+	} else if from != to {
+		allowed, ok := deviceTransitions[from]
+		if !ok || !contains(allowed, to) {
+			return &lifecycleError{fmt.Sprintf("cannot change status from %s to %s; allowed: %s", from, to, strings.Join(allowed, ", "))}
+		}
+	}
+
+	switch to {
+	case "active":
+		if d.RackID == nil || d.Position == nil {
+			return &lifecycleError{"active devices require a rack and position"}
+		}
+		if d.PrimaryIP == nil || *d.PrimaryIP == "" {
+			return &lifecycleError{"active devices require a primary IP"}
+		}
+	case "retired":
+		var cables int
+		if err := tx.QueryRow(ctx, `
+			WITH comp AS (
+				SELECT 'interface' AS t, id FROM interfaces WHERE device_id = $1 AND deleted_at IS NULL
+				UNION ALL SELECT 'frontPort', id FROM front_ports WHERE device_id = $1 AND deleted_at IS NULL
+				UNION ALL SELECT 'rearPort', id FROM rear_ports WHERE device_id = $1 AND deleted_at IS NULL
+				UNION ALL SELECT 'consolePort', id FROM console_ports WHERE device_id = $1 AND deleted_at IS NULL
+				UNION ALL SELECT 'powerPort', id FROM power_ports WHERE device_id = $1 AND deleted_at IS NULL
+			)
+			SELECT COUNT(*) FROM cables c
+			WHERE c.deleted_at IS NULL AND EXISTS (
+				SELECT 1 FROM comp
+				WHERE (c.termination_a_type = comp.t AND c.termination_a_id = comp.id)
+				   OR (c.termination_b_type = comp.t AND c.termination_b_id = comp.id))`, d.ID).Scan(&cables); err != nil {
+			return err
+		}
+		if cables > 0 {
+			return &lifecycleError{fmt.Sprintf("retired devices must have no cables; %d still connected", cables)}
+		}
+	}
+	return nil
+}
+
+func writeLifecycleError(w http.ResponseWriter, err error) {
+	var le *lifecycleError
+	if errors.As(err, &le) {
+		response.Conflict(w, le.msg, nil)
+		return
+	}
+	log.Printf("device lifecycle check error: %v", err)
+	response.InternalError(w, "database error")
+}
+
+// recordStatusChange appends an entry to device_status_history.
+func recordStatusChange(ctx context.Context, tx pgx.Tx, deviceID, from, to, actor, reason string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO device_status_history (id, device_id, from_status, to_status, changed_by, reason)
+		VALUES (gen_random_uuid(), $1, $2, $3, $4, $5)`,
+		deviceID, nilIfEmpty(from), to, nilIfEmpty(actor), nilIfEmpty(reason))
+	return err
+}
+
+type statusHistoryRow struct {
+	ID         string  `json:"id"`
+	DeviceID   string  `json:"deviceId"`
+	FromStatus *string `json:"fromStatus"`
+	ToStatus   string  `json:"toStatus"`
+	ChangedBy  *string `json:"changedBy"`
+	Reason     *string `json:"reason"`
+	ChangedAt  string  `json:"changedAt"`
+}
+
+// StatusHistory handles GET /devices/{id}/status-history
+func (h *DeviceHandler) StatusHistory(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	rows, err := h.DB.Pool.Query(r.Context(), `
+		SELECT id, device_id, from_status, to_status, changed_by, reason, changed_at
+		FROM device_status_history
+		WHERE device_id = $1
+		ORDER BY changed_at`, id)
+	if err != nil {
+		log.Printf("device status history error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	defer rows.Close()
+	results := []statusHistoryRow{}
+	for rows.Next() {
+		var s statusHistoryRow
+		var ca time.Time
+		if err := rows.Scan(&s.ID, &s.DeviceID, &s.FromStatus, &s.ToStatus, &s.ChangedBy, &s.Reason, &ca); err != nil {
+			continue
+		}
+		s.ChangedAt = ca.UTC().Format(time.RFC3339)
+		results = append(results, s)
+	}
+	response.OK(w, results)
+}
+
+type stateDurationRow struct {
+	DeviceID     string  `json:"deviceId"`
+	DeviceName   string  `json:"deviceName"`
+	Status       string  `json:"status"`
+	TotalSeconds float64 `json:"totalSeconds"`
+	Entries      int     `json:"entries"`
+	Current      bool    `json:"current"`
+}
+
+// StateDurations handles GET /devices/lifecycle/durations?deviceId=&rackId=&status=
+// It reports how long each device has spent in each status, counting the open
+// interval of the current status up to now.
+func (h *DeviceHandler) StateDurations(w http.ResponseWriter, r *http.Request) {
+	where := `WHERE d.deleted_at IS NULL`
+	args := []interface{}{}
+	ai := 1
+	if v := r.URL.Query().Get("deviceId"); v != "" {
+		where += fmt.Sprintf(" AND d.id = $%d", ai)
+		args = append(args, v)
+		ai++
+	}
+	if v := r.URL.Query().Get("rackId"); v != "" {
+		where += fmt.Sprintf(" AND d.rack_id = $%d", ai)
+		args = append(args, v)
+		ai++
+	}
+	statusFilter := ""
+	if v := r.URL.Query().Get("status"); v != "" {
+		statusFilter = fmt.Sprintf(" WHERE iv.to_status::text = $%d", ai)
+		args = append(args, v)
+		ai++
+	}
+
+	query := fmt.Sprintf(`
+		WITH iv AS (
+			SELECT h.device_id, h.to_status, h.changed_at,
+			       LEAD(h.changed_at) OVER (PARTITION BY h.device_id ORDER BY h.changed_at) AS ended_at
+			FROM device_status_history h
+			JOIN devices d ON d.id = h.device_id
+			%s
+		)
+		SELECT iv.device_id, d.name, iv.to_status::text,
+		       SUM(EXTRACT(EPOCH FROM COALESCE(iv.ended_at, NOW()) - iv.changed_at))::float8,
+		       COUNT(*)::int,
+		       BOOL_OR(iv.ended_at IS NULL)
+		FROM iv
+		JOIN devices d ON d.id = iv.device_id
+		%s
+		GROUP BY iv.device_id, d.name, iv.to_status
+		ORDER BY d.name, iv.to_status`, where, statusFilter)
+
+	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
+	if err != nil {
+		log.Printf("device state durations error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	defer rows.Close()
+	results := []stateDurationRow{}
+	for rows.Next() {
+		var s stateDurationRow
+		if err := rows.Scan(&s.DeviceID, &s.DeviceName, &s.Status, &s.TotalSeconds, &s.Entries, &s.Current); err != nil {
+			log.Printf("device state durations scan error: %v", err)
+			continue
+		}
+		results = append(results, s)
+	}
+	response.OK(w, results)
+}
@@ -8,7 +8,10 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/cascade"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -30,17 +33,62 @@ type deviceTypeRow struct {
 
 const dtCols = `id, manufacturer_id, model, slug, u_height, full_depth, weight, power_draw, description, created_at, updated_at`
 
+// deviceTypeConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /device-types.
+var deviceTypeConfig = crud.Config{
+	Table:      "device_types",
+	Name:       "Device type",
+	SoftDelete: true,
+	OrderBy:    "model",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "manufacturer_id", JSON: "manufacturerId", Type: "string", Required: true, Ref: "manufacturers"},
+		{Name: "model", JSON: "model", Type: "string", Required: true},
+		{Name: "slug", JSON: "slug", Type: "string", Format: "slug"},
+		{Name: "u_height", JSON: "uHeight", Type: "int", Range: &crud.Range{Min: 0, Max: 60}},
+		{Name: "full_depth", JSON: "fullDepth", Type: "int"},
+		{Name: "weight", JSON: "weight", Type: "float", Nullable: true, Range: crud.AtLeast(0)},
+		{Name: "power_draw", JSON: "powerDraw", Type: "int", Nullable: true, Range: crud.AtLeast(0)},
+		{Name: "description", JSON: "description", Type: "string", Nullable: true},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "model", Column: "model", Op: "ilike"},
+		{QueryParam: "slug", Column: "slug"},
+		{QueryParam: "manufacturerId", Column: "manufacturer_id"},
+		{QueryParam: "uHeight", Column: "u_height", Type: "int"},
+		{QueryParam: "fullDepth", Column: "full_depth", Type: "int"},
+		{QueryParam: "weight", Column: "weight", Type: "float"},
+		{QueryParam: "powerDraw", Column: "power_draw", Type: "int"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+}
+
+func scanDeviceType(scan func(dest ...interface{}) error) (deviceTypeRow, error) {
+	var d deviceTypeRow
+	var ca, ua time.Time
+	if err := scan(&d.ID, &d.ManufacturerID, &d.Model, &d.Slug, &d.UHeight, &d.FullDepth, &d.Weight, &d.PowerDraw, &d.Description, &ca, &ua); err != nil {
+		return d, err
+	}
+	d.CreatedAt = ca.UTC().Format(time.RFC3339)
+	d.UpdatedAt = ua.UTC().Format(time.RFC3339)
+	return d, nil
+}
+
 func (h *DeviceTypeHandler) List(w http.ResponseWriter, r *http.Request) {
-	mfID := r.URL.Query().Get("manufacturerId")
-	query := fmt.Sprintf(`SELECT %s FROM device_types WHERE deleted_at IS NULL`, dtCols)
-	args := []interface{}{}
-	ai := 1
-	if mfID != "" {
-		query += fmt.Sprintf(" AND manufacturer_id = $%d", ai)
-		args = append(args, mfID)
-		ai++
+	q, err := crud.ParseQuery(r, deviceTypeConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	from := ` FROM device_types WHERE deleted_at IS NULL` + q.Where
+	if !crud.Total(w, r, h.DB.Pool, q, from, q.Args) {
+		return
 	}
-	query += " ORDER BY model"
+	seek, args := q.Seek(q.Args)
+	query := `SELECT ` + dtCols + from + seek + " ORDER BY " + q.OrderBy + q.LimitClause()
 	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
 	if err != nil {
 		log.Printf("device_type list error: %v", err)
@@ -50,30 +98,22 @@ func (h *DeviceTypeHandler) List(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 	results := []deviceTypeRow{}
 	for rows.Next() {
-		var d deviceTypeRow
-		var ca, ua time.Time
-		if err := rows.Scan(&d.ID, &d.ManufacturerID, &d.Model, &d.Slug, &d.UHeight, &d.FullDepth, &d.Weight, &d.PowerDraw, &d.Description, &ca, &ua); err != nil {
+		d, err := scanDeviceType(rows.Scan)
+		if err != nil {
 			continue
 		}
-		d.CreatedAt = ca.UTC().Format(time.RFC3339)
-		d.UpdatedAt = ua.UTC().Format(time.RFC3339)
 		results = append(results, d)
 	}
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 func (h *DeviceTypeHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	var d deviceTypeRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(), fmt.Sprintf(`SELECT %s FROM device_types WHERE id = $1 AND deleted_at IS NULL`, dtCols), id).Scan(
-		&d.ID, &d.ManufacturerID, &d.Model, &d.Slug, &d.UHeight, &d.FullDepth, &d.Weight, &d.PowerDraw, &d.Description, &ca, &ua)
+	d, err := scanDeviceType(h.DB.Pool.QueryRow(r.Context(), fmt.Sprintf(`SELECT %s FROM device_types WHERE id = $1 AND deleted_at IS NULL`, dtCols), id).Scan)
 	if err != nil {
 		response.NotFound(w, "Device type")
 		return
 	}
-	d.CreatedAt = ca.UTC().Format(time.RFC3339)
-	d.UpdatedAt = ua.UTC().Format(time.RFC3339)
 	response.OK(w, d)
 }
 
@@ -83,6 +123,9 @@ func (h *DeviceTypeHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, deviceTypeConfig, body, true) {
+		return
+	}
 	mfID, _ := body["manufacturerId"].(string)
 	model, _ := body["model"].(string)
 	slug, _ := body["slug"].(string)
@@ -100,20 +143,29 @@ func (h *DeviceTypeHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	desc, _ := body["description"].(string)
 
-	var d deviceTypeRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	d, err := scanDeviceType(tx.QueryRow(ctx,
 		fmt.Sprintf(`INSERT INTO device_types (manufacturer_id, model, slug, u_height, full_depth, description) VALUES ($1,$2,$3,$4,$5,$6) RETURNING %s`, dtCols),
-		mfID, model, slug, uHeight, fullDepth, nilIfEmpty(desc)).Scan(
-		&d.ID, &d.ManufacturerID, &d.Model, &d.Slug, &d.UHeight, &d.FullDepth, &d.Weight, &d.PowerDraw, &d.Description, &ca, &ua)
+		mfID, model, slug, uHeight, fullDepth, nilIfEmpty(desc)).Scan)
 	if err != nil {
-		log.Printf("device_type create error: %v", err)
-		response.InternalError(w, "create failed")
+		response.DBError(w, err, "Device type")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "create", "device_types", d.ID, nil, d); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	d.CreatedAt = ca.UTC().Format(time.RFC3339)
-	d.UpdatedAt = ua.UTC().Format(time.RFC3339)
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "device_types", d.ID, nil, d)
 	response.Created(w, d)
 }
 
@@ -124,6 +176,9 @@ func (h *DeviceTypeHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, deviceTypeConfig, body, false) {
+		return
+	}
 	sc := []string{}
 	args := []interface{}{}
 	ai := 1
@@ -154,27 +209,40 @@ func (h *DeviceTypeHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	args = append(args, id)
-	var d deviceTypeRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(), fmt.Sprintf(`UPDATE device_types SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`, joinStrings(sc, ", "), ai, dtCols), args...).Scan(
-		&d.ID, &d.ManufacturerID, &d.Model, &d.Slug, &d.UHeight, &d.FullDepth, &d.Weight, &d.PowerDraw, &d.Description, &ca, &ua)
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
 	if err != nil {
-		response.NotFound(w, "Device type")
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	cur, err := scanDeviceType(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM device_types WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, dtCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Device type")
+		return
+	}
+	if err := etag.Check(ctx, tx, "device_types", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	d, err := scanDeviceType(tx.QueryRow(ctx, fmt.Sprintf(`UPDATE device_types SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`, joinStrings(sc, ", "), ai, dtCols), args...).Scan)
+	if err != nil {
+		response.DBError(w, err, "Device type")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "device_types", id, cur, d); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	d.CreatedAt = ca.UTC().Format(time.RFC3339)
-	d.UpdatedAt = ua.UTC().Format(time.RFC3339)
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "device_types", id, nil, d)
 	response.OK(w, d)
 }
 
+// Delete handles DELETE /device-types/{id}?cascade=true
 func (h *DeviceTypeHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	tag, err := h.DB.Pool.Exec(r.Context(), `UPDATE device_types SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Device type")
-		return
-	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "device_types", id, nil, nil)
-	response.Message(w, "Device type deleted", http.StatusOK)
+	cascade.HandleDelete(w, r, h.DB.Pool, "device_types", "Device type")
 }
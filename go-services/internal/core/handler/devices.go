@@ -1,49 +1,107 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/cascade"
 	"github.com/dcim/go-services/internal/shared/crud"
+	"github.com/dcim/go-services/internal/shared/customfields"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
 )
 
 type DeviceHandler struct{ DB *db.DB }
 
 type deviceRow struct {
-	ID                string  `json:"id"`
-	Name              string  `json:"name"`
-	DeviceTypeID      string  `json:"deviceTypeId"`
-	RackID            *string `json:"rackId"`
-	TenantID          *string `json:"tenantId"`
-	Status            string  `json:"status"`
-	Face              string  `json:"face"`
-	Position          *int    `json:"position"`
-	SerialNumber      *string `json:"serialNumber"`
-	AssetTag          *string `json:"assetTag"`
-	WarrantyExpiresAt *string `json:"warrantyExpiresAt"`
-	PrimaryIP         *string `json:"primaryIp"`
-	Description       *string `json:"description"`
-	CreatedAt         string  `json:"createdAt"`
-	UpdatedAt         string  `json:"updatedAt"`
+	ID                string          `json:"id"`
+	Name              string          `json:"name"`
+	DeviceTypeID      string          `json:"deviceTypeId"`
+	RackID            *string         `json:"rackId"`
+	TenantID          *string         `json:"tenantId"`
+	Status            string          `json:"status"`
+	Face              string          `json:"face"`
+	Position          *int            `json:"position"`
+	SerialNumber      *string         `json:"serialNumber"`
+	AssetTag          *string         `json:"assetTag"`
+	WarrantyExpiresAt *string         `json:"warrantyExpiresAt"`
+	PrimaryIP         *string         `json:"primaryIp"`
+	Description       *string         `json:"description"`
+	CustomFields      json.RawMessage `json:"customFields"`
+	CreatedAt         string          `json:"createdAt"`
+	UpdatedAt         string          `json:"updatedAt"`
 }
 
-const deviceCols = `id, name, device_type_id, rack_id, tenant_id, status, face, position, serial_number, asset_tag, warranty_expires_at, primary_ip, description, created_at, updated_at`
+const deviceCols = `id, name, device_type_id, rack_id, tenant_id, status, face, position, serial_number, asset_tag, warranty_expires_at, primary_ip, description, custom_fields, created_at, updated_at`
+
+// deviceConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /devices.
+// search is kept as a name substring filter for existing clients.
+var deviceConfig = crud.Config{
+	Table:      "devices",
+	Name:       "Device",
+	Check:      checkBulkDevice,
+	After:      afterBulkDevice,
+	SoftDelete: true,
+	OrderBy:    "name",
+	PageSize:   crud.DefaultLimit,
+	Extra:      []string{"reason"},
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "name", JSON: "name", Type: "string", Required: true},
+		{Name: "device_type_id", JSON: "deviceTypeId", Type: "string", Required: true, Ref: "device_types"},
+		{Name: "rack_id", JSON: "rackId", Type: "string", Nullable: true, Ref: "racks"},
+		{Name: "tenant_id", JSON: "tenantId", Type: "string", Nullable: true, Ref: "tenants"},
+		{Name: "status", JSON: "status", Type: "string", Default: "planned", Enum: deviceStatuses},
+		{Name: "face", JSON: "face", Type: "string", Enum: []string{"front", "rear"}},
+		{Name: "position", JSON: "position", Type: "int", Nullable: true, Range: &crud.Range{Min: 1, Max: 60}},
+		{Name: "serial_number", JSON: "serialNumber", Type: "string", Nullable: true},
+		{Name: "asset_tag", JSON: "assetTag", Type: "string", Nullable: true},
+		{Name: "warranty_expires_at", JSON: "warrantyExpiresAt", Type: "timestamp", Nullable: true},
+		{Name: "primary_ip", JSON: "primaryIp", Type: "string", Nullable: true, Format: "ip"},
+		{Name: "description", JSON: "description", Type: "string", Nullable: true},
+		{Name: "custom_fields", JSON: "customFields", Type: "json", Nullable: true},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "name", Column: "name", Op: "ilike"},
+		{QueryParam: "search", Column: "name", Op: "ilike"},
+		{QueryParam: "deviceTypeId", Column: "device_type_id"},
+		{QueryParam: "rackId", Column: "rack_id"},
+		{QueryParam: "tenantId", Column: "tenant_id"},
+		{QueryParam: "status", Column: "status"},
+		{QueryParam: "face", Column: "face"},
+		{QueryParam: "position", Column: "position", Type: "int"},
+		{QueryParam: "serialNumber", Column: "serial_number"},
+		{QueryParam: "assetTag", Column: "asset_tag"},
+		{QueryParam: "primaryIp", Column: "primary_ip"},
+		{QueryParam: "warrantyExpiresAt", Column: "warranty_expires_at", Type: "timestamp"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+}
 
 func scanDevice(scan func(dest ...interface{}) error) (deviceRow, error) {
 	var d deviceRow
 	var ca, ua time.Time
 	var wea *time.Time
 	err := scan(&d.ID, &d.Name, &d.DeviceTypeID, &d.RackID, &d.TenantID, &d.Status, &d.Face, &d.Position,
-		&d.SerialNumber, &d.AssetTag, &wea, &d.PrimaryIP, &d.Description, &ca, &ua)
+		&d.SerialNumber, &d.AssetTag, &wea, &d.PrimaryIP, &d.Description, &d.CustomFields, &ca, &ua)
 	if err != nil {
 		return d, err
 	}
+	if d.CustomFields == nil {
+		d.CustomFields = json.RawMessage("{}")
+	}
 	d.CreatedAt = ca.UTC().Format(time.RFC3339)
 	d.UpdatedAt = ua.UTC().Format(time.RFC3339)
 	if wea != nil {
@@ -53,43 +111,34 @@ func scanDevice(scan func(dest ...interface{}) error) (deviceRow, error) {
 	return d, nil
 }
 
+// List handles GET /devices?limit=&cursor=
+// page= still selects an offset page for older clients; the response always
+// carries the total and the next and previous cursors.
 func (h *DeviceHandler) List(w http.ResponseWriter, r *http.Request) {
-	pg := crud.ParsePagination(r)
-	where := `WHERE deleted_at IS NULL`
-	args := []interface{}{}
-	ai := 1
-	if v := r.URL.Query().Get("rackId"); v != "" {
-		where += fmt.Sprintf(" AND rack_id = $%d", ai)
-		args = append(args, v)
-		ai++
-	}
-	if v := r.URL.Query().Get("status"); v != "" {
-		where += fmt.Sprintf(" AND status = $%d", ai)
-		args = append(args, v)
-		ai++
-	}
-	if v := r.URL.Query().Get("tenantId"); v != "" {
-		where += fmt.Sprintf(" AND tenant_id = $%d", ai)
-		args = append(args, v)
-		ai++
-	}
-	if v := r.URL.Query().Get("search"); v != "" {
-		where += fmt.Sprintf(" AND name ILIKE $%d", ai)
-		args = append(args, "%"+v+"%")
-		ai++
+	q, err := crud.ParseQuery(r, deviceConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
 	}
+	from := ` FROM devices WHERE deleted_at IS NULL` + q.Where
+	args := q.Args
+	cfWhere, cfArgs, _ := customfields.Filter(r.URL.Query(), "custom_fields", q.Next)
+	from += cfWhere
+	args = append(args, cfArgs...)
 
 	var total int
-	countArgs := make([]interface{}, len(args))
-	copy(countArgs, args)
-	if err := h.DB.Pool.QueryRow(r.Context(), `SELECT COUNT(*) FROM devices `+where, countArgs...).Scan(&total); err != nil {
+	if err := h.DB.Pool.QueryRow(r.Context(), `SELECT COUNT(*)`+from, args...).Scan(&total); err != nil {
 		log.Printf("device count error: %v", err)
 		response.InternalError(w, "database error")
 		return
 	}
 
-	query := fmt.Sprintf(`SELECT %s FROM devices %s ORDER BY name LIMIT $%d OFFSET $%d`, deviceCols, where, ai, ai+1)
-	args = append(args, pg.Limit, pg.Offset)
+	offset := 0
+	if r.URL.Query().Get("cursor") == "" {
+		offset = crud.ParsePagination(r).Offset
+	}
+	seek, args := q.Seek(args)
+	query := fmt.Sprintf(`SELECT %s%s%s ORDER BY %s%s OFFSET %d`, deviceCols, from, seek, q.OrderBy, q.LimitClause(), offset)
 
 	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
 	if err != nil {
@@ -106,7 +155,19 @@ func (h *DeviceHandler) List(w http.ResponseWriter, r *http.Request) {
 		}
 		results = append(results, d)
 	}
-	response.OK(w, map[string]interface{}{"data": results, "total": total, "limit": pg.Limit, "offset": pg.Offset})
+	results, cursors, ok := crud.Page(w, r, h.DB.Pool, q, results)
+	if !ok {
+		return
+	}
+	data, err := q.Project(results)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	response.OK(w, map[string]interface{}{
+		"data": data, "total": total, "limit": q.Limit, "offset": offset,
+		"next": cursors.Next, "prev": cursors.Prev,
+	})
 }
 
 func (h *DeviceHandler) Get(w http.ResponseWriter, r *http.Request) {
@@ -125,6 +186,9 @@ func (h *DeviceHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, deviceConfig, body, true) {
+		return
+	}
 	name, _ := body["name"].(string)
 	dtID, _ := body["deviceTypeId"].(string)
 	if name == "" || dtID == "" {
@@ -150,21 +214,101 @@ func (h *DeviceHandler) Create(w http.ResponseWriter, r *http.Request) {
 	asset, _ := body["assetTag"].(string)
 	pip, _ := body["primaryIp"].(string)
 	desc, _ := body["description"].(string)
+	customFields, _, err := validateCustomFields(r.Context(), h.DB.Pool, "device", nil, body, true)
+	if err != nil {
+		writeCustomFieldError(w, err)
+		return
+	}
+
+	// The device and the components instantiated from its type's templates
+	// are created in one transaction.
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
 
-	d, err := scanDevice(h.DB.Pool.QueryRow(r.Context(), fmt.Sprintf(
-		`INSERT INTO devices (name, device_type_id, rack_id, tenant_id, status, face, position, serial_number, asset_tag, primary_ip, description)
-		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING %s`, deviceCols),
+	var rackPtr *string
+	if rackID != "" {
+		rackPtr = &rackID
+	}
+	if err := checkRackPlacement(ctx, tx, "", rackPtr, dtID, pos, face); err != nil {
+		writePlacementError(w, err)
+		return
+	}
+
+	d, err := scanDevice(tx.QueryRow(ctx, fmt.Sprintf(
+		`INSERT INTO devices (name, device_type_id, rack_id, tenant_id, status, face, position, serial_number, asset_tag, primary_ip, description, custom_fields)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) RETURNING %s`, deviceCols),
 		name, dtID, nilIfEmpty(rackID), nilIfEmpty(tenantID), status, face, pos,
-		nilIfEmpty(serial), nilIfEmpty(asset), nilIfEmpty(pip), nilIfEmpty(desc)).Scan)
+		nilIfEmpty(serial), nilIfEmpty(asset), nilIfEmpty(pip), nilIfEmpty(desc), customFields).Scan)
 	if err != nil {
-		log.Printf("device create error: %v", err)
+		response.DBError(w, err, "Device")
+		return
+	}
+	if err := checkDeviceTransition(ctx, tx, "", d); err != nil {
+		writeLifecycleError(w, err)
+		return
+	}
+	reason, _ := body["reason"].(string)
+	ctx = audit.WithReason(ctx, reason)
+	if err := recordStatusChange(ctx, tx, d.ID, "", d.Status, audit.ActorFrom(ctx).UserID, reason); err != nil {
+		log.Printf("device status history error: %v", err)
 		response.InternalError(w, "create failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "devices", d.ID, nil, d)
+	if _, err := instantiateComponents(ctx, tx, d.ID, d.DeviceTypeID); err != nil {
+		log.Printf("device component instantiation error: %v", err)
+		response.InternalError(w, "create failed")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "create", "devices", d.ID, nil, d); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
 	response.Created(w, d)
 }
 
+// SyncComponents handles POST /devices/{id}/sync-components — creates any
+// components defined by the device type's templates that the device is missing.
+func (h *DeviceHandler) SyncComponents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	var dtID string
+	if err := tx.QueryRow(ctx, `SELECT device_type_id FROM devices WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&dtID); err != nil {
+		response.NotFound(w, "Device")
+		return
+	}
+	counts, err := instantiateComponents(ctx, tx, id, dtID)
+	if err != nil {
+		log.Printf("device component sync error: %v", err)
+		response.InternalError(w, "sync failed")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "sync_components", "devices", id, nil, counts); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+	response.OK(w, map[string]interface{}{"deviceId": id, "created": counts})
+}
+
 func (h *DeviceHandler) Update(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	var body map[string]interface{}
@@ -172,6 +316,9 @@ func (h *DeviceHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, deviceConfig, body, false) {
+		return
+	}
 	sc := []string{}
 	args := []interface{}{}
 	ai := 1
@@ -194,38 +341,142 @@ func (h *DeviceHandler) Update(w http.ResponseWriter, r *http.Request) {
 	sc = append(sc, fmt.Sprintf("updated_at = $%d", ai))
 	args = append(args, time.Now().UTC())
 	ai++
-	if len(sc) <= 1 {
+	_, hasCustomFields := body["customFields"]
+	if len(sc) <= 1 && !hasCustomFields {
 		response.BadRequest(w, "no fields to update")
 		return
 	}
 	args = append(args, id)
+
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	cur, err := scanDevice(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM devices WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, deviceCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Device")
+		return
+	}
+	if err := etag.Check(ctx, tx, "devices", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	// Custom fields are merged onto the locked row's values.
+	if cfBytes, ok, err := validateCustomFields(ctx, tx, "device", storedCustomFields(cur.CustomFields), body, false); err != nil {
+		writeCustomFieldError(w, err)
+		return
+	} else if ok {
+		sc = append(sc, fmt.Sprintf("custom_fields = $%d", len(args)+1))
+		args = append(args, cfBytes)
+	}
+	reason, _ := body["reason"].(string)
+	ctx = audit.WithReason(ctx, reason)
+
+	// Re-validate the rack placement only when it changes, so unrelated edits
+	// are not blocked by pre-existing overlaps.
+	if placementChanged(body) {
+		rackID, dtID, face, pos := cur.RackID, cur.DeviceTypeID, cur.Face, cur.Position
+		if v, ok := body["rackId"].(string); ok {
+			rackID = &v
+		}
+		if v, ok := body["deviceTypeId"].(string); ok && v != "" {
+			dtID = v
+		}
+		if v, ok := body["face"].(string); ok && v != "" {
+			face = v
+		}
+		if v, ok := body["position"].(float64); ok {
+			p := int(v)
+			pos = &p
+		}
+		if err := checkRackPlacement(ctx, tx, id, rackID, dtID, pos, face); err != nil {
+			writePlacementError(w, err)
+			return
+		}
+	}
+
 	q := fmt.Sprintf(`UPDATE devices SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`, joinStrings(sc, ", "), ai, deviceCols)
-	d, err := scanDevice(h.DB.Pool.QueryRow(r.Context(), q, args...).Scan)
+	d, err := scanDevice(tx.QueryRow(ctx, q, args...).Scan)
 	if err != nil {
-		response.NotFound(w, "Device")
+		response.DBError(w, err, "Device")
+		return
+	}
+	// Status changes must follow the lifecycle; an active device must also keep
+	// meeting the active requirements when its placement or IP is edited.
+	if d.Status != cur.Status || (d.Status == "active" && activeFieldsChanged(body)) {
+		if err := checkDeviceTransition(ctx, tx, cur.Status, d); err != nil {
+			writeLifecycleError(w, err)
+			return
+		}
+	}
+	if d.Status != cur.Status {
+		if err := recordStatusChange(ctx, tx, id, cur.Status, d.Status, audit.ActorFrom(ctx).UserID, reason); err != nil {
+			log.Printf("device status history error: %v", err)
+			response.InternalError(w, "update failed")
+			return
+		}
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "devices", id, cur, d); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "devices", id, nil, d)
 	response.OK(w, d)
 }
 
-func (h *DeviceHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	tag, err := h.DB.Pool.Exec(r.Context(), `UPDATE devices SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Device")
+func placementChanged(body map[string]interface{}) bool {
+	for _, k := range []string{"rackId", "deviceTypeId", "face", "position"} {
+		if _, ok := body[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func activeFieldsChanged(body map[string]interface{}) bool {
+	for _, k := range []string{"rackId", "position", "primaryIp"} {
+		if _, ok := body[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func writePlacementError(w http.ResponseWriter, err error) {
+	var pe *placementError
+	if !errors.As(err, &pe) {
+		log.Printf("device placement check error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	if pe.Conflict == nil {
+		response.ValidationError(w, "Validation failed", pe.issues())
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "devices", id, nil, nil)
-	response.Message(w, "Device deleted", http.StatusOK)
+	response.Conflict(w, pe.msg, pe.Conflict)
+}
+
+// Delete handles DELETE /devices/{id}?cascade=true
+func (h *DeviceHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	cascade.HandleDelete(w, r, h.DB.Pool, "devices", "Device")
 }
 
-// Batch handles POST /devices/batch — batch delete or status change.
+// Batch handles POST /devices/batch[?cascade=true] — batch delete or status
+// change. A delete is all-or-nothing: one blocked or modified device (If-Match
+// lists one tag per device) rejects the batch.
 func (h *DeviceHandler) Batch(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		Action string   `json:"action"`
 		IDs    []string `json:"ids"`
 		Status string   `json:"status"`
+		Reason string   `json:"reason"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		response.BadRequest(w, "invalid JSON")
@@ -235,28 +486,156 @@ func (h *DeviceHandler) Batch(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "ids are required")
 		return
 	}
-	ctx := r.Context()
+	ctx := audit.WithReason(r.Context(), body.Reason)
 	now := time.Now().UTC()
 	switch body.Action {
 	case "delete":
-		tag, err := h.DB.Pool.Exec(ctx, `UPDATE devices SET deleted_at = $1 WHERE id = ANY($2) AND deleted_at IS NULL`, now, body.IDs)
+		tx, err := h.DB.Pool.Begin(ctx)
 		if err != nil {
 			response.InternalError(w, "batch delete failed")
 			return
 		}
-		response.OK(w, map[string]int64{"deleted": tag.RowsAffected()})
+		defer tx.Rollback(ctx) //nolint:errcheck
+
+		// Each device goes through the cascade rules and If-Match of DELETE
+		// /devices/{id}; ids already deleted are skipped.
+		ctx = etag.WithIfMatch(ctx, r.Header.Get("If-Match"))
+		cascadeDeletes := r.URL.Query().Get("cascade") == "true"
+		deleted := 0
+		for _, id := range body.IDs {
+			err := h.batchDelete(ctx, tx, id, cascadeDeletes, now)
+			var be *cascade.BlockedError
+			switch {
+			case errors.Is(err, pgx.ErrNoRows):
+				continue
+			case errors.As(err, &be):
+				response.Conflict(w, fmt.Sprintf("Device %s is %s; delete them first or pass ?cascade=true", id, be.Error()), be.Blockers)
+				return
+			case errors.Is(err, etag.ErrModified):
+				etag.WriteError(w, err)
+				return
+			case err != nil:
+				log.Printf("batch delete error [devices/%s]: %v", id, err)
+				response.InternalError(w, "batch delete failed")
+				return
+			}
+			deleted++
+		}
+		if err := tx.Commit(ctx); err != nil {
+			response.InternalError(w, "commit failed")
+			return
+		}
+		response.OK(w, map[string]int64{"deleted": int64(deleted)})
 	case "statusChange":
 		if body.Status == "" {
 			response.BadRequest(w, "status is required for statusChange")
 			return
 		}
-		tag, err := h.DB.Pool.Exec(ctx, `UPDATE devices SET status = $1, updated_at = $2 WHERE id = ANY($3) AND deleted_at IS NULL`, body.Status, now, body.IDs)
+		updated, err := h.batchStatusChange(w, r.WithContext(ctx), body.IDs, body.Status, body.Reason, now)
 		if err != nil {
-			response.InternalError(w, "batch status change failed")
 			return
 		}
-		response.OK(w, map[string]int64{"updated": tag.RowsAffected()})
+		response.OK(w, map[string]int64{"updated": updated})
 	default:
 		response.BadRequest(w, "action must be 'delete' or 'statusChange'")
 	}
 }
+
+// batchDelete soft-deletes one device of a batch inside tx and audits it with
+// everything the delete cascaded to. pgx.ErrNoRows means the device does not
+// exist or is already deleted.
+func (h *DeviceHandler) batchDelete(ctx context.Context, tx pgx.Tx, id string, cascadeDeletes bool, now time.Time) error {
+	before, err := scanDevice(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM devices WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, deviceCols), id).Scan)
+	if err != nil {
+		return err
+	}
+	if err := etag.Check(ctx, tx, "devices", id); err != nil {
+		return err
+	}
+	res, err := cascade.SoftDelete(ctx, tx, "devices", id, cascadeDeletes, now)
+	if err != nil {
+		return err
+	}
+	if err := audit.LogEntry(ctx, tx, "delete", "devices", id, before, nil); err != nil {
+		return err
+	}
+	return cascade.LogResult(ctx, tx, "devices", id, res)
+}
+
+// batchStatusChange applies a lifecycle transition to several devices atomically.
+// If any device cannot make the transition, nothing is changed and the rejected
+// devices are reported. On error the response has already been written.
+func (h *DeviceHandler) batchStatusChange(w http.ResponseWriter, r *http.Request, ids []string, status, reason string, now time.Time) (int64, error) {
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "batch status change failed")
+		return 0, err
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	rows, err := tx.Query(ctx, fmt.Sprintf(`SELECT %s FROM devices WHERE id = ANY($1) AND deleted_at IS NULL FOR UPDATE`, deviceCols), ids)
+	if err != nil {
+		response.InternalError(w, "batch status change failed")
+		return 0, err
+	}
+	devices := []deviceRow{}
+	for rows.Next() {
+		d, err := scanDevice(rows.Scan)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, d)
+	}
+	rows.Close()
+
+	rejected := []map[string]string{}
+	for _, d := range devices {
+		from := d.Status
+		if from == status {
+			continue
+		}
+		d.Status = status
+		if err := checkDeviceTransition(ctx, tx, from, d); err != nil {
+			var le *lifecycleError
+			if !errors.As(err, &le) {
+				response.InternalError(w, "batch status change failed")
+				return 0, err
+			}
+			rejected = append(rejected, map[string]string{"id": d.ID, "name": d.Name, "error": le.msg})
+		}
+	}
+	if len(rejected) > 0 {
+		response.Conflict(w, "status change rejected for some devices", rejected)
+		return 0, errors.New("rejected transitions")
+	}
+
+	actor := audit.ActorFrom(ctx).UserID
+	changed := []deviceRow{}
+	for _, d := range devices {
+		if d.Status == status {
+			continue
+		}
+		if _, err := tx.Exec(ctx, `UPDATE devices SET status = $1, updated_at = $2 WHERE id = $3`, status, now, d.ID); err != nil {
+			response.InternalError(w, "batch status change failed")
+			return 0, err
+		}
+		if err := recordStatusChange(ctx, tx, d.ID, d.Status, status, actor, reason); err != nil {
+			response.InternalError(w, "batch status change failed")
+			return 0, err
+		}
+		after := d
+		after.Status = status
+		after.UpdatedAt = now.Format(time.RFC3339)
+		if err := audit.LogEntry(ctx, tx, "update", "devices", d.ID, d, after); err != nil {
+			response.InternalError(w, "audit log failed")
+			return 0, err
+		}
+		changed = append(changed, d)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return 0, err
+	}
+	return int64(len(changed)), nil
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
+	"github.com/dcim/go-services/internal/shared/csvimport"
+	"github.com/dcim/go-services/internal/shared/customfields"
+	"github.com/dcim/go-services/internal/shared/response"
+)
+
+// deviceImportColumns are the CSV columns of a device import, as named in
+// the body of POST /devices; custom fields are cf_<name> columns.
+var deviceImportColumns = []string{"name", "deviceTypeId", "rackId", "tenantId", "status", "face", "position",
+	"serialNumber", "assetTag", "primaryIp", "description"}
+
+// customFieldValues collects cf_<name> columns into a custom fields object, typed
+// according to the definitions. Columns without a definition are kept as text so
+// validation rejects them.
+func customFieldValues(record []string, header csvimport.Header, defs map[string]customfields.Definition) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for col := range header {
+		name := strings.TrimPrefix(col, customfields.FilterPrefix)
+		if name == col {
+			continue
+		}
+		cell := header.Get(record, col)
+		d, ok := defs[name]
+		if !ok {
+			if cell != "" {
+				values[name] = cell
+			}
+			continue
+		}
+		v, err := d.Parse(cell)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			values[name] = v
+		}
+	}
+	return values, nil
+}
+
+// Import handles POST /import/devices — CSV import for devices.
+//
+// Each row is created like a POST /devices body, in its own transaction, so
+// it gets the same validation, rack placement check, lifecycle rules, status
+// history and component instantiation. The status defaults to planned; rows
+// that fail are reported by row number and the others are kept.
+func (h *DeviceHandler) Import(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		response.BadRequest(w, "failed to parse form: "+err.Error())
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		response.BadRequest(w, "file field required")
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	header, err := csvimport.ReadHeader(reader)
+	if err != nil {
+		response.BadRequest(w, "failed to read CSV headers")
+		return
+	}
+
+	ctx := r.Context()
+	defList, err := customfields.Load(ctx, h.DB.Pool, "device")
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defs := make(map[string]customfields.Definition, len(defList))
+	for _, d := range defList {
+		defs[d.Name] = d
+	}
+
+	devices := crud.NewResource(deviceConfig, h.DB.Pool)
+	imported := 0
+	var importErrors []map[string]string
+	fail := func(rowNum int, msg string) {
+		importErrors = append(importErrors, map[string]string{"row": strconv.Itoa(rowNum), "error": msg})
+	}
+
+	for rowNum := 2; ; rowNum++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(rowNum, err.Error())
+			continue
+		}
+
+		body := map[string]interface{}{}
+		for _, col := range deviceImportColumns {
+			if v := header.Get(record, col); v != "" {
+				body[col] = v
+			}
+		}
+		if v, ok := body["position"].(string); ok {
+			p, err := strconv.Atoi(v)
+			if err != nil {
+				fail(rowNum, "position must be an integer")
+				continue
+			}
+			body["position"] = float64(p)
+		}
+		cf, err := customFieldValues(record, header, defs)
+		if err != nil {
+			fail(rowNum, err.Error())
+			continue
+		}
+		body["customFields"] = cf
+
+		tx, err := h.DB.Pool.Begin(ctx)
+		if err != nil {
+			response.InternalError(w, "database error")
+			return
+		}
+		id, row, err := devices.Insert(ctx, tx, body)
+		if err == nil {
+			err = audit.LogEntry(ctx, tx, "create", "devices", id, nil, row)
+		}
+		if err == nil {
+			err = tx.Commit(ctx)
+		}
+		_ = tx.Rollback(ctx)
+		if err != nil {
+			fail(rowNum, devices.Explain(err))
+			continue
+		}
+		imported++
+	}
+
+	// Return flat response (no "data" wrapper) so callers can access imported/errors directly.
+	response.JSON(w, map[string]interface{}{
+		"imported": imported,
+		"errors":   importErrors,
+	}, http.StatusOK)
+}
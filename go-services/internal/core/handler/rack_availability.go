@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/dcim/go-services/internal/shared/response"
+)
+
+type rackCandidate struct {
+	RackID             string  `json:"rackId"`
+	RackName           string  `json:"rackName"`
+	LocationID         string  `json:"locationId"`
+	LocationName       string  `json:"locationName"`
+	SiteID             string  `json:"siteId"`
+	TenantID           *string `json:"tenantId"`
+	UHeight            int     `json:"uHeight"`
+	FreeU              int     `json:"freeU"`
+	BestFitBlockU      int     `json:"bestFitBlockU"`
+	CandidatePositions []int   `json:"candidatePositions"`
+	PowerCapacityKw    float64 `json:"powerCapacityKw"`
+	PowerAllocatedKw   float64 `json:"powerAllocatedKw"`
+	PowerMeasuredKw    float64 `json:"powerMeasuredKw"`
+	PowerHeadroomKw    float64 `json:"powerHeadroomKw"`
+}
+
+// Available handles GET /racks/available?siteId=&u=&face=&fullDepth=&powerKw=&tenantId=
+// It returns racks with a contiguous free block of at least u units on the requested
+// face (both faces for full-depth devices) and enough power headroom, best fit first.
+//
+// Power capacity is the sum of the rack's primary feeds. Draw is the larger of the
+// allocated draw (device_types.power_draw of mounted devices) and the latest measured
+// readings on those feeds.
+func (h *RackHandler) Available(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	u, err := strconv.Atoi(q.Get("u"))
+	if err != nil || u < 1 {
+		response.BadRequest(w, "u must be a positive integer")
+		return
+	}
+	face := "front"
+	if v := q.Get("face"); v != "" {
+		if v != "front" && v != "rear" {
+			response.BadRequest(w, "face must be 'front' or 'rear'")
+			return
+		}
+		face = v
+	}
+	fullDepth := true
+	if v := q.Get("fullDepth"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			response.BadRequest(w, "fullDepth must be a boolean")
+			return
+		}
+		fullDepth = b
+	}
+	var powerKw float64
+	if v := q.Get("powerKw"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			response.BadRequest(w, "powerKw must be a non-negative number")
+			return
+		}
+		powerKw = f
+	}
+
+	ctx := r.Context()
+	query := `
+		SELECT r.id, r.name, r.u_height, r.tenant_id, l.id, l.name, l.site_id,
+		       COALESCE(pw.capacity_kw, 0), COALESCE(alloc.allocated_kw, 0), COALESCE(pw.measured_kw, 0)
+		FROM racks r
+		JOIN locations l ON r.location_id = l.id AND l.deleted_at IS NULL
+		LEFT JOIN LATERAL (
+			SELECT SUM(f.rated_kw) AS capacity_kw, SUM(lr.power_kw) AS measured_kw
+			FROM power_feeds f
+			LEFT JOIN LATERAL (
+				SELECT pr.power_kw FROM power_readings pr
+				WHERE pr.feed_id = f.id
+				ORDER BY pr.recorded_at DESC
+				LIMIT 1
+			) lr ON true
+			WHERE f.rack_id = r.id AND f.deleted_at IS NULL AND f.feed_type = 'primary'
+		) pw ON true
+		LEFT JOIN LATERAL (
+			SELECT SUM(COALESCE(dt.power_draw, 0)) / 1000.0 AS allocated_kw
+			FROM devices d
+			JOIN device_types dt ON d.device_type_id = dt.id
+			WHERE d.rack_id = r.id AND d.deleted_at IS NULL
+		) alloc ON true
+		WHERE r.deleted_at IS NULL AND r.u_height >= $1`
+	args := []interface{}{u}
+	ai := 2
+	if v := q.Get("siteId"); v != "" {
+		query += fmt.Sprintf(" AND l.site_id = $%d", ai)
+		args = append(args, v)
+		ai++
+	}
+	if v := q.Get("tenantId"); v != "" {
+		query += fmt.Sprintf(" AND (r.tenant_id = $%d OR r.tenant_id IS NULL)", ai)
+		args = append(args, v)
+		ai++
+	}
+
+	rows, err := h.DB.Pool.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("rack availability query error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	candidates := map[string]*rackCandidate{}
+	rackIDs := []string{}
+	for rows.Next() {
+		var c rackCandidate
+		if err := rows.Scan(&c.RackID, &c.RackName, &c.UHeight, &c.TenantID, &c.LocationID, &c.LocationName, &c.SiteID,
+			&c.PowerCapacityKw, &c.PowerAllocatedKw, &c.PowerMeasuredKw); err != nil {
+			log.Printf("rack availability scan error: %v", err)
+			continue
+		}
+		c.PowerHeadroomKw = c.PowerCapacityKw - math.Max(c.PowerAllocatedKw, c.PowerMeasuredKw)
+		if powerKw > 0 && c.PowerHeadroomKw < powerKw {
+			continue
+		}
+		candidates[c.RackID] = &c
+		rackIDs = append(rackIDs, c.RackID)
+	}
+	rows.Close()
+
+	if len(rackIDs) == 0 {
+		response.OK(w, []rackCandidate{})
+		return
+	}
+
+	// Occupancy per rack: occupied[rackID][face][u] for u in 1..uHeight.
+	occupied := map[string][2][]bool{}
+	for _, id := range rackIDs {
+		n := candidates[id].UHeight + 1
+		occupied[id] = [2][]bool{make([]bool, n), make([]bool, n)}
+	}
+	devRows, err := h.DB.Pool.Query(ctx, `
+		SELECT d.rack_id, d.position, dt.u_height, d.face, dt.full_depth
+		FROM devices d
+		JOIN device_types dt ON d.device_type_id = dt.id
+		WHERE d.rack_id = ANY($1) AND d.deleted_at IS NULL AND d.position IS NOT NULL`, rackIDs)
+	if err != nil {
+		log.Printf("rack availability occupancy error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	for devRows.Next() {
+		var rackID, devFace string
+		var pos, height, devFullDepth int
+		if err := devRows.Scan(&rackID, &pos, &height, &devFace, &devFullDepth); err != nil {
+			continue
+		}
+		occ := occupied[rackID]
+		for i := pos; i < pos+height && i < len(occ[0]); i++ {
+			if i < 1 {
+				continue
+			}
+			if devFullDepth == 1 || devFace == "front" {
+				occ[0][i] = true
+			}
+			if devFullDepth == 1 || devFace == "rear" {
+				occ[1][i] = true
+			}
+		}
+	}
+	devRows.Close()
+
+	results := []rackCandidate{}
+	for _, id := range rackIDs {
+		c := candidates[id]
+		occ := occupied[id]
+		blocked := func(i int) bool {
+			if fullDepth {
+				return occ[0][i] || occ[1][i]
+			}
+			if face == "rear" {
+				return occ[1][i]
+			}
+			return occ[0][i]
+		}
+
+		// Walk contiguous free blocks; the best fit is the smallest block that holds u.
+		c.CandidatePositions = []int{}
+		for i := 1; i <= c.UHeight; {
+			if blocked(i) {
+				i++
+				continue
+			}
+			start := i
+			for i <= c.UHeight && !blocked(i) {
+				i++
+			}
+			size := i - start
+			c.FreeU += size
+			if size < u {
+				continue
+			}
+			if c.BestFitBlockU == 0 || size < c.BestFitBlockU {
+				c.BestFitBlockU = size
+			}
+			for p := start; p+u-1 < i; p++ {
+				c.CandidatePositions = append(c.CandidatePositions, p)
+			}
+		}
+		if len(c.CandidatePositions) == 0 {
+			continue
+		}
+		c.PowerCapacityKw = math.Round(c.PowerCapacityKw*100) / 100
+		c.PowerAllocatedKw = math.Round(c.PowerAllocatedKw*100) / 100
+		c.PowerMeasuredKw = math.Round(c.PowerMeasuredKw*100) / 100
+		c.PowerHeadroomKw = math.Round(c.PowerHeadroomKw*100) / 100
+		results = append(results, *c)
+	}
+
+	// Best fit: least wasted U in the chosen block, then least spare power, then fullest rack.
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.BestFitBlockU != b.BestFitBlockU {
+			return a.BestFitBlockU < b.BestFitBlockU
+		}
+		if powerKw > 0 && a.PowerHeadroomKw != b.PowerHeadroomKw {
+			return a.PowerHeadroomKw < b.PowerHeadroomKw
+		}
+		if a.FreeU != b.FreeU {
+			return a.FreeU < b.FreeU
+		}
+		return a.RackName < b.RackName
+	})
+
+	response.OK(w, results)
+}
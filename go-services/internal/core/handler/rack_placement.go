@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// placementConflict describes a device already occupying part of the requested U span.
+type placementConflict struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	UHeight  int    `json:"uHeight"`
+	Face     string `json:"face"`
+}
+
+// placementError is returned when a device does not fit in its rack or overlaps another device.
+// Conflict is set for overlaps, which answer 409; otherwise field names the request field at
+// fault (a missing rack or device type, a span outside the rack), which answers 422.
+type placementError struct {
+	msg      string
+	field    string
+	Conflict *placementConflict
+}
+
+func (e *placementError) Error() string { return e.msg }
+
+// issues returns e as the validation issues of its field.
+func (e *placementError) issues() []map[string]string {
+	return []map[string]string{{"path": e.field, "message": e.msg}}
+}
+
+// checkRackPlacement verifies that a device of deviceTypeID mounted at position/face
+// fits inside the rack and does not overlap another device. Full-depth devices block
+// both faces. excludeID is the device being moved (empty on create).
+// Devices without a rack or position are unmounted and always pass.
+// The rack row is locked so concurrent placements in the same rack are serialized.
+func checkRackPlacement(ctx context.Context, tx pgx.Tx, excludeID string, rackID *string, deviceTypeID string, position *int, face string) error {
+	if rackID == nil || *rackID == "" || position == nil {
+		return nil
+	}
+
+	var rackHeight int
+	err := tx.QueryRow(ctx, `SELECT u_height FROM racks WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, *rackID).Scan(&rackHeight)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &placementError{msg: "rack not found", field: "rackId"}
+	} else if err != nil {
+		return err
+	}
+
+	var uHeight, fullDepth int
+	err = tx.QueryRow(ctx, `SELECT u_height, full_depth FROM device_types WHERE id = $1 AND deleted_at IS NULL`, deviceTypeID).Scan(&uHeight, &fullDepth)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &placementError{msg: "device type not found", field: "deviceTypeId"}
+	} else if err != nil {
+		return err
+	}
+
+	top := *position + uHeight - 1
+	if *position < 1 || top > rackHeight {
+		return &placementError{msg: fmt.Sprintf("device spans U%d-U%d but rack has U1-U%d", *position, top, rackHeight), field: "position"}
+	}
+
+	var c placementConflict
+	err = tx.QueryRow(ctx, `
+		SELECT d.id, d.name, d.position, dt.u_height, d.face
+		FROM devices d
+		JOIN device_types dt ON d.device_type_id = dt.id
+		WHERE d.rack_id = $1 AND d.deleted_at IS NULL AND d.position IS NOT NULL AND d.id <> $2
+		  AND d.position <= $4 AND d.position + dt.u_height - 1 >= $3
+		  AND (d.face = $5 OR dt.full_depth = 1 OR $6)
+		ORDER BY d.position
+		LIMIT 1`,
+		*rackID, excludeID, *position, top, face, fullDepth == 1).Scan(&c.ID, &c.Name, &c.Position, &c.UHeight, &c.Face)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return &placementError{
+		msg:      fmt.Sprintf("U%d-U%d (%s) overlaps device %s", *position, top, face, c.Name),
+		Conflict: &c,
+	}
+}
+
+// checkRackHeight verifies that rack id can be resized to height without leaving
+// a mounted device above the top U.
+func checkRackHeight(ctx context.Context, q dbtx, id string, height int) error {
+	var c placementConflict
+	err := q.QueryRow(ctx, `
+		SELECT d.id, d.name, d.position, dt.u_height, d.face
+		FROM devices d
+		JOIN device_types dt ON d.device_type_id = dt.id
+		WHERE d.rack_id = $1 AND d.deleted_at IS NULL AND d.position IS NOT NULL
+		  AND d.position + dt.u_height - 1 > $2
+		ORDER BY d.position DESC
+		LIMIT 1`, id, height).Scan(&c.ID, &c.Name, &c.Position, &c.UHeight, &c.Face)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return &placementError{
+		msg:      fmt.Sprintf("device %s occupies U%d-U%d", c.Name, c.Position, c.Position+c.UHeight-1),
+		Conflict: &c,
+	}
+}
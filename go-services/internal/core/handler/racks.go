@@ -8,7 +8,11 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/cascade"
+	"github.com/dcim/go-services/internal/shared/crud"
+	"github.com/dcim/go-services/internal/shared/customfields"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -32,25 +36,70 @@ type rackRow struct {
 
 const rackCols = `id, name, location_id, tenant_id, type, u_height, pos_x, pos_y, rotation, description, custom_fields, created_at, updated_at`
 
-func scanRack(rk *rackRow, ca, ua *time.Time) {
+// rackConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /racks.
+var rackConfig = crud.Config{
+	Table:      "racks",
+	Name:       "Rack",
+	Check:      checkBulkRack,
+	SoftDelete: true,
+	OrderBy:    "name",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "name", JSON: "name", Type: "string", Required: true},
+		{Name: "location_id", JSON: "locationId", Type: "string", Required: true, Ref: "locations"},
+		{Name: "tenant_id", JSON: "tenantId", Type: "string", Nullable: true, Ref: "tenants"},
+		{Name: "type", JSON: "type", Type: "string", Enum: []string{"server", "network", "power", "mixed"}},
+		{Name: "u_height", JSON: "uHeight", Type: "int", Range: &crud.Range{Min: 1, Max: 60}},
+		{Name: "pos_x", JSON: "posX", Type: "int", Nullable: true},
+		{Name: "pos_y", JSON: "posY", Type: "int", Nullable: true},
+		{Name: "rotation", JSON: "rotation", Type: "int", Nullable: true, Enum: []string{"0", "90", "180", "270"}},
+		{Name: "description", JSON: "description", Type: "string", Nullable: true},
+		{Name: "custom_fields", JSON: "customFields", Type: "json", Nullable: true},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "name", Column: "name", Op: "ilike"},
+		{QueryParam: "locationId", Column: "location_id"},
+		{QueryParam: "tenantId", Column: "tenant_id"},
+		{QueryParam: "type", Column: "type"},
+		{QueryParam: "uHeight", Column: "u_height", Type: "int"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+}
+
+func scanRack(scan func(dest ...interface{}) error) (rackRow, error) {
+	var rk rackRow
+	var ca, ua time.Time
+	if err := scan(&rk.ID, &rk.Name, &rk.LocationID, &rk.TenantID, &rk.Type, &rk.UHeight, &rk.PosX, &rk.PosY, &rk.Rotation, &rk.Description, &rk.CustomFields, &ca, &ua); err != nil {
+		return rk, err
+	}
 	rk.CreatedAt = ca.UTC().Format(time.RFC3339)
 	rk.UpdatedAt = ua.UTC().Format(time.RFC3339)
 	if rk.CustomFields == nil {
 		rk.CustomFields = json.RawMessage("{}")
 	}
+	return rk, nil
 }
 
 func (h *RackHandler) List(w http.ResponseWriter, r *http.Request) {
-	locID := r.URL.Query().Get("locationId")
-	query := fmt.Sprintf(`SELECT %s FROM racks WHERE deleted_at IS NULL`, rackCols)
-	args := []interface{}{}
-	ai := 1
-	if locID != "" {
-		query += fmt.Sprintf(" AND location_id = $%d", ai)
-		args = append(args, locID)
-		ai++
+	q, err := crud.ParseQuery(r, rackConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	from := ` FROM racks WHERE deleted_at IS NULL` + q.Where
+	args := q.Args
+	cfWhere, cfArgs, _ := customfields.Filter(r.URL.Query(), "custom_fields", q.Next)
+	from += cfWhere
+	args = append(args, cfArgs...)
+	if !crud.Total(w, r, h.DB.Pool, q, from, args) {
+		return
 	}
-	query += " ORDER BY name"
+	seek, args := q.Seek(args)
+	query := `SELECT ` + rackCols + from + seek + " ORDER BY " + q.OrderBy + q.LimitClause()
 	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
 	if err != nil {
 		log.Printf("rack list error: %v", err)
@@ -60,29 +109,23 @@ func (h *RackHandler) List(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 	results := []rackRow{}
 	for rows.Next() {
-		var rk rackRow
-		var ca, ua time.Time
-		if err := rows.Scan(&rk.ID, &rk.Name, &rk.LocationID, &rk.TenantID, &rk.Type, &rk.UHeight, &rk.PosX, &rk.PosY, &rk.Rotation, &rk.Description, &rk.CustomFields, &ca, &ua); err != nil {
+		rk, err := scanRack(rows.Scan)
+		if err != nil {
 			continue
 		}
-		scanRack(&rk, &ca, &ua)
 		results = append(results, rk)
 	}
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 func (h *RackHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	var rk rackRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
-		fmt.Sprintf(`SELECT %s FROM racks WHERE id = $1 AND deleted_at IS NULL`, rackCols), id).Scan(
-		&rk.ID, &rk.Name, &rk.LocationID, &rk.TenantID, &rk.Type, &rk.UHeight, &rk.PosX, &rk.PosY, &rk.Rotation, &rk.Description, &rk.CustomFields, &ca, &ua)
+	rk, err := scanRack(h.DB.Pool.QueryRow(r.Context(),
+		fmt.Sprintf(`SELECT %s FROM racks WHERE id = $1 AND deleted_at IS NULL`, rackCols), id).Scan)
 	if err != nil {
 		response.NotFound(w, "Rack")
 		return
 	}
-	scanRack(&rk, &ca, &ua)
 	response.OK(w, rk)
 }
 
@@ -92,6 +135,9 @@ func (h *RackHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, rackConfig, body, true) {
+		return
+	}
 	name, _ := body["name"].(string)
 	locationID, _ := body["locationId"].(string)
 	if name == "" || locationID == "" {
@@ -109,27 +155,37 @@ func (h *RackHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	desc, _ := body["description"].(string)
 
-	var customFields interface{}
-	if cf, ok := body["customFields"]; ok && cf != nil {
-		cfBytes, _ := json.Marshal(cf)
-		customFields = cfBytes
+	customFields, _, err := validateCustomFields(r.Context(), h.DB.Pool, "rack", nil, body, true)
+	if err != nil {
+		writeCustomFieldError(w, err)
+		return
 	}
 
-	var rk rackRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	rk, err := scanRack(tx.QueryRow(ctx,
 		fmt.Sprintf(`INSERT INTO racks (name, location_id, tenant_id, type, u_height, description, custom_fields)
 		 VALUES ($1,$2,$3,$4,$5,$6,$7)
 		 RETURNING %s`, rackCols),
-		name, locationID, nilIfEmpty(tenantID), rType, uHeight, nilIfEmpty(desc), customFields).Scan(
-		&rk.ID, &rk.Name, &rk.LocationID, &rk.TenantID, &rk.Type, &rk.UHeight, &rk.PosX, &rk.PosY, &rk.Rotation, &rk.Description, &rk.CustomFields, &ca, &ua)
+		name, locationID, nilIfEmpty(tenantID), rType, uHeight, nilIfEmpty(desc), customFields).Scan)
 	if err != nil {
-		log.Printf("rack create error: %v", err)
-		response.InternalError(w, "create failed")
+		response.DBError(w, err, "Rack")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "create", "racks", rk.ID, nil, rk); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	scanRack(&rk, &ca, &ua)
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "racks", rk.ID, nil, rk)
 	response.Created(w, rk)
 }
 
@@ -140,6 +196,9 @@ func (h *RackHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, rackConfig, body, false) {
+		return
+	}
 	sc := []string{}
 	args := []interface{}{}
 	ai := 1
@@ -159,41 +218,68 @@ func (h *RackHandler) Update(w http.ResponseWriter, r *http.Request) {
 			ai++
 		}
 	}
-	if cf, ok := body["customFields"]; ok && cf != nil {
-		cfBytes, _ := json.Marshal(cf)
-		sc = append(sc, fmt.Sprintf("custom_fields = $%d", ai))
-		args = append(args, cfBytes)
-		ai++
-	}
 	sc = append(sc, fmt.Sprintf("updated_at = $%d", ai))
 	args = append(args, time.Now().UTC())
 	ai++
-	if len(sc) <= 1 {
+	_, hasCustomFields := body["customFields"]
+	if len(sc) <= 1 && !hasCustomFields {
 		response.BadRequest(w, "no fields to update")
 		return
 	}
 	args = append(args, id)
+
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	cur, err := scanRack(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM racks WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, rackCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Rack")
+		return
+	}
+	if err := etag.Check(ctx, tx, "racks", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	// Custom fields are merged onto the locked row's values.
+	if cfBytes, ok, err := validateCustomFields(ctx, tx, "rack", storedCustomFields(cur.CustomFields), body, false); err != nil {
+		writeCustomFieldError(w, err)
+		return
+	} else if ok {
+		sc = append(sc, fmt.Sprintf("custom_fields = $%d", len(args)+1))
+		args = append(args, cfBytes)
+	}
+
+	// Shrinking a rack must not leave mounted devices hanging above the top U.
+	if v, ok := body["uHeight"].(float64); ok {
+		if err := checkRackHeight(ctx, tx, id, int(v)); err != nil {
+			writePlacementError(w, err)
+			return
+		}
+	}
+
 	q := fmt.Sprintf(`UPDATE racks SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`, joinStrings(sc, ", "), ai, rackCols)
-	var rk rackRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(), q, args...).Scan(
-		&rk.ID, &rk.Name, &rk.LocationID, &rk.TenantID, &rk.Type, &rk.UHeight, &rk.PosX, &rk.PosY, &rk.Rotation, &rk.Description, &rk.CustomFields, &ca, &ua)
+	rk, err := scanRack(tx.QueryRow(ctx, q, args...).Scan)
 	if err != nil {
-		response.NotFound(w, "Rack")
+		response.DBError(w, err, "Rack")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "racks", id, cur, rk); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	scanRack(&rk, &ca, &ua)
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "racks", id, nil, rk)
 	response.OK(w, rk)
 }
 
+// Delete handles DELETE /racks/{id}?cascade=true
 func (h *RackHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	tag, err := h.DB.Pool.Exec(r.Context(), `UPDATE racks SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Rack")
-		return
-	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "racks", id, nil, nil)
-	response.Message(w, "Rack deleted", http.StatusOK)
+	cascade.HandleDelete(w, r, h.DB.Pool, "racks", "Rack")
 }
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/response"
+)
+
+// regionSubtreeSQL selects the ids of region $1 and all of its descendants.
+const regionSubtreeSQL = `
+	WITH RECURSIVE subtree AS (
+		SELECT id FROM regions WHERE id = $1 AND deleted_at IS NULL
+		UNION
+		SELECT r.id FROM regions r
+		JOIN subtree s ON r.parent_id = s.id
+		WHERE r.deleted_at IS NULL
+	)
+	SELECT id FROM subtree`
+
+type regionRollup struct {
+	Sites      int     `json:"sites"`
+	Racks      int     `json:"racks"`
+	Devices    int     `json:"devices"`
+	RatedKw    float64 `json:"ratedKw"`
+	MeasuredKw float64 `json:"measuredKw"`
+}
+
+func (a *regionRollup) add(b regionRollup) {
+	a.Sites += b.Sites
+	a.Racks += b.Racks
+	a.Devices += b.Devices
+	a.RatedKw += b.RatedKw
+	a.MeasuredKw += b.MeasuredKw
+}
+
+type regionNode struct {
+	regionRow
+	Own      regionRollup  `json:"own"`
+	Rollup   regionRollup  `json:"rollup"`
+	Children []*regionNode `json:"children"`
+}
+
+// Tree handles GET /regions/tree?rootId=
+// Each node carries its own totals (sites directly in the region) and a rollup
+// that includes every descendant. Regions whose parent was deleted are roots.
+func (h *RegionHandler) Tree(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rows, err := h.DB.Pool.Query(ctx, `SELECT `+regionCols+` FROM regions WHERE deleted_at IS NULL ORDER BY name`)
+	if err != nil {
+		log.Printf("region tree error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	nodes := map[string]*regionNode{}
+	order := []*regionNode{}
+	for rows.Next() {
+		n := &regionNode{Children: []*regionNode{}}
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&n.ID, &n.Name, &n.Slug, &n.ParentID, &n.Description, &createdAt, &updatedAt); err != nil {
+			log.Printf("region tree scan error: %v", err)
+			continue
+		}
+		n.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		n.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
+		nodes[n.ID] = n
+		order = append(order, n)
+	}
+	rows.Close()
+
+	// Direct totals per region. Rated capacity counts every feed on the rack;
+	// measured power is the latest reading of each feed.
+	statRows, err := h.DB.Pool.Query(ctx, `
+		SELECT s.region_id, COUNT(*)::int,
+		       COALESCE(SUM(rs.racks), 0)::int, COALESCE(SUM(rs.devices), 0)::int,
+		       COALESCE(SUM(rs.rated_kw), 0)::float8, COALESCE(SUM(rs.measured_kw), 0)::float8
+		FROM sites s
+		LEFT JOIN LATERAL (
+			SELECT COUNT(*) AS racks, SUM(dc.cnt) AS devices,
+			       SUM(pw.rated_kw) AS rated_kw, SUM(pw.measured_kw) AS measured_kw
+			FROM racks rk
+			JOIN locations l ON rk.location_id = l.id AND l.deleted_at IS NULL
+			LEFT JOIN LATERAL (
+				SELECT COUNT(*) AS cnt FROM devices d
+				WHERE d.rack_id = rk.id AND d.deleted_at IS NULL
+			) dc ON true
+			LEFT JOIN LATERAL (
+				SELECT SUM(f.rated_kw) AS rated_kw, SUM(lr.power_kw) AS measured_kw
+				FROM power_feeds f
+				LEFT JOIN LATERAL (
+					SELECT pr.power_kw FROM power_readings pr
+					WHERE pr.feed_id = f.id
+					ORDER BY pr.recorded_at DESC
+					LIMIT 1
+				) lr ON true
+				WHERE f.rack_id = rk.id AND f.deleted_at IS NULL
+			) pw ON true
+			WHERE l.site_id = s.id AND rk.deleted_at IS NULL
+		) rs ON true
+		WHERE s.deleted_at IS NULL AND s.region_id IS NOT NULL
+		GROUP BY s.region_id`)
+	if err != nil {
+		log.Printf("region rollup error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	for statRows.Next() {
+		var regionID string
+		var own regionRollup
+		if err := statRows.Scan(&regionID, &own.Sites, &own.Racks, &own.Devices, &own.RatedKw, &own.MeasuredKw); err != nil {
+			log.Printf("region rollup scan error: %v", err)
+			continue
+		}
+		if n, ok := nodes[regionID]; ok {
+			n.Own = own
+		}
+	}
+	statRows.Close()
+
+	roots := []*regionNode{}
+	for _, n := range order {
+		if n.ParentID != nil {
+			if p, ok := nodes[*n.ParentID]; ok {
+				p.Children = append(p.Children, n)
+				continue
+			}
+		}
+		roots = append(roots, n)
+	}
+
+	// visiting guards against cycles written directly to the database.
+	visiting := map[string]bool{}
+	var rollup func(n *regionNode)
+	rollup = func(n *regionNode) {
+		visiting[n.ID] = true
+		n.Rollup = n.Own
+		kept := n.Children[:0]
+		for _, c := range n.Children {
+			if visiting[c.ID] {
+				continue
+			}
+			rollup(c)
+			n.Rollup.add(c.Rollup)
+			kept = append(kept, c)
+		}
+		n.Children = kept
+		sort.SliceStable(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+		n.Own.RatedKw = math.Round(n.Own.RatedKw*100) / 100
+		n.Own.MeasuredKw = math.Round(n.Own.MeasuredKw*100) / 100
+		n.Rollup.RatedKw = math.Round(n.Rollup.RatedKw*100) / 100
+		n.Rollup.MeasuredKw = math.Round(n.Rollup.MeasuredKw*100) / 100
+	}
+	for _, n := range roots {
+		rollup(n)
+	}
+
+	if rootID := r.URL.Query().Get("rootId"); rootID != "" {
+		n, ok := nodes[rootID]
+		if !ok {
+			response.NotFound(w, "Region")
+			return
+		}
+		if !visiting[n.ID] {
+			rollup(n)
+		}
+		response.OK(w, []*regionNode{n})
+		return
+	}
+	response.OK(w, roots)
+}
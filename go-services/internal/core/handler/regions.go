@@ -1,216 +1,108 @@
 package handler
 
 import (
-    "encoding/json"
-    "fmt"
+    "context"
     "log"
     "net/http"
-    "time"
 
-    "github.com/dcim/go-services/internal/shared/audit"
+    "github.com/dcim/go-services/internal/shared/crud"
     "github.com/dcim/go-services/internal/shared/db"
-    "github.com/dcim/go-services/internal/shared/response"
+    "github.com/jackc/pgx/v5"
 )
 
-// RegionHandler handles region CRUD.
+// RegionHandler serves the region tree; region CRUD is RegionConfig.
 type RegionHandler struct {
     DB *db.DB
 }
 
+const regionCols = `id, name, slug, parent_id, description, created_at, updated_at`
+
+// RegionConfig serves /regions.
+var RegionConfig = crud.Config{
+    Table:      "regions",
+    Name:       "Region",
+    OrderBy:    "name",
+    SoftDelete: true,
+    Check:      checkRegion,
+    Columns: []crud.Column{
+        {Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+        {Name: "name", JSON: "name", Type: "string", Required: true},
+        {Name: "slug", JSON: "slug", Type: "string", Required: true, Format: "slug"},
+        {Name: "parent_id", JSON: "parentId", Type: "string", Nullable: true, Ref: "regions"},
+        {Name: "description", JSON: "description", Type: "string", Nullable: true},
+        {Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+        {Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+    },
+    Filters: []crud.FilterDef{
+        {QueryParam: "name", Column: "name", Op: "ilike"},
+        {QueryParam: "slug", Column: "slug"},
+        {QueryParam: "parentId", Column: "parent_id"},
+        {QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+        {QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+    },
+}
+
 type regionRow struct {
     ID          string  `json:"id"`
     Name        string  `json:"name"`
     Slug        string  `json:"slug"`
+    ParentID    *string `json:"parentId"`
     Description *string `json:"description"`
     CreatedAt   string  `json:"createdAt"`
     UpdatedAt   string  `json:"updatedAt"`
 }
 
-// List handles GET /regions
-func (h *RegionHandler) List(w http.ResponseWriter, r *http.Request) {
-    query := `
-        SELECT id, name, slug, description, created_at, updated_at
-        FROM regions
-        WHERE deleted_at IS NULL
-        ORDER BY name`
+// regionTreeLock is the advisory lock key serializing changes of region parents.
+const regionTreeLock int64 = 0x726567696f6e // "region"
 
-    rows, err := h.DB.Pool.Query(r.Context(), query)
-    if err != nil {
-        log.Printf("region list error: %v", err)
-        response.InternalError(w, "database error")
-        return
+// checkRegion rejects a parentId that does not exist or would create a cycle.
+// Parent changes are serialized for the rest of the transaction, so two
+// concurrent moves (A under B, B under A) cannot both pass the check.
+func checkRegion(ctx context.Context, tx pgx.Tx, w *crud.Write) error {
+    parentID, _ := w.Data["parentId"].(string)
+    if parentID == "" {
+        return nil
     }
-    defer rows.Close()
-
-    results := []regionRow{}
-    for rows.Next() {
-        var rg regionRow
-        var createdAt, updatedAt time.Time
-        if err := rows.Scan(&rg.ID, &rg.Name, &rg.Slug, &rg.Description,
-            &createdAt, &updatedAt); err != nil {
-            log.Printf("region scan error: %v", err)
-            continue
-        }
-        rg.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-        rg.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
-        results = append(results, rg)
+    if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, regionTreeLock); err != nil {
+        return err
     }
-
-    response.OK(w, results)
-}
-
-// Get handles GET /regions/{id}
-func (h *RegionHandler) Get(w http.ResponseWriter, r *http.Request) {
-    id := r.PathValue("id")
-    if id == "" {
-        response.BadRequest(w, "id is required")
-        return
-    }
-
-    var rg regionRow
-    var createdAt, updatedAt time.Time
-
-    err := h.DB.Pool.QueryRow(r.Context(), `
-        SELECT id, name, slug, description, created_at, updated_at
-        FROM regions
-        WHERE id = $1 AND deleted_at IS NULL`, id).Scan(
-        &rg.ID, &rg.Name, &rg.Slug, &rg.Description,
-        &createdAt, &updatedAt)
+    msg, err := checkRegionParent(ctx, tx, w.ID, parentID)
     if err != nil {
-        response.NotFound(w, "Region")
-        return
-    }
-    rg.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-    rg.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
-
-    response.OK(w, rg)
-}
-
-// Create handles POST /regions
-func (h *RegionHandler) Create(w http.ResponseWriter, r *http.Request) {
-    var body map[string]interface{}
-    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-        response.BadRequest(w, "invalid JSON")
-        return
+        return err
     }
-
-    name, _ := body["name"].(string)
-    slug, _ := body["slug"].(string)
-    if name == "" {
-        response.BadRequest(w, "name is required")
-        return
+    if msg != "" {
+        return &crud.OpError{Status: http.StatusBadRequest, Msg: msg}
     }
-
-    description, _ := body["description"].(string)
-
-    var rg regionRow
-    var createdAt, updatedAt time.Time
-
-    err := h.DB.Pool.QueryRow(r.Context(), `
-        INSERT INTO regions (name, slug, description)
-        VALUES ($1, $2, $3)
-        RETURNING id, name, slug, description, created_at, updated_at`,
-        name, slug, nilIfEmpty(description)).Scan(
-        &rg.ID, &rg.Name, &rg.Slug, &rg.Description,
-        &createdAt, &updatedAt)
-    if err != nil {
-        log.Printf("region create error: %v", err)
-        response.InternalError(w, "create failed")
-        return
-    }
-    rg.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-    rg.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
-
-    _ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "regions", rg.ID, nil, rg)
-
-    response.Created(w, rg)
+    return nil
 }
 
-// Update handles PATCH /regions/{id}
-func (h *RegionHandler) Update(w http.ResponseWriter, r *http.Request) {
-    id := r.PathValue("id")
-    if id == "" {
-        response.BadRequest(w, "id is required")
-        return
-    }
-
-    var body map[string]interface{}
-    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-        response.BadRequest(w, "invalid JSON")
-        return
-    }
-
-    setClauses := []string{}
-    args := []interface{}{}
-    argIdx := 1
-
-    if v, ok := body["name"].(string); ok {
-        setClauses = append(setClauses, fmt.Sprintf("name = $%d", argIdx))
-        args = append(args, v)
-        argIdx++
-    }
-    if v, ok := body["slug"].(string); ok {
-        setClauses = append(setClauses, fmt.Sprintf("slug = $%d", argIdx))
-        args = append(args, v)
-        argIdx++
-    }
-    if v, ok := body["description"].(string); ok {
-        setClauses = append(setClauses, fmt.Sprintf("description = $%d", argIdx))
-        args = append(args, nilIfEmpty(v))
-        argIdx++
-    }
-
-    setClauses = append(setClauses, fmt.Sprintf("updated_at = $%d", argIdx))
-    args = append(args, time.Now().UTC())
-    argIdx++
-
-    if len(setClauses) <= 1 {
-        response.BadRequest(w, "no fields to update")
-        return
-    }
-
-    args = append(args, id)
-    query := fmt.Sprintf(`
-        UPDATE regions SET %s
-        WHERE id = $%d AND deleted_at IS NULL
-        RETURNING id, name, slug, description, created_at, updated_at`,
-        joinStrings(setClauses, ", "), argIdx)
-
-    var rg regionRow
-    var createdAt, updatedAt time.Time
-
-    err := h.DB.Pool.QueryRow(r.Context(), query, args...).Scan(
-        &rg.ID, &rg.Name, &rg.Slug, &rg.Description,
-        &createdAt, &updatedAt)
+// checkRegionParent validates parentID as the parent of region id (empty on create).
+// It returns a non-empty message when the parent does not exist or when the
+// assignment would make a region its own ancestor.
+func checkRegionParent(ctx context.Context, q dbtx, id, parentID string) (string, error) {
+    if parentID == id {
+        return "a region cannot be its own parent", nil
+    }
+    var exists, cycle bool
+    err := q.QueryRow(ctx, `
+        WITH RECURSIVE ancestors AS (
+            SELECT id, parent_id FROM regions WHERE id = $1 AND deleted_at IS NULL
+            UNION
+            SELECT r.id, r.parent_id FROM regions r
+            JOIN ancestors a ON r.id = a.parent_id
+            WHERE r.deleted_at IS NULL
+        )
+        SELECT EXISTS(SELECT 1 FROM ancestors WHERE id = $1),
+               EXISTS(SELECT 1 FROM ancestors WHERE id = $2)`, parentID, id).Scan(&exists, &cycle)
     if err != nil {
-        response.NotFound(w, "Region")
-        return
+        log.Printf("region parent check error: %v", err)
+        return "", err
     }
-    rg.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-    rg.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
-
-    _ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "regions", id, nil, rg)
-
-    response.OK(w, rg)
-}
-
-// Delete handles DELETE /regions/{id}
-func (h *RegionHandler) Delete(w http.ResponseWriter, r *http.Request) {
-    id := r.PathValue("id")
-    if id == "" {
-        response.BadRequest(w, "id is required")
-        return
+    if !exists {
+        return "parent region not found", nil
     }
-
-    tag, err := h.DB.Pool.Exec(r.Context(), `
-        UPDATE regions SET deleted_at = $1
-        WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-    if err != nil || tag.RowsAffected() == 0 {
-        response.NotFound(w, "Region")
-        return
+    if id != "" && cycle {
+        return "parent region is a descendant of this region", nil
     }
-
-    _ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "regions", id, nil, nil)
-
-    response.Message(w, "Region deleted", http.StatusOK)
+    return "", nil
 }
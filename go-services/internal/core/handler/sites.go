@@ -8,7 +8,11 @@ import (
     "time"
 
     "github.com/dcim/go-services/internal/shared/audit"
+    "github.com/dcim/go-services/internal/shared/cascade"
+    "github.com/dcim/go-services/internal/shared/crud"
+    "github.com/dcim/go-services/internal/shared/customfields"
     "github.com/dcim/go-services/internal/shared/db"
+    "github.com/dcim/go-services/internal/shared/etag"
     "github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -36,19 +40,86 @@ type siteRow struct {
 
 const siteCols = `id, name, slug, status, region_id, tenant_id, facility, address, latitude, longitude, description, custom_fields, created_at, updated_at`
 
-func scanSite(s *siteRow, createdAt, updatedAt *time.Time) {
+// siteConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /sites.
+var siteConfig = crud.Config{
+    Table:      "sites",
+    Name:       "Site",
+    Check:      checkBulkCustomFields("site"),
+    SoftDelete: true,
+    OrderBy:    "name",
+    Columns: []crud.Column{
+        {Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+        {Name: "name", JSON: "name", Type: "string", Required: true},
+        {Name: "slug", JSON: "slug", Type: "string", Required: true, Format: "slug"},
+        {Name: "status", JSON: "status", Type: "string",
+            Enum: []string{"active", "planned", "staging", "decommissioning", "retired"}},
+        {Name: "region_id", JSON: "regionId", Type: "string", Nullable: true, Ref: "regions"},
+        {Name: "tenant_id", JSON: "tenantId", Type: "string", Nullable: true, Ref: "tenants"},
+        {Name: "facility", JSON: "facility", Type: "string", Nullable: true},
+        {Name: "address", JSON: "address", Type: "string", Nullable: true},
+        {Name: "latitude", JSON: "latitude", Type: "decimal", Nullable: true, Range: &crud.Range{Min: -90, Max: 90}},
+        {Name: "longitude", JSON: "longitude", Type: "decimal", Nullable: true, Range: &crud.Range{Min: -180, Max: 180}},
+        {Name: "description", JSON: "description", Type: "string", Nullable: true},
+        {Name: "custom_fields", JSON: "customFields", Type: "json", Nullable: true},
+        {Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+        {Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+    },
+    Filters: []crud.FilterDef{
+        {QueryParam: "name", Column: "name", Op: "ilike"},
+        {QueryParam: "slug", Column: "slug"},
+        {QueryParam: "status", Column: "status"},
+        {QueryParam: "tenantId", Column: "tenant_id"},
+        {QueryParam: "facility", Column: "facility", Op: "ilike"},
+        {QueryParam: "latitude", Column: "latitude", Type: "float"},
+        {QueryParam: "longitude", Column: "longitude", Type: "float"},
+        {QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+        {QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+    },
+}
+
+func scanSite(scan func(dest ...interface{}) error) (siteRow, error) {
+    var s siteRow
+    var createdAt, updatedAt time.Time
+    if err := scan(&s.ID, &s.Name, &s.Slug, &s.Status, &s.RegionID, &s.TenantID,
+        &s.Facility, &s.Address, &s.Latitude, &s.Longitude, &s.Description,
+        &s.CustomFields, &createdAt, &updatedAt); err != nil {
+        return s, err
+    }
     s.CreatedAt = createdAt.UTC().Format(time.RFC3339)
     s.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
     if s.CustomFields == nil {
         s.CustomFields = json.RawMessage("{}")
     }
+    return s, nil
 }
 
 // List handles GET /sites
 func (h *SiteHandler) List(w http.ResponseWriter, r *http.Request) {
-    query := fmt.Sprintf(`SELECT %s FROM sites WHERE deleted_at IS NULL ORDER BY name`, siteCols)
+    from := ` FROM sites WHERE deleted_at IS NULL`
+    args := []interface{}{}
+    // regionId matches sites in the region and all of its descendants.
+    if v := r.URL.Query().Get("regionId"); v != "" {
+        from += ` AND region_id IN (` + regionSubtreeSQL + `)`
+        args = append(args, v)
+    }
+    q, err := crud.ParseQuery(r, siteConfig, len(args)+1)
+    if err != nil {
+        response.BadRequest(w, err.Error())
+        return
+    }
+    from += q.Where
+    args = append(args, q.Args...)
+    cfWhere, cfArgs, _ := customfields.Filter(r.URL.Query(), "custom_fields", q.Next)
+    from += cfWhere
+    args = append(args, cfArgs...)
+    if !crud.Total(w, r, h.DB.Pool, q, from, args) {
+        return
+    }
+    seek, args := q.Seek(args)
+    query := `SELECT ` + siteCols + from + seek + " ORDER BY " + q.OrderBy + q.LimitClause()
 
-    rows, err := h.DB.Pool.Query(r.Context(), query)
+    rows, err := h.DB.Pool.Query(r.Context(), query, args...)
     if err != nil {
         log.Printf("site list error: %v", err)
         response.InternalError(w, "database error")
@@ -58,19 +129,15 @@ func (h *SiteHandler) List(w http.ResponseWriter, r *http.Request) {
 
     results := []siteRow{}
     for rows.Next() {
-        var s siteRow
-        var createdAt, updatedAt time.Time
-        if err := rows.Scan(&s.ID, &s.Name, &s.Slug, &s.Status, &s.RegionID, &s.TenantID,
-            &s.Facility, &s.Address, &s.Latitude, &s.Longitude, &s.Description,
-            &s.CustomFields, &createdAt, &updatedAt); err != nil {
+        s, err := scanSite(rows.Scan)
+        if err != nil {
             log.Printf("site scan error: %v", err)
             continue
         }
-        scanSite(&s, &createdAt, &updatedAt)
         results = append(results, s)
     }
 
-    response.OK(w, results)
+    crud.List(w, r, h.DB.Pool, q, results)
 }
 
 // Get handles GET /sites/{id}
@@ -81,19 +148,12 @@ func (h *SiteHandler) Get(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    var s siteRow
-    var createdAt, updatedAt time.Time
-
-    err := h.DB.Pool.QueryRow(r.Context(),
-        fmt.Sprintf(`SELECT %s FROM sites WHERE id = $1 AND deleted_at IS NULL`, siteCols), id).Scan(
-        &s.ID, &s.Name, &s.Slug, &s.Status, &s.RegionID, &s.TenantID,
-        &s.Facility, &s.Address, &s.Latitude, &s.Longitude, &s.Description,
-        &s.CustomFields, &createdAt, &updatedAt)
+    s, err := scanSite(h.DB.Pool.QueryRow(r.Context(),
+        fmt.Sprintf(`SELECT %s FROM sites WHERE id = $1 AND deleted_at IS NULL`, siteCols), id).Scan)
     if err != nil {
         response.NotFound(w, "Site")
         return
     }
-    scanSite(&s, &createdAt, &updatedAt)
 
     response.OK(w, s)
 }
@@ -105,6 +165,9 @@ func (h *SiteHandler) Create(w http.ResponseWriter, r *http.Request) {
         response.BadRequest(w, "invalid JSON")
         return
     }
+    if !crud.ValidBody(w, r, h.DB.Pool, siteConfig, body, true) {
+        return
+    }
 
     name, _ := body["name"].(string)
     slug, _ := body["slug"].(string)
@@ -125,32 +188,39 @@ func (h *SiteHandler) Create(w http.ResponseWriter, r *http.Request) {
     longitude, _ := body["longitude"].(string)
     description, _ := body["description"].(string)
 
-    var customFields interface{}
-    if cf, ok := body["customFields"]; ok && cf != nil {
-        cfBytes, _ := json.Marshal(cf)
-        customFields = cfBytes
+    customFields, _, err := validateCustomFields(r.Context(), h.DB.Pool, "site", nil, body, true)
+    if err != nil {
+        writeCustomFieldError(w, err)
+        return
     }
 
-    var s siteRow
-    var createdAt, updatedAt time.Time
+    ctx := r.Context()
+    tx, err := h.DB.Pool.Begin(ctx)
+    if err != nil {
+        response.InternalError(w, "database error")
+        return
+    }
+    defer tx.Rollback(ctx) //nolint:errcheck
 
-    err := h.DB.Pool.QueryRow(r.Context(),
+    s, err := scanSite(tx.QueryRow(ctx,
         fmt.Sprintf(`INSERT INTO sites (name, slug, status, region_id, tenant_id, facility, address, latitude, longitude, description, custom_fields)
         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
         RETURNING %s`, siteCols),
         name, slug, status, nilIfEmpty(regionID), nilIfEmpty(tenantID), nilIfEmpty(facility),
-        nilIfEmpty(address), nilIfEmpty(latitude), nilIfEmpty(longitude), nilIfEmpty(description), customFields).Scan(
-        &s.ID, &s.Name, &s.Slug, &s.Status, &s.RegionID, &s.TenantID,
-        &s.Facility, &s.Address, &s.Latitude, &s.Longitude, &s.Description,
-        &s.CustomFields, &createdAt, &updatedAt)
+        nilIfEmpty(address), nilIfEmpty(latitude), nilIfEmpty(longitude), nilIfEmpty(description), customFields).Scan)
     if err != nil {
-        log.Printf("site create error: %v", err)
-        response.InternalError(w, "create failed")
+        response.DBError(w, err, "Site")
         return
     }
-    scanSite(&s, &createdAt, &updatedAt)
 
-    _ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "sites", s.ID, nil, s)
+    if err := audit.LogEntry(ctx, tx, "create", "sites", s.ID, nil, s); err != nil {
+        response.InternalError(w, "audit log failed")
+        return
+    }
+    if err := tx.Commit(ctx); err != nil {
+        response.InternalError(w, "commit failed")
+        return
+    }
 
     response.Created(w, s)
 }
@@ -168,6 +238,9 @@ func (h *SiteHandler) Update(w http.ResponseWriter, r *http.Request) {
         response.BadRequest(w, "invalid JSON")
         return
     }
+    if !crud.ValidBody(w, r, h.DB.Pool, siteConfig, body, false) {
+        return
+    }
 
     setClauses := []string{}
     args := []interface{}{}
@@ -223,63 +296,66 @@ func (h *SiteHandler) Update(w http.ResponseWriter, r *http.Request) {
         args = append(args, nilIfEmpty(v))
         argIdx++
     }
-    if cf, ok := body["customFields"]; ok && cf != nil {
-        cfBytes, _ := json.Marshal(cf)
-        setClauses = append(setClauses, fmt.Sprintf("custom_fields = $%d", argIdx))
-        args = append(args, cfBytes)
-        argIdx++
-    }
-
     setClauses = append(setClauses, fmt.Sprintf("updated_at = $%d", argIdx))
     args = append(args, time.Now().UTC())
     argIdx++
 
-    if len(setClauses) <= 1 {
+    _, hasCustomFields := body["customFields"]
+    if len(setClauses) <= 1 && !hasCustomFields {
         response.BadRequest(w, "no fields to update")
         return
     }
 
     args = append(args, id)
-    query := fmt.Sprintf(`UPDATE sites SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`,
-        joinStrings(setClauses, ", "), argIdx, siteCols)
 
-    var s siteRow
-    var createdAt, updatedAt time.Time
-
-    err := h.DB.Pool.QueryRow(r.Context(), query, args...).Scan(
-        &s.ID, &s.Name, &s.Slug, &s.Status, &s.RegionID, &s.TenantID,
-        &s.Facility, &s.Address, &s.Latitude, &s.Longitude, &s.Description,
-        &s.CustomFields, &createdAt, &updatedAt)
+    ctx := r.Context()
+    tx, err := h.DB.Pool.Begin(ctx)
     if err != nil {
-        response.NotFound(w, "Site")
+        response.InternalError(w, "database error")
         return
     }
-    scanSite(&s, &createdAt, &updatedAt)
-
-    _ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "sites", id, nil, s)
-
-    response.OK(w, s)
-}
+    defer tx.Rollback(ctx) //nolint:errcheck
 
-// Delete handles DELETE /sites/{id}
-func (h *SiteHandler) Delete(w http.ResponseWriter, r *http.Request) {
-    id := r.PathValue("id")
-    if id == "" {
-        response.BadRequest(w, "id is required")
+    cur, err := scanSite(tx.QueryRow(ctx,
+        fmt.Sprintf(`SELECT %s FROM sites WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, siteCols), id).Scan)
+    if err != nil {
+        response.DBError(w, err, "Site")
         return
     }
-
-    tag, err := h.DB.Pool.Exec(r.Context(), `
-        UPDATE sites SET deleted_at = $1
-        WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-    if err != nil || tag.RowsAffected() == 0 {
-        response.NotFound(w, "Site")
+    if err := etag.Check(ctx, tx, "sites", id); err != nil {
+        etag.WriteError(w, err)
+        return
+    }
+    // Custom fields are merged onto the locked row's values.
+    if cfBytes, ok, err := validateCustomFields(ctx, tx, "site", storedCustomFields(cur.CustomFields), body, false); err != nil {
+        writeCustomFieldError(w, err)
+        return
+    } else if ok {
+        setClauses = append(setClauses, fmt.Sprintf("custom_fields = $%d", len(args)+1))
+        args = append(args, cfBytes)
+    }
+    query := fmt.Sprintf(`UPDATE sites SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`,
+        joinStrings(setClauses, ", "), argIdx, siteCols)
+    s, err := scanSite(tx.QueryRow(ctx, query, args...).Scan)
+    if err != nil {
+        response.DBError(w, err, "Site")
+        return
+    }
+    if err := audit.LogEntry(ctx, tx, "update", "sites", id, cur, s); err != nil {
+        response.InternalError(w, "audit log failed")
+        return
+    }
+    if err := tx.Commit(ctx); err != nil {
+        response.InternalError(w, "commit failed")
         return
     }
 
-    _ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "sites", id, nil, nil)
+    response.OK(w, s)
+}
 
-    response.Message(w, "Site deleted", http.StatusOK)
+// Delete handles DELETE /sites/{id}?cascade=true
+func (h *SiteHandler) Delete(w http.ResponseWriter, r *http.Request) {
+    cascade.HandleDelete(w, r, h.DB.Pool, "sites", "Site")
 }
 
 func nilIfEmpty(s string) interface{} {
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/history"
+	"github.com/dcim/go-services/internal/shared/response"
+)
+
+// SnapshotHandler serves sites and racks with their contents as they were at
+// a past time, rebuilt from audit_logs.
+type SnapshotHandler struct{ DB *db.DB }
+
+type siteSnapshot struct {
+	AsOf      string                   `json:"asOf"`
+	Site      map[string]interface{}   `json:"site"`
+	Locations []map[string]interface{} `json:"locations"`
+	Racks     []map[string]interface{} `json:"racks"`
+	Devices   []map[string]interface{} `json:"devices"`
+}
+
+type rackSnapshot struct {
+	AsOf    string                   `json:"asOf"`
+	Rack    map[string]interface{}   `json:"rack"`
+	Devices []map[string]interface{} `json:"devices"`
+}
+
+// Site handles GET /sites/{id}/snapshot?asOf=2026-06-01T00:00:00Z: the site
+// with the locations, racks and devices it held at asOf, each as it was then.
+func (h *SnapshotHandler) Site(w http.ResponseWriter, r *http.Request) {
+	t, ok := asOf(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	hist := history.New(h.DB.Pool)
+	site, found, err := hist.State(ctx, "sites", r.PathValue("id"), t)
+	if err != nil {
+		snapshotError(w, "sites", err)
+		return
+	}
+	if !found {
+		notAt(w, "Site", t)
+		return
+	}
+
+	snap := siteSnapshot{AsOf: t.UTC().Format(time.RFC3339), Site: site}
+	if snap.Locations, err = hist.Children(ctx, "locations", "siteId", "site_id", []string{fmt.Sprint(site["id"])}, t); err != nil {
+		snapshotError(w, "locations", err)
+		return
+	}
+	if snap.Racks, err = hist.Children(ctx, "racks", "locationId", "location_id", ids(snap.Locations), t); err != nil {
+		snapshotError(w, "racks", err)
+		return
+	}
+	if snap.Devices, err = rackDevices(ctx, hist, ids(snap.Racks), t); err != nil {
+		snapshotError(w, "devices", err)
+		return
+	}
+	response.OK(w, snap)
+}
+
+// Rack handles GET /racks/{id}/snapshot?asOf=2026-06-01T00:00:00Z: the rack
+// and the devices mounted in it at asOf, each as it was then.
+func (h *SnapshotHandler) Rack(w http.ResponseWriter, r *http.Request) {
+	t, ok := asOf(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	hist := history.New(h.DB.Pool)
+	rack, found, err := hist.State(ctx, "racks", r.PathValue("id"), t)
+	if err != nil {
+		snapshotError(w, "racks", err)
+		return
+	}
+	if !found {
+		notAt(w, "Rack", t)
+		return
+	}
+
+	snap := rackSnapshot{AsOf: t.UTC().Format(time.RFC3339), Rack: rack}
+	if snap.Devices, err = rackDevices(ctx, hist, []string{fmt.Sprint(rack["id"])}, t); err != nil {
+		snapshotError(w, "devices", err)
+		return
+	}
+	response.OK(w, snap)
+}
+
+func rackDevices(ctx context.Context, hist *history.Store, rackIDs []string, t time.Time) ([]map[string]interface{}, error) {
+	return hist.Children(ctx, "devices", "rackId", "rack_id", rackIDs, t)
+}
+
+// asOf reads the required ?asOf= time, answering 400 when it is missing or invalid.
+func asOf(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	t, set, err := history.ParseAsOf(r)
+	if !set {
+		response.BadRequest(w, "asOf is required")
+		return t, false
+	}
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return t, false
+	}
+	return t, true
+}
+
+func notAt(w http.ResponseWriter, resource string, t time.Time) {
+	response.Error(w, fmt.Sprintf("%s did not exist at %s", resource, t.UTC().Format(time.RFC3339)), http.StatusNotFound)
+}
+
+func snapshotError(w http.ResponseWriter, table string, err error) {
+	log.Printf("snapshot error [%s]: %v", table, err)
+	response.InternalError(w, "database error")
+}
+
+func ids(rows []map[string]interface{}) []string {
+	out := make([]string, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, fmt.Sprint(row["id"]))
+	}
+	return out
+}
@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
+)
+
+type tenantUsageMonth struct {
+	Month     string  `json:"month"`
+	Racks     int     `json:"racks"`
+	Devices   int     `json:"devices"`
+	UsedU     int     `json:"usedU"`
+	AvgKw     float64 `json:"avgKw"`
+	EnergyKwh float64 `json:"energyKwh"`
+	// Changes against the previous month; the percentage is nil when the previous month had no draw.
+	DevicesDelta      int      `json:"devicesDelta"`
+	UsedUDelta        int      `json:"usedUDelta"`
+	AvgKwDeltaPercent *float64 `json:"avgKwDeltaPercent"`
+}
+
+type tenantUsage struct {
+	TenantID        string             `json:"tenantId"`
+	TenantName      string             `json:"tenantName"`
+	TenantSlug      string             `json:"tenantSlug"`
+	Racks           int                `json:"racks"`
+	RackU           int                `json:"rackU"`
+	UsedU           int                `json:"usedU"`
+	Devices         int                `json:"devices"`
+	DevicesByStatus map[string]int     `json:"devicesByStatus"`
+	Cables          int                `json:"cables"`
+	AllocatedKw     float64            `json:"allocatedKw"`
+	MeasuredKw      float64            `json:"measuredKw"`
+	Trend           []tenantUsageMonth `json:"trend"`
+}
+
+// Usage handles GET /tenants/{id}/usage?months=
+func (h *TenantHandler) Usage(w http.ResponseWriter, r *http.Request) {
+	months, ok := usageMonths(w, r)
+	if !ok {
+		return
+	}
+	results, err := h.usage(r.Context(), []string{r.PathValue("id")}, months)
+	if err != nil {
+		log.Printf("tenant usage error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	if len(results) == 0 {
+		response.NotFound(w, "Tenant")
+		return
+	}
+	response.OK(w, results[0])
+}
+
+// BulkUsage handles GET /tenants/usage?ids=a,b&months=
+// Without ids it reports every tenant.
+func (h *TenantHandler) BulkUsage(w http.ResponseWriter, r *http.Request) {
+	months, ok := usageMonths(w, r)
+	if !ok {
+		return
+	}
+	var ids []string
+	if v := r.URL.Query().Get("ids"); v != "" {
+		ids = strings.Split(v, ",")
+	}
+	results, err := h.usage(r.Context(), ids, months)
+	if err != nil {
+		log.Printf("tenant bulk usage error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	response.OK(w, results)
+}
+
+func usageMonths(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("months")
+	if v == "" {
+		return 6, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > 24 {
+		response.BadRequest(w, "months must be between 1 and 24")
+		return 0, false
+	}
+	return n, true
+}
+
+// usage reports utilization for the given tenants (all tenants when ids is nil).
+// Devices belong to their own tenant, or to the rack's tenant when unset.
+// The trend reconstructs each month-end inventory from created_at/deleted_at using
+// current tenancy, and averages each feed's measured draw over the month.
+func (h *TenantHandler) usage(ctx context.Context, ids []string, months int) ([]tenantUsage, error) {
+	query := `SELECT id, name, slug FROM tenants WHERE deleted_at IS NULL`
+	args := []interface{}{}
+	if ids != nil {
+		query += ` AND id = ANY($1)`
+		args = append(args, ids)
+	}
+	query += ` ORDER BY name`
+	rows, err := h.DB.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	results := []tenantUsage{}
+	byID := map[string]*tenantUsage{}
+	tenantIDs := []string{}
+	for rows.Next() {
+		var u tenantUsage
+		if err := rows.Scan(&u.TenantID, &u.TenantName, &u.TenantSlug); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		u.DevicesByStatus = map[string]int{}
+		u.Trend = []tenantUsageMonth{}
+		results = append(results, u)
+		tenantIDs = append(tenantIDs, u.TenantID)
+	}
+	rows.Close()
+	if len(results) == 0 {
+		return results, nil
+	}
+	for i := range results {
+		byID[results[i].TenantID] = &results[i]
+	}
+
+	// each runs a query over the tenant ids and hands every row to scan.
+	each := func(sql string, scan func(row pgx.Rows) error, extra ...interface{}) error {
+		rows, err := h.DB.Pool.Query(ctx, sql, append([]interface{}{tenantIDs}, extra...)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+
+	err = each(`
+		SELECT tenant_id, COUNT(*)::int, COALESCE(SUM(u_height), 0)::int
+		FROM racks
+		WHERE tenant_id = ANY($1) AND deleted_at IS NULL
+		GROUP BY tenant_id`,
+		func(row pgx.Rows) error {
+			var id string
+			var racks, rackU int
+			if err := row.Scan(&id, &racks, &rackU); err != nil {
+				return err
+			}
+			byID[id].Racks, byID[id].RackU = racks, rackU
+			return nil
+		})
+	if err == nil {
+		err = each(`
+			SELECT COALESCE(d.tenant_id, r.tenant_id), d.status::text, COUNT(*)::int,
+			       COALESCE(SUM(dt.u_height) FILTER (WHERE d.position IS NOT NULL AND d.rack_id IS NOT NULL), 0)::int,
+			       COALESCE(SUM(dt.power_draw), 0)::float8 / 1000.0
+			FROM devices d
+			JOIN device_types dt ON d.device_type_id = dt.id
+			LEFT JOIN racks r ON d.rack_id = r.id AND r.deleted_at IS NULL
+			WHERE d.deleted_at IS NULL AND COALESCE(d.tenant_id, r.tenant_id) = ANY($1)
+			GROUP BY 1, 2`,
+			func(row pgx.Rows) error {
+				var id, status string
+				var count, usedU int
+				var kw float64
+				if err := row.Scan(&id, &status, &count, &usedU, &kw); err != nil {
+					return err
+				}
+				u := byID[id]
+				u.DevicesByStatus[status] = count
+				u.Devices += count
+				u.UsedU += usedU
+				u.AllocatedKw += kw
+				return nil
+			})
+	}
+	if err == nil {
+		err = each(`
+			SELECT tenant_id, COUNT(*)::int
+			FROM cables
+			WHERE tenant_id = ANY($1) AND deleted_at IS NULL
+			GROUP BY tenant_id`,
+			func(row pgx.Rows) error {
+				var id string
+				var cables int
+				if err := row.Scan(&id, &cables); err != nil {
+					return err
+				}
+				byID[id].Cables = cables
+				return nil
+			})
+	}
+	if err == nil {
+		err = each(`
+			SELECT r.tenant_id, COALESCE(SUM(lr.power_kw), 0)::float8
+			FROM power_feeds f
+			JOIN racks r ON f.rack_id = r.id AND r.deleted_at IS NULL
+			LEFT JOIN LATERAL (
+				SELECT pr.power_kw FROM power_readings pr
+				WHERE pr.feed_id = f.id
+				ORDER BY pr.recorded_at DESC
+				LIMIT 1
+			) lr ON true
+			WHERE f.deleted_at IS NULL AND r.tenant_id = ANY($1)
+			GROUP BY r.tenant_id`,
+			func(row pgx.Rows) error {
+				var id string
+				var kw float64
+				if err := row.Scan(&id, &kw); err != nil {
+					return err
+				}
+				byID[id].MeasuredKw = kw
+				return nil
+			})
+	}
+	if err == nil {
+		err = each(`
+			WITH bounds AS (
+				SELECT m AS month_start, LEAST(m + INTERVAL '1 month', NOW()) AS month_end
+				FROM generate_series(
+					date_trunc('month', NOW()) - ($2::int - 1) * INTERVAL '1 month',
+					date_trunc('month', NOW()),
+					INTERVAL '1 month') AS m
+			)
+			SELECT t.tid, b.month_start, b.month_end, rk.racks, dv.devices, dv.used_u, pw.avg_kw
+			FROM unnest($1::text[]) AS t(tid)
+			CROSS JOIN bounds b
+			CROSS JOIN LATERAL (
+				SELECT COUNT(*)::int AS racks FROM racks r
+				WHERE r.tenant_id = t.tid AND r.created_at < b.month_end
+				  AND (r.deleted_at IS NULL OR r.deleted_at >= b.month_end)
+			) rk
+			CROSS JOIN LATERAL (
+				SELECT COUNT(*)::int AS devices,
+				       COALESCE(SUM(dt.u_height) FILTER (WHERE d.position IS NOT NULL AND d.rack_id IS NOT NULL), 0)::int AS used_u
+				FROM devices d
+				JOIN device_types dt ON d.device_type_id = dt.id
+				LEFT JOIN racks r ON d.rack_id = r.id
+				WHERE COALESCE(d.tenant_id, r.tenant_id) = t.tid AND d.created_at < b.month_end
+				  AND (d.deleted_at IS NULL OR d.deleted_at >= b.month_end)
+			) dv
+			CROSS JOIN LATERAL (
+				SELECT COALESCE(SUM(fa.avg_kw), 0)::float8 AS avg_kw FROM (
+					SELECT AVG(pr.power_kw) AS avg_kw
+					FROM power_readings pr
+					JOIN power_feeds f ON pr.feed_id = f.id
+					JOIN racks r ON f.rack_id = r.id
+					WHERE r.tenant_id = t.tid AND pr.recorded_at >= b.month_start AND pr.recorded_at < b.month_end
+					GROUP BY pr.feed_id
+				) fa
+			) pw
+			ORDER BY t.tid, b.month_start`,
+			func(row pgx.Rows) error {
+				var id string
+				var start, end time.Time
+				var m tenantUsageMonth
+				if err := row.Scan(&id, &start, &end, &m.Racks, &m.Devices, &m.UsedU, &m.AvgKw); err != nil {
+					return err
+				}
+				u := byID[id]
+				m.Month = start.UTC().Format("2006-01")
+				m.EnergyKwh = math.Round(m.AvgKw*end.Sub(start).Hours()*100) / 100
+				if n := len(u.Trend); n > 0 {
+					prev := u.Trend[n-1]
+					m.DevicesDelta = m.Devices - prev.Devices
+					m.UsedUDelta = m.UsedU - prev.UsedU
+					if prev.AvgKw > 0 {
+						pct := math.Round((m.AvgKw-prev.AvgKw)/prev.AvgKw*10000) / 100
+						m.AvgKwDeltaPercent = &pct
+					}
+				}
+				u.Trend = append(u.Trend, m)
+				return nil
+			}, months)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		u := &results[i]
+		u.AllocatedKw = math.Round(u.AllocatedKw*100) / 100
+		u.MeasuredKw = math.Round(u.MeasuredKw*100) / 100
+		for j := range u.Trend {
+			u.Trend[j].AvgKw = math.Round(u.Trend[j].AvgKw*100) / 100
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/dcim/go-services/internal/shared/trash"
+	"github.com/jackc/pgx/v5"
+)
+
+// TrashResources are the core resources with trash, restore and purge endpoints,
+// children before parents so expired rows purge in one pass.
+var TrashResources = []trash.Resource{
+	{
+		Path: "/devices", Table: "devices", Name: "Device", Label: "name",
+		Parents: []trash.Parent{
+			{Column: "device_type_id", Table: "device_types"},
+			{Column: "rack_id", Table: "racks"},
+			{Column: "tenant_id", Table: "tenants"},
+		},
+		PurgeFirst:   []string{`DELETE FROM device_status_history WHERE device_id = $1`},
+		CheckRestore: checkDeviceRestore,
+	},
+	{
+		Path: "/racks", Table: "racks", Name: "Rack", Label: "name",
+		Parents: []trash.Parent{
+			{Column: "location_id", Table: "locations"},
+			{Column: "tenant_id", Table: "tenants"},
+		},
+	},
+	{
+		Path: "/locations", Table: "locations", Name: "Location", Label: "name",
+		Parents: []trash.Parent{
+			{Column: "site_id", Table: "sites"},
+			{Column: "tenant_id", Table: "tenants"},
+		},
+	},
+	{
+		Path: "/sites", Table: "sites", Name: "Site", Label: "name",
+		Parents: []trash.Parent{
+			{Column: "region_id", Table: "regions"},
+			{Column: "tenant_id", Table: "tenants"},
+		},
+	},
+	{
+		Path: "/regions", Table: "regions", Name: "Region", Label: "name",
+		Parents: []trash.Parent{{Column: "parent_id", Table: "regions"}},
+	},
+	{
+		Path: "/device-types", Table: "device_types", Name: "Device type", Label: "model",
+		Parents: []trash.Parent{{Column: "manufacturer_id", Table: "manufacturers"}},
+	},
+	{Path: "/manufacturers", Table: "manufacturers", Name: "Manufacturer", Label: "name"},
+	{Path: "/tenants", Table: "tenants", Name: "Tenant", Label: "name"},
+	{Path: "/custom-fields", Table: "custom_field_definitions", Name: "Custom field", Label: "object_type || '.' || name"},
+}
+
+// checkDeviceRestore re-checks the rack placement of a trashed device: another
+// device may have taken its U span while it was in the trash.
+func checkDeviceRestore(ctx context.Context, tx pgx.Tx, id string) error {
+	var rackID *string
+	var dtID, face string
+	var position *int
+	if err := tx.QueryRow(ctx, `SELECT rack_id, device_type_id, face, position FROM devices WHERE id = $1`,
+		id).Scan(&rackID, &dtID, &face, &position); err != nil {
+		return err
+	}
+	return bulkError(checkRackPlacement(ctx, tx, id, rackID, dtID, position, face))
+}
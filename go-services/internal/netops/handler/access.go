@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -60,22 +62,61 @@ func scanAccessLog(scan func(dest ...interface{}) error) (accessLogRow, error) {
 	return a, nil
 }
 
+// accessLogConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /access-logs.
+var accessLogConfig = crud.Config{
+	Table:      "access_logs",
+	Name:       "Access log",
+	SoftDelete: true,
+	OrderBy:    "check_in_at DESC",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "site_id", JSON: "siteId", Type: "string", Required: true, Ref: "sites"},
+		{Name: "personnel_name", JSON: "personnelName", Type: "string", Required: true},
+		{Name: "company", JSON: "company", Type: "string", Nullable: true},
+		{Name: "contact_phone", JSON: "contactPhone", Type: "string", Nullable: true},
+		{Name: "access_type", JSON: "accessType", Type: "string", Required: true, Enum: []string{"visit", "maintenance", "delivery", "emergency", "tour"}},
+		{Name: "status", JSON: "status", Type: "string", Enum: []string{"checked_in", "checked_out", "expired", "denied"}},
+		{Name: "purpose", JSON: "purpose", Type: "string", Nullable: true},
+		{Name: "escort_name", JSON: "escortName", Type: "string", Nullable: true},
+		{Name: "badge_number", JSON: "badgeNumber", Type: "string", Nullable: true},
+		{Name: "check_in_at", JSON: "checkInAt", Type: "timestamp"},
+		{Name: "expected_check_out_at", JSON: "expectedCheckOutAt", Type: "timestamp", Nullable: true},
+		{Name: "actual_check_out_at", JSON: "actualCheckOutAt", Type: "timestamp", Nullable: true},
+		{Name: "check_out_note", JSON: "checkOutNote", Type: "string", Nullable: true},
+		{Name: "created_by", JSON: "createdBy", Type: "string", Nullable: true},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "siteId", Column: "site_id"},
+		{QueryParam: "status", Column: "status"},
+		{QueryParam: "accessType", Column: "access_type"},
+		{QueryParam: "personnelName", Column: "personnel_name", Op: "ilike"},
+		{QueryParam: "company", Column: "company", Op: "ilike"},
+		{QueryParam: "badgeNumber", Column: "badge_number"},
+		{QueryParam: "checkInAt", Column: "check_in_at", Type: "timestamp"},
+		{QueryParam: "expectedCheckOutAt", Column: "expected_check_out_at", Type: "timestamp"},
+		{QueryParam: "actualCheckOutAt", Column: "actual_check_out_at", Type: "timestamp"},
+		{QueryParam: "createdBy", Column: "created_by"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+}
+
 func (h *AccessLogHandler) List(w http.ResponseWriter, r *http.Request) {
-	query := fmt.Sprintf(`SELECT %s FROM access_logs WHERE deleted_at IS NULL`, alCols)
-	args := []interface{}{}
-	ai := 1
-	if v := r.URL.Query().Get("siteId"); v != "" {
-		query += fmt.Sprintf(" AND site_id = $%d", ai)
-		args = append(args, v)
-		ai++
+	q, err := crud.ParseQuery(r, accessLogConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
 	}
-	if v := r.URL.Query().Get("status"); v != "" {
-		query += fmt.Sprintf(" AND status = $%d", ai)
-		args = append(args, v)
-		ai++
+	from := ` FROM access_logs WHERE deleted_at IS NULL` + q.Where
+	if !crud.Total(w, r, h.DB.Pool, q, from, q.Args) {
+		return
 	}
-	query += " ORDER BY check_in_at DESC"
-	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
+	seek, args := q.Seek(q.Args)
+	rows, err := h.DB.Pool.Query(r.Context(),
+		`SELECT `+alCols+from+seek+" ORDER BY "+q.OrderBy+q.LimitClause(), args...)
 	if err != nil {
 		log.Printf("access_log list error: %v", err)
 		response.InternalError(w, "database error")
@@ -90,7 +131,7 @@ func (h *AccessLogHandler) List(w http.ResponseWriter, r *http.Request) {
 		}
 		results = append(results, a)
 	}
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 func (h *AccessLogHandler) Get(w http.ResponseWriter, r *http.Request) {
@@ -110,6 +151,9 @@ func (h *AccessLogHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, accessLogConfig, body, true) {
+		return
+	}
 	siteID, _ := body["siteId"].(string)
 	personnelName, _ := body["personnelName"].(string)
 	accessType, _ := body["accessType"].(string)
@@ -127,17 +171,31 @@ func (h *AccessLogHandler) Create(w http.ResponseWriter, r *http.Request) {
 	escort, _ := body["escortName"].(string)
 	badge, _ := body["badgeNumber"].(string)
 
-	a, err := scanAccessLog(h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	a, err := scanAccessLog(tx.QueryRow(ctx,
 		fmt.Sprintf(`INSERT INTO access_logs (site_id, personnel_name, company, contact_phone, access_type, status, purpose, escort_name, badge_number)
 		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING %s`, alCols),
 		siteID, personnelName, nilIfEmpty(company), nilIfEmpty(phone), accessType, status,
 		nilIfEmpty(purpose), nilIfEmpty(escort), nilIfEmpty(badge)).Scan)
 	if err != nil {
-		log.Printf("access_log create error: %v", err)
-		response.InternalError(w, "create failed")
+		response.DBError(w, err, "Access log")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "create", "access_logs", a.ID, nil, a); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "access_logs", a.ID, nil, a)
 	response.Created(w, a)
 }
 
@@ -148,6 +206,9 @@ func (h *AccessLogHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, accessLogConfig, body, false) {
+		return
+	}
 	sc := []string{}
 	args := []interface{}{}
 	ai := 1
@@ -177,23 +238,68 @@ func (h *AccessLogHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	args = append(args, id)
-	a, err := scanAccessLog(h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	cur, err := scanAccessLog(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM access_logs WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, alCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Access log")
+		return
+	}
+	if err := etag.Check(ctx, tx, "access_logs", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	a, err := scanAccessLog(tx.QueryRow(ctx,
 		fmt.Sprintf(`UPDATE access_logs SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`, joinStrings(sc, ", "), ai, alCols), args...).Scan)
 	if err != nil {
-		response.NotFound(w, "Access log")
+		response.DBError(w, err, "Access log")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "access_logs", id, cur, a); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "access_logs", id, nil, a)
 	response.OK(w, a)
 }
 
 func (h *AccessLogHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	tag, err := h.DB.Pool.Exec(r.Context(), `UPDATE access_logs SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Access log")
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if err := etag.Check(ctx, tx, "access_logs", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	// The returned columns exclude deleted_at, so they are the row's before-state.
+	before, err := scanAccessLog(tx.QueryRow(ctx,
+		fmt.Sprintf(`UPDATE access_logs SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL RETURNING %s`, alCols), time.Now().UTC(), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Access log")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "delete", "access_logs", id, before, nil); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "access_logs", id, nil, nil)
 	response.Message(w, "Access log deleted", http.StatusOK)
 }
@@ -9,7 +9,9 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -34,9 +36,66 @@ type alertRuleRow struct {
 
 const arCols = `id, name, rule_type, resource, condition_field, condition_operator, threshold_value, severity, enabled, notification_channels, cooldown_minutes, created_by, created_at, updated_at`
 
+// alertRuleConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /alerts/rules.
+var alertRuleConfig = crud.Config{
+	Table:   "alert_rules",
+	Name:    "Alert rule",
+	OrderBy: "name",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "name", JSON: "name", Type: "string", Required: true},
+		{Name: "rule_type", JSON: "ruleType", Type: "string", Required: true, Enum: []string{"power_threshold", "warranty_expiry", "rack_capacity"}},
+		{Name: "resource", JSON: "resource", Type: "string", Required: true},
+		{Name: "condition_field", JSON: "conditionField", Type: "string", Required: true},
+		{Name: "condition_operator", JSON: "conditionOperator", Type: "string", Required: true, Enum: []string{"gt", "lt", "gte", "lte", "eq"}},
+		{Name: "threshold_value", JSON: "thresholdValue", Type: "decimal", Required: true},
+		{Name: "severity", JSON: "severity", Type: "string", Required: true, Enum: []string{"critical", "warning", "info"}},
+		{Name: "enabled", JSON: "enabled", Type: "bool"},
+		{Name: "notification_channels", JSON: "notificationChannels", Type: "json"},
+		{Name: "cooldown_minutes", JSON: "cooldownMinutes", Type: "int", Range: crud.AtLeast(0)},
+		{Name: "created_by", JSON: "createdBy", Type: "string", Nullable: true},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "name", Column: "name", Op: "ilike"},
+		{QueryParam: "ruleType", Column: "rule_type"},
+		{QueryParam: "resource", Column: "resource"},
+		{QueryParam: "severity", Column: "severity"},
+		{QueryParam: "enabled", Column: "enabled", Type: "bool"},
+		{QueryParam: "createdBy", Column: "created_by"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+}
+
+func scanAlertRule(scan func(dest ...interface{}) error) (alertRuleRow, error) {
+	var a alertRuleRow
+	var ca, ua time.Time
+	if err := scan(&a.ID, &a.Name, &a.RuleType, &a.Resource, &a.ConditionField, &a.ConditionOperator, &a.ThresholdValue, &a.Severity, &a.Enabled, &a.NotificationChannels, &a.CooldownMinutes, &a.CreatedBy, &ca, &ua); err != nil {
+		return a, err
+	}
+	if a.NotificationChannels == nil {
+		a.NotificationChannels = json.RawMessage("[]")
+	}
+	a.CreatedAt = ca.UTC().Format(time.RFC3339)
+	a.UpdatedAt = ua.UTC().Format(time.RFC3339)
+	return a, nil
+}
+
 func (h *AlertRuleHandler) List(w http.ResponseWriter, r *http.Request) {
+	q, err := crud.ParseQuery(r, alertRuleConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	if !crud.Total(w, r, h.DB.Pool, q, ` FROM alert_rules`+crud.WhereClause(q.Where), q.Args) {
+		return
+	}
+	seek, args := q.Seek(q.Args)
 	rows, err := h.DB.Pool.Query(r.Context(),
-		fmt.Sprintf(`SELECT %s FROM alert_rules ORDER BY name`, arCols))
+		fmt.Sprintf(`SELECT %s FROM alert_rules%s ORDER BY %s%s`, arCols, crud.WhereClause(q.Where+seek), q.OrderBy, q.LimitClause()), args...)
 	if err != nil {
 		log.Printf("alert_rule list error: %v", err)
 		response.InternalError(w, "database error")
@@ -45,41 +104,23 @@ func (h *AlertRuleHandler) List(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 	results := []alertRuleRow{}
 	for rows.Next() {
-		var a alertRuleRow
-		var ca, ua time.Time
-		if err := rows.Scan(&a.ID, &a.Name, &a.RuleType, &a.Resource, &a.ConditionField,
-			&a.ConditionOperator, &a.ThresholdValue, &a.Severity, &a.Enabled,
-			&a.NotificationChannels, &a.CooldownMinutes, &a.CreatedBy, &ca, &ua); err != nil {
+		a, err := scanAlertRule(rows.Scan)
+		if err != nil {
 			continue
 		}
-		if a.NotificationChannels == nil {
-			a.NotificationChannels = json.RawMessage("[]")
-		}
-		a.CreatedAt = ca.UTC().Format(time.RFC3339)
-		a.UpdatedAt = ua.UTC().Format(time.RFC3339)
 		results = append(results, a)
 	}
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 func (h *AlertRuleHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	var a alertRuleRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
-		fmt.Sprintf(`SELECT %s FROM alert_rules WHERE id = $1`, arCols), id).Scan(
-		&a.ID, &a.Name, &a.RuleType, &a.Resource, &a.ConditionField,
-		&a.ConditionOperator, &a.ThresholdValue, &a.Severity, &a.Enabled,
-		&a.NotificationChannels, &a.CooldownMinutes, &a.CreatedBy, &ca, &ua)
+	a, err := scanAlertRule(h.DB.Pool.QueryRow(r.Context(),
+		fmt.Sprintf(`SELECT %s FROM alert_rules WHERE id = $1`, arCols), id).Scan)
 	if err != nil {
 		response.NotFound(w, "Alert rule")
 		return
 	}
-	if a.NotificationChannels == nil {
-		a.NotificationChannels = json.RawMessage("[]")
-	}
-	a.CreatedAt = ca.UTC().Format(time.RFC3339)
-	a.UpdatedAt = ua.UTC().Format(time.RFC3339)
 	response.OK(w, a)
 }
 
@@ -89,6 +130,9 @@ func (h *AlertRuleHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, alertRuleConfig, body, true) {
+		return
+	}
 	name, _ := body["name"].(string)
 	ruleType, _ := body["ruleType"].(string)
 	resource, _ := body["resource"].(string)
@@ -120,26 +164,30 @@ func (h *AlertRuleHandler) Create(w http.ResponseWriter, r *http.Request) {
 		channels = string(b)
 	}
 
-	var a alertRuleRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	a, err := scanAlertRule(tx.QueryRow(ctx,
 		fmt.Sprintf(`INSERT INTO alert_rules (name, rule_type, resource, condition_field, condition_operator, threshold_value, severity, enabled, notification_channels, cooldown_minutes)
 		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9::jsonb,$10) RETURNING %s`, arCols),
-		name, ruleType, resource, condField, condOp, threshold, severity, enabled, channels, cooldown).Scan(
-		&a.ID, &a.Name, &a.RuleType, &a.Resource, &a.ConditionField,
-		&a.ConditionOperator, &a.ThresholdValue, &a.Severity, &a.Enabled,
-		&a.NotificationChannels, &a.CooldownMinutes, &a.CreatedBy, &ca, &ua)
+		name, ruleType, resource, condField, condOp, threshold, severity, enabled, channels, cooldown).Scan)
 	if err != nil {
-		log.Printf("alert_rule create error: %v", err)
-		response.InternalError(w, "create failed")
+		response.DBError(w, err, "Alert rule")
 		return
 	}
-	if a.NotificationChannels == nil {
-		a.NotificationChannels = json.RawMessage("[]")
+	if err := audit.LogEntry(ctx, tx, "create", "alert_rules", a.ID, nil, a); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
 	}
-	a.CreatedAt = ca.UTC().Format(time.RFC3339)
-	a.UpdatedAt = ua.UTC().Format(time.RFC3339)
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "alert_rules", a.ID, nil, a)
 	response.Created(w, a)
 }
 
@@ -150,6 +198,9 @@ func (h *AlertRuleHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, alertRuleConfig, body, false) {
+		return
+	}
 	sc := []string{}
 	args := []interface{}{}
 	ai := 1
@@ -188,34 +239,68 @@ func (h *AlertRuleHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	args = append(args, id)
-	var a alertRuleRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
-		fmt.Sprintf(`UPDATE alert_rules SET %s WHERE id = $%d RETURNING %s`, joinStrings(sc, ", "), ai, arCols), args...).Scan(
-		&a.ID, &a.Name, &a.RuleType, &a.Resource, &a.ConditionField,
-		&a.ConditionOperator, &a.ThresholdValue, &a.Severity, &a.Enabled,
-		&a.NotificationChannels, &a.CooldownMinutes, &a.CreatedBy, &ca, &ua)
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
 	if err != nil {
-		response.NotFound(w, "Alert rule")
+		response.InternalError(w, "database error")
 		return
 	}
-	if a.NotificationChannels == nil {
-		a.NotificationChannels = json.RawMessage("[]")
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	cur, err := scanAlertRule(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM alert_rules WHERE id = $1 FOR UPDATE`, arCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Alert rule")
+		return
+	}
+	if err := etag.Check(ctx, tx, "alert_rules", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	a, err := scanAlertRule(tx.QueryRow(ctx,
+		fmt.Sprintf(`UPDATE alert_rules SET %s WHERE id = $%d RETURNING %s`, joinStrings(sc, ", "), ai, arCols), args...).Scan)
+	if err != nil {
+		response.DBError(w, err, "Alert rule")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "alert_rules", id, cur, a); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
 	}
-	a.CreatedAt = ca.UTC().Format(time.RFC3339)
-	a.UpdatedAt = ua.UTC().Format(time.RFC3339)
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "alert_rules", id, nil, a)
 	response.OK(w, a)
 }
 
 func (h *AlertRuleHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	tag, err := h.DB.Pool.Exec(r.Context(), `DELETE FROM alert_rules WHERE id = $1`, id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Alert rule")
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if err := etag.Check(ctx, tx, "alert_rules", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	before, err := scanAlertRule(tx.QueryRow(ctx,
+		fmt.Sprintf(`DELETE FROM alert_rules WHERE id = $1 RETURNING %s`, arCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Alert rule")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "delete", "alert_rules", id, before, nil); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "alert_rules", id, nil, nil)
 	response.Message(w, "Alert rule deleted", http.StatusOK)
 }
 
@@ -239,10 +324,34 @@ type alertHistoryRow struct {
 	CreatedAt      string  `json:"createdAt"`
 }
 
+// alertHistoryList whitelists the list filters and sort keys of GET /alerts/history.
+var alertHistoryList = crud.Config{
+	Table:   "alert_history",
+	OrderBy: "created_at DESC",
+	Filters: []crud.FilterDef{
+		{QueryParam: "ruleId", Column: "rule_id"},
+		{QueryParam: "severity", Column: "severity"},
+		{QueryParam: "resourceType", Column: "resource_type"},
+		{QueryParam: "resourceId", Column: "resource_id"},
+		{QueryParam: "acknowledgedAt", Column: "acknowledged_at", Type: "timestamp"},
+		{QueryParam: "resolvedAt", Column: "resolved_at", Type: "timestamp"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+	},
+}
+
 func (h *AlertHistoryHandler) List(w http.ResponseWriter, r *http.Request) {
+	q, err := crud.ParseQuery(r, alertHistoryList, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	if !crud.Total(w, r, h.DB.Pool, q, ` FROM alert_history`+crud.WhereClause(q.Where), q.Args) {
+		return
+	}
+	seek, args := q.Seek(q.Args)
 	rows, err := h.DB.Pool.Query(r.Context(),
 		`SELECT id, rule_id, severity, message, resource_type, resource_id, resource_name, threshold_value, actual_value, acknowledged_at, acknowledged_by, resolved_at, created_at
-		FROM alert_history ORDER BY created_at DESC`)
+		FROM alert_history`+crud.WhereClause(q.Where+seek)+` ORDER BY `+q.OrderBy+q.LimitClause(), args...)
 	if err != nil {
 		log.Printf("alert_history list error: %v", err)
 		response.InternalError(w, "database error")
@@ -270,7 +379,7 @@ func (h *AlertHistoryHandler) List(w http.ResponseWriter, r *http.Request) {
 		}
 		results = append(results, a)
 	}
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 func (h *AlertHistoryHandler) Acknowledge(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/apitoken"
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
+	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/rbac"
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
+)
+
+// APITokenHandler manages the API tokens of direct service clients. The
+// routes are for the BFF only, so that a token cannot mint itself a broader
+// one. Users manage their own tokens; managing those of other users is user
+// administration and needs an unscoped users permission.
+type APITokenHandler struct{ DB *db.DB }
+
+// tokenList whitelists the filters of GET /api-tokens.
+var tokenList = crud.Config{
+	Table:   "api_tokens",
+	OrderBy: "name",
+	Filters: []crud.FilterDef{
+		{QueryParam: "name", Column: "name", Op: "ilike"},
+		{QueryParam: "ownerId", Column: "owner_id"},
+		{QueryParam: "prefix", Column: "prefix"},
+		{QueryParam: "expiresAt", Column: "expires_at", Type: "timestamp"},
+		{QueryParam: "lastUsedAt", Column: "last_used_at", Type: "timestamp"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+	},
+}
+
+// tokenBody is the body of POST and PATCH /api-tokens. Absent fields are left
+// unchanged on PATCH; a null expiresAt removes the expiry.
+type tokenBody struct {
+	Name       *string         `json:"name"`
+	Scopes     *[]string       `json:"scopes"`
+	AllowedIPs *[]string       `json:"allowedIps"`
+	ExpiresAt  json.RawMessage `json:"expiresAt"`
+}
+
+// check validates b and returns its expiry: nil when absent or null.
+func (b *tokenBody) check() (expires *time.Time, issues []map[string]string) {
+	if b.Name != nil && strings.TrimSpace(*b.Name) == "" {
+		issues = append(issues, map[string]string{"path": "name", "message": "must not be empty"})
+	}
+	if b.Scopes != nil {
+		for _, s := range *b.Scopes {
+			if err := apitoken.CheckScope(s); err != nil {
+				issues = append(issues, map[string]string{"path": "scopes", "message": err.Error()})
+			}
+		}
+	}
+	if b.AllowedIPs != nil {
+		for _, ip := range *b.AllowedIPs {
+			if err := apitoken.CheckIP(ip); err != nil {
+				issues = append(issues, map[string]string{"path": "allowedIps", "message": err.Error()})
+			}
+		}
+	}
+	if len(b.ExpiresAt) > 0 && string(b.ExpiresAt) != "null" {
+		var s string
+		_ = json.Unmarshal(b.ExpiresAt, &s)
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			issues = append(issues, map[string]string{"path": "expiresAt", "message": "must be an RFC 3339 timestamp"})
+		} else {
+			expires = &t
+		}
+	}
+	return expires, issues
+}
+
+// List handles GET /api-tokens?ownerId=&name=
+func (h *APITokenHandler) List(w http.ResponseWriter, r *http.Request) {
+	q, err := crud.ParseQuery(r, tokenList, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	// Revoked tokens are kept for the audit trail but not listed.
+	where := q.Where + " AND revoked_at IS NULL"
+	args := q.Args
+	if owner := tokenOwner(r); owner != "" {
+		where += fmt.Sprintf(" AND owner_id = $%d", q.Next)
+		args = append(args, owner)
+	}
+	if !crud.Total(w, r, h.DB.Pool, q, ` FROM api_tokens`+crud.WhereClause(where), args) {
+		return
+	}
+	seek, args := q.Seek(args)
+	rows, err := h.DB.Pool.Query(r.Context(), `SELECT `+apitoken.Cols+` FROM api_tokens`+
+		crud.WhereClause(where+seek)+` ORDER BY `+q.OrderBy+q.LimitClause(), args...)
+	if err != nil {
+		log.Printf("api token list error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	defer rows.Close()
+	results := []*apitoken.Token{}
+	for rows.Next() {
+		t, err := apitoken.Scan(rows.Scan)
+		if err != nil {
+			continue
+		}
+		results = append(results, t)
+	}
+	crud.List(w, r, h.DB.Pool, q, results)
+}
+
+// Get handles GET /api-tokens/{id}
+func (h *APITokenHandler) Get(w http.ResponseWriter, r *http.Request) {
+	t, err := h.fetch(h.DB.Pool, r, r.PathValue("id"), false)
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, "API token")
+		return
+	}
+	if err != nil {
+		response.DBError(w, err, "API token")
+		return
+	}
+	response.OK(w, t)
+}
+
+// Create handles POST /api-tokens
+//
+//	{"name": "inventory sync", "scopes": ["devices:write", "racks:read"],
+//	 "allowedIps": ["10.0.0.0/8"], "expiresAt": "2027-01-01T00:00:00Z"}
+//
+// The token is owned by the signed-in user the BFF forwards. The response
+// carries the token in its "token" field; it is not stored and cannot be
+// shown again.
+func (h *APITokenHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var body tokenBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		response.BadRequest(w, "invalid JSON")
+		return
+	}
+	owner := audit.ActorFrom(r.Context()).UserID
+	if owner == "" {
+		response.BadRequest(w, "API tokens are created for the signed-in user; x-user-id is required")
+		return
+	}
+	expires, issues := body.check()
+	if body.Name == nil {
+		issues = append(issues, map[string]string{"path": "name", "message": "is required"})
+	}
+	if body.Scopes == nil || len(*body.Scopes) == 0 {
+		issues = append(issues, map[string]string{"path": "scopes", "message": "must grant at least one scope"})
+	}
+	if issues != nil {
+		response.ValidationError(w, "Validation failed", issues)
+		return
+	}
+	if body.AllowedIPs == nil {
+		body.AllowedIPs = &[]string{}
+	}
+
+	token, hash, err := apitoken.Generate()
+	if err != nil {
+		log.Printf("api token generate error: %v", err)
+		response.InternalError(w, "could not generate token")
+		return
+	}
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	t, err := apitoken.Scan(tx.QueryRow(ctx, `
+		INSERT INTO api_tokens (id, name, owner_id, token_hash, prefix, scopes, allowed_ips, expires_at)
+		VALUES (gen_random_uuid()::text, $1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+apitoken.Cols,
+		strings.TrimSpace(*body.Name), owner, hash, token[:len(apitoken.Prefix)+6],
+		*body.Scopes, *body.AllowedIPs, expires).Scan)
+	if err != nil {
+		response.DBError(w, err, "API token")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "create", "api_tokens", t.ID, nil, t); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+	response.Created(w, createdToken{t, token})
+}
+
+// createdToken is a new token with its secret, as answered once by Create.
+type createdToken struct {
+	*apitoken.Token
+	Secret string `json:"token"`
+}
+
+// Update handles PATCH /api-tokens/{id}: name, scopes, allowedIps and
+// expiresAt. The token itself never changes; create a new one to rotate it.
+func (h *APITokenHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var body tokenBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		response.BadRequest(w, "invalid JSON")
+		return
+	}
+	expires, issues := body.check()
+	if body.Scopes != nil && len(*body.Scopes) == 0 {
+		issues = append(issues, map[string]string{"path": "scopes", "message": "must grant at least one scope"})
+	}
+	if issues != nil {
+		response.ValidationError(w, "Validation failed", issues)
+		return
+	}
+
+	id := r.PathValue("id")
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	before, err := h.fetch(tx, r, id, true)
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, "API token")
+		return
+	}
+	if err != nil {
+		response.DBError(w, err, "API token")
+		return
+	}
+	name := before.Name
+	if body.Name != nil {
+		name = strings.TrimSpace(*body.Name)
+	}
+	scopes, ips := before.Scopes, before.AllowedIPs
+	if body.Scopes != nil {
+		scopes = *body.Scopes
+	}
+	if body.AllowedIPs != nil {
+		ips = *body.AllowedIPs
+	}
+	after, err := apitoken.Scan(tx.QueryRow(ctx, `
+		UPDATE api_tokens
+		SET name = $2, scopes = $3, allowed_ips = $4,
+		    expires_at = CASE WHEN $5 THEN $6::timestamptz ELSE expires_at END, updated_at = now()
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING `+apitoken.Cols, id, name, scopes, ips, len(body.ExpiresAt) > 0, expires).Scan)
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, "API token")
+		return
+	}
+	if err != nil {
+		response.DBError(w, err, "API token")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "api_tokens", id, before, after); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+	response.OK(w, after)
+}
+
+// Revoke handles DELETE /api-tokens/{id}. The row is kept, revoked, so the
+// audit trail can still name the token.
+func (h *APITokenHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	before, err := h.fetch(tx, r, id, true)
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, "API token")
+		return
+	}
+	if err != nil {
+		response.DBError(w, err, "API token")
+		return
+	}
+	after, err := apitoken.Scan(tx.QueryRow(ctx, `
+		UPDATE api_tokens SET revoked_at = now(), updated_at = now()
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING `+apitoken.Cols, id).Scan)
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, "API token")
+		return
+	}
+	if err != nil {
+		response.DBError(w, err, "API token")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "revoke", "api_tokens", id, before, after); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+	response.Message(w, "API token revoked", http.StatusOK)
+}
+
+// rowQuerier is satisfied by both *pgxpool.Pool and pgx.Tx.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// fetch returns the live token id, if r may manage it. lock takes a row lock
+// for the rest of the transaction.
+func (h *APITokenHandler) fetch(q rowQuerier, r *http.Request, id string, lock bool) (*apitoken.Token, error) {
+	query := `SELECT ` + apitoken.Cols + ` FROM api_tokens
+		WHERE id = $1 AND revoked_at IS NULL AND ($2 = '' OR owner_id = $2)`
+	if lock {
+		query += " FOR UPDATE"
+	}
+	return apitoken.Scan(q.QueryRow(r.Context(), query, id, tokenOwner(r)).Scan)
+}
+
+// tokenOwner returns the user whose tokens r may manage, or "" for everyone's.
+func tokenOwner(r *http.Request) string {
+	a := rbac.FromContext(r.Context())
+	if a == nil {
+		return audit.ActorFrom(r.Context()).UserID
+	}
+	if a.Everywhere("users", rbac.Action(r.Method)) {
+		return ""
+	}
+	return a.UserID
+}
@@ -1,16 +1,22 @@
 package handler
 
 import (
-	"fmt"
+	"encoding/json"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/history"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
-type AuditLogHandler struct{ DB *db.DB }
+type AuditLogHandler struct {
+	DB     *db.DB
+	Signer *audit.Signer // nil when checkpoint signing is not configured
+}
 
 type auditLogRow struct {
 	ID            string  `json:"id"`
@@ -25,33 +31,38 @@ type auditLogRow struct {
 	IPAddress     *string `json:"ipAddress"`
 	UserAgent     *string `json:"userAgent"`
 	CreatedAt     string  `json:"createdAt"`
+	ChainSeq      *int64  `json:"chainSeq"`
+	Hash          *string `json:"hash"`
+}
+
+// auditLogList whitelists the list filters and sort keys of GET /audit-logs.
+// Pages hold 200 entries unless ?limit= asks otherwise.
+var auditLogList = crud.Config{
+	Table:    "audit_logs",
+	OrderBy:  "created_at DESC",
+	PageSize: 200,
+	Filters: []crud.FilterDef{
+		{QueryParam: "tableName", Column: "table_name"},
+		{QueryParam: "recordId", Column: "record_id"},
+		{QueryParam: "action", Column: "action"},
+		{QueryParam: "actionType", Column: "action_type"},
+		{QueryParam: "userId", Column: "user_id"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+	},
 }
 
 func (h *AuditLogHandler) List(w http.ResponseWriter, r *http.Request) {
-	query := `SELECT id, user_id, action, action_type, table_name, record_id, changes_before, changes_after, reason, ip_address, user_agent, created_at
-		FROM audit_logs`
-	args := []interface{}{}
-	ai := 1
-	where := ""
-	if v := r.URL.Query().Get("tableName"); v != "" {
-		where += fmt.Sprintf(" AND table_name = $%d", ai)
-		args = append(args, v)
-		ai++
-	}
-	if v := r.URL.Query().Get("recordId"); v != "" {
-		where += fmt.Sprintf(" AND record_id = $%d", ai)
-		args = append(args, v)
-		ai++
-	}
-	if v := r.URL.Query().Get("action"); v != "" {
-		where += fmt.Sprintf(" AND action = $%d", ai)
-		args = append(args, v)
-		ai++
+	q, err := crud.ParseQuery(r, auditLogList, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
 	}
-	if where != "" {
-		query += " WHERE " + where[5:] // trim leading " AND "
+	if !crud.Total(w, r, h.DB.Pool, q, ` FROM audit_logs`+crud.WhereClause(q.Where), q.Args) {
+		return
 	}
-	query += " ORDER BY created_at DESC LIMIT 200"
+	seek, args := q.Seek(q.Args)
+	query := `SELECT id, user_id, action, action_type, table_name, record_id, changes_before, changes_after, reason, ip_address, user_agent, created_at, chain_seq, hash
+		FROM audit_logs` + crud.WhereClause(q.Where+seek) + ` ORDER BY ` + q.OrderBy + q.LimitClause()
 
 	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
 	if err != nil {
@@ -66,11 +77,78 @@ func (h *AuditLogHandler) List(w http.ResponseWriter, r *http.Request) {
 		var ca time.Time
 		if err := rows.Scan(&a.ID, &a.UserID, &a.Action, &a.ActionType, &a.TableName,
 			&a.RecordID, &a.ChangesBefore, &a.ChangesAfter, &a.Reason,
-			&a.IPAddress, &a.UserAgent, &ca); err != nil {
+			&a.IPAddress, &a.UserAgent, &ca, &a.ChainSeq, &a.Hash); err != nil {
 			continue
 		}
 		a.CreatedAt = ca.UTC().Format(time.RFC3339)
 		results = append(results, a)
 	}
+	crud.List(w, r, h.DB.Pool, q, results)
+}
+
+// History handles GET /audit-logs/{table}/{recordId}/history: every audit
+// entry of the record, oldest first, with the fields each one changed.
+func (h *AuditLogHandler) History(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("recordId")
+	entries, err := history.New(h.DB.Pool).Entries(r.Context(), r.PathValue("table"), []string{id})
+	if err != nil {
+		log.Printf("audit_log history error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	results := entries[id]
+	if results == nil {
+		results = []history.Entry{}
+	}
+	history.Replay(results)
 	response.OK(w, results)
 }
+
+// Verify handles GET /audit-logs/verify: walks the hash chain and reports the
+// first entry that was changed, removed or inserted out of order.
+func (h *AuditLogHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	v, err := audit.Verify(r.Context(), h.DB.Pool)
+	if err != nil {
+		log.Printf("audit_log verify error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	response.OK(w, v)
+}
+
+// Checkpoint handles GET /audit-logs/checkpoint: a signed statement of the
+// current chain head, for export to storage outside the database.
+func (h *AuditLogHandler) Checkpoint(w http.ResponseWriter, r *http.Request) {
+	if h.Signer == nil {
+		response.Error(w, "checkpoint signing is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	c, err := h.Signer.Checkpoint(r.Context(), h.DB.Pool)
+	if err != nil {
+		log.Printf("audit_log checkpoint error: %v", err)
+		response.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	response.OK(w, c)
+}
+
+// CheckCheckpoint handles POST /audit-logs/checkpoint/verify with a checkpoint
+// exported earlier, reporting whether the chain still matches it.
+func (h *AuditLogHandler) CheckCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if h.Signer == nil {
+		response.Error(w, "checkpoint signing is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var c audit.Checkpoint
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		response.BadRequest(w, "invalid JSON")
+		return
+	}
+	reason, err := h.Signer.Check(r.Context(), h.DB.Pool, c)
+	if err != nil {
+		log.Printf("audit_log checkpoint verify error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	response.OK(w, map[string]interface{}{"valid": reason == "", "reason": reason})
+}
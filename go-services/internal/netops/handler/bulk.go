@@ -0,0 +1,17 @@
+package handler
+
+import "github.com/dcim/go-services/internal/shared/crud"
+
+// BulkResources are the network-ops resources with a POST /{resource}/bulk endpoint.
+var BulkResources = []crud.BulkResource{
+	{Path: "/cables", Config: cableConfig},
+	{Path: "/interfaces", Config: interfaceConfig},
+	{Path: "/console-ports", Config: ConsolePortConfig},
+	{Path: "/front-ports", Config: FrontPortConfig},
+	{Path: "/rear-ports", Config: RearPortConfig},
+	{Path: "/access-logs", Config: accessLogConfig},
+	{Path: "/equipment-movements", Config: equipmentMovementConfig},
+	{Path: "/alerts/rules", Config: alertRuleConfig},
+	{Path: "/alerts/channels", Config: ChannelConfig},
+	{Path: "/reports/schedules", Config: reportScheduleConfig},
+}
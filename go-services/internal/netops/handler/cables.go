@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -31,29 +33,80 @@ type cableRow struct {
 	UpdatedAt        string  `json:"updatedAt"`
 }
 
+// cableTypes are the values of the cable_type enum.
+var cableTypes = []string{"cat5e", "cat6", "cat6a", "fiber-om3", "fiber-om4", "fiber-sm", "dac", "power", "console"}
+
+// terminationTypes are the component kinds a cable end can attach to.
+var terminationTypes = []string{"interface", "frontPort", "rearPort", "consolePort", "powerPort", "powerOutlet"}
+
 const cableCols = `id, cable_type, status, label, length, color, termination_a_type, termination_a_id, termination_b_type, termination_b_id, tenant_id, description, created_at, updated_at`
 
+// cableConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /cables.
+// search is kept as a label substring filter for existing clients.
+var cableConfig = crud.Config{
+	Table:      "cables",
+	Name:       "Cable",
+	SoftDelete: true,
+	OrderBy:    "label",
+	Extra:      []string{"reason"},
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "cable_type", JSON: "cableType", Type: "string", Required: true, Enum: cableTypes},
+		{Name: "status", JSON: "status", Type: "string", Enum: []string{"connected", "planned", "decommissioned"}},
+		{Name: "label", JSON: "label", Type: "string", Required: true},
+		{Name: "length", JSON: "length", Type: "decimal", Nullable: true, Range: crud.AtLeast(0)},
+		{Name: "color", JSON: "color", Type: "string", Nullable: true},
+		{Name: "termination_a_type", JSON: "terminationAType", Type: "string", Required: true, Enum: terminationTypes},
+		{Name: "termination_a_id", JSON: "terminationAId", Type: "string", Required: true},
+		{Name: "termination_b_type", JSON: "terminationBType", Type: "string", Required: true, Enum: terminationTypes},
+		{Name: "termination_b_id", JSON: "terminationBId", Type: "string", Required: true},
+		{Name: "tenant_id", JSON: "tenantId", Type: "string", Nullable: true, Ref: "tenants"},
+		{Name: "description", JSON: "description", Type: "string", Nullable: true},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "label", Column: "label", Op: "ilike"},
+		{QueryParam: "search", Column: "label", Op: "ilike"},
+		{QueryParam: "cableType", Column: "cable_type"},
+		{QueryParam: "status", Column: "status"},
+		{QueryParam: "color", Column: "color"},
+		{QueryParam: "length", Column: "length", Type: "float"},
+		{QueryParam: "terminationAType", Column: "termination_a_type"},
+		{QueryParam: "terminationAId", Column: "termination_a_id"},
+		{QueryParam: "terminationBType", Column: "termination_b_type"},
+		{QueryParam: "terminationBId", Column: "termination_b_id"},
+		{QueryParam: "tenantId", Column: "tenant_id"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+}
+
+func scanCable(scan func(dest ...interface{}) error) (cableRow, error) {
+	var c cableRow
+	var ca, ua time.Time
+	if err := scan(&c.ID, &c.CableType, &c.Status, &c.Label, &c.Length, &c.Color, &c.TerminationAType, &c.TerminationAID, &c.TerminationBType, &c.TerminationBID, &c.TenantID, &c.Description, &ca, &ua); err != nil {
+		return c, err
+	}
+	c.CreatedAt = ca.UTC().Format(time.RFC3339)
+	c.UpdatedAt = ua.UTC().Format(time.RFC3339)
+	return c, nil
+}
+
 func (h *CableHandler) List(w http.ResponseWriter, r *http.Request) {
-	query := fmt.Sprintf(`SELECT %s FROM cables WHERE deleted_at IS NULL`, cableCols)
-	args := []interface{}{}
-	ai := 1
-	if v := r.URL.Query().Get("cableType"); v != "" {
-		query += fmt.Sprintf(" AND cable_type = $%d", ai)
-		args = append(args, v)
-		ai++
-	}
-	if v := r.URL.Query().Get("status"); v != "" {
-		query += fmt.Sprintf(" AND status = $%d", ai)
-		args = append(args, v)
-		ai++
-	}
-	if v := r.URL.Query().Get("search"); v != "" {
-		query += fmt.Sprintf(" AND label ILIKE $%d", ai)
-		args = append(args, "%"+v+"%")
-		ai++
-	}
-	query += " ORDER BY label"
-	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
+	q, err := crud.ParseQuery(r, cableConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	from := ` FROM cables WHERE deleted_at IS NULL` + q.Where
+	if !crud.Total(w, r, h.DB.Pool, q, from, q.Args) {
+		return
+	}
+	seek, args := q.Seek(q.Args)
+	rows, err := h.DB.Pool.Query(r.Context(),
+		`SELECT `+cableCols+from+seek+" ORDER BY "+q.OrderBy+q.LimitClause(), args...)
 	if err != nil {
 		log.Printf("cable list error: %v", err)
 		response.InternalError(w, "database error")
@@ -62,35 +115,23 @@ func (h *CableHandler) List(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 	results := []cableRow{}
 	for rows.Next() {
-		var c cableRow
-		var ca, ua time.Time
-		if err := rows.Scan(&c.ID, &c.CableType, &c.Status, &c.Label, &c.Length, &c.Color,
-			&c.TerminationAType, &c.TerminationAID, &c.TerminationBType, &c.TerminationBID,
-			&c.TenantID, &c.Description, &ca, &ua); err != nil {
+		c, err := scanCable(rows.Scan)
+		if err != nil {
 			continue
 		}
-		c.CreatedAt = ca.UTC().Format(time.RFC3339)
-		c.UpdatedAt = ua.UTC().Format(time.RFC3339)
 		results = append(results, c)
 	}
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 func (h *CableHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	var c cableRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
-		fmt.Sprintf(`SELECT %s FROM cables WHERE id = $1 AND deleted_at IS NULL`, cableCols), id).Scan(
-		&c.ID, &c.CableType, &c.Status, &c.Label, &c.Length, &c.Color,
-		&c.TerminationAType, &c.TerminationAID, &c.TerminationBType, &c.TerminationBID,
-		&c.TenantID, &c.Description, &ca, &ua)
+	c, err := scanCable(h.DB.Pool.QueryRow(r.Context(),
+		fmt.Sprintf(`SELECT %s FROM cables WHERE id = $1 AND deleted_at IS NULL`, cableCols), id).Scan)
 	if err != nil {
 		response.NotFound(w, "Cable")
 		return
 	}
-	c.CreatedAt = ca.UTC().Format(time.RFC3339)
-	c.UpdatedAt = ua.UTC().Format(time.RFC3339)
 	response.OK(w, c)
 }
 
@@ -100,6 +141,9 @@ func (h *CableHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, cableConfig, body, true) {
+		return
+	}
 	cableType, _ := body["cableType"].(string)
 	label, _ := body["label"].(string)
 	taType, _ := body["terminationAType"].(string)
@@ -119,23 +163,31 @@ func (h *CableHandler) Create(w http.ResponseWriter, r *http.Request) {
 	tenantID, _ := body["tenantId"].(string)
 	desc, _ := body["description"].(string)
 
-	var c cableRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	c, err := scanCable(tx.QueryRow(ctx,
 		fmt.Sprintf(`INSERT INTO cables (cable_type, status, label, length, color, termination_a_type, termination_a_id, termination_b_type, termination_b_id, tenant_id, description)
 		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING %s`, cableCols),
-		cableType, status, label, nilIfEmpty(length), nilIfEmpty(color), taType, taID, tbType, tbID, nilIfEmpty(tenantID), nilIfEmpty(desc)).Scan(
-		&c.ID, &c.CableType, &c.Status, &c.Label, &c.Length, &c.Color,
-		&c.TerminationAType, &c.TerminationAID, &c.TerminationBType, &c.TerminationBID,
-		&c.TenantID, &c.Description, &ca, &ua)
+		cableType, status, label, nilIfEmpty(length), nilIfEmpty(color), taType, taID, tbType, tbID, nilIfEmpty(tenantID), nilIfEmpty(desc)).Scan)
 	if err != nil {
-		log.Printf("cable create error: %v", err)
-		response.InternalError(w, "create failed")
+		response.DBError(w, err, "Cable")
+		return
+	}
+	reason, _ := body["reason"].(string)
+	if err := audit.LogEntry(audit.WithReason(ctx, reason), tx, "create", "cables", c.ID, nil, c); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	c.CreatedAt = ca.UTC().Format(time.RFC3339)
-	c.UpdatedAt = ua.UTC().Format(time.RFC3339)
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "cables", c.ID, nil, c)
 	response.Created(w, c)
 }
 
@@ -146,6 +198,9 @@ func (h *CableHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, cableConfig, body, false) {
+		return
+	}
 	sc := []string{}
 	args := []interface{}{}
 	ai := 1
@@ -170,31 +225,70 @@ func (h *CableHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	args = append(args, id)
-	var c cableRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
-		fmt.Sprintf(`UPDATE cables SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`, joinStrings(sc, ", "), ai, cableCols), args...).Scan(
-		&c.ID, &c.CableType, &c.Status, &c.Label, &c.Length, &c.Color,
-		&c.TerminationAType, &c.TerminationAID, &c.TerminationBType, &c.TerminationBID,
-		&c.TenantID, &c.Description, &ca, &ua)
+	reason, _ := body["reason"].(string)
+	ctx := audit.WithReason(r.Context(), reason)
+	tx, err := h.DB.Pool.Begin(ctx)
 	if err != nil {
-		response.NotFound(w, "Cable")
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	cur, err := scanCable(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM cables WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, cableCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Cable")
+		return
+	}
+	if err := etag.Check(ctx, tx, "cables", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	c, err := scanCable(tx.QueryRow(ctx,
+		fmt.Sprintf(`UPDATE cables SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`, joinStrings(sc, ", "), ai, cableCols), args...).Scan)
+	if err != nil {
+		response.DBError(w, err, "Cable")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "cables", id, cur, c); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	c.CreatedAt = ca.UTC().Format(time.RFC3339)
-	c.UpdatedAt = ua.UTC().Format(time.RFC3339)
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "cables", id, nil, c)
 	response.OK(w, c)
 }
 
 func (h *CableHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	tag, err := h.DB.Pool.Exec(r.Context(), `UPDATE cables SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Cable")
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if err := etag.Check(ctx, tx, "cables", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	// The returned columns exclude deleted_at, so they are the row's before-state.
+	before, err := scanCable(tx.QueryRow(ctx,
+		fmt.Sprintf(`UPDATE cables SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL RETURNING %s`, cableCols), time.Now().UTC(), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Cable")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "delete", "cables", id, before, nil); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "cables", id, nil, nil)
 	response.Message(w, "Cable deleted", http.StatusOK)
 }
 
@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -58,22 +60,61 @@ func scanEquipment(scan func(dest ...interface{}) error) (equipmentMovementRow,
 	return e, nil
 }
 
+// equipmentMovementConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /equipment-movements.
+var equipmentMovementConfig = crud.Config{
+	Table:      "equipment_movements",
+	Name:       "Equipment movement",
+	SoftDelete: true,
+	OrderBy:    "created_at DESC",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "site_id", JSON: "siteId", Type: "string", Required: true, Ref: "sites"},
+		{Name: "rack_id", JSON: "rackId", Type: "string", Nullable: true, Ref: "racks"},
+		{Name: "device_id", JSON: "deviceId", Type: "string", Nullable: true, Ref: "devices"},
+		{Name: "movement_type", JSON: "movementType", Type: "string", Required: true, Enum: []string{"install", "remove", "relocate", "rma"}},
+		{Name: "status", JSON: "status", Type: "string", Enum: []string{"pending", "approved", "in_progress", "completed", "rejected"}},
+		{Name: "description", JSON: "description", Type: "string", Nullable: true},
+		{Name: "requested_by", JSON: "requestedBy", Type: "string", Required: true},
+		{Name: "approved_by", JSON: "approvedBy", Type: "string", Nullable: true},
+		{Name: "approved_at", JSON: "approvedAt", Type: "timestamp", Nullable: true},
+		{Name: "completed_at", JSON: "completedAt", Type: "timestamp", Nullable: true},
+		{Name: "serial_number", JSON: "serialNumber", Type: "string", Nullable: true},
+		{Name: "asset_tag", JSON: "assetTag", Type: "string", Nullable: true},
+		{Name: "notes", JSON: "notes", Type: "string", Nullable: true},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "siteId", Column: "site_id"},
+		{QueryParam: "rackId", Column: "rack_id"},
+		{QueryParam: "deviceId", Column: "device_id"},
+		{QueryParam: "movementType", Column: "movement_type"},
+		{QueryParam: "status", Column: "status"},
+		{QueryParam: "requestedBy", Column: "requested_by"},
+		{QueryParam: "approvedBy", Column: "approved_by"},
+		{QueryParam: "serialNumber", Column: "serial_number"},
+		{QueryParam: "assetTag", Column: "asset_tag"},
+		{QueryParam: "approvedAt", Column: "approved_at", Type: "timestamp"},
+		{QueryParam: "completedAt", Column: "completed_at", Type: "timestamp"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+}
+
 func (h *EquipmentMovementHandler) List(w http.ResponseWriter, r *http.Request) {
-	query := fmt.Sprintf(`SELECT %s FROM equipment_movements WHERE deleted_at IS NULL`, emCols)
-	args := []interface{}{}
-	ai := 1
-	if v := r.URL.Query().Get("siteId"); v != "" {
-		query += fmt.Sprintf(" AND site_id = $%d", ai)
-		args = append(args, v)
-		ai++
+	q, err := crud.ParseQuery(r, equipmentMovementConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
 	}
-	if v := r.URL.Query().Get("status"); v != "" {
-		query += fmt.Sprintf(" AND status = $%d", ai)
-		args = append(args, v)
-		ai++
+	from := ` FROM equipment_movements WHERE deleted_at IS NULL` + q.Where
+	if !crud.Total(w, r, h.DB.Pool, q, from, q.Args) {
+		return
 	}
-	query += " ORDER BY created_at DESC"
-	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
+	seek, args := q.Seek(q.Args)
+	rows, err := h.DB.Pool.Query(r.Context(),
+		`SELECT `+emCols+from+seek+" ORDER BY "+q.OrderBy+q.LimitClause(), args...)
 	if err != nil {
 		log.Printf("equipment_movement list error: %v", err)
 		response.InternalError(w, "database error")
@@ -88,7 +129,7 @@ func (h *EquipmentMovementHandler) List(w http.ResponseWriter, r *http.Request)
 		}
 		results = append(results, e)
 	}
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 func (h *EquipmentMovementHandler) Get(w http.ResponseWriter, r *http.Request) {
@@ -108,6 +149,9 @@ func (h *EquipmentMovementHandler) Create(w http.ResponseWriter, r *http.Request
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, equipmentMovementConfig, body, true) {
+		return
+	}
 	siteID, _ := body["siteId"].(string)
 	movementType, _ := body["movementType"].(string)
 	requestedBy, _ := body["requestedBy"].(string)
@@ -126,17 +170,31 @@ func (h *EquipmentMovementHandler) Create(w http.ResponseWriter, r *http.Request
 	asset, _ := body["assetTag"].(string)
 	notes, _ := body["notes"].(string)
 
-	e, err := scanEquipment(h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	e, err := scanEquipment(tx.QueryRow(ctx,
 		fmt.Sprintf(`INSERT INTO equipment_movements (site_id, rack_id, device_id, movement_type, status, description, requested_by, serial_number, asset_tag, notes)
 		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING %s`, emCols),
 		siteID, nilIfEmpty(rackID), nilIfEmpty(deviceID), movementType, status,
 		nilIfEmpty(desc), requestedBy, nilIfEmpty(serial), nilIfEmpty(asset), nilIfEmpty(notes)).Scan)
 	if err != nil {
-		log.Printf("equipment_movement create error: %v", err)
-		response.InternalError(w, "create failed")
+		response.DBError(w, err, "Equipment movement")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "create", "equipment_movements", e.ID, nil, e); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "equipment_movements", e.ID, nil, e)
 	response.Created(w, e)
 }
 
@@ -147,6 +205,9 @@ func (h *EquipmentMovementHandler) Update(w http.ResponseWriter, r *http.Request
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, equipmentMovementConfig, body, false) {
+		return
+	}
 	sc := []string{}
 	args := []interface{}{}
 	ai := 1
@@ -180,23 +241,68 @@ func (h *EquipmentMovementHandler) Update(w http.ResponseWriter, r *http.Request
 		return
 	}
 	args = append(args, id)
-	e, err := scanEquipment(h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	cur, err := scanEquipment(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM equipment_movements WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, emCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Equipment movement")
+		return
+	}
+	if err := etag.Check(ctx, tx, "equipment_movements", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	e, err := scanEquipment(tx.QueryRow(ctx,
 		fmt.Sprintf(`UPDATE equipment_movements SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`, joinStrings(sc, ", "), ai, emCols), args...).Scan)
 	if err != nil {
-		response.NotFound(w, "Equipment movement")
+		response.DBError(w, err, "Equipment movement")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "equipment_movements", id, cur, e); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "equipment_movements", id, nil, e)
 	response.OK(w, e)
 }
 
 func (h *EquipmentMovementHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	tag, err := h.DB.Pool.Exec(r.Context(), `UPDATE equipment_movements SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Equipment movement")
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if err := etag.Check(ctx, tx, "equipment_movements", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	// The returned columns exclude deleted_at, so they are the row's before-state.
+	before, err := scanEquipment(tx.QueryRow(ctx,
+		fmt.Sprintf(`UPDATE equipment_movements SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL RETURNING %s`, emCols), time.Now().UTC(), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Equipment movement")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "delete", "equipment_movements", id, before, nil); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "equipment_movements", id, nil, nil)
 	response.Message(w, "Equipment movement deleted", http.StatusOK)
 }
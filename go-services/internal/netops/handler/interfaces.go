@@ -8,7 +8,10 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/cascade"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -27,19 +30,67 @@ type interfaceRow struct {
 	UpdatedAt     string  `json:"updatedAt"`
 }
 
+// interfaceTypes are the values of the interface_type enum.
+var interfaceTypes = []string{"rj45-1g", "rj45-10g", "sfp-1g", "sfp+-10g", "sfp28-25g", "qsfp+-40g", "qsfp28-100g", "console", "power"}
+
 const ifaceCols = `id, device_id, name, interface_type, speed, mac_address, enabled, description, created_at, updated_at`
 
+// interfaceConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /interfaces.
+var interfaceConfig = crud.Config{
+	Table:      "interfaces",
+	Name:       "Interface",
+	SoftDelete: true,
+	OrderBy:    "name",
+	Extra:      []string{"reason"},
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "device_id", JSON: "deviceId", Type: "string", Required: true, Ref: "devices"},
+		{Name: "name", JSON: "name", Type: "string", Required: true},
+		{Name: "interface_type", JSON: "interfaceType", Type: "string", Required: true, Enum: interfaceTypes},
+		{Name: "speed", JSON: "speed", Type: "int", Nullable: true, Range: crud.AtLeast(0)},
+		{Name: "mac_address", JSON: "macAddress", Type: "string", Nullable: true, Format: "mac"},
+		{Name: "enabled", JSON: "enabled", Type: "bool"},
+		{Name: "description", JSON: "description", Type: "string", Nullable: true},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "name", Column: "name", Op: "ilike"},
+		{QueryParam: "deviceId", Column: "device_id"},
+		{QueryParam: "interfaceType", Column: "interface_type"},
+		{QueryParam: "speed", Column: "speed", Type: "int"},
+		{QueryParam: "macAddress", Column: "mac_address"},
+		{QueryParam: "enabled", Column: "enabled", Type: "bool"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+}
+
+func scanInterface(scan func(dest ...interface{}) error) (interfaceRow, error) {
+	var i interfaceRow
+	var ca, ua time.Time
+	if err := scan(&i.ID, &i.DeviceID, &i.Name, &i.InterfaceType, &i.Speed, &i.MACAddress, &i.Enabled, &i.Description, &ca, &ua); err != nil {
+		return i, err
+	}
+	i.CreatedAt = ca.UTC().Format(time.RFC3339)
+	i.UpdatedAt = ua.UTC().Format(time.RFC3339)
+	return i, nil
+}
+
 func (h *InterfaceHandler) List(w http.ResponseWriter, r *http.Request) {
-	query := fmt.Sprintf(`SELECT %s FROM interfaces WHERE deleted_at IS NULL`, ifaceCols)
-	args := []interface{}{}
-	ai := 1
-	if v := r.URL.Query().Get("deviceId"); v != "" {
-		query += fmt.Sprintf(" AND device_id = $%d", ai)
-		args = append(args, v)
-		ai++
+	q, err := crud.ParseQuery(r, interfaceConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
 	}
-	query += " ORDER BY name"
-	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
+	from := ` FROM interfaces WHERE deleted_at IS NULL` + q.Where
+	if !crud.Total(w, r, h.DB.Pool, q, from, q.Args) {
+		return
+	}
+	seek, args := q.Seek(q.Args)
+	rows, err := h.DB.Pool.Query(r.Context(),
+		`SELECT `+ifaceCols+from+seek+" ORDER BY "+q.OrderBy+q.LimitClause(), args...)
 	if err != nil {
 		log.Printf("interface list error: %v", err)
 		response.InternalError(w, "database error")
@@ -48,31 +99,23 @@ func (h *InterfaceHandler) List(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 	results := []interfaceRow{}
 	for rows.Next() {
-		var i interfaceRow
-		var ca, ua time.Time
-		if err := rows.Scan(&i.ID, &i.DeviceID, &i.Name, &i.InterfaceType, &i.Speed, &i.MACAddress, &i.Enabled, &i.Description, &ca, &ua); err != nil {
+		i, err := scanInterface(rows.Scan)
+		if err != nil {
 			continue
 		}
-		i.CreatedAt = ca.UTC().Format(time.RFC3339)
-		i.UpdatedAt = ua.UTC().Format(time.RFC3339)
 		results = append(results, i)
 	}
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 func (h *InterfaceHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	var i interfaceRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
-		fmt.Sprintf(`SELECT %s FROM interfaces WHERE id = $1 AND deleted_at IS NULL`, ifaceCols), id).Scan(
-		&i.ID, &i.DeviceID, &i.Name, &i.InterfaceType, &i.Speed, &i.MACAddress, &i.Enabled, &i.Description, &ca, &ua)
+	i, err := scanInterface(h.DB.Pool.QueryRow(r.Context(),
+		fmt.Sprintf(`SELECT %s FROM interfaces WHERE id = $1 AND deleted_at IS NULL`, ifaceCols), id).Scan)
 	if err != nil {
 		response.NotFound(w, "Interface")
 		return
 	}
-	i.CreatedAt = ca.UTC().Format(time.RFC3339)
-	i.UpdatedAt = ua.UTC().Format(time.RFC3339)
 	response.OK(w, i)
 }
 
@@ -82,6 +125,9 @@ func (h *InterfaceHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, interfaceConfig, body, true) {
+		return
+	}
 	deviceID, _ := body["deviceId"].(string)
 	name, _ := body["name"].(string)
 	ifaceType, _ := body["interfaceType"].(string)
@@ -101,21 +147,31 @@ func (h *InterfaceHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	desc, _ := body["description"].(string)
 
-	var i interfaceRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	i, err := scanInterface(tx.QueryRow(ctx,
 		fmt.Sprintf(`INSERT INTO interfaces (device_id, name, interface_type, speed, mac_address, enabled, description)
 		VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING %s`, ifaceCols),
-		deviceID, name, ifaceType, speed, nilIfEmpty(mac), enabled, nilIfEmpty(desc)).Scan(
-		&i.ID, &i.DeviceID, &i.Name, &i.InterfaceType, &i.Speed, &i.MACAddress, &i.Enabled, &i.Description, &ca, &ua)
+		deviceID, name, ifaceType, speed, nilIfEmpty(mac), enabled, nilIfEmpty(desc)).Scan)
 	if err != nil {
-		log.Printf("interface create error: %v", err)
-		response.InternalError(w, "create failed")
+		response.DBError(w, err, "Interface")
+		return
+	}
+	reason, _ := body["reason"].(string)
+	if err := audit.LogEntry(audit.WithReason(ctx, reason), tx, "create", "interfaces", i.ID, nil, i); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	i.CreatedAt = ca.UTC().Format(time.RFC3339)
-	i.UpdatedAt = ua.UTC().Format(time.RFC3339)
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "interfaces", i.ID, nil, i)
 	response.Created(w, i)
 }
 
@@ -126,6 +182,9 @@ func (h *InterfaceHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, interfaceConfig, body, false) {
+		return
+	}
 	sc := []string{}
 	args := []interface{}{}
 	ai := 1
@@ -156,28 +215,42 @@ func (h *InterfaceHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	args = append(args, id)
-	var i interfaceRow
-	var ca, ua time.Time
-	err := h.DB.Pool.QueryRow(r.Context(),
-		fmt.Sprintf(`UPDATE interfaces SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`, joinStrings(sc, ", "), ai, ifaceCols), args...).Scan(
-		&i.ID, &i.DeviceID, &i.Name, &i.InterfaceType, &i.Speed, &i.MACAddress, &i.Enabled, &i.Description, &ca, &ua)
+	reason, _ := body["reason"].(string)
+	ctx := audit.WithReason(r.Context(), reason)
+	tx, err := h.DB.Pool.Begin(ctx)
 	if err != nil {
-		response.NotFound(w, "Interface")
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	cur, err := scanInterface(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM interfaces WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, ifaceCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Interface")
+		return
+	}
+	if err := etag.Check(ctx, tx, "interfaces", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	i, err := scanInterface(tx.QueryRow(ctx,
+		fmt.Sprintf(`UPDATE interfaces SET %s WHERE id = $%d AND deleted_at IS NULL RETURNING %s`, joinStrings(sc, ", "), ai, ifaceCols), args...).Scan)
+	if err != nil {
+		response.DBError(w, err, "Interface")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "interfaces", id, cur, i); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	i.CreatedAt = ca.UTC().Format(time.RFC3339)
-	i.UpdatedAt = ua.UTC().Format(time.RFC3339)
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "interfaces", id, nil, i)
 	response.OK(w, i)
 }
 
+// Delete handles DELETE /interfaces/{id}?cascade=true
 func (h *InterfaceHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	tag, err := h.DB.Pool.Exec(r.Context(), `UPDATE interfaces SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Interface")
-		return
-	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "interfaces", id, nil, nil)
-	response.Message(w, "Interface deleted", http.StatusOK)
+	cascade.HandleDelete(w, r, h.DB.Pool, "interfaces", "Interface")
 }
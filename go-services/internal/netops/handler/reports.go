@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -56,9 +58,51 @@ func scanSchedule(scan func(dest ...interface{}) error) (reportScheduleRow, erro
 	return r, nil
 }
 
+// reportScheduleConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /reports/schedules.
+var reportScheduleConfig = crud.Config{
+	Table:   "report_schedules",
+	Name:    "Report schedule",
+	OrderBy: "name",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "name", JSON: "name", Type: "string", Required: true},
+		{Name: "report_type", JSON: "reportType", Type: "string", Required: true, Enum: []string{"racks", "devices", "cables", "power", "access"}},
+		{Name: "frequency", JSON: "frequency", Type: "string", Required: true, Enum: []string{"daily", "weekly", "monthly"}},
+		{Name: "cron_expression", JSON: "cronExpression", Type: "string", Required: true},
+		{Name: "recipient_emails", JSON: "recipientEmails", Type: "json"},
+		{Name: "is_active", JSON: "isActive", Type: "bool"},
+		{Name: "last_run_at", JSON: "lastRunAt", Type: "timestamp", Nullable: true, ReadOnly: true},
+		{Name: "next_run_at", JSON: "nextRunAt", Type: "timestamp", Nullable: true, ReadOnly: true},
+		{Name: "created_by", JSON: "createdBy", Type: "string", Nullable: true},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "name", Column: "name", Op: "ilike"},
+		{QueryParam: "reportType", Column: "report_type"},
+		{QueryParam: "frequency", Column: "frequency"},
+		{QueryParam: "isActive", Column: "is_active", Type: "bool"},
+		{QueryParam: "lastRunAt", Column: "last_run_at", Type: "timestamp"},
+		{QueryParam: "nextRunAt", Column: "next_run_at", Type: "timestamp"},
+		{QueryParam: "createdBy", Column: "created_by"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+}
+
 func (h *ReportScheduleHandler) List(w http.ResponseWriter, r *http.Request) {
+	q, err := crud.ParseQuery(r, reportScheduleConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	if !crud.Total(w, r, h.DB.Pool, q, ` FROM report_schedules`+crud.WhereClause(q.Where), q.Args) {
+		return
+	}
+	seek, args := q.Seek(q.Args)
 	rows, err := h.DB.Pool.Query(r.Context(),
-		fmt.Sprintf(`SELECT %s FROM report_schedules ORDER BY name`, rsCols))
+		fmt.Sprintf(`SELECT %s FROM report_schedules%s ORDER BY %s%s`, rsCols, crud.WhereClause(q.Where+seek), q.OrderBy, q.LimitClause()), args...)
 	if err != nil {
 		log.Printf("report_schedule list error: %v", err)
 		response.InternalError(w, "database error")
@@ -73,7 +117,7 @@ func (h *ReportScheduleHandler) List(w http.ResponseWriter, r *http.Request) {
 		}
 		results = append(results, s)
 	}
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 func (h *ReportScheduleHandler) Get(w http.ResponseWriter, r *http.Request) {
@@ -93,6 +137,9 @@ func (h *ReportScheduleHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, reportScheduleConfig, body, true) {
+		return
+	}
 	name, _ := body["name"].(string)
 	reportType, _ := body["reportType"].(string)
 	frequency, _ := body["frequency"].(string)
@@ -111,16 +158,30 @@ func (h *ReportScheduleHandler) Create(w http.ResponseWriter, r *http.Request) {
 		emails = string(b)
 	}
 
-	s, err := scanSchedule(h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	s, err := scanSchedule(tx.QueryRow(ctx,
 		fmt.Sprintf(`INSERT INTO report_schedules (name, report_type, frequency, cron_expression, recipient_emails, is_active)
 		VALUES ($1,$2,$3,$4,$5::jsonb,$6) RETURNING %s`, rsCols),
 		name, reportType, frequency, cronExpr, emails, isActive).Scan)
 	if err != nil {
-		log.Printf("report_schedule create error: %v", err)
-		response.InternalError(w, "create failed")
+		response.DBError(w, err, "Report schedule")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "create", "report_schedules", s.ID, nil, s); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "report_schedules", s.ID, nil, s)
 	response.Created(w, s)
 }
 
@@ -131,6 +192,9 @@ func (h *ReportScheduleHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, reportScheduleConfig, body, false) {
+		return
+	}
 	sc := []string{}
 	args := []interface{}{}
 	ai := 1
@@ -162,24 +226,68 @@ func (h *ReportScheduleHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	args = append(args, id)
-	s, err := scanSchedule(h.DB.Pool.QueryRow(r.Context(),
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	cur, err := scanSchedule(tx.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM report_schedules WHERE id = $1 FOR UPDATE`, rsCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Report schedule")
+		return
+	}
+	if err := etag.Check(ctx, tx, "report_schedules", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	s, err := scanSchedule(tx.QueryRow(ctx,
 		fmt.Sprintf(`UPDATE report_schedules SET %s WHERE id = $%d RETURNING %s`, joinStrings(sc, ", "), ai, rsCols), args...).Scan)
 	if err != nil {
-		response.NotFound(w, "Report schedule")
+		response.DBError(w, err, "Report schedule")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "report_schedules", id, cur, s); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "report_schedules", id, nil, s)
 	response.OK(w, s)
 }
 
 func (h *ReportScheduleHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	tag, err := h.DB.Pool.Exec(r.Context(), `DELETE FROM report_schedules WHERE id = $1`, id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Report schedule")
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if err := etag.Check(ctx, tx, "report_schedules", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	before, err := scanSchedule(tx.QueryRow(ctx,
+		fmt.Sprintf(`DELETE FROM report_schedules WHERE id = $1 RETURNING %s`, rsCols), id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Report schedule")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "delete", "report_schedules", id, before, nil); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "report_schedules", id, nil, nil)
 	response.Message(w, "Report schedule deleted", http.StatusOK)
 }
 
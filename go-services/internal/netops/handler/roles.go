@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/dcim/go-services/internal/shared/crud"
+	"github.com/dcim/go-services/internal/shared/rbac"
+	"github.com/jackc/pgx/v5"
+)
+
+// RoleConfig serves /roles: custom roles, each a list of permissions such as
+// ["devices:*", "racks:read", "*:read"]. The built-in roles that users.role
+// names are defined in rbac.Builtin. Roles are hard-deleted together with
+// their assignments.
+var RoleConfig = crud.Config{
+	Table:   "roles",
+	Name:    "Role",
+	OrderBy: "name",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "name", JSON: "name", Type: "string", Required: true},
+		{Name: "description", JSON: "description", Type: "string", Nullable: true},
+		{Name: "permissions", JSON: "permissions", Type: "json", Default: []interface{}{}},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "name", Column: "name", Op: "ilike"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+	Check: checkRole,
+}
+
+func checkRole(_ context.Context, _ pgx.Tx, w *crud.Write) error {
+	v, ok := w.Data["permissions"]
+	if !ok {
+		return nil
+	}
+	if !isStringList(v) {
+		return &crud.ValidationError{Issues: []map[string]string{{"path": "permissions", "message": "must be a list of strings"}}}
+	}
+	var issues []map[string]string
+	for _, p := range v.([]interface{}) {
+		if err := rbac.CheckPermission(p.(string)); err != nil {
+			issues = append(issues, map[string]string{"path": "permissions", "message": err.Error()})
+		}
+	}
+	if issues != nil {
+		return &crud.ValidationError{Issues: issues}
+	}
+	return nil
+}
+
+// RoleAssignmentConfig serves /role-assignments: a role given to a user
+// everywhere, at one site (siteId) or for the rows of one tenant (tenantId).
+var RoleAssignmentConfig = crud.Config{
+	Table:   "role_assignments",
+	Name:    "Role assignment",
+	OrderBy: "created_at",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "user_id", JSON: "userId", Type: "string", Required: true},
+		{Name: "role_id", JSON: "roleId", Type: "string", Required: true},
+		{Name: "site_id", JSON: "siteId", Type: "string", Nullable: true, Ref: "sites"},
+		{Name: "tenant_id", JSON: "tenantId", Type: "string", Nullable: true, Ref: "tenants"},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "userId", Column: "user_id"},
+		{QueryParam: "roleId", Column: "role_id"},
+		{QueryParam: "siteId", Column: "site_id"},
+		{QueryParam: "tenantId", Column: "tenant_id"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+	},
+	Check: checkRoleAssignment,
+}
+
+func checkRoleAssignment(_ context.Context, _ pgx.Tx, w *crud.Write) error {
+	// set reports whether a field is set after the write; Before holds the
+	// current row on update.
+	set := func(name string) bool {
+		v, ok := w.Data[name]
+		if !ok {
+			v = w.Before[name]
+		}
+		switch s := v.(type) {
+		case string:
+			return s != ""
+		case *string:
+			return s != nil && *s != ""
+		}
+		return false
+	}
+	if set("siteId") && set("tenantId") {
+		return &crud.ValidationError{Issues: []map[string]string{
+			{"path": "tenantId", "message": "an assignment is scoped to a site or a tenant, not both"},
+		}}
+	}
+	return nil
+}
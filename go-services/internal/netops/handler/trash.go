@@ -0,0 +1,43 @@
+package handler
+
+import "github.com/dcim/go-services/internal/shared/trash"
+
+// TrashResources are the network-ops resources with trash, restore and purge
+// endpoints, children before parents so expired rows purge in one pass.
+var TrashResources = []trash.Resource{
+	{
+		Path: "/cables", Table: "cables", Name: "Cable", Label: "label",
+		Parents: []trash.Parent{{Column: "tenant_id", Table: "tenants"}},
+	},
+	{
+		Path: "/interfaces", Table: "interfaces", Name: "Interface", Label: "name",
+		Parents: []trash.Parent{{Column: "device_id", Table: "devices"}},
+	},
+	{
+		Path: "/console-ports", Table: "console_ports", Name: "Console port", Label: "name",
+		Parents: []trash.Parent{{Column: "device_id", Table: "devices"}},
+	},
+	{
+		Path: "/front-ports", Table: "front_ports", Name: "Front port", Label: "name",
+		Parents: []trash.Parent{
+			{Column: "device_id", Table: "devices"},
+			{Column: "rear_port_id", Table: "rear_ports"},
+		},
+	},
+	{
+		Path: "/rear-ports", Table: "rear_ports", Name: "Rear port", Label: "name",
+		Parents: []trash.Parent{{Column: "device_id", Table: "devices"}},
+	},
+	{
+		Path: "/access-logs", Table: "access_logs", Name: "Access log", Label: "personnel_name",
+		Parents: []trash.Parent{{Column: "site_id", Table: "sites"}},
+	},
+	{
+		Path: "/equipment-movements", Table: "equipment_movements", Name: "Equipment movement", Label: "movement_type",
+		Parents: []trash.Parent{
+			{Column: "site_id", Table: "sites"},
+			{Column: "rack_id", Table: "racks"},
+			{Column: "device_id", Table: "devices"},
+		},
+	},
+}
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/crud"
+	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/outbox"
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
+)
+
+// WebhookConfig serves /webhooks. resources and actions filter the events a
+// webhook receives, e.g. ["devices", "racks"] and ["create", "delete"]; empty
+// lists receive everything. A secret is generated when none is given.
+// Webhooks are hard-deleted together with their deliveries.
+var WebhookConfig = crud.Config{
+	Table:   "webhooks",
+	Name:    "Webhook",
+	OrderBy: "name",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "name", JSON: "name", Type: "string", Required: true},
+		{Name: "url", JSON: "url", Type: "string", Required: true},
+		{Name: "secret", JSON: "secret", Type: "string"},
+		{Name: "resources", JSON: "resources", Type: "json", Default: []interface{}{}},
+		{Name: "actions", JSON: "actions", Type: "json", Default: []interface{}{}},
+		{Name: "active", JSON: "active", Type: "bool", Default: true},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "name", Column: "name", Op: "ilike"},
+		{QueryParam: "active", Column: "active", Type: "bool"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "updated_at", Type: "timestamp"},
+	},
+	Check: checkWebhook,
+}
+
+func checkWebhook(_ context.Context, _ pgx.Tx, w *crud.Write) error {
+	var issues []map[string]string
+	if v, ok := w.Data["url"]; ok {
+		s, _ := v.(string)
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			issues = append(issues, map[string]string{"path": "url", "message": "must be an absolute http or https URL"})
+		}
+	}
+	for _, field := range []string{"resources", "actions"} {
+		if v, ok := w.Data[field]; ok && !isStringList(v) {
+			issues = append(issues, map[string]string{"path": field, "message": "must be a list of strings"})
+		}
+	}
+	if issues != nil {
+		return &crud.ValidationError{Issues: issues}
+	}
+	if s, _ := w.Data["secret"].(string); s == "" {
+		if w.ID != "" {
+			// Keep the current secret rather than clearing it.
+			delete(w.Data, "secret")
+			return nil
+		}
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		w.Data["secret"] = hex.EncodeToString(b)
+	}
+	return nil
+}
+
+func isStringList(v interface{}) bool {
+	list, ok := v.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, item := range list {
+		if _, ok := item.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// WebhookHandler serves webhook deliveries: the delivery log with its
+// dead-letter queue, and replays.
+type WebhookHandler struct{ DB *db.DB }
+
+type deliveryRow struct {
+	ID             string  `json:"id"`
+	WebhookID      string  `json:"webhookId"`
+	EventID        string  `json:"eventId"`
+	Resource       string  `json:"resource"`
+	Action         string  `json:"action"`
+	RecordID       string  `json:"recordId"`
+	Status         string  `json:"status"`
+	Attempts       int     `json:"attempts"`
+	NextAttemptAt  string  `json:"nextAttemptAt"`
+	LastStatusCode *int    `json:"lastStatusCode"`
+	LastError      *string `json:"lastError"`
+	DeliveredAt    *string `json:"deliveredAt"`
+	CreatedAt      string  `json:"createdAt"`
+}
+
+// deliveryList whitelists the filters of GET /webhooks/deliveries.
+// ?status=dead lists the dead-letter queue.
+var deliveryList = crud.Config{
+	Table:    "webhook_deliveries",
+	OrderBy:  "d.created_at DESC",
+	PageSize: 200,
+	Filters: []crud.FilterDef{
+		{QueryParam: "webhookId", Column: "d.webhook_id"},
+		{QueryParam: "eventId", Column: "d.event_id"},
+		{QueryParam: "status", Column: "d.status"},
+		{QueryParam: "resource", Column: "e.resource"},
+		{QueryParam: "action", Column: "e.action"},
+		{QueryParam: "recordId", Column: "e.record_id"},
+		{QueryParam: "createdAt", Column: "d.created_at", Type: "timestamp"},
+	},
+}
+
+// Deliveries handles GET /webhooks/deliveries
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	q, err := crud.ParseQuery(r, deliveryList, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	from := ` FROM webhook_deliveries d JOIN outbox_events e ON e.id = d.event_id`
+	if !crud.Total(w, r, h.DB.Pool, q, from+crud.WhereClause(q.Where), q.Args) {
+		return
+	}
+	seek, args := q.Seek(q.Args)
+	query := `SELECT d.id, d.webhook_id, d.event_id, e.resource, e.action, e.record_id, d.status, d.attempts,
+		d.next_attempt_at, d.last_status_code, d.last_error, d.delivered_at, d.created_at` +
+		from + crud.WhereClause(q.Where+seek) + ` ORDER BY ` + q.OrderBy + q.LimitClause()
+
+	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
+	if err != nil {
+		log.Printf("webhook delivery list error: %v", err)
+		response.InternalError(w, "database error")
+		return
+	}
+	defer rows.Close()
+	results := []deliveryRow{}
+	for rows.Next() {
+		var d deliveryRow
+		var next, created time.Time
+		var delivered *time.Time
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Resource, &d.Action, &d.RecordID, &d.Status,
+			&d.Attempts, &next, &d.LastStatusCode, &d.LastError, &delivered, &created); err != nil {
+			continue
+		}
+		d.NextAttemptAt = next.UTC().Format(time.RFC3339)
+		d.CreatedAt = created.UTC().Format(time.RFC3339)
+		if delivered != nil {
+			s := delivered.UTC().Format(time.RFC3339)
+			d.DeliveredAt = &s
+		}
+		results = append(results, d)
+	}
+	crud.List(w, r, h.DB.Pool, q, results)
+}
+
+// ReplayDelivery handles POST /webhooks/deliveries/{id}/replay: queues the
+// delivery again with a fresh attempt budget, whatever its status.
+func (h *WebhookHandler) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	tag, err := h.DB.Pool.Exec(r.Context(), `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = now(), updated_at = now()
+		WHERE id = $1`, r.PathValue("id"))
+	if err != nil {
+		response.DBError(w, err, "Webhook delivery")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.NotFound(w, "Webhook delivery")
+		return
+	}
+	response.OK(w, map[string]int64{"queued": 1})
+}
+
+// Replay handles POST /webhooks/{id}/replay
+//
+//	{}                                     requeue the webhook's dead-lettered deliveries
+//	{"since": "2026-06-01T00:00:00Z"}      redeliver every matching event since then
+//
+// Events since a time are queued whether or not they were delivered before,
+// so receivers should deduplicate by event id.
+func (h *WebhookHandler) Replay(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Since string `json:"since"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			response.BadRequest(w, "invalid JSON")
+			return
+		}
+	}
+	ctx := r.Context()
+	id := r.PathValue("id")
+	var exists bool
+	if err := h.DB.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1)`, id).Scan(&exists); err != nil {
+		response.DBError(w, err, "Webhook")
+		return
+	}
+	if !exists {
+		response.NotFound(w, "Webhook")
+		return
+	}
+
+	var queued int64
+	if body.Since == "" {
+		tag, err := h.DB.Pool.Exec(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'pending', attempts = 0, next_attempt_at = now(), updated_at = now()
+			WHERE webhook_id = $1 AND status = 'dead'`, id)
+		if err != nil {
+			response.DBError(w, err, "Webhook delivery")
+			return
+		}
+		queued = tag.RowsAffected()
+	} else {
+		since, err := time.Parse(time.RFC3339, body.Since)
+		if err != nil {
+			response.BadRequest(w, "since must be an RFC 3339 timestamp")
+			return
+		}
+		tag, err := h.DB.Pool.Exec(ctx, `
+			INSERT INTO webhook_deliveries (id, webhook_id, event_id)
+			SELECT gen_random_uuid()::text, w.id, e.id
+			FROM webhooks w JOIN outbox_events e ON e.created_at >= $2
+			WHERE w.id = $1`+outbox.Matches("w", "e.resource", "e.action")+`
+			ON CONFLICT (webhook_id, event_id) DO UPDATE
+			SET status = 'pending', attempts = 0, next_attempt_at = now(), updated_at = now()`, id, since)
+		if err != nil {
+			response.DBError(w, err, "Webhook delivery")
+			return
+		}
+		queued = tag.RowsAffected()
+	}
+	response.OK(w, map[string]int64{"queued": queued})
+}
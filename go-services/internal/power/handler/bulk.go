@@ -0,0 +1,9 @@
+package handler
+
+import "github.com/dcim/go-services/internal/shared/crud"
+
+// BulkResources are the power resources with a POST /{resource}/bulk endpoint.
+var BulkResources = []crud.BulkResource{
+	{Path: "/panels", Config: panelConfig},
+	{Path: "/feeds", Config: feedConfig},
+}
@@ -1,11 +1,16 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"log"
 	"net/http"
+	"sort"
 	"time"
 
+	"github.com/dcim/go-services/internal/shared/customfields"
 	"github.com/dcim/go-services/internal/shared/db"
 	"github.com/xuri/excelize/v2"
 )
@@ -59,13 +64,43 @@ func setRow(f *excelize.File, sheet string, rowIdx int, vals []interface{}) {
 	}
 }
 
+// customFieldDefs loads the custom field definitions exported as extra columns.
+func (h *ExportHandler) customFieldDefs(ctx context.Context, objectType string) []customfields.Definition {
+	defs, err := customfields.Load(ctx, h.DB.Pool, objectType)
+	if err != nil {
+		log.Printf("export custom fields error: %v", err)
+		return nil
+	}
+	return defs
+}
+
+// customFieldHeaders names custom field columns cf_<name>, matching the import format.
+func customFieldHeaders(defs []customfields.Definition) []string {
+	headers := make([]string, len(defs))
+	for i, d := range defs {
+		headers[i] = customfields.FilterPrefix + d.Name
+	}
+	return headers
+}
+
+// customFieldCells renders stored custom field JSON in definition order.
+func customFieldCells(defs []customfields.Definition, raw []byte) []interface{} {
+	values := map[string]interface{}{}
+	_ = json.Unmarshal(raw, &values)
+	cells := make([]interface{}, len(defs))
+	for i, d := range defs {
+		cells[i] = customfields.Format(values[d.Name])
+	}
+	return cells
+}
+
 // ExportRacks handles GET /export/racks — exports rack and device data as xlsx.
 func (h *ExportHandler) ExportRacks(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	rows, err := h.DB.Pool.Query(ctx, `
 		SELECT r.name, l.name, s.name, r.u_height,
 		       COALESCE(d.name, ''), COALESCE(d.position::text, ''),
-		       COALESCE(dt.model, ''), COALESCE(d.status::text, '')
+		       COALESCE(dt.model, ''), COALESCE(d.status::text, ''), r.custom_fields
 		FROM racks r
 		JOIN locations l ON r.location_id = l.id
 		JOIN sites s ON l.site_id = s.id
@@ -83,17 +118,20 @@ func (h *ExportHandler) ExportRacks(w http.ResponseWriter, r *http.Request) {
 	f := excelize.NewFile()
 	sheet := "Racks"
 	f.SetSheetName("Sheet1", sheet)
+	defs := h.customFieldDefs(ctx, "rack")
 	headers := []string{"Rack Name", "Location", "Site", "U-Height", "Device Name", "Position", "Device Type", "Status"}
-	setHeaders(f, sheet, headers)
+	setHeaders(f, sheet, append(headers, customFieldHeaders(defs)...))
 
 	rowIdx := 2
 	for rows.Next() {
 		var rackName, location, site, deviceName, position, deviceType, status string
 		var uHeight int
-		if err := rows.Scan(&rackName, &location, &site, &uHeight, &deviceName, &position, &deviceType, &status); err != nil {
+		var cf []byte
+		if err := rows.Scan(&rackName, &location, &site, &uHeight, &deviceName, &position, &deviceType, &status, &cf); err != nil {
 			continue
 		}
-		setRow(f, sheet, rowIdx, []interface{}{rackName, location, site, uHeight, deviceName, position, deviceType, status})
+		vals := []interface{}{rackName, location, site, uHeight, deviceName, position, deviceType, status}
+		setRow(f, sheet, rowIdx, append(vals, customFieldCells(defs, cf)...))
 		rowIdx++
 	}
 
@@ -109,7 +147,7 @@ func (h *ExportHandler) ExportDevices(w http.ResponseWriter, r *http.Request) {
 	query := `
 		SELECT d.name, dt.model, m.name,
 		       COALESCE(rk.name, ''), COALESCE(d.position::text, ''), d.status,
-		       COALESCE(d.serial_number, ''), COALESCE(d.asset_tag, ''), COALESCE(t.name, '')
+		       COALESCE(d.serial_number, ''), COALESCE(d.asset_tag, ''), COALESCE(t.name, ''), d.custom_fields
 		FROM devices d
 		JOIN device_types dt ON d.device_type_id = dt.id
 		JOIN manufacturers m ON dt.manufacturer_id = m.id
@@ -126,7 +164,11 @@ func (h *ExportHandler) ExportDevices(w http.ResponseWriter, r *http.Request) {
 	if statusFilter != "" {
 		query += fmt.Sprintf(" AND d.status = $%d", argIdx)
 		args = append(args, statusFilter)
+		argIdx++
 	}
+	cfWhere, cfArgs, _ := customfields.Filter(r.URL.Query(), "d.custom_fields", argIdx)
+	query += cfWhere
+	args = append(args, cfArgs...)
 	query += " ORDER BY d.name"
 
 	rows, err := h.DB.Pool.Query(ctx, query, args...)
@@ -139,16 +181,19 @@ func (h *ExportHandler) ExportDevices(w http.ResponseWriter, r *http.Request) {
 	f := excelize.NewFile()
 	sheet := "Devices"
 	f.SetSheetName("Sheet1", sheet)
+	defs := h.customFieldDefs(ctx, "device")
 	headers := []string{"Name", "Type", "Manufacturer", "Rack", "Position", "Status", "Serial", "Asset Tag", "Tenant"}
-	setHeaders(f, sheet, headers)
+	setHeaders(f, sheet, append(headers, customFieldHeaders(defs)...))
 
 	rowIdx := 2
 	for rows.Next() {
 		var name, deviceType, manufacturer, rack, position, status, serial, assetTag, tenant string
-		if err := rows.Scan(&name, &deviceType, &manufacturer, &rack, &position, &status, &serial, &assetTag, &tenant); err != nil {
+		var cf []byte
+		if err := rows.Scan(&name, &deviceType, &manufacturer, &rack, &position, &status, &serial, &assetTag, &tenant, &cf); err != nil {
 			continue
 		}
-		setRow(f, sheet, rowIdx, []interface{}{name, deviceType, manufacturer, rack, position, status, serial, assetTag, tenant})
+		vals := []interface{}{name, deviceType, manufacturer, rack, position, status, serial, assetTag, tenant}
+		setRow(f, sheet, rowIdx, append(vals, customFieldCells(defs, cf)...))
 		rowIdx++
 	}
 
@@ -346,10 +391,28 @@ type xmlLocation struct {
 }
 
 type xmlRack struct {
-	ID      string          `xml:"id,attr"`
-	Name    string          `xml:"name,attr"`
-	UHeight int             `xml:"uHeight,attr"`
-	Devices []xmlRackDevice `xml:"device"`
+	ID           string           `xml:"id,attr"`
+	Name         string           `xml:"name,attr"`
+	UHeight      int              `xml:"uHeight,attr"`
+	CustomFields []xmlCustomField `xml:"customFields>field,omitempty"`
+	Devices      []xmlRackDevice  `xml:"device"`
+}
+
+type xmlCustomField struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// xmlCustomFields renders stored custom field JSON as name-sorted elements.
+func xmlCustomFields(raw []byte) []xmlCustomField {
+	values := map[string]interface{}{}
+	_ = json.Unmarshal(raw, &values)
+	fields := make([]xmlCustomField, 0, len(values))
+	for k, v := range values {
+		fields = append(fields, xmlCustomField{Name: k, Value: customfields.Format(v)})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
 }
 
 type xmlRackDevice struct {
@@ -379,6 +442,7 @@ type xmlDevice struct {
 	Type         xmlDeviceType    `xml:"type"`
 	Rack         *xmlDeviceRack   `xml:"rack,omitempty"`
 	Tenant       *xmlDeviceTenant `xml:"tenant,omitempty"`
+	CustomFields []xmlCustomField `xml:"customFields>field,omitempty"`
 	Interfaces   xmlInterfaces    `xml:"interfaces"`
 }
 
@@ -404,7 +468,7 @@ func (h *ExportHandler) ExportXMLRacks(w http.ResponseWriter, r *http.Request) {
 	rows, err := h.DB.Pool.Query(ctx, `
 		SELECT s.id, s.name,
 		       l.id, l.name,
-		       rk.id, rk.name, rk.u_height,
+		       rk.id, rk.name, rk.u_height, rk.custom_fields,
 		       d.id, d.name, d.status, d.position,
 		       dt.u_height, dt.model,
 		       i.name, i.interface_type
@@ -446,6 +510,7 @@ func (h *ExportHandler) ExportXMLRacks(w http.ResponseWriter, r *http.Request) {
 		var siteID, siteName string
 		var locID, locName, rackID, rackName *string
 		var rackUH *int
+		var rackCF []byte
 		var devID, devName, devStatus, devPos *string
 		var dtUH *int
 		var dtModel *string
@@ -454,7 +519,7 @@ func (h *ExportHandler) ExportXMLRacks(w http.ResponseWriter, r *http.Request) {
 		if err := rows.Scan(
 			&siteID, &siteName,
 			&locID, &locName,
-			&rackID, &rackName, &rackUH,
+			&rackID, &rackName, &rackUH, &rackCF,
 			&devID, &devName, &devStatus, &devPos,
 			&dtUH, &dtModel,
 			&ifName, &ifType,
@@ -488,7 +553,7 @@ func (h *ExportHandler) ExportXMLRacks(w http.ResponseWriter, r *http.Request) {
 		}
 		if _, ok := le.racks[*rackID]; !ok {
 			le.racks[*rackID] = &rackEntry{
-				rack:    xmlRack{ID: *rackID, Name: *rackName, UHeight: derefInt(rackUH)},
+				rack:    xmlRack{ID: *rackID, Name: *rackName, UHeight: derefInt(rackUH), CustomFields: xmlCustomFields(rackCF)},
 				devices: map[string]*xmlRackDevice{},
 			}
 			le.rackOrder = append(le.rackOrder, *rackID)
@@ -559,7 +624,7 @@ func (h *ExportHandler) ExportXMLDevices(w http.ResponseWriter, r *http.Request)
 		SELECT d.id, d.name, d.status, COALESCE(d.serial_number, ''), COALESCE(d.asset_tag, ''),
 		       dt.model, m.name, dt.u_height,
 		       rk.name, d.position,
-		       t.name,
+		       t.name, d.custom_fields,
 		       i.name, i.interface_type
 		FROM devices d
 		JOIN device_types dt ON d.device_type_id = dt.id
@@ -585,13 +650,14 @@ func (h *ExportHandler) ExportXMLDevices(w http.ResponseWriter, r *http.Request)
 		var dtUH int
 		var rackName, devPos *string
 		var tenantName *string
+		var devCF []byte
 		var ifName, ifType *string
 
 		if err := rows.Scan(
 			&devID, &devName, &devStatus, &devSerial, &devAsset,
 			&dtModel, &mName, &dtUH,
 			&rackName, &devPos,
-			&tenantName,
+			&tenantName, &devCF,
 			&ifName, &ifType,
 		); err != nil {
 			continue
@@ -604,6 +670,7 @@ func (h *ExportHandler) ExportXMLDevices(w http.ResponseWriter, r *http.Request)
 				Status:       devStatus,
 				SerialNumber: devSerial,
 				AssetTag:     devAsset,
+				CustomFields: xmlCustomFields(devCF),
 				Type: xmlDeviceType{
 					Model:        dtModel,
 					Manufacturer: mName,
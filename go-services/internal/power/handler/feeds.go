@@ -8,7 +8,10 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/cascade"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -29,30 +32,68 @@ type feedRow struct {
 	UpdatedAt string  `json:"updatedAt"`
 }
 
+// feedConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /feeds.
+var feedConfig = crud.Config{
+	Table:      "power_feeds",
+	Name:       "Power feed",
+	SoftDelete: true,
+	OrderBy:    "pf.name",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "panel_id", JSON: "panelId", Type: "string", Required: true, Ref: "power_panels"},
+		{Name: "rack_id", JSON: "rackId", Type: "string", Nullable: true, Ref: "racks"},
+		{Name: "name", JSON: "name", Type: "string", Required: true},
+		{Name: "feed_type", JSON: "feedType", Type: "string", Enum: []string{"primary", "redundant"}},
+		{Name: "max_amps", JSON: "maxAmps", Type: "float", Range: crud.AtLeast(0)},
+		{Name: "rated_kw", JSON: "ratedKw", Type: "float", Range: crud.AtLeast(0)},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "name", Column: "pf.name", Op: "ilike"},
+		{QueryParam: "panelId", Column: "pf.panel_id"},
+		{QueryParam: "rackId", Column: "pf.rack_id"},
+		{QueryParam: "feedType", Column: "pf.feed_type"},
+		{QueryParam: "maxAmps", Column: "pf.max_amps", Type: "float"},
+		{QueryParam: "ratedKw", Column: "pf.rated_kw", Type: "float"},
+		{QueryParam: "createdAt", Column: "pf.created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "pf.updated_at", Type: "timestamp"},
+	},
+}
+
+func scanFeed(scan func(dest ...interface{}) error) (feedRow, error) {
+	var f feedRow
+	var rID *string
+	var createdAt, updatedAt time.Time
+	if err := scan(&f.ID, &f.PanelID, &rID, &f.Name, &f.FeedType, &f.MaxAmps, &f.RatedKw, &createdAt, &updatedAt); err != nil {
+		return f, err
+	}
+	f.RackID = rID
+	f.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	f.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
+	return f, nil
+}
+
 // List handles GET /feeds?panelId=&rackId=
 func (h *FeedHandler) List(w http.ResponseWriter, r *http.Request) {
-	panelID := r.URL.Query().Get("panelId")
-	rackID := r.URL.Query().Get("rackId")
+	q, err := crud.ParseQuery(r, feedConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
 
-	query := `
-		SELECT pf.id, pf.panel_id, pf.rack_id, pf.name, pf.feed_type,
-		       pf.max_amps, pf.rated_kw, pf.created_at, pf.updated_at
+	from := `
 		FROM power_feeds pf
-		WHERE pf.deleted_at IS NULL`
-	args := []interface{}{}
-	argIdx := 1
-
-	if panelID != "" {
-		query += fmt.Sprintf(" AND pf.panel_id = $%d", argIdx)
-		args = append(args, panelID)
-		argIdx++
-	}
-	if rackID != "" {
-		query += fmt.Sprintf(" AND pf.rack_id = $%d", argIdx)
-		args = append(args, rackID)
-		argIdx++
+		WHERE pf.deleted_at IS NULL` + q.Where
+	if !crud.Total(w, r, h.DB.Pool, q, from, q.Args) {
+		return
 	}
-	query += " ORDER BY pf.name"
+	seek, args := q.Seek(q.Args)
+	query := `
+		SELECT pf.id, pf.panel_id, pf.rack_id, pf.name, pf.feed_type,
+		       pf.max_amps, pf.rated_kw, pf.created_at, pf.updated_at` +
+		from + seek + " ORDER BY " + q.OrderBy + q.LimitClause()
 
 	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
 	if err != nil {
@@ -64,21 +105,15 @@ func (h *FeedHandler) List(w http.ResponseWriter, r *http.Request) {
 
 	results := []feedRow{}
 	for rows.Next() {
-		var f feedRow
-		var rID *string
-		var createdAt, updatedAt time.Time
-		if err := rows.Scan(&f.ID, &f.PanelID, &rID, &f.Name, &f.FeedType,
-			&f.MaxAmps, &f.RatedKw, &createdAt, &updatedAt); err != nil {
+		f, err := scanFeed(rows.Scan)
+		if err != nil {
 			log.Printf("feed scan error: %v", err)
 			continue
 		}
-		f.RackID = rID
-		f.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-		f.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
 		results = append(results, f)
 	}
 
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 // Get handles GET /feeds/{id}
@@ -89,23 +124,14 @@ func (h *FeedHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var f feedRow
-	var rID *string
-	var createdAt, updatedAt time.Time
-
-	err := h.DB.Pool.QueryRow(r.Context(), `
+	f, err := scanFeed(h.DB.Pool.QueryRow(r.Context(), `
 		SELECT id, panel_id, rack_id, name, feed_type, max_amps, rated_kw, created_at, updated_at
 		FROM power_feeds
-		WHERE id = $1 AND deleted_at IS NULL`, id).Scan(
-		&f.ID, &f.PanelID, &rID, &f.Name, &f.FeedType,
-		&f.MaxAmps, &f.RatedKw, &createdAt, &updatedAt)
+		WHERE id = $1 AND deleted_at IS NULL`, id).Scan)
 	if err != nil {
 		response.NotFound(w, "Power feed")
 		return
 	}
-	f.RackID = rID
-	f.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-	f.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
 
 	response.OK(w, f)
 }
@@ -117,6 +143,9 @@ func (h *FeedHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, feedConfig, body, true) {
+		return
+	}
 
 	panelID, _ := body["panelId"].(string)
 	name, _ := body["name"].(string)
@@ -133,27 +162,32 @@ func (h *FeedHandler) Create(w http.ResponseWriter, r *http.Request) {
 	maxAmps, _ := body["maxAmps"].(float64)
 	ratedKw, _ := body["ratedKw"].(float64)
 
-	var f feedRow
-	var rID *string
-	var createdAt, updatedAt time.Time
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
 
-	err := h.DB.Pool.QueryRow(r.Context(), `
+	f, err := scanFeed(tx.QueryRow(ctx, `
 		INSERT INTO power_feeds (panel_id, rack_id, name, feed_type, max_amps, rated_kw)
 		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, panel_id, rack_id, name, feed_type, max_amps, rated_kw, created_at, updated_at`,
-		panelID, nilIfEmpty(rackID), name, feedType, maxAmps, ratedKw).Scan(
-		&f.ID, &f.PanelID, &rID, &f.Name, &f.FeedType,
-		&f.MaxAmps, &f.RatedKw, &createdAt, &updatedAt)
+		panelID, nilIfEmpty(rackID), name, feedType, maxAmps, ratedKw).Scan)
 	if err != nil {
-		log.Printf("feed create error: %v", err)
-		response.InternalError(w, "create failed")
+		response.DBError(w, err, "Power feed")
 		return
 	}
-	f.RackID = rID
-	f.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-	f.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
 
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "power_feeds", f.ID, nil, f)
+	if err := audit.LogEntry(ctx, tx, "create", "power_feeds", f.ID, nil, f); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
 
 	response.Created(w, f)
 }
@@ -171,6 +205,9 @@ func (h *FeedHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, feedConfig, body, false) {
+		return
+	}
 
 	setClauses := []string{}
 	args := []interface{}{}
@@ -223,43 +260,45 @@ func (h *FeedHandler) Update(w http.ResponseWriter, r *http.Request) {
 		RETURNING id, panel_id, rack_id, name, feed_type, max_amps, rated_kw, created_at, updated_at`,
 		joinStrings(setClauses, ", "), argIdx)
 
-	var f feedRow
-	var rID *string
-	var createdAt, updatedAt time.Time
-
-	err := h.DB.Pool.QueryRow(r.Context(), query, args...).Scan(
-		&f.ID, &f.PanelID, &rID, &f.Name, &f.FeedType,
-		&f.MaxAmps, &f.RatedKw, &createdAt, &updatedAt)
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
 	if err != nil {
-		response.NotFound(w, "Power feed")
+		response.InternalError(w, "database error")
 		return
 	}
-	f.RackID = rID
-	f.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-	f.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
-
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "power_feeds", id, nil, f)
+	defer tx.Rollback(ctx) //nolint:errcheck
 
-	response.OK(w, f)
-}
-
-// Delete handles DELETE /feeds/{id}
-func (h *FeedHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	if id == "" {
-		response.BadRequest(w, "id is required")
+	cur, err := scanFeed(tx.QueryRow(ctx, `
+		SELECT id, panel_id, rack_id, name, feed_type, max_amps, rated_kw, created_at, updated_at
+		FROM power_feeds
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE`, id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Power feed")
 		return
 	}
-
-	tag, err := h.DB.Pool.Exec(r.Context(), `
-		UPDATE power_feeds SET deleted_at = $1
-		WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Power feed")
+	if err := etag.Check(ctx, tx, "power_feeds", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	f, err := scanFeed(tx.QueryRow(ctx, query, args...).Scan)
+	if err != nil {
+		response.DBError(w, err, "Power feed")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "power_feeds", id, cur, f); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
 
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "power_feeds", id, nil, nil)
+	response.OK(w, f)
+}
 
-	response.Message(w, "Power feed deleted", http.StatusOK)
+// Delete handles DELETE /feeds/{id}?cascade=true
+func (h *FeedHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	cascade.HandleDelete(w, r, h.DB.Pool, "power_feeds", "Power feed")
 }
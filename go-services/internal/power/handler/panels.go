@@ -8,7 +8,10 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/cascade"
+	"github.com/dcim/go-services/internal/shared/crud"
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/etag"
 	"github.com/dcim/go-services/internal/shared/response"
 )
 
@@ -30,25 +33,71 @@ type panelRow struct {
 	UpdatedAt       string   `json:"updatedAt"`
 }
 
+// panelConfig describes the writable columns, with their validation rules, and the
+// list filters and sort keys of GET /panels.
+var panelConfig = crud.Config{
+	Table:      "power_panels",
+	Name:       "Power panel",
+	SoftDelete: true,
+	OrderBy:    "pp.name",
+	Columns: []crud.Column{
+		{Name: "id", JSON: "id", Type: "string", ReadOnly: true},
+		{Name: "site_id", JSON: "siteId", Type: "string", Required: true, Ref: "sites"},
+		{Name: "name", JSON: "name", Type: "string", Required: true},
+		{Name: "slug", JSON: "slug", Type: "string", Format: "slug"},
+		{Name: "location", JSON: "location", Type: "string", Nullable: true},
+		{Name: "rated_capacity_kw", JSON: "ratedCapacityKw", Type: "float", Range: crud.AtLeast(0)},
+		{Name: "voltage_v", JSON: "voltageV", Type: "int", Range: crud.AtLeast(0)},
+		{Name: "phase_type", JSON: "phaseType", Type: "string", Enum: []string{"single", "three"}},
+		{Name: "created_at", JSON: "createdAt", Type: "timestamp", ReadOnly: true},
+		{Name: "updated_at", JSON: "updatedAt", Type: "timestamp", ReadOnly: true},
+	},
+	Filters: []crud.FilterDef{
+		{QueryParam: "name", Column: "pp.name", Op: "ilike"},
+		{QueryParam: "slug", Column: "pp.slug"},
+		{QueryParam: "siteId", Column: "pp.site_id"},
+		{QueryParam: "location", Column: "pp.location", Op: "ilike"},
+		{QueryParam: "ratedCapacityKw", Column: "pp.rated_capacity_kw", Type: "float"},
+		{QueryParam: "voltageV", Column: "pp.voltage_v", Type: "int"},
+		{QueryParam: "phaseType", Column: "pp.phase_type"},
+		{QueryParam: "createdAt", Column: "pp.created_at", Type: "timestamp"},
+		{QueryParam: "updatedAt", Column: "pp.updated_at", Type: "timestamp"},
+	},
+}
+
+func scanPanel(scan func(dest ...interface{}) error) (panelRow, error) {
+	var p panelRow
+	var loc *string
+	var createdAt, updatedAt time.Time
+	if err := scan(&p.ID, &p.SiteID, &p.Name, &p.Slug, &loc, &p.RatedCapacityKw, &p.VoltageV, &p.PhaseType, &createdAt, &updatedAt); err != nil {
+		return p, err
+	}
+	p.Location = loc
+	p.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	p.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
+	return p, nil
+}
+
 // List handles GET /panels?siteId=
 func (h *PanelHandler) List(w http.ResponseWriter, r *http.Request) {
-	siteID := r.URL.Query().Get("siteId")
+	q, err := crud.ParseQuery(r, panelConfig, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
 
+	from := `
+		FROM power_panels pp
+		WHERE pp.deleted_at IS NULL` + q.Where
+	if !crud.Total(w, r, h.DB.Pool, q, from, q.Args) {
+		return
+	}
+	seek, args := q.Seek(q.Args)
 	query := `
 		SELECT pp.id, pp.site_id, pp.name, pp.slug, pp.location,
 		       pp.rated_capacity_kw, pp.voltage_v, pp.phase_type,
-		       pp.created_at, pp.updated_at
-		FROM power_panels pp
-		WHERE pp.deleted_at IS NULL`
-	args := []interface{}{}
-	argIdx := 1
-
-	if siteID != "" {
-		query += fmt.Sprintf(" AND pp.site_id = $%d", argIdx)
-		args = append(args, siteID)
-		argIdx++
-	}
-	query += " ORDER BY pp.name"
+		       pp.created_at, pp.updated_at` +
+		from + seek + " ORDER BY " + q.OrderBy + q.LimitClause()
 
 	rows, err := h.DB.Pool.Query(r.Context(), query, args...)
 	if err != nil {
@@ -60,22 +109,15 @@ func (h *PanelHandler) List(w http.ResponseWriter, r *http.Request) {
 
 	results := []panelRow{}
 	for rows.Next() {
-		var p panelRow
-		var loc *string
-		var createdAt, updatedAt time.Time
-		if err := rows.Scan(&p.ID, &p.SiteID, &p.Name, &p.Slug, &loc,
-			&p.RatedCapacityKw, &p.VoltageV, &p.PhaseType,
-			&createdAt, &updatedAt); err != nil {
+		p, err := scanPanel(rows.Scan)
+		if err != nil {
 			log.Printf("panel scan error: %v", err)
 			continue
 		}
-		p.Location = loc
-		p.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-		p.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
 		results = append(results, p)
 	}
 
-	response.OK(w, results)
+	crud.List(w, r, h.DB.Pool, q, results)
 }
 
 // Get handles GET /panels/{id}
@@ -86,26 +128,16 @@ func (h *PanelHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var p panelRow
-	var loc *string
-	var createdAt, updatedAt time.Time
-
-	err := h.DB.Pool.QueryRow(r.Context(), `
+	p, err := scanPanel(h.DB.Pool.QueryRow(r.Context(), `
 		SELECT id, site_id, name, slug, location,
 		       rated_capacity_kw, voltage_v, phase_type,
 		       created_at, updated_at
 		FROM power_panels
-		WHERE id = $1 AND deleted_at IS NULL`, id).Scan(
-		&p.ID, &p.SiteID, &p.Name, &p.Slug, &loc,
-		&p.RatedCapacityKw, &p.VoltageV, &p.PhaseType,
-		&createdAt, &updatedAt)
+		WHERE id = $1 AND deleted_at IS NULL`, id).Scan)
 	if err != nil {
 		response.NotFound(w, "Power panel")
 		return
 	}
-	p.Location = loc
-	p.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-	p.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
 
 	response.OK(w, p)
 }
@@ -117,6 +149,9 @@ func (h *PanelHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, panelConfig, body, true) {
+		return
+	}
 
 	siteID, _ := body["siteId"].(string)
 	name, _ := body["name"].(string)
@@ -137,28 +172,32 @@ func (h *PanelHandler) Create(w http.ResponseWriter, r *http.Request) {
 		phaseType = pt
 	}
 
-	var p panelRow
-	var createdAt, updatedAt time.Time
-	var locOut *string
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
 
-	err := h.DB.Pool.QueryRow(r.Context(), `
+	p, err := scanPanel(tx.QueryRow(ctx, `
 		INSERT INTO power_panels (site_id, name, slug, location, rated_capacity_kw, voltage_v, phase_type)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, site_id, name, slug, location, rated_capacity_kw, voltage_v, phase_type, created_at, updated_at`,
-		siteID, name, slug, nilIfEmpty(loc), ratedKw, voltageV, phaseType).Scan(
-		&p.ID, &p.SiteID, &p.Name, &p.Slug, &locOut,
-		&p.RatedCapacityKw, &p.VoltageV, &p.PhaseType,
-		&createdAt, &updatedAt)
+		siteID, name, slug, nilIfEmpty(loc), ratedKw, voltageV, phaseType).Scan)
 	if err != nil {
-		log.Printf("panel create error: %v", err)
-		response.InternalError(w, "create failed")
+		response.DBError(w, err, "Power panel")
 		return
 	}
-	p.Location = locOut
-	p.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-	p.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
 
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "create", "power_panels", p.ID, nil, p)
+	if err := audit.LogEntry(ctx, tx, "create", "power_panels", p.ID, nil, p); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
 
 	response.Created(w, p)
 }
@@ -176,6 +215,9 @@ func (h *PanelHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
+	if !crud.ValidBody(w, r, h.DB.Pool, panelConfig, body, false) {
+		return
+	}
 
 	setClauses := []string{}
 	args := []interface{}{}
@@ -233,46 +275,49 @@ func (h *PanelHandler) Update(w http.ResponseWriter, r *http.Request) {
 		RETURNING id, site_id, name, slug, location, rated_capacity_kw, voltage_v, phase_type, created_at, updated_at`,
 		joinStrings(setClauses, ", "), argIdx)
 
-	var p panelRow
-	var locOut *string
-	var createdAt, updatedAt time.Time
-
-	err := h.DB.Pool.QueryRow(r.Context(), query, args...).Scan(
-		&p.ID, &p.SiteID, &p.Name, &p.Slug, &locOut,
-		&p.RatedCapacityKw, &p.VoltageV, &p.PhaseType,
-		&createdAt, &updatedAt)
+	ctx := r.Context()
+	tx, err := h.DB.Pool.Begin(ctx)
 	if err != nil {
-		response.NotFound(w, "Power panel")
+		response.InternalError(w, "database error")
 		return
 	}
-	p.Location = locOut
-	p.CreatedAt = createdAt.UTC().Format(time.RFC3339)
-	p.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
-
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "update", "power_panels", id, nil, p)
+	defer tx.Rollback(ctx) //nolint:errcheck
 
-	response.OK(w, p)
-}
-
-// Delete handles DELETE /panels/{id}
-func (h *PanelHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	if id == "" {
-		response.BadRequest(w, "id is required")
+	cur, err := scanPanel(tx.QueryRow(ctx, `
+		SELECT id, site_id, name, slug, location,
+		       rated_capacity_kw, voltage_v, phase_type,
+		       created_at, updated_at
+		FROM power_panels
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE`, id).Scan)
+	if err != nil {
+		response.DBError(w, err, "Power panel")
 		return
 	}
-
-	tag, err := h.DB.Pool.Exec(r.Context(), `
-		UPDATE power_panels SET deleted_at = $1
-		WHERE id = $2 AND deleted_at IS NULL`, time.Now().UTC(), id)
-	if err != nil || tag.RowsAffected() == 0 {
-		response.NotFound(w, "Power panel")
+	if err := etag.Check(ctx, tx, "power_panels", id); err != nil {
+		etag.WriteError(w, err)
+		return
+	}
+	p, err := scanPanel(tx.QueryRow(ctx, query, args...).Scan)
+	if err != nil {
+		response.DBError(w, err, "Power panel")
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "update", "power_panels", id, cur, p); err != nil {
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
 
-	_ = audit.LogEntry(r.Context(), h.DB.Pool, "", "delete", "power_panels", id, nil, nil)
+	response.OK(w, p)
+}
 
-	response.Message(w, "Power panel deleted", http.StatusOK)
+// Delete handles DELETE /panels/{id}?cascade=true
+func (h *PanelHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	cascade.HandleDelete(w, r, h.DB.Pool, "power_panels", "Power panel")
 }
 
 func nilIfEmpty(s string) interface{} {
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/response"
 )
 
 // PowerHandler handles power-related HTTP requests.
@@ -63,7 +64,7 @@ func (h *PowerHandler) CreateReadings(w http.ResponseWriter, r *http.Request) {
 			inp.FeedID, inp.VoltageV, inp.CurrentA, inp.PowerKw, inp.PowerFactor, inp.EnergyKwh,
 		)
 		if err != nil {
-			jsonResponse(w, map[string]string{"error": "insert failed"}, http.StatusInternalServerError)
+			response.DBError(w, err, "Power reading")
 			return
 		}
 		count++
@@ -0,0 +1,20 @@
+package handler
+
+import "github.com/dcim/go-services/internal/shared/trash"
+
+// TrashResources are the power resources with trash, restore and purge endpoints,
+// children before parents so expired rows purge in one pass.
+var TrashResources = []trash.Resource{
+	{
+		Path: "/feeds", Table: "power_feeds", Name: "Feed", Label: "name",
+		Parents: []trash.Parent{
+			{Column: "panel_id", Table: "power_panels"},
+			{Column: "rack_id", Table: "racks"},
+		},
+		PurgeFirst: []string{`DELETE FROM power_readings WHERE feed_id = $1`},
+	},
+	{
+		Path: "/panels", Table: "power_panels", Name: "Panel", Label: "name",
+		Parents: []trash.Parent{{Column: "site_id", Table: "sites"}},
+	},
+}
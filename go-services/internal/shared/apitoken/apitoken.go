@@ -0,0 +1,216 @@
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Prefix starts every token, so leaked tokens are easy to recognize and scan for.
+const Prefix = "dcim_"
+
+// touchEvery throttles last-used tracking to one write per token per interval.
+const touchEvery = time.Minute
+
+// Authentication failures. ErrInvalid covers unknown, revoked and malformed tokens.
+var (
+	ErrInvalid = errors.New("invalid API token")
+	ErrExpired = errors.New("API token expired")
+	ErrIP      = errors.New("API token not allowed from this address")
+)
+
+// Token is an API token as stored, without its secret.
+type Token struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	OwnerID    string   `json:"ownerId"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	AllowedIPs []string `json:"allowedIps"`
+	ExpiresAt  *string  `json:"expiresAt"`
+	LastUsedAt *string  `json:"lastUsedAt"`
+	LastUsedIP *string  `json:"lastUsedIp"`
+	RevokedAt  *string  `json:"revokedAt"`
+	CreatedAt  string   `json:"createdAt"`
+	UpdatedAt  string   `json:"updatedAt"`
+
+	expires  *time.Time
+	lastUsed *time.Time
+	revoked  bool
+}
+
+// Cols is the column list Scan reads, in order.
+const Cols = `id, name, owner_id, prefix, scopes, allowed_ips, expires_at, last_used_at, last_used_ip, revoked_at, created_at, updated_at`
+
+// Scan reads a row of Cols.
+func Scan(scan func(dest ...interface{}) error) (*Token, error) {
+	var t Token
+	var lastUsed, revoked *time.Time
+	var created, updated time.Time
+	if err := scan(&t.ID, &t.Name, &t.OwnerID, &t.Prefix, &t.Scopes, &t.AllowedIPs, &t.expires,
+		&lastUsed, &t.LastUsedIP, &revoked, &created, &updated); err != nil {
+		return nil, err
+	}
+	t.ExpiresAt = format(t.expires)
+	t.LastUsedAt = format(lastUsed)
+	t.RevokedAt = format(revoked)
+	t.CreatedAt = created.UTC().Format(time.RFC3339)
+	t.UpdatedAt = updated.UTC().Format(time.RFC3339)
+	t.lastUsed = lastUsed
+	t.revoked = revoked != nil
+	if t.Scopes == nil {
+		t.Scopes = []string{}
+	}
+	if t.AllowedIPs == nil {
+		t.AllowedIPs = []string{}
+	}
+	return &t, nil
+}
+
+// Generate returns a new token and the hash to store for it. The token is
+// shown to its owner once and never stored.
+func Generate() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = Prefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, Hash(token), nil
+}
+
+// Hash returns the stored form of a token: its hex SHA-256. Tokens are random,
+// so an unsalted fast hash is enough to make a leaked table useless.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Allows reports whether t may access resource, the first path segment of a
+// route, to read or to write. A write scope grants read too; "*" matches every
+// resource.
+func (t *Token) Allows(resource string, write bool) bool {
+	for _, s := range t.Scopes {
+		res, access, _ := strings.Cut(s, ":")
+		if res != "*" && res != resource {
+			continue
+		}
+		if access == "write" || (access == "read" && !write) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsIP reports whether ip matches t's allowlist of addresses and CIDRs.
+func (t *Token) allowsIP(ip string) bool {
+	if len(t.AllowedIPs) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, a := range t.AllowedIPs {
+		if _, cidr, err := net.ParseCIDR(a); err == nil {
+			if cidr.Contains(addr) {
+				return true
+			}
+		} else if allowed := net.ParseIP(a); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+var scopePattern = regexp.MustCompile(`^(\*|[a-z][a-z0-9-]*):(read|write)$`)
+
+// CheckScope returns an error unless s is a valid scope such as "devices:read".
+func CheckScope(s string) error {
+	if !scopePattern.MatchString(s) {
+		return fmt.Errorf("%q is not <resource>:read or <resource>:write", s)
+	}
+	return nil
+}
+
+// CheckIP returns an error unless s is an IP address or CIDR.
+func CheckIP(s string) error {
+	if net.ParseIP(s) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%q is not an IP address or CIDR", s)
+}
+
+// Store authenticates tokens against api_tokens.
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+// NewStore returns a Store reading api_tokens from pool.
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{pool: pool}
+}
+
+// Authenticate returns the live token matching raw, presented from ip, and
+// records its use.
+func (s *Store) Authenticate(ctx context.Context, raw, ip string) (*Token, error) {
+	if !strings.HasPrefix(raw, Prefix) {
+		return nil, ErrInvalid
+	}
+	t, err := Scan(s.pool.QueryRow(ctx, `SELECT `+Cols+` FROM api_tokens WHERE token_hash = $1`, Hash(raw)).Scan)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case t.revoked:
+		return nil, ErrInvalid
+	case t.expires != nil && !t.expires.After(time.Now()):
+		return nil, ErrExpired
+	case !t.allowsIP(ip):
+		return nil, ErrIP
+	}
+	if t.lastUsed == nil || time.Since(*t.lastUsed) > touchEvery {
+		if _, err := s.pool.Exec(ctx, `UPDATE api_tokens SET last_used_at = now(), last_used_ip = $2 WHERE id = $1`,
+			t.ID, ip); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+type tokenKey struct{}
+
+// WithToken returns ctx carrying the token a request authenticated with.
+func WithToken(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+// FromContext returns the token of ctx, or nil for requests from the BFF.
+func FromContext(ctx context.Context) *Token {
+	t, _ := ctx.Value(tokenKey{}).(*Token)
+	return t
+}
+
+func format(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.UTC().Format(time.RFC3339)
+	return &s
+}
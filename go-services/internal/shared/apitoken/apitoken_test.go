@@ -0,0 +1,56 @@
+package apitoken
+
+import "testing"
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		scopes   []string
+		resource string
+		write    bool
+		want     bool
+	}{
+		{[]string{"devices:read"}, "devices", false, true},
+		{[]string{"devices:read"}, "devices", true, false},
+		{[]string{"devices:read"}, "racks", false, false},
+		{[]string{"racks:write"}, "racks", false, true},
+		{[]string{"racks:write"}, "racks", true, true},
+		{[]string{"*:read"}, "sites", false, true},
+		{[]string{"*:read"}, "sites", true, false},
+		{[]string{"*:write"}, "audit-logs", true, true},
+		{[]string{"devices:read", "devices:write"}, "devices", true, true},
+		{[]string{"devices:read", "racks:write", "*:read"}, "cables", true, false},
+		{nil, "devices", false, false},
+	}
+	for _, tt := range tests {
+		tok := &Token{Scopes: tt.scopes}
+		if got := tok.Allows(tt.resource, tt.write); got != tt.want {
+			t.Errorf("%v.Allows(%q, %v) = %v, want %v", tt.scopes, tt.resource, tt.write, got, tt.want)
+		}
+	}
+}
+
+func TestAllowsIP(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		ip      string
+		want    bool
+	}{
+		{nil, "203.0.113.7", true},
+		{nil, "", true},
+		{[]string{"203.0.113.7"}, "203.0.113.7", true},
+		{[]string{"203.0.113.7"}, "203.0.113.8", false},
+		{[]string{"203.0.113.0/24"}, "203.0.113.200", true},
+		{[]string{"203.0.113.0/24"}, "203.0.114.1", false},
+		{[]string{"10.0.0.0/8", "2001:db8::/32"}, "2001:db8::1", true},
+		{[]string{"2001:db8::/32"}, "2001:db9::1", false},
+		{[]string{"203.0.113.7"}, "::ffff:203.0.113.7", true},
+		{[]string{"203.0.113.0/24"}, "not-an-ip", false},
+		{[]string{"203.0.113.0/24"}, "", false},
+	}
+	for _, tt := range tests {
+		tok := &Token{AllowedIPs: tt.allowed}
+		if got := tok.allowsIP(tt.ip); got != tt.want {
+			t.Errorf("%v.allowsIP(%q) = %v, want %v", tt.allowed, tt.ip, got, tt.want)
+		}
+	}
+}
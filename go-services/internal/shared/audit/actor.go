@@ -0,0 +1,36 @@
+package audit
+
+import "context"
+
+// Actor is who made a change and from where, as forwarded by the BFF.
+type Actor struct {
+	UserID     string
+	IP         string
+	UserAgent  string
+	Reason     string // why the change was made, if the client said
+	ActionType string // audit_action_type: login, api_call, asset_view or export
+}
+
+type actorKey struct{}
+
+// WithActor returns ctx carrying a, for LogEntry to record.
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// ActorFrom returns the actor of ctx, or the zero Actor.
+func ActorFrom(ctx context.Context) Actor {
+	a, _ := ctx.Value(actorKey{}).(Actor)
+	return a
+}
+
+// WithReason returns ctx with the actor's reason set, e.g. from the "reason"
+// field of a request body. An empty reason leaves ctx as is.
+func WithReason(ctx context.Context, reason string) context.Context {
+	if reason == "" {
+		return ctx
+	}
+	a := ActorFrom(ctx)
+	a.Reason = reason
+	return WithActor(ctx, a)
+}
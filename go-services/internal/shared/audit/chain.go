@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// chainLock is the advisory lock key serializing appends to the hash chain.
+const chainLock int64 = 0x617564697463 // "auditc"
+
+// Link is an audit_logs row as covered by the hash chain.
+type Link struct {
+	Seq           int64
+	PrevHash      *string // nil for the first entry
+	Hash          string
+	ID            string
+	UserID        *string
+	Action        string
+	ActionType    *string
+	TableName     string
+	RecordID      string
+	ChangesBefore *string // jsonb text as stored
+	ChangesAfter  *string
+	Reason        *string
+	IPAddress     *string
+	UserAgent     *string
+	CreatedAt     time.Time
+}
+
+// linkCols are the columns of Link in scan order.
+const linkCols = `chain_seq, prev_hash, hash, id, user_id, action, action_type::text, table_name, record_id,
+	changes_before::text, changes_after::text, reason, ip_address, user_agent, created_at`
+
+func scanLink(scan func(dest ...interface{}) error) (Link, error) {
+	var l Link
+	err := scan(&l.Seq, &l.PrevHash, &l.Hash, &l.ID, &l.UserID, &l.Action, &l.ActionType, &l.TableName, &l.RecordID,
+		&l.ChangesBefore, &l.ChangesAfter, &l.Reason, &l.IPAddress, &l.UserAgent, &l.CreatedAt)
+	l.CreatedAt = l.CreatedAt.UTC()
+	return l, err
+}
+
+// sum returns the hash of the entry: SHA-256 over a JSON array of its sequence
+// number, the previous hash and every column, with NULL as null.
+func (l Link) sum() string {
+	content, _ := json.Marshal([]interface{}{
+		l.Seq, l.PrevHash, l.ID, l.UserID, l.Action, l.ActionType, l.TableName, l.RecordID,
+		l.ChangesBefore, l.ChangesAfter, l.Reason, l.IPAddress, l.UserAgent,
+		l.CreatedAt.Format(time.RFC3339Nano),
+	})
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:])
+}
+
+// hashRef returns the hash for the next entry's prev_hash, nil before the first entry.
+func (l Link) hashRef() *string {
+	if l.Seq == 0 {
+		return nil
+	}
+	return &l.Hash
+}
+
+// Break is the first place the chain does not hold.
+type Break struct {
+	Seq    int64  `json:"seq"`
+	ID     string `json:"id,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Verification is the result of walking the chain.
+type Verification struct {
+	Valid      bool   `json:"valid"`
+	Checked    int64  `json:"checked"`
+	HeadSeq    int64  `json:"headSeq"`
+	HeadHash   string `json:"headHash,omitempty"`
+	FirstBreak *Break `json:"firstBreak"`
+	// Unchained counts rows written since the chain began without joining it.
+	// Every writer appends to the chain, so any such row fails verification.
+	Unchained int64 `json:"unchained"`
+}
+
+// Verify walks the chain from its first entry and reports the first break:
+// a missing sequence number, a prev_hash that is not the hash of the entry
+// before, or a hash that does not match the entry's contents. A row inserted
+// after the first entry without joining the chain is a break too. Deleting the
+// newest entries leaves a valid shorter chain; compare the head with a signed
+// Checkpoint to detect that.
+func Verify(ctx context.Context, pool *pgxpool.Pool) (Verification, error) {
+	var v Verification
+	rows, err := pool.Query(ctx, `SELECT `+linkCols+` FROM audit_logs WHERE chain_seq IS NOT NULL ORDER BY chain_seq`)
+	if err != nil {
+		return v, err
+	}
+	defer rows.Close()
+
+	var prev Link
+	for rows.Next() {
+		l, err := scanLink(rows.Scan)
+		if err != nil {
+			return v, err
+		}
+		if b := check(prev, l); b != nil {
+			v.FirstBreak = b
+			break
+		}
+		v.Checked++
+		v.HeadSeq, v.HeadHash = l.Seq, l.Hash
+		prev = l
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return v, err
+	}
+
+	var first *string
+	err = pool.QueryRow(ctx, `
+		SELECT count(*), (array_agg(id ORDER BY created_at, id))[1] FROM audit_logs
+		WHERE chain_seq IS NULL
+		  AND created_at >= (SELECT created_at FROM audit_logs WHERE chain_seq = 1)`).Scan(&v.Unchained, &first)
+	if err != nil {
+		return v, err
+	}
+	if v.FirstBreak == nil && first != nil {
+		v.FirstBreak = &Break{ID: *first, Reason: fmt.Sprintf("%d entries were written outside the chain", v.Unchained)}
+	}
+	v.Valid = v.FirstBreak == nil
+	return v, nil
+}
+
+// check returns the break between l and the entry before it, or nil.
+func check(prev, l Link) *Break {
+	switch {
+	case l.Seq != prev.Seq+1:
+		return &Break{Seq: prev.Seq + 1, Reason: fmt.Sprintf("entries %d to %d are missing", prev.Seq+1, l.Seq-1)}
+	case !sameRef(l.PrevHash, prev.hashRef()):
+		return &Break{Seq: l.Seq, ID: l.ID, Reason: "prev_hash does not match the hash of the previous entry"}
+	case l.Hash != l.sum():
+		return &Break{Seq: l.Seq, ID: l.ID, Reason: "hash does not match the entry's contents"}
+	}
+	return nil
+}
+
+func sameRef(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Head returns the newest entry of the chain. ok is false when it is empty.
+func Head(ctx context.Context, pool *pgxpool.Pool) (l Link, ok bool, err error) {
+	l, err = scanLink(pool.QueryRow(ctx, `SELECT `+linkCols+` FROM audit_logs WHERE chain_seq IS NOT NULL ORDER BY chain_seq DESC LIMIT 1`).Scan)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Link{}, false, nil
+	}
+	return l, err == nil, err
+}
+
+// linkAt returns the entry with sequence number seq.
+func linkAt(ctx context.Context, pool *pgxpool.Pool, seq int64) (Link, error) {
+	return scanLink(pool.QueryRow(ctx, `SELECT `+linkCols+` FROM audit_logs WHERE chain_seq = $1`, seq).Scan)
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+func strp(s string) *string { return &s }
+
+// link returns a chained entry following prev.
+func link(prev Link, id string) Link {
+	l := Link{
+		Seq:          prev.Seq + 1,
+		PrevHash:     prev.hashRef(),
+		ID:           id,
+		UserID:       strp("user-1"),
+		Action:       "update",
+		ActionType:   strp("api_call"),
+		TableName:    "devices",
+		RecordID:     "dev-1",
+		ChangesAfter: strp(`{"name": "a<b>&c"}`),
+		CreatedAt:    time.Date(2026, 10, 19, 8, 30, 0, 120000000, time.UTC),
+	}
+	l.Hash = l.sum()
+	return l
+}
+
+func TestSum(t *testing.T) {
+	// The hash format is shared with lib/audit.ts; changing it breaks verification
+	// of entries written by the web app.
+	const want = "babe0a5a534f19bcd4661f3b6bdd5fd7dc5f1a75d50f764b3a6e194cd31b087b"
+	if got := link(Link{}, "a1").sum(); got != want {
+		t.Errorf("sum() = %s, want %s", got, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	first := link(Link{}, "a1")
+	second := link(first, "a2")
+
+	tests := []struct {
+		name   string
+		prev   Link
+		l      func() Link
+		reason string
+	}{
+		{"first entry", Link{}, func() Link { return first }, ""},
+		{"next entry", first, func() Link { return second }, ""},
+		{"gap", first, func() Link { return link(second, "a3") }, "entries 2 to 2 are missing"},
+		{"first entry with prev hash", Link{}, func() Link { l := second; l.Seq = 1; return l }, "prev_hash does not match the hash of the previous entry"},
+		{"wrong prev hash", first, func() Link { l := second; l.PrevHash = strp("00"); return l }, "prev_hash does not match the hash of the previous entry"},
+		{"edited contents", first, func() Link { l := second; l.RecordID = "dev-2"; return l }, "hash does not match the entry's contents"},
+		{"edited timestamp", first, func() Link { l := second; l.CreatedAt = l.CreatedAt.Add(time.Microsecond); return l }, "hash does not match the entry's contents"},
+		{"cleared user", first, func() Link { l := second; l.UserID = nil; return l }, "hash does not match the entry's contents"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := check(tt.prev, tt.l())
+			switch {
+			case tt.reason == "" && b != nil:
+				t.Errorf("check() = %+v, want no break", b)
+			case tt.reason != "" && b == nil:
+				t.Errorf("check() = nil, want %q", tt.reason)
+			case tt.reason != "" && b.Reason != tt.reason:
+				t.Errorf("check() reason = %q, want %q", b.Reason, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Checkpoint is a signed statement of the chain head at a point in time.
+// Kept outside the database, it shows later that no entry up to Seq was
+// changed or removed, including the newest ones Verify cannot vouch for.
+type Checkpoint struct {
+	Seq       int64  `json:"seq"`
+	Hash      string `json:"hash"`
+	SignedAt  string `json:"signedAt"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"publicKey"` // base64
+	Signature string `json:"signature"` // base64, over Message()
+}
+
+// Message returns the bytes a checkpoint's signature covers.
+func (c Checkpoint) Message() []byte {
+	return []byte(fmt.Sprintf("dcim-audit-checkpoint\n%d\n%s\n%s\n", c.Seq, c.Hash, c.SignedAt))
+}
+
+// Signer signs checkpoints with an Ed25519 key.
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner returns a Signer for a base64-encoded 32-byte Ed25519 seed, or
+// nil when seed is empty.
+func NewSigner(seed string) (*Signer, error) {
+	if seed == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		return nil, fmt.Errorf("decode signing key: %w", err)
+	}
+	if len(b) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be a %d-byte Ed25519 seed", ed25519.SeedSize)
+	}
+	return &Signer{key: ed25519.NewKeyFromSeed(b)}, nil
+}
+
+// Checkpoint verifies the chain and signs its head. It fails when the chain is
+// broken or empty, so a checkpoint never vouches for tampered history.
+func (s *Signer) Checkpoint(ctx context.Context, pool *pgxpool.Pool) (Checkpoint, error) {
+	v, err := Verify(ctx, pool)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if !v.Valid {
+		return Checkpoint{}, fmt.Errorf("audit chain is broken at entry %d: %s", v.FirstBreak.Seq, v.FirstBreak.Reason)
+	}
+	if v.Checked == 0 {
+		return Checkpoint{}, errors.New("audit chain is empty")
+	}
+	c := Checkpoint{
+		Seq:       v.HeadSeq,
+		Hash:      v.HeadHash,
+		SignedAt:  time.Now().UTC().Format(time.RFC3339),
+		Algorithm: "ed25519",
+		PublicKey: base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey)),
+	}
+	c.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, c.Message()))
+	return c, nil
+}
+
+// Check reports why c does not hold against this signer's key and the current
+// chain, or "" when it does: the signature must be valid and entry c.Seq must
+// still carry c.Hash. Entries before it are covered by Verify.
+func (s *Signer) Check(ctx context.Context, pool *pgxpool.Pool, c Checkpoint) (string, error) {
+	sig, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil || !ed25519.Verify(s.key.Public().(ed25519.PublicKey), c.Message(), sig) {
+		return "signature is not valid for this checkpoint", nil
+	}
+	l, err := linkAt(ctx, pool, c.Seq)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Sprintf("entry %d no longer exists", c.Seq), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if l.Hash != c.Hash || l.Hash != l.sum() {
+		return fmt.Sprintf("entry %d has changed since the checkpoint", c.Seq), nil
+	}
+	return "", nil
+}
@@ -3,12 +3,25 @@ package audit
 import (
 	"context"
 	"encoding/json"
+	"errors"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/dcim/go-services/internal/shared/outbox"
+	"github.com/jackc/pgx/v5"
 )
 
-// LogEntry represents an audit log record for INSERT.
-func LogEntry(ctx context.Context, pool *pgxpool.Pool, userID, action, tableName, recordID string, before, after interface{}) error {
+// LogEntry records a change to tableName/recordID in audit_logs. The user, IP,
+// user agent, reason and action type come from the Actor of ctx. A user ID
+// that names no user is recorded as NULL rather than failing the insert.
+//
+// The entry is written in tx, the transaction that made the change, so the
+// change, its entry and its outbox event commit together or not at all. Call it
+// just before tx.Commit and fail the request when it returns an error.
+//
+// Entries are appended to the hash chain one at a time: each takes the next
+// chain_seq and hashes its contents with the hash of the entry before it. The
+// chain lock is held until tx ends.
+func LogEntry(ctx context.Context, tx pgx.Tx, action, tableName, recordID string, before, after interface{}) error {
 	var beforeJSON, afterJSON []byte
 	var err error
 
@@ -25,19 +38,60 @@ func LogEntry(ctx context.Context, pool *pgxpool.Pool, userID, action, tableName
 		}
 	}
 
-	_, err = pool.Exec(ctx,
-		`INSERT INTO audit_logs (user_id, action, table_name, record_id, changes_before, changes_after)
-         VALUES ($1, $2, $3, $4, $5, $6)`,
-		nilIfEmpty(userID), action, tableName, recordID, nullableJSON(beforeJSON), nullableJSON(afterJSON),
-	)
-	return err
-}
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, chainLock); err != nil {
+		return err
+	}
+	var prev Link
+	err = tx.QueryRow(ctx, `SELECT chain_seq, hash FROM audit_logs WHERE chain_seq IS NOT NULL ORDER BY chain_seq DESC LIMIT 1`).
+		Scan(&prev.Seq, &prev.Hash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
 
-func nilIfEmpty(s string) interface{} {
-	if s == "" {
-		return nil
+	// The row is hashed as Postgres will store it: jsonb normalizes the
+	// changes, and the user ID is NULL when it names no user.
+	actor := ActorFrom(ctx)
+	e := Link{
+		Seq:        prev.Seq + 1,
+		PrevHash:   prev.hashRef(),
+		Action:     action,
+		ActionType: stringRef(actor.ActionType),
+		TableName:  tableName,
+		RecordID:   recordID,
+		Reason:     stringRef(actor.Reason),
+		IPAddress:  stringRef(actor.IP),
+		UserAgent:  stringRef(actor.UserAgent),
+	}
+	err = tx.QueryRow(ctx, `SELECT gen_random_uuid()::text, (SELECT id FROM users WHERE id = $1), $2::jsonb::text, $3::jsonb::text, now()`,
+		db.NullIfEmpty(actor.UserID), nullableJSON(beforeJSON), nullableJSON(afterJSON)).
+		Scan(&e.ID, &e.UserID, &e.ChangesBefore, &e.ChangesAfter, &e.CreatedAt)
+	if err != nil {
+		return err
+	}
+	e.CreatedAt = e.CreatedAt.UTC()
+	e.Hash = e.sum()
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO audit_logs (id, user_id, action, action_type, table_name, record_id, changes_before, changes_after, reason, ip_address, user_agent, created_at, chain_seq, prev_hash, hash)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		e.ID, e.UserID, e.Action, e.ActionType, e.TableName, e.RecordID,
+		e.ChangesBefore, e.ChangesAfter, e.Reason, e.IPAddress, e.UserAgent, e.CreatedAt,
+		e.Seq, e.PrevHash, e.Hash,
+	)
+	if err != nil {
+		return err
 	}
-	return s
+
+	return outbox.Enqueue(ctx, tx, outbox.Event{
+		Resource:   tableName,
+		Action:     action,
+		RecordID:   recordID,
+		AuditLogID: e.ID,
+		ActorID:    e.UserID,
+		Before:     e.ChangesBefore,
+		After:      e.ChangesAfter,
+		Reason:     e.Reason,
+	})
 }
 
 func nullableJSON(b []byte) interface{} {
@@ -46,3 +100,10 @@ func nullableJSON(b []byte) interface{} {
 	}
 	return b
 }
+
+func stringRef(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
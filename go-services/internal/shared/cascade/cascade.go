@@ -0,0 +1,387 @@
+package cascade
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/etag"
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Kind says what happens to a dependent row when its parent is deleted.
+type Kind int
+
+const (
+	// Block rows stop the delete unless ?cascade=true, in which case they are deleted too.
+	Block Kind = iota
+	// Owned rows exist only as part of the parent and are always deleted with it.
+	Owned
+	// Detach rows keep living; their reference to the parent is set to NULL.
+	Detach
+)
+
+// Edge is a reference from Table to a parent table. Match is a condition on
+// Table where $1 is the array of parent ids.
+type Edge struct {
+	Table  string
+	Column string // referencing column; the column nulled for Detach edges
+	Match  string
+	Kind   Kind
+}
+
+func ref(table, column string, kind Kind) Edge {
+	return Edge{Table: table, Column: column, Match: column + " = ANY($1)", Kind: kind}
+}
+
+// cablesOn matches cables with either end on a component of termType.
+func cablesOn(termType string) Edge {
+	return Edge{Table: "cables", Kind: Block, Match: fmt.Sprintf(
+		"((termination_a_type = '%[1]s' AND termination_a_id = ANY($1)) OR (termination_b_type = '%[1]s' AND termination_b_id = ANY($1)))",
+		termType)}
+}
+
+// Graph lists, per table, the soft-deletable rows that reference it.
+var Graph = map[string][]Edge{
+	"regions": {
+		ref("regions", "parent_id", Block),
+		ref("sites", "region_id", Detach),
+	},
+	"tenants": {
+		ref("sites", "tenant_id", Detach),
+		ref("locations", "tenant_id", Detach),
+		ref("racks", "tenant_id", Detach),
+		ref("devices", "tenant_id", Detach),
+		ref("cables", "tenant_id", Detach),
+	},
+	"sites": {
+		ref("locations", "site_id", Block),
+		ref("power_panels", "site_id", Block),
+		ref("access_logs", "site_id", Block),
+		ref("equipment_movements", "site_id", Block),
+	},
+	"locations": {
+		ref("racks", "location_id", Block),
+		ref("location_floor_cells", "location_id", Owned),
+	},
+	"racks": {
+		ref("devices", "rack_id", Block),
+		ref("power_feeds", "rack_id", Detach),
+		ref("equipment_movements", "rack_id", Detach),
+	},
+	"manufacturers": {
+		ref("device_types", "manufacturer_id", Block),
+	},
+	"device_types": {
+		ref("devices", "device_type_id", Block),
+		ref("interface_templates", "device_type_id", Owned),
+		ref("console_port_templates", "device_type_id", Owned),
+		ref("rear_port_templates", "device_type_id", Owned),
+		ref("front_port_templates", "device_type_id", Owned),
+		ref("power_port_templates", "device_type_id", Owned),
+	},
+	"rear_port_templates": {
+		ref("front_port_templates", "rear_port_template_id", Owned),
+	},
+	"devices": {
+		ref("interfaces", "device_id", Owned),
+		ref("console_ports", "device_id", Owned),
+		ref("rear_ports", "device_id", Owned),
+		ref("front_ports", "device_id", Owned),
+		ref("power_ports", "device_id", Owned),
+		ref("equipment_movements", "device_id", Detach),
+	},
+	"interfaces":    {cablesOn("interface")},
+	"console_ports": {cablesOn("consolePort")},
+	"front_ports":   {cablesOn("frontPort")},
+	"rear_ports": {
+		ref("front_ports", "rear_port_id", Block),
+		cablesOn("rearPort"),
+	},
+	"power_panels": {
+		ref("power_feeds", "panel_id", Block),
+		ref("power_outlets", "panel_id", Owned),
+	},
+	"power_feeds": {
+		ref("power_ports", "feed_id", Detach),
+	},
+	"power_ports": {
+		ref("power_outlets", "port_id", Detach),
+		cablesOn("powerPort"),
+	},
+	"power_outlets": {cablesOn("powerOutlet")},
+}
+
+// blockerSample caps the ids listed per blocking table.
+const blockerSample = 10
+
+// Blocker is a table with live rows that prevent a delete.
+type Blocker struct {
+	Table string   `json:"table"`
+	Count int      `json:"count"`
+	IDs   []string `json:"ids"`
+}
+
+// BlockedError is returned when a delete without cascade has dependents.
+type BlockedError struct {
+	Blockers []Blocker
+}
+
+func (e *BlockedError) Error() string {
+	parts := make([]string, len(e.Blockers))
+	for i, b := range e.Blockers {
+		parts[i] = fmt.Sprintf("%d %s", b.Count, b.Table)
+	}
+	return "still referenced by " + strings.Join(parts, ", ")
+}
+
+// Row identifies one affected record. Column names the nulled reference of a
+// detached row. Before is the row as it was, or for a detached row the value
+// of Column.
+type Row struct {
+	Table  string
+	ID     string
+	Column string
+	Before json.RawMessage
+}
+
+// Result reports what a delete touched besides the root row.
+type Result struct {
+	Deleted  []Row // cascaded soft-deletes, in delete order
+	Detached []Row // rows whose reference was nulled
+}
+
+// SoftDelete soft-deletes table/id inside tx along with every Owned dependent and,
+// when cascade is set, every Block dependent, recursively. Detach references to
+// deleted rows are nulled. Without cascade, Block dependents anywhere below the
+// row are returned as a *BlockedError and nothing is written. pgx.ErrNoRows is
+// returned when the row does not exist or is already deleted.
+func SoftDelete(ctx context.Context, tx pgx.Tx, table, id string, cascade bool, now time.Time) (Result, error) {
+	var res Result
+	var locked string
+	if err := tx.QueryRow(ctx, fmt.Sprintf(`SELECT id FROM %s WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, table), id).Scan(&locked); err != nil {
+		return res, err
+	}
+
+	type level struct {
+		table string
+		ids   []string
+	}
+	seen := map[[2]string]bool{{table, id}: true}
+	order := []level{{table, []string{id}}}
+	blocked := map[string]*Blocker{}
+	for i := 0; i < len(order); i++ {
+		cur := order[i]
+		for _, e := range Graph[cur.table] {
+			if e.Kind == Detach {
+				continue
+			}
+			ids, err := liveIDs(ctx, tx, e, cur.ids)
+			if err != nil {
+				return res, err
+			}
+			var fresh []string
+			for _, cid := range ids {
+				if row := [2]string{e.Table, cid}; !seen[row] {
+					seen[row] = true
+					fresh = append(fresh, cid)
+				}
+			}
+			if len(fresh) == 0 {
+				continue
+			}
+			if e.Kind == Block && !cascade {
+				b := blocked[e.Table]
+				if b == nil {
+					b = &Blocker{Table: e.Table, IDs: []string{}}
+					blocked[e.Table] = b
+				}
+				b.Count += len(fresh)
+				for _, cid := range fresh {
+					if len(b.IDs) < blockerSample {
+						b.IDs = append(b.IDs, cid)
+					}
+				}
+				continue
+			}
+			order = append(order, level{e.Table, fresh})
+		}
+	}
+	if len(blocked) > 0 {
+		be := &BlockedError{}
+		for _, b := range blocked {
+			be.Blockers = append(be.Blockers, *b)
+		}
+		sort.Slice(be.Blockers, func(i, j int) bool { return be.Blockers[i].Table < be.Blockers[j].Table })
+		return res, be
+	}
+
+	for i, lv := range order {
+		// The rows are locked by liveIDs, so old holds their state before the update.
+		rows, err := tx.Query(ctx, fmt.Sprintf(`
+			WITH old AS (SELECT t.id, row_to_json(t) AS row FROM %[1]s t WHERE t.id = ANY($1) AND t.deleted_at IS NULL)
+			UPDATE %[1]s u SET deleted_at = $2, updated_at = $2
+			FROM old WHERE u.id = old.id
+			RETURNING u.id, old.row`, lv.table), lv.ids, now)
+		if err != nil {
+			return res, err
+		}
+		deleted, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Row, error) {
+			d := Row{Table: lv.table}
+			err := row.Scan(&d.ID, &d.Before)
+			return d, err
+		})
+		if err != nil {
+			return res, err
+		}
+		if i == 0 {
+			continue
+		}
+		res.Deleted = append(res.Deleted, deleted...)
+	}
+
+	for _, lv := range order {
+		for _, e := range Graph[lv.table] {
+			if e.Kind != Detach {
+				continue
+			}
+			rows, err := tx.Query(ctx, fmt.Sprintf(`
+				WITH old AS (SELECT id, to_json(%[2]s) AS value FROM %[1]s WHERE %[3]s AND deleted_at IS NULL FOR UPDATE)
+				UPDATE %[1]s u SET %[2]s = NULL, updated_at = $2
+				FROM old WHERE u.id = old.id
+				RETURNING u.id, old.value`, e.Table, e.Column, e.Match), lv.ids, now)
+			if err != nil {
+				return res, err
+			}
+			detached, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Row, error) {
+				d := Row{Table: e.Table, Column: e.Column}
+				err := row.Scan(&d.ID, &d.Before)
+				return d, err
+			})
+			if err != nil {
+				return res, err
+			}
+			res.Detached = append(res.Detached, detached...)
+		}
+	}
+	return res, nil
+}
+
+func liveIDs(ctx context.Context, tx pgx.Tx, e Edge, parents []string) ([]string, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf(`
+		SELECT id FROM %s
+		WHERE %s AND deleted_at IS NULL
+		ORDER BY id
+		FOR UPDATE`, e.Table, e.Match), parents)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+type deleteResult struct {
+	Message  string         `json:"message"`
+	Cascaded map[string]int `json:"cascaded"`
+	Detached map[string]int `json:"detached"`
+}
+
+// HandleDelete serves DELETE /prefix/{id}[?cascade=true] for table. Dependents are
+// checked and deleted in one transaction; blockers are reported as 409.
+func HandleDelete(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, table, name string) {
+	id := r.PathValue("id")
+	if id == "" {
+		response.BadRequest(w, "id is required")
+		return
+	}
+	ctx := r.Context()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	// The row as it was before the delete, for the audit log.
+	var before json.RawMessage
+	err = tx.QueryRow(ctx, fmt.Sprintf(`SELECT row_to_json(t) FROM %s t WHERE t.id = $1 AND t.deleted_at IS NULL FOR UPDATE`, table), id).Scan(&before)
+	if err == nil {
+		err = etag.Check(ctx, tx, table, id)
+	}
+	var res Result
+	if err == nil {
+		res, err = SoftDelete(ctx, tx, table, id, r.URL.Query().Get("cascade") == "true", time.Now().UTC())
+	}
+	if err == nil {
+		err = audit.LogEntry(ctx, tx, "delete", table, id, before, nil)
+	}
+	if err == nil {
+		err = LogResult(ctx, tx, table, id, res)
+	}
+	var be *BlockedError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		response.NotFound(w, name)
+		return
+	case errors.As(err, &be):
+		response.Conflict(w, fmt.Sprintf("%s is %s; delete them first or pass ?cascade=true", name, be.Error()), be.Blockers)
+		return
+	case errors.Is(err, etag.ErrModified):
+		etag.WriteError(w, err)
+		return
+	case err != nil:
+		log.Printf("delete error [%s]: %v", table, err)
+		response.InternalError(w, "delete failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+
+	out := deleteResult{Message: name + " deleted", Cascaded: map[string]int{}, Detached: map[string]int{}}
+	for _, d := range res.Deleted {
+		out.Cascaded[d.Table]++
+	}
+	for _, d := range res.Detached {
+		out.Detached[d.Table]++
+	}
+	response.OK(w, out)
+}
+
+// LogResult writes the audit entries for the rows a delete of table/id cascaded to
+// or detached, in the transaction of the delete. Each entry holds the row's
+// before-state; the reason names the delete it came from.
+func LogResult(ctx context.Context, tx pgx.Tx, table, id string, res Result) error {
+	ctx = audit.WithReason(ctx, cascadeReason(audit.ActorFrom(ctx).Reason, table, id))
+	for _, d := range res.Deleted {
+		if err := audit.LogEntry(ctx, tx, "delete", d.Table, d.ID, d.Before, nil); err != nil {
+			return err
+		}
+	}
+	for _, d := range res.Detached {
+		before := map[string]json.RawMessage{d.Column: d.Before}
+		if err := audit.LogEntry(ctx, tx, "update", d.Table, d.ID, before, map[string]interface{}{d.Column: nil}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cascadeReason is the audit reason of a row touched by the delete of
+// table/id, keeping the reason given for that delete.
+func cascadeReason(reason, table, id string) string {
+	origin := "cascaded from " + table + "/" + id
+	if reason == "" {
+		return origin
+	}
+	return reason + " (" + origin + ")"
+}
@@ -0,0 +1,17 @@
+package cascade
+
+import "testing"
+
+func TestCascadeReason(t *testing.T) {
+	tests := []struct {
+		reason, want string
+	}{
+		{"", "cascaded from devices/d1"},
+		{"decommissioned", "decommissioned (cascaded from devices/d1)"},
+	}
+	for _, tt := range tests {
+		if got := cascadeReason(tt.reason, "devices", "d1"); got != tt.want {
+			t.Errorf("cascadeReason(%q) = %q, want %q", tt.reason, got, tt.want)
+		}
+	}
+}
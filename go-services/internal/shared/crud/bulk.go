@@ -0,0 +1,310 @@
+package crud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/cascade"
+	"github.com/dcim/go-services/internal/shared/etag"
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MaxBulkOps caps the operations of one bulk request.
+const MaxBulkOps = 1000
+
+// BulkResource exposes a resource through POST {Path}/bulk. Creates and updates
+// go through the same checks and hooks as the single-row routes; deletes follow
+// the cascade graph when Config.SoftDelete is set.
+type BulkResource struct {
+	Path   string // URL prefix, e.g. "/racks"
+	Config Config
+}
+
+// RegisterBulk registers POST /prefix/bulk for every resource.
+func RegisterBulk(mux *http.ServeMux, wrap func(http.Handler) http.Handler, pool *pgxpool.Pool, resources []BulkResource) {
+	for _, res := range resources {
+		b := &bulkHandler{h: NewResource(res.Config, pool)}
+		mux.Handle("POST "+res.Path+"/bulk", wrap(http.HandlerFunc(b.serve)))
+	}
+}
+
+type bulkHandler struct {
+	h *Resource
+}
+
+// BulkOp is one operation of a bulk request.
+type BulkOp struct {
+	Op      string                 `json:"op"` // "create" | "update" | "delete"
+	ID      string                 `json:"id,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	IfMatch string                 `json:"ifMatch,omitempty"` // as the If-Match header of PATCH and DELETE
+}
+
+type bulkRequest struct {
+	Operations []BulkOp `json:"operations"`
+	Atomic     *bool    `json:"atomic"`  // default true
+	Cascade    bool     `json:"cascade"` // as ?cascade=true on DELETE
+}
+
+// BulkResult reports one operation.
+type BulkResult struct {
+	Index  int                 `json:"index"`
+	Op     string              `json:"op"`
+	ID     string              `json:"id,omitempty"`
+	Status int                 `json:"status"`
+	Data   interface{}         `json:"data,omitempty"`
+	Error  string              `json:"error,omitempty"`
+	Issues []map[string]string `json:"issues,omitempty"`
+
+	conflict interface{}
+	reason   string
+	before   map[string]interface{}
+	deleted  cascade.Result
+}
+
+type bulkResponse struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []BulkResult `json:"results"`
+}
+
+type bulkFailure struct {
+	Error    string              `json:"error"`
+	Index    int                 `json:"index"`
+	Conflict interface{}         `json:"conflict,omitempty"`
+	Issues   []map[string]string `json:"issues,omitempty"`
+	Results  []BulkResult        `json:"results"`
+}
+
+// serve handles POST /prefix/bulk
+//
+//	{"operations": [{"op": "create", "data": {...}}, {"op": "update", "id": "...", "data": {...}},
+//	                {"op": "delete", "id": "...", "ifMatch": "\"...\""}],
+//	 "atomic": true, "cascade": false}
+//
+// Atomic requests (the default) apply every operation or none: the first failure
+// rolls everything back and is returned with its own status. With "atomic": false
+// each operation runs in its own savepoint and the response lists per-item results.
+// One audit entry is written per affected row, in the operation's savepoint.
+func (b *bulkHandler) serve(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid JSON")
+		return
+	}
+	if len(req.Operations) == 0 {
+		response.BadRequest(w, "operations are required")
+		return
+	}
+	if len(req.Operations) > MaxBulkOps {
+		response.BadRequest(w, fmt.Sprintf("at most %d operations per request", MaxBulkOps))
+		return
+	}
+	atomic := req.Atomic == nil || *req.Atomic
+
+	ctx := r.Context()
+	tx, err := b.h.pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	now := time.Now().UTC()
+	actor := audit.ActorFrom(ctx).UserID
+	out := bulkResponse{Results: make([]BulkResult, 0, len(req.Operations))}
+	for i, op := range req.Operations {
+		res := b.apply(ctx, tx, i, op, req.Cascade, actor, now)
+		out.Results = append(out.Results, res)
+		if res.Error == "" {
+			out.Succeeded++
+			continue
+		}
+		out.Failed++
+		if atomic {
+			response.JSON(w, bulkFailure{
+				Error:    fmt.Sprintf("operation %d: %s", i, res.Error),
+				Index:    i,
+				Conflict: res.conflict,
+				Issues:   res.Issues,
+				Results:  out.Results,
+			}, res.Status)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+	response.OK(w, out)
+}
+
+// apply runs one operation in a savepoint, so a failure leaves the transaction usable.
+func (b *bulkHandler) apply(ctx context.Context, tx pgx.Tx, i int, op BulkOp, cascadeDeletes bool, actor string, now time.Time) BulkResult {
+	res := BulkResult{Index: i, Op: op.Op, ID: op.ID}
+	res.reason, _ = op.Data["reason"].(string)
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return b.fail(res, err)
+	}
+	defer sp.Rollback(ctx) //nolint:errcheck
+
+	ctx = etag.WithIfMatch(ctx, op.IfMatch)
+	switch op.Op {
+	case "create":
+		err = b.create(ctx, sp, &res, op, actor)
+	case "update":
+		err = b.update(ctx, sp, &res, op, actor, now)
+	case "delete":
+		err = b.delete(ctx, sp, &res, op, cascadeDeletes, now)
+	default:
+		err = opErrorf(http.StatusBadRequest, "op must be create, update or delete")
+	}
+	if err == nil {
+		err = b.audit(ctx, sp, res)
+	}
+	if err == nil {
+		err = sp.Commit(ctx)
+	}
+	if err != nil {
+		return b.fail(res, err)
+	}
+	return res
+}
+
+func (b *bulkHandler) fail(res BulkResult, err error) BulkResult {
+	res.Data = nil
+	res.Status, res.Error, res.conflict = b.h.classify(err)
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		res.Issues = ve.Issues
+	} else if f, ok := response.TranslateDB(err, b.h.cfg.Name); ok {
+		res.Issues = f.Issues()
+	}
+	return res
+}
+
+func (b *bulkHandler) create(ctx context.Context, tx pgx.Tx, res *BulkResult, op BulkOp, actor string) error {
+	if op.ID != "" {
+		return opErrorf(http.StatusBadRequest, "create takes no id")
+	}
+	wr := &Write{Actor: actor, Data: op.Data}
+	row, err := b.h.insert(ctx, tx, wr)
+	if err != nil {
+		return err
+	}
+	res.ID, res.Status, res.Data = wr.ID, http.StatusCreated, row
+	return nil
+}
+
+func (b *bulkHandler) update(ctx context.Context, tx pgx.Tx, res *BulkResult, op BulkOp, actor string, now time.Time) error {
+	if op.ID == "" {
+		return opErrorf(http.StatusBadRequest, "id is required")
+	}
+	wr := &Write{ID: op.ID, Actor: actor, Data: op.Data}
+	row, err := b.h.modify(ctx, tx, wr, now)
+	if err != nil {
+		return err
+	}
+	res.Status, res.Data, res.before = http.StatusOK, row, wr.Before
+	return nil
+}
+
+func (b *bulkHandler) delete(ctx context.Context, tx pgx.Tx, res *BulkResult, op BulkOp, cascadeDeletes bool, now time.Time) error {
+	if op.ID == "" {
+		return opErrorf(http.StatusBadRequest, "id is required")
+	}
+	before, err := b.h.fetch(ctx, tx, op.ID, true)
+	if err != nil {
+		return err
+	}
+	if err := etag.Check(ctx, tx, b.h.cfg.Table, op.ID); err != nil {
+		return err
+	}
+	if b.h.cfg.SoftDelete {
+		res.deleted, err = cascade.SoftDelete(ctx, tx, b.h.cfg.Table, op.ID, cascadeDeletes, now)
+	} else {
+		_, err = tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s = $1", b.h.cfg.Table, b.h.cfg.IDColumn), op.ID)
+	}
+	if err != nil {
+		return err
+	}
+	res.Status, res.before = http.StatusOK, before
+	return nil
+}
+
+func (b *bulkHandler) audit(ctx context.Context, tx pgx.Tx, res BulkResult) error {
+	table := b.h.cfg.AuditTable
+	ctx = audit.WithReason(ctx, res.reason)
+	switch res.Op {
+	case "create":
+		return audit.LogEntry(ctx, tx, "create", table, res.ID, nil, res.Data)
+	case "update":
+		return audit.LogEntry(ctx, tx, "update", table, res.ID, res.before, res.Data)
+	case "delete":
+		if err := audit.LogEntry(ctx, tx, "delete", table, res.ID, res.before, nil); err != nil {
+			return err
+		}
+		return cascade.LogResult(ctx, tx, b.h.cfg.Table, res.ID, res.deleted)
+	}
+	return nil
+}
+
+// arg converts a decoded JSON value to the query argument for the column.
+// Empty strings clear nullable columns, as in the resource handlers.
+func (c Column) arg(v interface{}) (interface{}, error) {
+	if v == nil || v == "" && c.Nullable && c.Type != "json" {
+		if !c.Nullable {
+			return nil, opErrorf(http.StatusBadRequest, "%s cannot be null", c.JSON)
+		}
+		return nil, nil
+	}
+	switch c.Type {
+	case "json":
+		return v, nil
+	case "int":
+		if f, ok := v.(float64); ok && f == math.Trunc(f) {
+			return int64(f), nil
+		}
+		return nil, opErrorf(http.StatusBadRequest, "%s must be an integer", c.JSON)
+	case "float":
+		if f, ok := v.(float64); ok {
+			return f, nil
+		}
+		return nil, opErrorf(http.StatusBadRequest, "%s must be a number", c.JSON)
+	case "bool":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, opErrorf(http.StatusBadRequest, "%s must be true or false", c.JSON)
+	case "decimal":
+		if f, ok := decimalValue(v); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+		return nil, opErrorf(http.StatusBadRequest, "%s must be a number", c.JSON)
+	case "timestamp":
+		if s, ok := v.(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, opErrorf(http.StatusBadRequest, "%s must be an RFC 3339 timestamp", c.JSON)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return nil, opErrorf(http.StatusBadRequest, "%s must be a string", c.JSON)
+}
+
+func isBlank(v interface{}) bool {
+	return v == nil || v == ""
+}
@@ -1,7 +1,9 @@
 package crud
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,7 +11,11 @@ import (
 	"time"
 
 	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/cascade"
+	"github.com/dcim/go-services/internal/shared/etag"
+	"github.com/dcim/go-services/internal/shared/rbac"
 	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -17,92 +23,126 @@ import (
 type Column struct {
 	Name     string // DB column name (snake_case)
 	JSON     string // JSON field name (camelCase)
-	Type     string // "string" | "int" | "float" | "bool" | "timestamp" | "json"
+	Type     string // "string" | "int" | "float" | "decimal" | "bool" | "timestamp" | "json"
 	Nullable bool
-	ReadOnly bool // Skip in INSERT/UPDATE (e.g., id, created_at, updated_at, deleted_at)
+	ReadOnly bool        // Skip in INSERT/UPDATE (e.g., id, created_at, updated_at, deleted_at)
+	Required bool        // Must be present and non-empty on create, and non-empty when updated
+	Default  interface{} // Written on create when the field is absent
+	Enum     []string    // Allowed values, e.g. the values of a DB enum; numbers as formatted
+	Range    *Range      // Inclusive bounds of a number column
+	Format   string      // String format: "slug" | "ip" | "mac" | "email"
+	Ref      string      // Soft-deleted table whose live row the value must be the id of
+	Expr     string      // Computed read-only column, e.g. "p.name" from JoinClause; not written
 }
 
-// FilterDef maps a query parameter to a WHERE clause.
+// FilterDef whitelists a list query parameter. The bare parameter applies Op;
+// suffixed forms (see Query) are available for every filter, which can also be
+// used as a sort key by parameter or column name.
 type FilterDef struct {
 	QueryParam string // URL query parameter name
 	Column     string // DB column name
-	Op         string // "eq" | "ilike" | "in"
+	Op         string // default operator: "eq" (default) | "ilike" | "in"
+	Type       string // value type: "string" (default) | "int" | "float" | "bool" | "timestamp"
 }
 
 // Config defines the CRUD handler configuration for a resource.
 type Config struct {
 	Table      string      // e.g., "sites"
+	Name       string      // Singular display name for errors, e.g., "Site"; default Table
 	IDColumn   string      // default "id"
 	Columns    []Column    // SELECT/INSERT/UPDATE column definitions
 	Filters    []FilterDef // Query param -> WHERE clause mapping
-	JoinClause string      // Optional JOIN for GET list/detail
+	JoinClause string      // Optional JOIN for GET list/detail, read through Column.Expr
 	OrderBy    string      // Optional ORDER BY clause, e.g., "name ASC"
-	SoftDelete bool        // Use deleted_at soft delete pattern
-	AuditTable string      // Resource name for audit logging
+	PageSize   int         // Rows per list page when ?limit= is absent; 0 lists everything
+	SoftDelete bool        // Use deleted_at soft delete pattern, with cascade.HandleDelete
+	AuditTable string      // Resource name for audit logging; default Table
+	Extra      []string    // Body fields besides Columns that the hooks read, e.g. "reason"
+
+	// Check runs before a create or update is written and may rewrite w.Data.
+	Check func(ctx context.Context, tx pgx.Tx, w *Write) error
+	// After runs once a create or update is written, in the same transaction.
+	After func(ctx context.Context, tx pgx.Tx, w *Write) error
 }
 
-// RegisterRoutes registers standard CRUD routes on the given mux.
-// Routes: GET /prefix, GET /prefix/{id}, POST /prefix, PATCH /prefix/{id}, DELETE /prefix/{id}
-func RegisterRoutes(mux *http.ServeMux, prefix string, cfg Config, pool *pgxpool.Pool) {
-	if cfg.IDColumn == "" {
-		cfg.IDColumn = "id"
-	}
+// Write is one create or update as seen by the Config hooks.
+type Write struct {
+	ID     string                 // row id; empty in Check on create
+	Actor  string                 // user ID of the request's audit.Actor
+	Data   map[string]interface{} // fields sent by the client, with create defaults applied
+	Before map[string]interface{} // current row on update, nil on create
+	After  map[string]interface{} // written row, set for After
+}
+
+// OpError rejects a write with a client error. Any other error from a hook
+// fails the request with 500.
+type OpError struct {
+	Status   int
+	Msg      string
+	Conflict interface{}
+}
 
-	h := &handler{cfg: cfg, pool: pool}
+func (e *OpError) Error() string { return e.Msg }
 
-	mux.HandleFunc("GET "+prefix, h.list)
-	mux.HandleFunc("GET "+prefix+"/{id}", h.get)
-	mux.HandleFunc("POST "+prefix, h.create)
-	mux.HandleFunc("PATCH "+prefix+"/{id}", h.update)
-	mux.HandleFunc("DELETE "+prefix+"/{id}", h.del)
+func opErrorf(status int, format string, args ...interface{}) *OpError {
+	return &OpError{Status: status, Msg: fmt.Sprintf(format, args...)}
 }
 
-type handler struct {
+// RegisterRoutes registers standard CRUD routes on the given mux, each wrapped with wrap.
+// Routes: GET /prefix, GET /prefix/{id}, POST /prefix, PATCH /prefix/{id}, DELETE /prefix/{id},
+// POST /prefix/bulk
+func RegisterRoutes(mux *http.ServeMux, wrap func(http.Handler) http.Handler, prefix string, cfg Config, pool *pgxpool.Pool) {
+	h := NewResource(cfg, pool)
+
+	mux.Handle("GET "+prefix, wrap(http.HandlerFunc(h.List)))
+	mux.Handle("GET "+prefix+"/{id}", wrap(http.HandlerFunc(h.Get)))
+	mux.Handle("POST "+prefix, wrap(http.HandlerFunc(h.Create)))
+	mux.Handle("PATCH "+prefix+"/{id}", wrap(http.HandlerFunc(h.Update)))
+	mux.Handle("DELETE "+prefix+"/{id}", wrap(http.HandlerFunc(h.Delete)))
+	RegisterBulk(mux, wrap, pool, []BulkResource{{Path: prefix, Config: cfg}})
+}
+
+// Resource serves one Config. Its methods are plain handler funcs so routes can
+// be wrapped like the hand-written handlers.
+type Resource struct {
 	cfg  Config
 	pool *pgxpool.Pool
 }
 
-// list handles GET /resource — list all records with optional filtering.
-func (h *handler) list(w http.ResponseWriter, r *http.Request) {
-	cols := h.selectColumns()
-	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(cols, ", "), h.cfg.Table)
-
-	if h.cfg.JoinClause != "" {
-		query += " " + h.cfg.JoinClause
+// NewResource returns the handlers for cfg.
+func NewResource(cfg Config, pool *pgxpool.Pool) *Resource {
+	if cfg.IDColumn == "" {
+		cfg.IDColumn = "id"
 	}
-
-	conditions := []string{}
-	args := []interface{}{}
-	argIdx := 1
-
-	if h.cfg.SoftDelete {
-		conditions = append(conditions, fmt.Sprintf("%s.deleted_at IS NULL", h.cfg.Table))
+	if cfg.Name == "" {
+		cfg.Name = cfg.Table
+	}
+	if cfg.AuditTable == "" {
+		cfg.AuditTable = cfg.Table
 	}
+	return &Resource{cfg: cfg, pool: pool}
+}
 
-	for _, f := range h.cfg.Filters {
-		val := r.URL.Query().Get(f.QueryParam)
-		if val == "" {
-			continue
-		}
-		switch f.Op {
-		case "eq":
-			conditions = append(conditions, fmt.Sprintf("%s = $%d", f.Column, argIdx))
-			args = append(args, val)
-			argIdx++
-		case "ilike":
-			conditions = append(conditions, fmt.Sprintf("%s ILIKE $%d", f.Column, argIdx))
-			args = append(args, "%"+val+"%")
-			argIdx++
-		}
+// List handles GET /resource — list all records with optional filtering.
+func (h *Resource) List(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseQuery(r, h.cfg, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
 	}
 
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	from := h.from()
+	where := q.Where
+	if h.cfg.SoftDelete {
+		where = fmt.Sprintf(" AND %s.deleted_at IS NULL", h.cfg.Table) + where
 	}
 
-	if h.cfg.OrderBy != "" {
-		query += " ORDER BY " + h.cfg.OrderBy
+	if !Total(w, r, h.pool, q, from+WhereClause(where), q.Args) {
+		return
 	}
+	seek, args := q.Seek(q.Args)
+	query := "SELECT " + strings.Join(h.selectColumns(), ", ") + from + WhereClause(where+seek) +
+		" ORDER BY " + q.OrderBy + q.LimitClause()
 
 	rows, err := h.pool.Query(r.Context(), query, args...)
 	if err != nil {
@@ -122,124 +162,67 @@ func (h *handler) list(w http.ResponseWriter, r *http.Request) {
 		results = append(results, row)
 	}
 
-	response.OK(w, results)
+	List(w, r, h.pool, q, results)
 }
 
-// get handles GET /resource/{id} — get single record by ID.
-func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+// Get handles GET /resource/{id} — get single record by ID.
+func (h *Resource) Get(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
 		response.BadRequest(w, "id is required")
 		return
 	}
 
-	cols := h.selectColumns()
-	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(cols, ", "), h.cfg.Table)
-
-	if h.cfg.JoinClause != "" {
-		query += " " + h.cfg.JoinClause
-	}
-
-	conditions := []string{fmt.Sprintf("%s.%s = $1", h.cfg.Table, h.cfg.IDColumn)}
-	if h.cfg.SoftDelete {
-		conditions = append(conditions, fmt.Sprintf("%s.deleted_at IS NULL", h.cfg.Table))
+	row, err := h.fetch(r.Context(), h.pool, id, false)
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, h.cfg.Name)
+		return
 	}
-	query += " WHERE " + strings.Join(conditions, " AND ")
-
-	rows, err := h.pool.Query(r.Context(), query, id)
 	if err != nil {
 		log.Printf("crud get error [%s]: %v", h.cfg.Table, err)
 		response.InternalError(w, "database error")
 		return
 	}
-	defer rows.Close()
-
-	if !rows.Next() {
-		response.NotFound(w, h.cfg.Table)
-		return
-	}
-
-	row, err := h.scanRow(rows)
-	if err != nil {
-		log.Printf("crud scan error [%s]: %v", h.cfg.Table, err)
-		response.InternalError(w, "scan error")
-		return
-	}
 
 	response.OK(w, row)
 }
 
-// create handles POST /resource — create a new record.
-func (h *handler) create(w http.ResponseWriter, r *http.Request) {
+// Create handles POST /resource — create a new record.
+func (h *Resource) Create(w http.ResponseWriter, r *http.Request) {
 	var body map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		response.BadRequest(w, "invalid JSON")
 		return
 	}
 
-	writeCols := h.writeColumns()
-	colNames := []string{}
-	placeholders := []string{}
-	args := []interface{}{}
-	argIdx := 1
-
-	for _, col := range writeCols {
-		val, exists := body[col.JSON]
-		if !exists {
-			continue
-		}
-		colNames = append(colNames, col.Name)
-		placeholders = append(placeholders, fmt.Sprintf("$%d", argIdx))
-		args = append(args, val)
-		argIdx++
-	}
-
-	if len(colNames) == 0 {
-		response.BadRequest(w, "no valid fields provided")
-		return
-	}
-
-	returnCols := h.selectColumnNames()
-	query := fmt.Sprintf(
-		"INSERT INTO %s (%s) VALUES (%s) RETURNING %s",
-		h.cfg.Table,
-		strings.Join(colNames, ", "),
-		strings.Join(placeholders, ", "),
-		strings.Join(returnCols, ", "),
-	)
-
-	rows, err := h.pool.Query(r.Context(), query, args...)
+	ctx := r.Context()
+	tx, err := h.pool.Begin(ctx)
 	if err != nil {
-		log.Printf("crud create error [%s]: %v", h.cfg.Table, err)
-		response.InternalError(w, "create failed")
+		response.InternalError(w, "database error")
 		return
 	}
-	defer rows.Close()
+	defer tx.Rollback(ctx) //nolint:errcheck
 
-	if !rows.Next() {
-		response.InternalError(w, "create returned no rows")
-		return
+	ctx = withBodyReason(ctx, body)
+	wr := &Write{Actor: audit.ActorFrom(ctx).UserID, Data: body}
+	row, err := h.insert(ctx, tx, wr)
+	if err == nil {
+		err = audit.LogEntry(ctx, tx, "create", h.cfg.AuditTable, wr.ID, nil, row)
 	}
-
-	row, err := h.scanRow(rows)
 	if err != nil {
-		log.Printf("crud scan error [%s]: %v", h.cfg.Table, err)
-		response.InternalError(w, "scan error")
+		h.writeError(w, err)
 		return
 	}
-
-	// Audit log (best-effort)
-	if h.cfg.AuditTable != "" {
-		if id, ok := row[h.idJSONName()]; ok {
-			_ = audit.LogEntry(r.Context(), h.pool, "", "create", h.cfg.AuditTable, fmt.Sprint(id), nil, row)
-		}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
 	}
 
 	response.Created(w, row)
 }
 
-// update handles PATCH /resource/{id} — update an existing record.
-func (h *handler) update(w http.ResponseWriter, r *http.Request) {
+// Update handles PATCH /resource/{id} — update an existing record.
+func (h *Resource) Update(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
 		response.BadRequest(w, "id is required")
@@ -252,158 +235,316 @@ func (h *handler) update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch existing record for audit
-	var existing map[string]interface{}
-	if h.cfg.AuditTable != "" {
-		existing = h.fetchByID(r, id)
+	ctx := r.Context()
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
 	}
+	defer tx.Rollback(ctx) //nolint:errcheck
 
-	if existing == nil && h.cfg.AuditTable == "" {
-		// Check existence without audit
-		exists := h.fetchByID(r, id)
-		if exists == nil {
-			response.NotFound(w, h.cfg.Table)
-			return
-		}
-	} else if existing == nil {
-		response.NotFound(w, h.cfg.Table)
+	ctx = withBodyReason(ctx, body)
+	wr := &Write{ID: id, Actor: audit.ActorFrom(ctx).UserID, Data: body}
+	row, err := h.modify(ctx, tx, wr, time.Now().UTC())
+	if err == nil {
+		err = audit.LogEntry(ctx, tx, "update", h.cfg.AuditTable, id, wr.Before, row)
+	}
+	if err != nil {
+		h.writeError(w, err)
 		return
 	}
-
-	writeCols := h.writeColumns()
-	setClauses := []string{}
-	args := []interface{}{}
-	argIdx := 1
-
-	for _, col := range writeCols {
-		val, exists := body[col.JSON]
-		if !exists {
-			continue
-		}
-		setClauses = append(setClauses, fmt.Sprintf("%s = $%d", col.Name, argIdx))
-		args = append(args, val)
-		argIdx++
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
 	}
 
-	// Always update updated_at
-	setClauses = append(setClauses, fmt.Sprintf("updated_at = $%d", argIdx))
-	args = append(args, time.Now().UTC())
-	argIdx++
+	response.OK(w, row)
+}
 
-	if len(setClauses) <= 1 {
-		response.BadRequest(w, "no valid fields to update")
+// Delete handles DELETE /resource/{id}. Soft-deleted resources go through
+// cascade.HandleDelete, so dependents are checked and ?cascade=true applies.
+func (h *Resource) Delete(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.SoftDelete {
+		cascade.HandleDelete(w, r, h.pool, h.cfg.Table, h.cfg.Name)
 		return
 	}
 
-	args = append(args, id)
-	returnCols := h.selectColumnNames()
-	query := fmt.Sprintf(
-		"UPDATE %s SET %s WHERE %s = $%d RETURNING %s",
-		h.cfg.Table,
-		strings.Join(setClauses, ", "),
-		h.cfg.IDColumn,
-		argIdx,
-		strings.Join(returnCols, ", "),
-	)
+	id := r.PathValue("id")
+	if id == "" {
+		response.BadRequest(w, "id is required")
+		return
+	}
 
-	rows, err := h.pool.Query(r.Context(), query, args...)
+	ctx := r.Context()
+	tx, err := h.pool.Begin(ctx)
 	if err != nil {
-		log.Printf("crud update error [%s]: %v", h.cfg.Table, err)
-		response.InternalError(w, "update failed")
+		response.InternalError(w, "database error")
 		return
 	}
-	defer rows.Close()
+	defer tx.Rollback(ctx) //nolint:errcheck
 
-	if !rows.Next() {
-		response.NotFound(w, h.cfg.Table)
+	// Fetch existing record for audit
+	existing, err := h.fetch(ctx, tx, id, true)
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, h.cfg.Name)
 		return
 	}
-
-	row, err := h.scanRow(rows)
+	if err == nil {
+		err = etag.Check(ctx, tx, h.cfg.Table, id)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx,
+			fmt.Sprintf("DELETE FROM %s WHERE %s = $1", h.cfg.Table, h.cfg.IDColumn),
+			id,
+		)
+	}
+	if err == nil {
+		err = audit.LogEntry(ctx, tx, "delete", h.cfg.AuditTable, id, existing, nil)
+	}
 	if err != nil {
-		log.Printf("crud scan error [%s]: %v", h.cfg.Table, err)
-		response.InternalError(w, "scan error")
+		h.writeError(w, err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
 		return
 	}
 
-	if h.cfg.AuditTable != "" {
-		_ = audit.LogEntry(r.Context(), h.pool, "", "update", h.cfg.AuditTable, id, existing, row)
+	response.Message(w, h.cfg.Name+" deleted", http.StatusOK)
+}
+
+// Insert creates a row from data in tx with the validation and hooks of Create,
+// for handlers that create rows from other input, such as a CSV import. It
+// returns the id and the row as Get serves it; the caller audits and commits.
+func (h *Resource) Insert(ctx context.Context, tx pgx.Tx, data map[string]interface{}) (string, map[string]interface{}, error) {
+	w := &Write{Actor: audit.ActorFrom(ctx).UserID, Data: data}
+	row, err := h.insert(ctx, tx, w)
+	return w.ID, row, err
+}
+
+// Explain describes a write error as one line, listing validation issues with
+// their fields, for reports of many writes.
+func (h *Resource) Explain(err error) string {
+	_, msg, _ := h.classify(err)
+	var issues []map[string]string
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		issues = ve.Issues
+	} else if f, ok := response.TranslateDB(err, h.cfg.Name); ok {
+		issues = f.Issues()
+	}
+	for i, is := range issues {
+		sep := "; "
+		if i == 0 {
+			sep = ": "
+		}
+		msg += sep + is["path"] + " " + is["message"]
+	}
+	return msg
+}
+
+// --- Writes shared by the single-row and bulk handlers ---
+
+// insert creates a row from w.Data in tx and returns it as Get serves it.
+func (h *Resource) insert(ctx context.Context, tx pgx.Tx, w *Write) (map[string]interface{}, error) {
+	if w.Data == nil {
+		w.Data = map[string]interface{}{}
+	}
+	for _, c := range h.writeColumns() {
+		if _, ok := w.Data[c.JSON]; !ok && c.Default != nil {
+			w.Data[c.JSON] = c.Default
+		}
+	}
+	if err := h.validate(ctx, tx, w.Data, true); err != nil {
+		return nil, err
+	}
+	if h.cfg.Check != nil {
+		if err := h.cfg.Check(ctx, tx, w); err != nil {
+			return nil, err
+		}
 	}
 
-	response.OK(w, row)
+	cols := []string{h.cfg.IDColumn}
+	vals := []string{"gen_random_uuid()"}
+	args := []interface{}{}
+	for _, c := range h.writeColumns() {
+		v, ok := w.Data[c.JSON]
+		if !ok {
+			continue
+		}
+		arg, err := c.arg(v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		cols = append(cols, c.Name)
+		vals = append(vals, fmt.Sprintf("$%d", len(args)))
+	}
+	if err := tx.QueryRow(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING %s",
+		h.cfg.Table, strings.Join(cols, ", "), strings.Join(vals, ", "), h.cfg.IDColumn), args...).Scan(&w.ID); err != nil {
+		return nil, err
+	}
+	return h.written(ctx, tx, w)
 }
 
-// del handles DELETE /resource/{id} — soft delete or hard delete.
-func (h *handler) del(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	if id == "" {
-		response.BadRequest(w, "id is required")
-		return
+// modify applies w.Data to row w.ID in tx, setting w.Before to the locked row,
+// and returns the row as Get serves it.
+func (h *Resource) modify(ctx context.Context, tx pgx.Tx, w *Write, now time.Time) (map[string]interface{}, error) {
+	before, err := h.fetch(ctx, tx, w.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := etag.Check(ctx, tx, h.cfg.Table, w.ID); err != nil {
+		return nil, err
+	}
+	w.Before = before
+	if w.Data == nil {
+		w.Data = map[string]interface{}{}
+	}
+	if err := h.validate(ctx, tx, w.Data, false); err != nil {
+		return nil, err
+	}
+	if h.cfg.Check != nil {
+		if err := h.cfg.Check(ctx, tx, w); err != nil {
+			return nil, err
+		}
 	}
 
-	// Fetch existing record for audit
-	existing := h.fetchByID(r, id)
-	if existing == nil {
-		response.NotFound(w, h.cfg.Table)
-		return
+	sets := []string{}
+	args := []interface{}{}
+	for _, c := range h.writeColumns() {
+		v, ok := w.Data[c.JSON]
+		if !ok {
+			continue
+		}
+		arg, err := c.arg(v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		sets = append(sets, fmt.Sprintf("%s = $%d", c.Name, len(args)))
+	}
+	if len(sets) == 0 {
+		return nil, opErrorf(http.StatusBadRequest, "no valid fields to update")
+	}
+	// Always update updated_at
+	args = append(args, now, w.ID)
+	sets = append(sets, fmt.Sprintf("updated_at = $%d", len(args)-1))
+	if _, err := tx.Exec(ctx, fmt.Sprintf("UPDATE %s SET %s WHERE %s = $%d",
+		h.cfg.Table, strings.Join(sets, ", "), h.cfg.IDColumn, len(args)), args...); err != nil {
+		return nil, err
 	}
+	return h.written(ctx, tx, w)
+}
 
-	var err error
-	if h.cfg.SoftDelete {
-		_, err = h.pool.Exec(r.Context(),
-			fmt.Sprintf("UPDATE %s SET deleted_at = $1 WHERE %s = $2", h.cfg.Table, h.cfg.IDColumn),
-			time.Now().UTC(), id,
-		)
-	} else {
-		_, err = h.pool.Exec(r.Context(),
-			fmt.Sprintf("DELETE FROM %s WHERE %s = $1", h.cfg.Table, h.cfg.IDColumn),
-			id,
-		)
+// validate checks data against the column rules, before the Check hook runs.
+func (h *Resource) validate(ctx context.Context, tx pgx.Tx, data map[string]interface{}, create bool) error {
+	issues, err := Validate(ctx, tx, h.cfg, data, create)
+	if err != nil {
+		return err
 	}
+	if issues != nil {
+		return &ValidationError{Issues: issues}
+	}
+	return nil
+}
 
+// written reads back a created or updated row and runs the After hook.
+func (h *Resource) written(ctx context.Context, tx pgx.Tx, w *Write) (map[string]interface{}, error) {
+	row, err := h.fetch(ctx, tx, w.ID, false)
 	if err != nil {
-		log.Printf("crud delete error [%s]: %v", h.cfg.Table, err)
-		response.InternalError(w, "delete failed")
-		return
+		return nil, err
+	}
+	w.After = row
+	if h.cfg.After != nil {
+		if err := h.cfg.After(ctx, tx, w); err != nil {
+			return nil, err
+		}
 	}
+	return row, nil
+}
+
+// classify maps a write error to a response status and message. Hooks report
+// client errors as *OpError; validation issues and constraint violations are
+// the client's too.
+func (h *Resource) classify(err error) (int, string, interface{}) {
+	var oe *OpError
+	var ve *ValidationError
+	var be *cascade.BlockedError
+	switch {
+	case errors.As(err, &ve):
+		return http.StatusUnprocessableEntity, "Validation failed", nil
+	case errors.As(err, &oe):
+		return oe.Status, oe.Msg, oe.Conflict
+	case errors.As(err, &be):
+		return http.StatusConflict, fmt.Sprintf("%s is %s; delete them first or pass \"cascade\": true", h.cfg.Name, be.Error()), be.Blockers
+	case errors.Is(err, etag.ErrModified):
+		return http.StatusPreconditionFailed, err.Error(), nil
+	}
+	if f, ok := response.TranslateDB(err, h.cfg.Name); ok {
+		return f.Status, f.Message, nil
+	}
+	log.Printf("crud write error [%s]: %v", h.cfg.Table, err)
+	return http.StatusInternalServerError, "operation failed", nil
+}
 
-	if h.cfg.AuditTable != "" {
-		_ = audit.LogEntry(r.Context(), h.pool, "", "delete", h.cfg.AuditTable, id, existing, nil)
+func (h *Resource) writeError(w http.ResponseWriter, err error) {
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		response.ValidationError(w, "Validation failed", ve.Issues)
+		return
+	}
+	if _, ok := response.TranslateDB(err, h.cfg.Name); ok {
+		response.DBError(w, err, h.cfg.Name)
+		return
 	}
+	status, msg, conflict := h.classify(err)
+	if status == http.StatusConflict {
+		response.Conflict(w, msg, conflict)
+		return
+	}
+	response.Error(w, msg, status)
+}
 
-	response.Message(w, h.cfg.Table+" deleted", http.StatusOK)
+// withBodyReason attaches the optional "reason" field of a write body to the
+// audit actor of ctx.
+func withBodyReason(ctx context.Context, body map[string]interface{}) context.Context {
+	reason, _ := body["reason"].(string)
+	return audit.WithReason(ctx, reason)
 }
 
 // --- Helper methods ---
 
-// selectColumns returns column expressions for SELECT (table-qualified for the main table).
-func (h *handler) selectColumns() []string {
-	cols := []string{}
-	for _, c := range h.cfg.Columns {
-		cols = append(cols, c.Name)
+// from returns the FROM clause of reads, with the optional join.
+func (h *Resource) from() string {
+	from := " FROM " + h.cfg.Table
+	if h.cfg.JoinClause != "" {
+		from += " " + h.cfg.JoinClause
 	}
-	return cols
+	return from
 }
 
-// selectColumnNames returns unqualified column names for RETURNING.
-func (h *handler) selectColumnNames() []string {
+// selectColumns returns column expressions for SELECT (table-qualified for the main table).
+func (h *Resource) selectColumns() []string {
 	cols := []string{}
 	for _, c := range h.cfg.Columns {
-		// Strip table prefix for RETURNING clause
-		name := c.Name
-		if dot := strings.LastIndex(name, "."); dot >= 0 {
-			name = name[dot+1:]
+		switch {
+		case c.Expr != "":
+			cols = append(cols, c.Expr)
+		case strings.Contains(c.Name, "."):
+			cols = append(cols, c.Name)
+		default:
+			cols = append(cols, h.cfg.Table+"."+c.Name)
 		}
-		cols = append(cols, name)
 	}
 	return cols
 }
 
-// writeColumns returns columns that are writable (not ReadOnly).
-func (h *handler) writeColumns() []Column {
+// writeColumns returns columns that are writable (not ReadOnly or computed).
+func (h *Resource) writeColumns() []Column {
 	cols := []Column{}
 	for _, c := range h.cfg.Columns {
-		if c.ReadOnly {
+		if c.ReadOnly || c.Expr != "" {
 			continue
 		}
 		cols = append(cols, c)
@@ -412,7 +553,7 @@ func (h *handler) writeColumns() []Column {
 }
 
 // idJSONName returns the JSON name for the ID column.
-func (h *handler) idJSONName() string {
+func (h *Resource) idJSONName() string {
 	for _, c := range h.cfg.Columns {
 		name := c.Name
 		if dot := strings.LastIndex(name, "."); dot >= 0 {
@@ -426,13 +567,13 @@ func (h *handler) idJSONName() string {
 }
 
 // scanRow scans a row into a map[string]interface{} based on column definitions.
-func (h *handler) scanRow(rows interface{ Scan(dest ...interface{}) error }) (map[string]interface{}, error) {
+func (h *Resource) scanRow(rows interface{ Scan(dest ...interface{}) error }) (map[string]interface{}, error) {
 	vals := make([]interface{}, len(h.cfg.Columns))
 	ptrs := make([]interface{}, len(h.cfg.Columns))
 
 	for i, col := range h.cfg.Columns {
 		switch col.Type {
-		case "string":
+		case "string", "decimal":
 			if col.Nullable {
 				var v *string
 				ptrs[i] = &v
@@ -486,7 +627,7 @@ func (h *handler) scanRow(rows interface{ Scan(dest ...interface{}) error }) (ma
 	for i, col := range h.cfg.Columns {
 		jsonKey := col.JSON
 		switch col.Type {
-		case "string":
+		case "string", "decimal":
 			if col.Nullable {
 				v := ptrs[i].(**string)
 				if *v != nil {
@@ -552,30 +693,50 @@ func (h *handler) scanRow(rows interface{ Scan(dest ...interface{}) error }) (ma
 	return result, nil
 }
 
-// fetchByID fetches a single record by ID for audit comparison.
-func (h *handler) fetchByID(r *http.Request, id string) map[string]interface{} {
-	cols := h.selectColumnNames()
-	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1",
-		strings.Join(cols, ", "), h.cfg.Table, h.cfg.IDColumn)
+// rowQuerier is satisfied by both *pgxpool.Pool and pgx.Tx.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
 
+// fetch reads a live row as Get serves it, or returns pgx.ErrNoRows. lock takes
+// a row lock on the main table for the rest of the transaction.
+func (h *Resource) fetch(ctx context.Context, q rowQuerier, id string, lock bool) (map[string]interface{}, error) {
+	query := fmt.Sprintf("SELECT %s%s WHERE %s.%s = $1",
+		strings.Join(h.selectColumns(), ", "), h.from(), h.cfg.Table, h.cfg.IDColumn)
 	if h.cfg.SoftDelete {
-		query += " AND deleted_at IS NULL"
+		query += fmt.Sprintf(" AND %s.deleted_at IS NULL", h.cfg.Table)
 	}
-
-	rows, err := h.pool.Query(r.Context(), query, id)
-	if err != nil {
-		return nil
+	if lock {
+		query += " FOR UPDATE OF " + h.cfg.Table
 	}
-	defer rows.Close()
+	return h.scanRow(q.QueryRow(ctx, query, id))
+}
 
-	if !rows.Next() {
-		return nil
+// Rows reads the live rows with the given ids as Get serves them, keyed by id.
+// Rows the user of ctx may not list (see rbac.Filter) are left out.
+func (h *Resource) Rows(ctx context.Context, ids []string) (map[string]map[string]interface{}, error) {
+	out := map[string]map[string]interface{}{}
+	if len(ids) == 0 {
+		return out, nil
 	}
-
-	// Simplified scan for audit (uses unqualified columns)
-	row, err := h.scanRow(rows)
+	query := fmt.Sprintf("SELECT %s%s WHERE %s.%s = ANY($1)",
+		strings.Join(h.selectColumns(), ", "), h.from(), h.cfg.Table, h.cfg.IDColumn)
+	if h.cfg.SoftDelete {
+		query += fmt.Sprintf(" AND %s.deleted_at IS NULL", h.cfg.Table)
+	}
+	cond, args := rbac.Filter(ctx, h.cfg.Table, h.cfg.Table, 2)
+	rows, err := h.pool.Query(ctx, query+cond, append([]interface{}{ids}, args...)...)
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	defer rows.Close()
+	idField := h.idJSONName()
+	for rows.Next() {
+		row, err := h.scanRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[fmt.Sprint(row[idField])] = row
 	}
-	return row
+	return out, rows.Err()
 }
@@ -0,0 +1,234 @@
+package crud
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// cursor is the opaque position handed out in next/prev links. It holds the sort
+// values of the row a page ends at, as PostgreSQL renders them in text, so inserts,
+// deletes and edits elsewhere, or of that row itself, do not shift later pages.
+type cursor struct {
+	Keys []*string `json:"keys"`           // one per sort term, nil for NULL; the id last
+	Prev bool      `json:"prev,omitempty"` // rows before the anchor, rather than after
+	Sort string    `json:"sort"`           // the ordering the cursor was issued for
+}
+
+func (c cursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Keys) == 0 || c.Keys[len(c.Keys)-1] == nil {
+		return nil, fmt.Errorf("cursor without id")
+	}
+	return &c, nil
+}
+
+// sortKey identifies the requested ordering independent of the page direction.
+func (q Query) sortKey() string {
+	parts := make([]string, len(q.terms))
+	for i, t := range q.terms {
+		parts[i] = t.col
+		if t.desc {
+			parts[i] = "-" + t.col
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// backward reports whether the page is read towards the start (a prev cursor).
+func (q Query) backward() bool {
+	return q.cursor != nil && q.cursor.Prev
+}
+
+// orderBy renders the sort terms, reversed when reading backwards. NULLs sort
+// last ascending and first descending, as in PostgreSQL's default.
+func (q Query) orderBy() string {
+	parts := make([]string, len(q.terms))
+	for i, t := range q.terms {
+		if t.desc != q.backward() {
+			parts[i] = t.col + " DESC"
+		} else {
+			parts[i] = t.col + " ASC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Seek returns the keyset condition for the cursor as " AND ..." and args with the
+// cursor's sort values appended as the next placeholders. Without a cursor it
+// returns "" and args unchanged. args must hold every argument of the query so far.
+func (q Query) Seek(args []interface{}) (string, []interface{}) {
+	if q.cursor == nil {
+		return "", args
+	}
+
+	// Rows after the anchor: equal on the leading terms and past it on the next one.
+	// The values are sent as text and take the type of the column they are compared with.
+	var or []string
+	var eq []string
+	for i, t := range q.terms {
+		towardsNull := t.desc == q.backward() // NULLs still to come on this term
+		var past, same string
+		if key := q.cursor.Keys[i]; key == nil {
+			same = t.col + " IS NULL"
+			if !towardsNull {
+				past = t.col + " IS NOT NULL"
+			}
+		} else {
+			args = append(args, *key)
+			ph := fmt.Sprintf("$%d", len(args))
+			same = fmt.Sprintf("%s = %s", t.col, ph)
+			if towardsNull {
+				past = fmt.Sprintf("(%[1]s IS NULL OR %[1]s > %[2]s)", t.col, ph)
+			} else {
+				past = fmt.Sprintf("%s < %s", t.col, ph)
+			}
+		}
+		if past != "" {
+			or = append(or, "("+strings.Join(append(slices.Clone(eq), past), " AND ")+")")
+		}
+		eq = append(eq, same)
+	}
+	return " AND (" + strings.Join(or, " OR ") + ")", args
+}
+
+// LimitClause returns " LIMIT n" fetching one row past the page, so Page can tell
+// whether another page follows, or "" when the list is not paged.
+func (q Query) LimitClause() string {
+	if q.Limit == 0 {
+		return ""
+	}
+	return " LIMIT " + strconv.Itoa(q.Limit+1)
+}
+
+// Cursors are the cursors of the pages around the current one; empty when there is none.
+type Cursors struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// Page trims rows read with q.LimitClause to the page, puts them back in the
+// requested order and sets the Link header to the next and previous pages.
+// It reports false after writing an error response.
+func Page[T any](w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, q Query, rows []T) ([]T, Cursors, bool) {
+	var c Cursors
+	if q.Limit == 0 {
+		return rows, c, true
+	}
+	more := len(rows) > q.Limit
+	if more {
+		rows = rows[:q.Limit]
+	}
+	if q.backward() {
+		slices.Reverse(rows)
+	}
+	if len(rows) == 0 {
+		return rows, c, true
+	}
+	var err error
+	if more || q.backward() {
+		c.Next, err = q.cursorAt(r.Context(), pool, rows[len(rows)-1], false)
+	}
+	if err == nil && ((more && q.backward()) || (q.cursor != nil && !q.backward())) {
+		c.Prev, err = q.cursorAt(r.Context(), pool, rows[0], true)
+	}
+	if err != nil {
+		log.Printf("crud cursor error [%s]: %v", q.table, err)
+		response.InternalError(w, "database error")
+		return nil, c, false
+	}
+
+	var links []string
+	for _, l := range []struct{ rel, cursor string }{{"next", c.Next}, {"prev", c.Prev}} {
+		if l.cursor == "" {
+			continue
+		}
+		u := *r.URL
+		values := u.Query()
+		values.Set("cursor", l.cursor)
+		values.Del("page")
+		u.RawQuery = values.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), l.rel))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	return rows, c, true
+}
+
+// cursorAt returns a cursor positioned at row, which must have a JSON "id". The
+// sort values are read back from the table, since rows render some of them
+// (timestamps, joined names) differently or not at all.
+func (q Query) cursorAt(ctx context.Context, pool *pgxpool.Pool, row interface{}, prev bool) (string, error) {
+	var v struct {
+		ID interface{} `json:"id"`
+	}
+	raw, err := json.Marshal(row)
+	if err == nil {
+		err = json.Unmarshal(raw, &v)
+	}
+	if err != nil || v.ID == nil {
+		return "", fmt.Errorf("row has no id")
+	}
+	from := q.table
+	if q.ref != q.table {
+		from += " " + q.ref
+	}
+	cols := make([]string, len(q.terms))
+	for i, t := range q.terms {
+		cols[i] = t.col + "::text"
+	}
+	c := cursor{Prev: prev, Sort: q.sortKey()}
+	err = pool.QueryRow(ctx, fmt.Sprintf("SELECT ARRAY[%s] FROM %s WHERE %s.id = $1", strings.Join(cols, ", "), from, q.ref),
+		fmt.Sprint(v.ID)).Scan(&c.Keys)
+	if err != nil {
+		return "", err
+	}
+	return c.encode(), nil
+}
+
+// List writes rows as the current page of q, reduced to the requested fields.
+func List[T any](w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, q Query, rows []T) {
+	rows, _, ok := Page(w, r, pool, q, rows)
+	if ok {
+		OK(w, q, rows)
+	}
+}
+
+// Total sets X-Total-Count to the number of rows matched by from (a FROM ... WHERE
+// tail without the cursor condition) when the client asked for count=true.
+// It reports false after writing an error response.
+func Total(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, q Query, from string, args []interface{}) bool {
+	if !q.Count {
+		return true
+	}
+	var n int
+	if err := pool.QueryRow(r.Context(), "SELECT COUNT(*)"+from, args...).Scan(&n); err != nil {
+		log.Printf("crud count error [%s]: %v", q.table, err)
+		response.InternalError(w, "database error")
+		return false
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(n))
+	return true
+}
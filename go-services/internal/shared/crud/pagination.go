@@ -5,6 +5,12 @@ import (
 	"strconv"
 )
 
+// Page size bounds shared by offset and cursor pagination.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
 // Pagination holds parsed pagination parameters from query string.
 type Pagination struct {
 	Limit  int
@@ -14,13 +20,13 @@ type Pagination struct {
 // ParsePagination extracts ?limit= and ?page= query params from a request.
 // Defaults: limit=50, page=1. Maximum allowed limit: 500.
 func ParsePagination(r *http.Request) Pagination {
-	limit := 50
+	limit := DefaultLimit
 	page := 1
 	if l := r.URL.Query().Get("limit"); l != "" {
 		if v, err := strconv.Atoi(l); err == nil && v > 0 {
 			limit = v
-			if limit > 500 {
-				limit = 500
+			if limit > MaxLimit {
+				limit = MaxLimit
 			}
 		}
 	}
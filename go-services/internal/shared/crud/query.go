@@ -0,0 +1,355 @@
+package crud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/rbac"
+	"github.com/dcim/go-services/internal/shared/response"
+)
+
+// List query parameters not treated as filters. cf_ parameters belong to custom fields.
+var reservedParams = map[string]bool{"sort": true, "fields": true, "limit": true, "page": true, "cursor": true, "count": true}
+
+// Query is a parsed list query: filters from cfg.Filters, sort=-col,col and fields=a,b.
+//
+// A filter parameter is either bare (field=v, using the FilterDef's Op) or carries an
+// operator suffix:
+//
+//	field__in=a,b      field__not_in=a,b
+//	field__gte=v       field__gt=v   field__lte=v   field__lt=v
+//	field__contains=v  field__not_contains=v
+//	field__isnull=true|false
+//	field__not=v
+//
+// limit=, cursor= and count=true select a page; see Seek and Page.
+type Query struct {
+	Where   string // " AND ..." conditions to append to a WHERE clause; empty when none
+	Args    []interface{}
+	Next    int      // next free placeholder index
+	OrderBy string   // ORDER BY expression from sort=, or cfg.OrderBy, ending in the id tiebreak
+	Fields  []string // JSON fields requested with fields=; nil for all
+	Limit   int      // page size; 0 lists every row
+	Count   bool     // count=true: report the total in X-Total-Count
+
+	table  string
+	ref    string // table name or alias qualifying the id column
+	terms  []sortTerm
+	cursor *cursor
+}
+
+type sortTerm struct {
+	col  string
+	desc bool
+}
+
+// QueryError is an invalid list query; handlers answer it with 400.
+type QueryError struct{ msg string }
+
+func (e *QueryError) Error() string { return e.msg }
+
+func queryErrorf(format string, args ...interface{}) error {
+	return &QueryError{msg: fmt.Sprintf(format, args...)}
+}
+
+// ParseQuery parses the list query of r against the filters whitelisted in cfg.
+// Placeholders start at ai. Parameters without an operator suffix that match no
+// filter are left to the handler (e.g. cf_ custom field filters). Where also
+// holds the rbac.Filter of the request's user.
+func ParseQuery(r *http.Request, cfg Config, ai int) (Query, error) {
+	q := Query{Args: []interface{}{}, Next: ai, table: cfg.Table, ref: tableRef(cfg)}
+	values := r.URL.Query()
+	byParam := map[string]FilterDef{}
+	for _, f := range cfg.Filters {
+		byParam[f.QueryParam] = f
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if reservedParams[key] || strings.HasPrefix(key, "cf_") {
+			continue
+		}
+		param, op, hasOp := strings.Cut(key, "__")
+		f, ok := byParam[param]
+		if !ok {
+			if hasOp {
+				return q, queryErrorf("unknown filter %s", param)
+			}
+			continue
+		}
+		if !hasOp {
+			op = f.Op
+		}
+		for _, raw := range values[key] {
+			if err := q.addFilter(f, op, raw); err != nil {
+				return q, err
+			}
+		}
+	}
+
+	// Users with scoped roles only list the rows of their sites and tenants.
+	if cond, args := rbac.Filter(r.Context(), cfg.Table, q.ref, q.Next); cond != "" {
+		q.Where += cond
+		q.Args = append(q.Args, args...)
+		q.Next += len(args)
+	}
+
+	if v := values.Get("sort"); v != "" {
+		for _, key := range strings.Split(v, ",") {
+			key = strings.TrimSpace(key)
+			desc := strings.HasPrefix(key, "-")
+			key = strings.TrimPrefix(key, "-")
+			f, ok := sortable(cfg, key)
+			if !ok {
+				return q, queryErrorf("cannot sort by %s", key)
+			}
+			q.terms = append(q.terms, sortTerm{col: f.Column, desc: desc})
+		}
+	} else {
+		q.terms = parseOrderBy(cfg.OrderBy)
+	}
+	if id := q.ref + ".id"; len(q.terms) == 0 || (q.terms[len(q.terms)-1].col != id && q.terms[len(q.terms)-1].col != "id") {
+		q.terms = append(q.terms, sortTerm{col: id})
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return q, queryErrorf("limit must be a positive integer")
+		}
+		q.Limit = min(n, MaxLimit)
+	} else if cfg.PageSize > 0 {
+		q.Limit = cfg.PageSize
+	}
+	if v := values.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil || c.Sort != q.sortKey() || len(c.Keys) != len(q.terms) {
+			return q, queryErrorf("invalid cursor")
+		}
+		q.cursor = c
+		if q.Limit == 0 {
+			q.Limit = DefaultLimit
+		}
+	}
+	q.Count = values.Get("count") == "true"
+	q.OrderBy = q.orderBy()
+
+	if v := values.Get("fields"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				q.Fields = append(q.Fields, name)
+			}
+		}
+	}
+	return q, nil
+}
+
+// WhereClause turns " AND ..." conditions into a WHERE clause for tables without
+// other conditions, or "" when there are none.
+func WhereClause(conds string) string {
+	if conds == "" {
+		return ""
+	}
+	return " WHERE " + conds[5:] // trim leading " AND "
+}
+
+// parseOrderBy splits a Config.OrderBy such as "object_type, created_at DESC".
+func parseOrderBy(s string) []sortTerm {
+	terms := []sortTerm{}
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		terms = append(terms, sortTerm{col: fields[0], desc: len(fields) > 1 && strings.EqualFold(fields[1], "DESC")})
+	}
+	return terms
+}
+
+// tableRef returns the alias the filter columns qualify the table with, or the table name.
+func tableRef(cfg Config) string {
+	for _, f := range cfg.Filters {
+		if dot := strings.Index(f.Column, "."); dot >= 0 {
+			return f.Column[:dot]
+		}
+	}
+	return cfg.Table
+}
+
+// sortable finds the filter named key by query parameter or column name.
+func sortable(cfg Config, key string) (FilterDef, bool) {
+	for _, f := range cfg.Filters {
+		col := f.Column
+		if dot := strings.LastIndex(col, "."); dot >= 0 {
+			col = col[dot+1:]
+		}
+		if f.QueryParam == key || col == key {
+			return f, true
+		}
+	}
+	return FilterDef{}, false
+}
+
+func (q *Query) addFilter(f FilterDef, op, raw string) error {
+	col := f.Column
+	if f.Type == "" || f.Type == "string" {
+		// Compare as text so enum columns accept plain string parameters.
+		col += "::text"
+	}
+	ph := fmt.Sprintf("$%d", q.Next)
+
+	switch op {
+	case "isnull":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return queryErrorf("%s__isnull must be true or false", f.QueryParam)
+		}
+		if b {
+			q.Where += fmt.Sprintf(" AND %s IS NULL", f.Column)
+		} else {
+			q.Where += fmt.Sprintf(" AND %s IS NOT NULL", f.Column)
+		}
+		return nil
+	case "in", "not_in":
+		vals := []interface{}{}
+		for _, s := range strings.Split(raw, ",") {
+			v, err := f.parse(strings.TrimSpace(s))
+			if err != nil {
+				return err
+			}
+			vals = append(vals, v)
+		}
+		if op == "in" {
+			q.Where += fmt.Sprintf(" AND %s = ANY(%s)", col, ph)
+		} else {
+			q.Where += fmt.Sprintf(" AND (%s IS NULL OR NOT %s = ANY(%s))", f.Column, col, ph)
+		}
+		q.Args = append(q.Args, vals)
+		q.Next++
+		return nil
+	case "contains", "ilike", "not_contains":
+		if op == "not_contains" {
+			q.Where += fmt.Sprintf(" AND (%s IS NULL OR %s::text NOT ILIKE %s)", f.Column, f.Column, ph)
+		} else {
+			q.Where += fmt.Sprintf(" AND %s::text ILIKE %s", f.Column, ph)
+		}
+		q.Args = append(q.Args, "%"+raw+"%")
+		q.Next++
+		return nil
+	}
+
+	var cond string
+	switch op {
+	case "", "eq":
+		cond = "%s = %s"
+	case "not":
+		cond = "%s IS DISTINCT FROM %s"
+	case "gte":
+		cond = "%s >= %s"
+	case "gt":
+		cond = "%s > %s"
+	case "lte":
+		cond = "%s <= %s"
+	case "lt":
+		cond = "%s < %s"
+	default:
+		return queryErrorf("unknown operator %s on %s", op, f.QueryParam)
+	}
+	v, err := f.parse(raw)
+	if err != nil {
+		return err
+	}
+	q.Where += " AND " + fmt.Sprintf(cond, col, ph)
+	q.Args = append(q.Args, v)
+	q.Next++
+	return nil
+}
+
+// parse converts a filter value to the Go type of the column.
+func (f FilterDef) parse(s string) (interface{}, error) {
+	switch f.Type {
+	case "int":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, queryErrorf("%s must be an integer", f.QueryParam)
+		}
+		return n, nil
+	case "float":
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, queryErrorf("%s must be a number", f.QueryParam)
+		}
+		return n, nil
+	case "bool":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, queryErrorf("%s must be true or false", f.QueryParam)
+		}
+		return b, nil
+	case "timestamp":
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, queryErrorf("%s must be an RFC 3339 timestamp or a date", f.QueryParam)
+		}
+		return t, nil
+	}
+	return s, nil
+}
+
+// OK writes v reduced to the requested fields, or 400 when a field is unknown.
+func OK(w http.ResponseWriter, q Query, v interface{}) {
+	out, err := q.Project(v)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	response.OK(w, out)
+}
+
+// Project reduces v (a slice of rows or a single row) to the requested fields.
+// It returns v unchanged when no fields were requested.
+func (q Query) Project(v interface{}) (interface{}, error) {
+	if q.Fields == nil {
+		return v, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]json.RawMessage
+	single := false
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		var row map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &row); err != nil {
+			return nil, err
+		}
+		rows, single = []map[string]json.RawMessage{row}, true
+	}
+	out := make([]map[string]json.RawMessage, len(rows))
+	for i, row := range rows {
+		out[i] = make(map[string]json.RawMessage, len(q.Fields))
+		for _, name := range q.Fields {
+			val, ok := row[name]
+			if !ok {
+				return nil, queryErrorf("unknown field %s", name)
+			}
+			out[i][name] = val
+		}
+	}
+	if single {
+		return out[0], nil
+	}
+	return out, nil
+}
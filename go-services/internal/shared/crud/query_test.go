@@ -0,0 +1,199 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{
+	Table:   "racks",
+	OrderBy: "name",
+	Filters: []FilterDef{
+		{QueryParam: "name", Column: "name", Op: "ilike"},
+		{QueryParam: "status", Column: "status"},
+		{QueryParam: "uHeight", Column: "u_height", Type: "int"},
+		{QueryParam: "createdAt", Column: "created_at", Type: "timestamp"},
+	},
+}
+
+func TestParseQuery(t *testing.T) {
+	day := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query   string
+		where   string
+		args    []interface{}
+		orderBy string
+		limit   int
+		wantErr bool
+	}{
+		{"", "", []interface{}{}, "name ASC, racks.id ASC", 0, false},
+		{"name=a1", " AND name::text ILIKE $1", []interface{}{"%a1%"}, "name ASC, racks.id ASC", 0, false},
+		{"status=active", " AND status::text = $1", []interface{}{"active"}, "name ASC, racks.id ASC", 0, false},
+		{"status__in=active,planned", " AND status::text = ANY($1)", []interface{}{[]interface{}{"active", "planned"}}, "name ASC, racks.id ASC", 0, false},
+		{"status__not_in=retired", " AND (status IS NULL OR NOT status::text = ANY($1))", []interface{}{[]interface{}{"retired"}}, "name ASC, racks.id ASC", 0, false},
+		{"uHeight__gte=42&status__not=retired", " AND status::text IS DISTINCT FROM $1 AND u_height >= $2", []interface{}{"retired", int64(42)}, "name ASC, racks.id ASC", 0, false},
+		{"createdAt__lt=2026-01-02", " AND created_at < $1", []interface{}{day}, "name ASC, racks.id ASC", 0, false},
+		{"status__isnull=true", " AND status IS NULL", []interface{}{}, "name ASC, racks.id ASC", 0, false},
+		{"cf_owner=ops&other=1", "", []interface{}{}, "name ASC, racks.id ASC", 0, false},
+		{"sort=-uHeight,created_at", "", []interface{}{}, "u_height DESC, created_at ASC, racks.id ASC", 0, false},
+		{"limit=10", "", []interface{}{}, "name ASC, racks.id ASC", 10, false},
+		{"limit=100000", "", []interface{}{}, "name ASC, racks.id ASC", MaxLimit, false},
+		{"limit=0", "", nil, "", 0, true},
+		{"uHeight=tall", "", nil, "", 0, true},
+		{"uHeight__between=1", "", nil, "", 0, true},
+		{"owner__in=a", "", nil, "", 0, true},
+		{"sort=secret", "", nil, "", 0, true},
+		{"status__isnull=maybe", "", nil, "", 0, true},
+		{"cursor=not-a-cursor", "", nil, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseQuery(httptest.NewRequest("GET", "/racks?"+tt.query, nil), testConfig, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, ok := err.(*QueryError); !ok {
+					t.Errorf("error is %T, want *QueryError", err)
+				}
+				return
+			}
+			if q.Where != tt.where {
+				t.Errorf("Where = %q, want %q", q.Where, tt.where)
+			}
+			if !reflect.DeepEqual(q.Args, tt.args) {
+				t.Errorf("Args = %#v, want %#v", q.Args, tt.args)
+			}
+			if q.Next != len(tt.args)+1 {
+				t.Errorf("Next = %d, want %d", q.Next, len(tt.args)+1)
+			}
+			if q.OrderBy != tt.orderBy {
+				t.Errorf("OrderBy = %q, want %q", q.OrderBy, tt.orderBy)
+			}
+			if q.Limit != tt.limit {
+				t.Errorf("Limit = %d, want %d", q.Limit, tt.limit)
+			}
+		})
+	}
+}
+
+// Lists are paged only when the client asks for a page or the Config pages by
+// default; existing callers read unpaged lists in full.
+func TestParseQueryLimit(t *testing.T) {
+	c := cursor{Keys: []*string{str("r10"), str("id9")}, Sort: "name,racks.id"}.encode()
+	tests := []struct {
+		name     string
+		pageSize int
+		query    string
+		want     int
+	}{
+		{"unpaged", 0, "", 0},
+		{"limit", 0, "limit=10", 10},
+		{"cursor", 0, "cursor=" + c, DefaultLimit},
+		{"cursor and limit", 0, "limit=10&cursor=" + c, 10},
+		{"page size", 200, "", 200},
+		{"page size and limit", 200, "limit=10", 10},
+		{"page size and cursor", 200, "cursor=" + c, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig
+			cfg.PageSize = tt.pageSize
+			q, err := ParseQuery(httptest.NewRequest("GET", "/racks?"+tt.query, nil), cfg, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Limit != tt.want {
+				t.Errorf("Limit = %d, want %d", q.Limit, tt.want)
+			}
+			if clause, want := q.LimitClause(), tt.want > 0; (clause != "") != want {
+				t.Errorf("LimitClause = %q, want a LIMIT %v", clause, want)
+			}
+		})
+	}
+}
+
+func str(s string) *string { return &s }
+
+func TestCursor(t *testing.T) {
+	tests := []struct {
+		name  string
+		sort  string
+		c     cursor
+		where string
+		args  []interface{}
+	}{
+		{
+			"ascending", "",
+			cursor{Keys: []*string{str("r10"), str("id9")}},
+			" AND (((name IS NULL OR name > $2)) OR (name = $2 AND (racks.id IS NULL OR racks.id > $3)))",
+			[]interface{}{"x", "r10", "id9"},
+		},
+		{
+			"backwards", "",
+			cursor{Keys: []*string{str("r10"), str("id9")}, Prev: true},
+			" AND ((name < $2) OR (name = $2 AND racks.id < $3))",
+			[]interface{}{"x", "r10", "id9"},
+		},
+		{
+			"descending", "sort=-uHeight",
+			cursor{Keys: []*string{str("42"), str("id9")}},
+			" AND ((u_height < $2) OR (u_height = $2 AND (racks.id IS NULL OR racks.id > $3)))",
+			[]interface{}{"x", "42", "id9"},
+		},
+		{
+			"NULL ascending", "",
+			cursor{Keys: []*string{nil, str("id9")}},
+			" AND ((name IS NULL AND (racks.id IS NULL OR racks.id > $2)))",
+			[]interface{}{"x", "id9"},
+		},
+		{
+			"NULL descending", "sort=-uHeight",
+			cursor{Keys: []*string{nil, str("id9")}},
+			" AND ((u_height IS NOT NULL) OR (u_height IS NULL AND (racks.id IS NULL OR racks.id > $2)))",
+			[]interface{}{"x", "id9"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, err := ParseQuery(httptest.NewRequest("GET", "/racks?"+tt.sort, nil), testConfig, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.c.Sort = base.sortKey()
+			q, err := ParseQuery(httptest.NewRequest("GET", "/racks?"+tt.sort+"&cursor="+tt.c.encode(), nil), testConfig, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			where, args := q.Seek([]interface{}{"x"})
+			if where != tt.where {
+				t.Errorf("Seek = %q, want %q", where, tt.where)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestCursorInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		sort string
+		c    cursor
+	}{
+		{"other ordering", "sort=-uHeight", cursor{Keys: []*string{str("r10"), str("id9")}, Sort: "name,racks.id"}},
+		{"missing keys", "", cursor{Keys: []*string{str("id9")}, Sort: "name,racks.id"}},
+		{"no id", "", cursor{Keys: []*string{str("r10"), nil}, Sort: "name,racks.id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseQuery(httptest.NewRequest("GET", "/racks?"+tt.sort+"&cursor="+tt.c.encode(), nil), testConfig, 1)
+			if err == nil {
+				t.Error("ParseQuery accepted the cursor")
+			}
+		})
+	}
+}
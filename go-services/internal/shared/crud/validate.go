@@ -0,0 +1,237 @@
+package crud
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/response"
+)
+
+// Range bounds a number, or the value of a decimal string, inclusively.
+type Range struct {
+	Min, Max float64
+}
+
+// AtLeast is the Range of numbers no smaller than min.
+func AtLeast(min float64) *Range {
+	return &Range{Min: min, Max: math.Inf(1)}
+}
+
+var (
+	slugPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+	uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// formats checks the string columns with a Column.Format.
+var formats = map[string]struct {
+	valid func(string) bool
+	msg   string
+}{
+	"slug": {func(s string) bool { return slugPattern.MatchString(s) }, "must be lowercase letters, digits and hyphens"},
+	"ip": {func(s string) bool {
+		// A bare address or an address with its prefix length, e.g. 10.0.0.5/24.
+		if _, err := netip.ParseAddr(s); err == nil {
+			return true
+		}
+		_, err := netip.ParsePrefix(s)
+		return err == nil
+	}, "must be an IP address"},
+	"mac":   {func(s string) bool { _, err := net.ParseMAC(s); return err == nil }, "must be a MAC address"},
+	"email": {func(s string) bool { a, err := mail.ParseAddress(s); return err == nil && a.Address == s }, "must be an email address"},
+}
+
+// ValidationError is a request body that breaks the column rules of a Config.
+// It is answered with 422 and every issue at once.
+type ValidationError struct {
+	Issues []map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		msgs[i] = issue["path"] + " " + issue["message"]
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks a create or update body against the columns of cfg: unknown
+// fields, JSON types, required fields, enums, ranges, formats and that Ref
+// columns name a live row. It returns every issue found, or nil. Issues have
+// the {"path", "message"} shape of the Next.js API.
+func Validate(ctx context.Context, q rowQuerier, cfg Config, body map[string]interface{}, create bool) ([]map[string]string, error) {
+	issues := []map[string]string{}
+	add := func(path, format string, args ...interface{}) {
+		issues = append(issues, map[string]string{"path": path, "message": fmt.Sprintf(format, args...)})
+	}
+
+	known := map[string]bool{}
+	for _, c := range cfg.Columns {
+		known[c.JSON] = true
+	}
+	for _, field := range sortedKeys(body) {
+		// Read-only fields are ignored, so a fetched object can be sent back as is.
+		if !known[field] && !slices.Contains(cfg.Extra, field) {
+			add(field, "is not a known field")
+		}
+	}
+
+	for _, c := range cfg.Columns {
+		if c.ReadOnly || c.Expr != "" {
+			continue
+		}
+		v, present := body[c.JSON]
+		if !present {
+			if create && c.Required {
+				add(c.JSON, "is required")
+			}
+			continue
+		}
+		if v == nil || v == "" && c.Nullable && c.Type != "json" {
+			if !c.Nullable {
+				add(c.JSON, "cannot be null")
+			}
+			continue
+		}
+		if c.Required && isBlank(v) {
+			add(c.JSON, "cannot be empty")
+			continue
+		}
+		if msg := c.check(v); msg != "" {
+			add(c.JSON, "%s", msg)
+			continue
+		}
+		if c.Ref == "" {
+			continue
+		}
+		if s, _ := v.(string); !uuidPattern.MatchString(s) {
+			add(c.JSON, "must be a UUID")
+			continue
+		}
+		var exists bool
+		if err := q.QueryRow(ctx, fmt.Sprintf(
+			`SELECT EXISTS(SELECT 1 FROM %s WHERE id = $1 AND deleted_at IS NULL)`, c.Ref), v).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			add(c.JSON, "does not reference an existing %s row", c.Ref)
+		}
+	}
+
+	if len(issues) == 0 {
+		return nil, nil
+	}
+	return issues, nil
+}
+
+// ValidBody validates the body of a hand-written create or update handler
+// against cfg. On failure it writes the response, 422 with the issues, and
+// returns false. Decimal numbers are rewritten as the strings handlers read.
+func ValidBody(w http.ResponseWriter, r *http.Request, q rowQuerier, cfg Config, body map[string]interface{}, create bool) bool {
+	issues, err := Validate(r.Context(), q, cfg, body, create)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return false
+	}
+	if issues != nil {
+		response.ValidationError(w, "Validation failed", issues)
+		return false
+	}
+	for _, c := range cfg.Columns {
+		if f, ok := body[c.JSON].(float64); ok && c.Type == "decimal" {
+			body[c.JSON] = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+	return true
+}
+
+// check returns why the non-null value v does not fit the column, or "".
+func (c Column) check(v interface{}) string {
+	var num float64
+	switch c.Type {
+	case "json":
+		return ""
+	case "bool":
+		if _, ok := v.(bool); !ok {
+			return "must be true or false"
+		}
+		return ""
+	case "timestamp":
+		s, ok := v.(string)
+		if _, err := time.Parse(time.RFC3339, s); !ok || err != nil {
+			return "must be an RFC 3339 timestamp"
+		}
+		return ""
+	case "int":
+		f, ok := v.(float64)
+		if !ok || f != math.Trunc(f) {
+			return "must be an integer"
+		}
+		num = f
+	case "float":
+		f, ok := v.(float64)
+		if !ok {
+			return "must be a number"
+		}
+		num = f
+	case "decimal":
+		f, ok := decimalValue(v)
+		if !ok {
+			return "must be a number"
+		}
+		num = f
+	default:
+		s, ok := v.(string)
+		if !ok {
+			return "must be a string"
+		}
+		if len(c.Enum) > 0 && !slices.Contains(c.Enum, s) {
+			return "must be one of: " + strings.Join(c.Enum, ", ")
+		}
+		if f, ok := formats[c.Format]; ok && !f.valid(s) {
+			return f.msg
+		}
+		return ""
+	}
+
+	if len(c.Enum) > 0 && !slices.Contains(c.Enum, strconv.FormatFloat(num, 'f', -1, 64)) {
+		return "must be one of: " + strings.Join(c.Enum, ", ")
+	}
+	if c.Range != nil && (num < c.Range.Min || num > c.Range.Max) {
+		if math.IsInf(c.Range.Max, 1) {
+			return fmt.Sprintf("must be at least %g", c.Range.Min)
+		}
+		return fmt.Sprintf("must be between %g and %g", c.Range.Min, c.Range.Max)
+	}
+	return ""
+}
+
+// decimalValue reads a decimal column value, sent as a JSON number or a numeric string.
+func decimalValue(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		return f, err == nil && !math.IsInf(f, 0) && !math.IsNaN(f)
+	}
+	return 0, false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
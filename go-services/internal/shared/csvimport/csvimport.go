@@ -0,0 +1,32 @@
+// Package csvimport reads the CSV files of the /import endpoints.
+package csvimport
+
+import (
+	"encoding/csv"
+	"strings"
+)
+
+// Header maps the lower-cased column names of a CSV header row to their index.
+type Header map[string]int
+
+// ReadHeader reads the header row of reader.
+func ReadHeader(reader *csv.Reader) (Header, error) {
+	headers, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	h := make(Header, len(headers))
+	for i, name := range headers {
+		h[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	return h, nil
+}
+
+// Get returns the trimmed cell of column key in record, or "" when the file
+// has no such column.
+func (h Header) Get(record []string, key string) string {
+	if i, ok := h[strings.ToLower(key)]; ok && i < len(record) {
+		return strings.TrimSpace(record[i])
+	}
+	return ""
+}
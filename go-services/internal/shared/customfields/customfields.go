@@ -0,0 +1,423 @@
+package customfields
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Querier is satisfied by *pgxpool.Pool and pgx.Tx.
+type Querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// Field types.
+const (
+	TypeText      = "text"
+	TypeInt       = "int"
+	TypeBool      = "bool"
+	TypeDate      = "date"
+	TypeSelect    = "select"
+	TypeURL       = "url"
+	TypeObjectRef = "object-ref"
+)
+
+// Types lists every supported field type.
+var Types = []string{TypeText, TypeInt, TypeBool, TypeDate, TypeSelect, TypeURL, TypeObjectRef}
+
+// ObjectTables maps object types that carry a custom_fields column to their table.
+var ObjectTables = map[string]string{
+	"site":   "sites",
+	"rack":   "racks",
+	"device": "devices",
+}
+
+// RefTables maps the object types an object-ref field may point at to their table.
+var RefTables = map[string]string{
+	"region":       "regions",
+	"site":         "sites",
+	"location":     "locations",
+	"rack":         "racks",
+	"device":       "devices",
+	"device-type":  "device_types",
+	"manufacturer": "manufacturers",
+	"tenant":       "tenants",
+}
+
+// Definition describes one custom field of an object type.
+type Definition struct {
+	ID          string          `json:"id"`
+	ObjectType  string          `json:"objectType"`
+	Name        string          `json:"name"`
+	Label       *string         `json:"label"`
+	Type        string          `json:"type"`
+	Required    bool            `json:"required"`
+	Default     json.RawMessage `json:"default"`
+	Regex       *string         `json:"regex"`
+	Choices     []string        `json:"choices"`
+	RefType     *string         `json:"refType"`
+	Description *string         `json:"description"`
+	Weight      int             `json:"weight"`
+	CreatedAt   string          `json:"createdAt"`
+	UpdatedAt   string          `json:"updatedAt"`
+}
+
+// Cols is the column list matching Scan.
+const Cols = `id, object_type, name, label, type, required, default_value, regex, choices, ref_type, description, weight, created_at, updated_at`
+
+// Scan reads a definition selected with Cols.
+func Scan(scan func(dest ...interface{}) error) (Definition, error) {
+	var d Definition
+	var choices []byte
+	var ca, ua time.Time
+	err := scan(&d.ID, &d.ObjectType, &d.Name, &d.Label, &d.Type, &d.Required, &d.Default, &d.Regex,
+		&choices, &d.RefType, &d.Description, &d.Weight, &ca, &ua)
+	if err != nil {
+		return d, err
+	}
+	d.Choices = []string{}
+	if len(choices) > 0 {
+		_ = json.Unmarshal(choices, &d.Choices)
+	}
+	if len(d.Default) == 0 {
+		d.Default = json.RawMessage("null")
+	}
+	d.CreatedAt = ca.UTC().Format(time.RFC3339)
+	d.UpdatedAt = ua.UTC().Format(time.RFC3339)
+	return d, nil
+}
+
+// Load returns the definitions of objectType ordered by weight, then name.
+func Load(ctx context.Context, q Querier, objectType string) ([]Definition, error) {
+	rows, err := q.Query(ctx, `SELECT `+Cols+` FROM custom_field_definitions
+		WHERE object_type = $1 AND deleted_at IS NULL
+		ORDER BY weight, name`, objectType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	defs := []Definition{}
+	for rows.Next() {
+		d, err := Scan(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		defs = append(defs, d)
+	}
+	return defs, rows.Err()
+}
+
+// ValidationError lists every invalid custom field value, or definition member,
+// as {"path", "message"} issues like those of crud.ValidationError.
+type ValidationError struct {
+	Issues []map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		msgs[i] = issue["path"] + " " + issue["message"]
+	}
+	return "invalid custom fields: " + strings.Join(msgs, "; ")
+}
+
+// add appends an issue at path.
+func (e *ValidationError) add(path, format string, args ...interface{}) {
+	e.Issues = append(e.Issues, map[string]string{"path": path, "message": fmt.Sprintf(format, args...)})
+}
+
+// orNil returns e, or nil when it holds no issue.
+func (e *ValidationError) orNil() error {
+	if len(e.Issues) == 0 {
+		return nil
+	}
+	return e
+}
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Check validates a definition before it is stored.
+func (d Definition) Check() error {
+	errs := &ValidationError{}
+	if _, ok := ObjectTables[d.ObjectType]; !ok {
+		errs.add("objectType", "must be one of: %s", strings.Join(sortedKeys(ObjectTables), ", "))
+	}
+	if !namePattern.MatchString(d.Name) {
+		errs.add("name", "must start with a letter and contain only lowercase letters, digits and underscores")
+	}
+	if !contains(Types, d.Type) {
+		errs.add("type", "must be one of: %s", strings.Join(Types, ", "))
+	}
+	if d.Regex != nil && *d.Regex != "" {
+		if d.Type != TypeText && d.Type != TypeURL {
+			errs.add("regex", "applies only to text and url fields")
+		} else if _, err := regexp.Compile(*d.Regex); err != nil {
+			errs.add("regex", "is invalid: %s", err.Error())
+		}
+	}
+	if d.Type == TypeSelect && len(d.Choices) == 0 {
+		errs.add("choices", "are required for select fields")
+	}
+	if d.Type != TypeSelect && len(d.Choices) > 0 {
+		errs.add("choices", "apply only to select fields")
+	}
+	if d.Type == TypeObjectRef {
+		if d.RefType == nil {
+			errs.add("refType", "is required for object-ref fields")
+		} else if _, ok := RefTables[*d.RefType]; !ok {
+			errs.add("refType", "must be one of: %s", strings.Join(sortedKeys(RefTables), ", "))
+		}
+	}
+	if len(errs.Issues) == 0 && hasValue(d.Default) {
+		var v interface{}
+		if err := json.Unmarshal(d.Default, &v); err != nil {
+			errs.add("default", "is not valid JSON")
+		} else if _, err := d.coerce(v); err != nil {
+			errs.add("default", "%s", err.Error())
+		}
+	}
+	return errs.orNil()
+}
+
+// Validate checks values against the definitions of objectType, fills in defaults for
+// missing fields and returns the JSON to store. Unknown keys are rejected. Object
+// references must point at a live row.
+func Validate(ctx context.Context, q Querier, objectType string, values map[string]interface{}) ([]byte, error) {
+	defs, err := Load(ctx, q, objectType)
+	if err != nil {
+		return nil, err
+	}
+	return validate(ctx, q, defs, values)
+}
+
+// ValidatePatch checks an update of the custom fields of an object and returns
+// the JSON to store. patch is merged onto the stored values like a JSON merge
+// patch: members replace stored fields and null members remove them. Stored
+// fields whose definition has since been deleted are dropped, not rejected.
+func ValidatePatch(ctx context.Context, q Querier, objectType string, stored, patch map[string]interface{}) ([]byte, error) {
+	defs, err := Load(ctx, q, objectType)
+	if err != nil {
+		return nil, err
+	}
+	merged := map[string]interface{}{}
+	for _, d := range defs {
+		if v, ok := stored[d.Name]; ok {
+			merged[d.Name] = v
+		}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return validate(ctx, q, defs, merged)
+}
+
+func validate(ctx context.Context, q Querier, defs []Definition, values map[string]interface{}) ([]byte, error) {
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	known := make(map[string]Definition, len(defs))
+	for _, d := range defs {
+		known[d.Name] = d
+	}
+
+	errs := &ValidationError{}
+	for _, k := range sortedKeys(values) {
+		if _, ok := known[k]; !ok {
+			errs.add(Path(k), "is not a defined field")
+		}
+	}
+
+	out := map[string]interface{}{}
+	for _, d := range defs {
+		v, present := values[d.Name]
+		if !present || v == nil {
+			if hasValue(d.Default) {
+				_ = json.Unmarshal(d.Default, &v)
+			} else {
+				v = nil
+			}
+		}
+		if v == nil {
+			if d.Required {
+				errs.add(Path(d.Name), "is required")
+			}
+			continue
+		}
+		cv, err := d.coerce(v)
+		if err != nil {
+			errs.add(Path(d.Name), "%s", err.Error())
+			continue
+		}
+		if d.Type == TypeObjectRef {
+			var exists bool
+			err := q.QueryRow(ctx,
+				fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM %s WHERE id = $1 AND deleted_at IS NULL)`, RefTables[*d.RefType]),
+				cv).Scan(&exists)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				errs.add(Path(d.Name), "references a %s that does not exist", *d.RefType)
+				continue
+			}
+		}
+		out[d.Name] = cv
+	}
+	if err := errs.orNil(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// Path returns the request body path of custom field name.
+func Path(name string) string {
+	return "customFields." + name
+}
+
+// coerce type-checks a decoded JSON value and returns its canonical form.
+func (d Definition) coerce(v interface{}) (interface{}, error) {
+	switch d.Type {
+	case TypeInt:
+		f, ok := v.(float64)
+		if !ok || f != math.Trunc(f) {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return int64(f), nil
+	case TypeBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return b, nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("must be a string")
+	}
+	switch d.Type {
+	case TypeDate:
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			return nil, fmt.Errorf("must be a date (YYYY-MM-DD)")
+		}
+	case TypeSelect:
+		if !contains(d.Choices, s) {
+			return nil, fmt.Errorf("must be one of: %s", strings.Join(d.Choices, ", "))
+		}
+	case TypeURL:
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("must be an http or https URL")
+		}
+	case TypeObjectRef:
+		if s == "" {
+			return nil, fmt.Errorf("must be an id")
+		}
+	}
+	if d.Regex != nil && *d.Regex != "" {
+		re, err := regexp.Compile(*d.Regex)
+		if err == nil && !re.MatchString(s) {
+			return nil, fmt.Errorf("must match %s", *d.Regex)
+		}
+	}
+	return s, nil
+}
+
+// Parse converts a text cell (CSV import) into the JSON value for d.
+// Empty cells yield nil.
+func (d Definition) Parse(s string) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	switch d.Type {
+	case TypeInt:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an integer", d.Name)
+		}
+		return float64(n), nil
+	case TypeBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a boolean", d.Name)
+		}
+		return b, nil
+	}
+	return s, nil
+}
+
+// Format renders a stored value as text for exports.
+func Format(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
+
+// FilterPrefix marks list query parameters that filter on a custom field, e.g. ?cf_owner=ops.
+const FilterPrefix = "cf_"
+
+// Filter builds WHERE conditions for every cf_<name> query parameter against column
+// (e.g. "custom_fields" or "d.custom_fields"). Placeholders start at ai; the next free
+// index is returned. Values are compared as text, so ?cf_managed=true matches a bool.
+func Filter(q url.Values, column string, ai int) (string, []interface{}, int) {
+	clause := ""
+	args := []interface{}{}
+	for _, k := range sortedKeys(q) {
+		name := strings.TrimPrefix(k, FilterPrefix)
+		if name == k || !namePattern.MatchString(name) {
+			continue
+		}
+		clause += fmt.Sprintf(" AND %s->>$%d = $%d", column, ai, ai+1)
+		args = append(args, name, q.Get(k))
+		ai += 2
+	}
+	return clause, args, ai
+}
+
+func hasValue(raw json.RawMessage) bool {
+	s := strings.TrimSpace(string(raw))
+	return s != "" && s != "null"
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
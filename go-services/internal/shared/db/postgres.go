@@ -28,3 +28,11 @@ func New(ctx context.Context, connStr string) (*DB, error) {
 func (d *DB) Close() {
 	d.Pool.Close()
 }
+
+// NullIfEmpty returns s as a query argument, or nil for SQL NULL when s is empty.
+func NullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,210 @@
+package etag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrModified is returned by Check when the row no longer matches If-Match.
+var ErrModified = errors.New("resource has been modified")
+
+// Of returns the entity tag for a row last changed at updatedAt.
+func Of(updatedAt time.Time) string {
+	return `"` + strconv.FormatInt(updatedAt.UnixMicro(), 36) + `"`
+}
+
+// Versioner tags rows by their updated_at column and enforces preconditions.
+type Versioner struct {
+	pool *pgxpool.Pool
+}
+
+// New returns a Versioner reading versions from pool.
+func New(pool *pgxpool.Pool) *Versioner {
+	return &Versioner{pool: pool}
+}
+
+// Resource returns a wrapper for the routes of table addressed by the {id} path value:
+//
+//	GET    sets ETag and answers If-None-Match with 304
+//	PATCH  requires If-Match (when sent) to match, and returns the new ETag
+//	DELETE requires If-Match (when sent) to match
+//
+// The If-Match list is handed to the handler through the request context; the
+// handler calls Check in its write transaction, so the comparison holds until
+// commit on every replica. A failed If-Match is answered with 412 and the
+// current representation served by get.
+func (v *Versioner) Resource(table string, get http.HandlerFunc) func(http.HandlerFunc) http.Handler {
+	return func(next http.HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.PathValue("id")
+			switch r.Method {
+			case http.MethodGet:
+				v.get(w, r, next, table, id)
+			case http.MethodPatch, http.MethodDelete:
+				v.write(w, r, next, get, table, id)
+			default:
+				next(w, r)
+			}
+		})
+	}
+}
+
+type ifMatchKey struct{}
+
+// WithIfMatch returns ctx carrying an If-Match list for Check. Resource sets it
+// from the request header; bulk operations set their own.
+func WithIfMatch(ctx context.Context, list string) context.Context {
+	if list == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ifMatchKey{}, list)
+}
+
+// Check enforces the If-Match list of ctx on row id of table inside tx. It locks
+// the row for the rest of the transaction and returns ErrModified unless the
+// row's tag is listed. Without If-Match, or when the row does not exist, it
+// does nothing and the caller's own lookup answers 404.
+func Check(ctx context.Context, tx pgx.Tx, table, id string) error {
+	list, _ := ctx.Value(ifMatchKey{}).(string)
+	if list == "" {
+		return nil
+	}
+	tag, err := version(ctx, tx, table, id, true)
+	if err != nil {
+		return err
+	}
+	if tag != "" && !matches(list, tag) {
+		return ErrModified
+	}
+	return nil
+}
+
+// WriteError answers an error from Check: 412 for ErrModified, 500 otherwise.
+func WriteError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrModified) {
+		response.Error(w, ErrModified.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	log.Printf("etag check error: %v", err)
+	response.InternalError(w, "database error")
+}
+
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// version returns the ETag of a live row, or "" when it does not exist or is
+// soft-deleted. Going through to_jsonb covers tables without a deleted_at column.
+func version(ctx context.Context, q rowQuerier, table, id string, lock bool) (string, error) {
+	query := fmt.Sprintf(`SELECT t.updated_at FROM %s t WHERE t.id = $1 AND to_jsonb(t)->>'deleted_at' IS NULL`, table)
+	if lock {
+		query += " FOR UPDATE"
+	}
+	var updatedAt time.Time
+	err := q.QueryRow(ctx, query, id).Scan(&updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return Of(updatedAt), nil
+}
+
+// current returns the ETag of a live row, or "" when there is none.
+func (v *Versioner) current(ctx context.Context, table, id string) (string, error) {
+	return version(ctx, v.pool, table, id, false)
+}
+
+// get tags the response with the version read before the handler runs, so a
+// concurrent write can only make the tag older than the body, never newer.
+// Expanded responses embed other rows the tag does not cover, so they are not tagged.
+func (v *Versioner) get(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, table, id string) {
+	if r.URL.Query().Has("expand") {
+		next(w, r)
+		return
+	}
+	tag, err := v.current(r.Context(), table, id)
+	if err != nil {
+		log.Printf("etag lookup error [%s]: %v", table, err)
+		next(w, r)
+		return
+	}
+	if tag != "" {
+		if matches(r.Header.Get("If-None-Match"), tag) {
+			w.Header().Set("ETag", tag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", tag)
+	}
+	next(w, r)
+}
+
+func (v *Versioner) write(w http.ResponseWriter, r *http.Request, next, get http.HandlerFunc, table, id string) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" && r.Method != http.MethodPatch {
+		next(w, r)
+		return
+	}
+	r = r.WithContext(WithIfMatch(r.Context(), ifMatch))
+	buf := response.NewBuffer()
+	next(buf, r)
+	switch {
+	case buf.Status == http.StatusPreconditionFailed && ifMatch != "":
+		v.preconditionFailed(w, r, get, buf, table, id)
+		return
+	case buf.Status < 300 && r.Method == http.MethodPatch:
+		if tag, err := v.current(r.Context(), table, id); err == nil && tag != "" {
+			buf.Header().Set("ETag", tag)
+		}
+	}
+	buf.Flush(w)
+}
+
+// preconditionFailed answers 412 with the current representation and its tag,
+// or with the handler's own response when the row cannot be read.
+func (v *Versioner) preconditionFailed(w http.ResponseWriter, r *http.Request, get http.HandlerFunc, failed *response.Buffer, table, id string) {
+	req := r.Clone(r.Context())
+	req.Method = http.MethodGet
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	req.Header.Del("If-Match")
+	req.Header.Del("If-None-Match")
+	tag, err := v.current(r.Context(), table, id)
+	if err != nil || tag == "" {
+		failed.Flush(w)
+		return
+	}
+	buf := response.NewBuffer()
+	get(buf, req)
+	if buf.Status != http.StatusOK {
+		failed.Flush(w)
+		return
+	}
+	buf.Status = http.StatusPreconditionFailed
+	buf.Header().Set("ETag", tag)
+	buf.Flush(w)
+}
+
+// matches reports whether an If-Match or If-None-Match list contains tag.
+// Weak validators are compared by their opaque tag.
+func matches(list, tag string) bool {
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
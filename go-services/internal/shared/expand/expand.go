@@ -0,0 +1,257 @@
+package expand
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dcim/go-services/internal/shared/crud"
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Limits on ?expand=, so one request cannot fan out into an unbounded number of queries.
+const (
+	MaxDepth = 3  // relations per path, e.g. rack.location.site
+	MaxPaths = 10 // comma-separated paths
+)
+
+// Relation embeds the row of Table whose id is in the JSON field Field.
+type Relation struct {
+	Field string // foreign key in the parent object, e.g. "rackId"
+	Table string // referenced table; its own relations come from Graph[Table]
+}
+
+func ref(field, table string) Relation { return Relation{Field: field, Table: table} }
+
+// Graph lists, per table, the relations that can be expanded by name.
+var Graph = map[string]map[string]Relation{
+	"regions":   {"parent": ref("parentId", "regions")},
+	"sites":     {"region": ref("regionId", "regions"), "tenant": ref("tenantId", "tenants")},
+	"locations": {"site": ref("siteId", "sites"), "tenant": ref("tenantId", "tenants")},
+	"racks":     {"location": ref("locationId", "locations"), "tenant": ref("tenantId", "tenants")},
+	"devices": {
+		"deviceType": ref("deviceTypeId", "device_types"),
+		"rack":       ref("rackId", "racks"),
+		"tenant":     ref("tenantId", "tenants"),
+	},
+	"device_types":  {"manufacturer": ref("manufacturerId", "manufacturers")},
+	"interfaces":    {"device": ref("deviceId", "devices")},
+	"console_ports": {"device": ref("deviceId", "devices")},
+	"rear_ports":    {"device": ref("deviceId", "devices")},
+	"front_ports":   {"device": ref("deviceId", "devices"), "rearPort": ref("rearPortId", "rear_ports")},
+	"cables":        {"tenant": ref("tenantId", "tenants")},
+	"access_logs":   {"site": ref("siteId", "sites")},
+	"equipment_movements": {
+		"site":   ref("siteId", "sites"),
+		"rack":   ref("rackId", "racks"),
+		"device": ref("deviceId", "devices"),
+	},
+	"alert_history": {"rule": ref("ruleId", "alert_rules")},
+	"power_panels":  {"site": ref("siteId", "sites")},
+	"power_feeds":   {"panel": ref("panelId", "power_panels"), "rack": ref("rackId", "racks")},
+}
+
+// node is a parsed expand tree: relation name to the relations expanded below it.
+type node map[string]node
+
+// parse validates expand paths against Graph starting at table. Relations to
+// tables the Expander has no Config of cannot be expanded.
+func (e *Expander) parse(table, expr string) (node, error) {
+	root := node{}
+	paths := strings.Split(expr, ",")
+	if len(paths) > MaxPaths {
+		return nil, fmt.Errorf("expand accepts at most %d paths", MaxPaths)
+	}
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		names := strings.Split(path, ".")
+		if len(names) > MaxDepth {
+			return nil, fmt.Errorf("expand %s is deeper than %d levels", path, MaxDepth)
+		}
+		cur, t := root, table
+		for _, name := range names {
+			rel, ok := Graph[t][name]
+			if _, loaded := e.resources[rel.Table]; !ok || !loaded {
+				return nil, fmt.Errorf("cannot expand %s on %s", name, t)
+			}
+			if cur[name] == nil {
+				cur[name] = node{}
+			}
+			cur, t = cur[name], rel.Table
+		}
+	}
+	return root, nil
+}
+
+// Expander embeds related rows into GET responses on ?expand=.
+type Expander struct {
+	resources map[string]*crud.Resource
+}
+
+// New returns an Expander loading related rows from pool as the given crud
+// resources serve them, e.g. the BulkResources of the services whose tables
+// can be embedded.
+func New(pool *pgxpool.Pool, resources ...[]crud.BulkResource) *Expander {
+	e := &Expander{resources: map[string]*crud.Resource{}}
+	for _, list := range resources {
+		for _, res := range list {
+			e.resources[res.Config.Table] = crud.NewResource(res.Config, pool)
+		}
+	}
+	return e
+}
+
+// Of returns a wrapper for GET routes serving rows of table, either a single
+// object or a list. With ?expand=rack,deviceType.manufacturer each object gains
+// a "rack" and a "deviceType" member holding the referenced row (or null), and
+// deviceType its "manufacturer". Every relation is loaded with one query per
+// level for the whole response.
+func (e *Expander) Of(table string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			expr := r.URL.Query().Get("expand")
+			if expr == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tree, err := e.parse(table, expr)
+			if err != nil {
+				response.BadRequest(w, err.Error())
+				return
+			}
+
+			buf := response.NewBuffer()
+			next.ServeHTTP(buf, r)
+			if buf.Status != http.StatusOK {
+				buf.Flush(w)
+				return
+			}
+			body, err := e.expand(r.Context(), table, tree, buf.Body.Bytes())
+			if err != nil {
+				log.Printf("expand error [%s]: %v", table, err)
+				response.InternalError(w, "expand failed")
+				return
+			}
+			buf.Body.Reset()
+			buf.Body.Write(body)
+			buf.Header().Del("Content-Length")
+			buf.Flush(w)
+		})
+	}
+}
+
+// expand rewrites a {"data": ...} body. data is an object, an array of objects,
+// or a page object holding the array in its own "data" member.
+func (e *Expander) expand(ctx context.Context, table string, tree node, body []byte) ([]byte, error) {
+	var env map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&env); err != nil {
+		return nil, err
+	}
+
+	var objs []map[string]interface{}
+	collect := func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			objs = append(objs, v)
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					objs = append(objs, m)
+				}
+			}
+		}
+	}
+	if page, ok := env["data"].(map[string]interface{}); ok && page["id"] == nil && page["data"] != nil {
+		collect(page["data"])
+	} else {
+		collect(env["data"])
+	}
+
+	if err := e.embed(ctx, table, tree, objs); err != nil {
+		return nil, err
+	}
+	return json.Marshal(env)
+}
+
+// embed loads the relations in tree for objs, then recurses into the loaded rows.
+func (e *Expander) embed(ctx context.Context, table string, tree node, objs []map[string]interface{}) error {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rel := Graph[table][name]
+		seen := map[string]bool{}
+		ids := []string{}
+		for _, o := range objs {
+			if id, ok := o[rel.Field].(string); ok && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		rows, err := e.load(ctx, rel.Table, ids)
+		if err != nil {
+			return err
+		}
+		for _, o := range objs {
+			id, _ := o[rel.Field].(string)
+			if row, ok := rows[id]; ok {
+				o[name] = row
+			} else {
+				o[name] = nil
+			}
+		}
+
+		if len(tree[name]) == 0 || len(rows) == 0 {
+			continue
+		}
+		loaded := make([]map[string]interface{}, 0, len(rows))
+		for _, id := range ids {
+			if row, ok := rows[id]; ok {
+				loaded = append(loaded, row)
+			}
+		}
+		if err := e.embed(ctx, rel.Table, tree[name], loaded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load returns the rows of table with the given ids in API form, keyed by id:
+// the live rows the request's user may read, as the table's crud Get serves them.
+func (e *Expander) load(ctx context.Context, table string, ids []string) (map[string]map[string]interface{}, error) {
+	rows, err := e.resources[table].Rows(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	// Round-trip through JSON so loaded rows hold the same values as decoded objects.
+	out := make(map[string]map[string]interface{}, len(rows))
+	for id, row := range rows {
+		raw, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		var obj map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil {
+			return nil, err
+		}
+		out[id] = obj
+	}
+	return out, nil
+}
@@ -0,0 +1,317 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/history"
+	"github.com/dcim/go-services/internal/shared/rbac"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	pollBatch   = 1000             // events read per poll
+	subBuffer   = 256              // events queued per client before it is dropped
+	pingEvery   = 15 * time.Second // keeps idle connections and proxies alive
+	retryMillis = 3000             // reconnect delay suggested to clients
+)
+
+// Change is one event of the feed: a create, update or delete of a row.
+type Change struct {
+	Seq        int64            `json:"seq"`
+	Resource   string           `json:"resource"` // table name, e.g. "devices"
+	Action     string           `json:"action"`
+	ID         string           `json:"id"`
+	SiteIDs    []string         `json:"siteIds"`
+	RackIDs    []string         `json:"rackIds"`
+	OccurredAt string           `json:"occurredAt"`
+	Diff       []history.Change `json:"diff"`
+}
+
+// Filter selects changes by site, rack and resource. An empty set matches all.
+// Permitted, when not nil, holds the only sites a user with a site-scoped
+// role may see changes of.
+type Filter struct {
+	Sites, Racks, Resources map[string]bool
+	Permitted               map[string]bool
+}
+
+func (f Filter) match(c Change) bool {
+	return (len(f.Resources) == 0 || f.Resources[c.Resource]) &&
+		(len(f.Sites) == 0 || anyIn(c.SiteIDs, f.Sites)) &&
+		(len(f.Racks) == 0 || anyIn(c.RackIDs, f.Racks)) &&
+		(f.Permitted == nil || anyIn(c.SiteIDs, f.Permitted))
+}
+
+func anyIn(ids []string, set map[string]bool) bool {
+	for _, id := range ids {
+		if set[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub streams the outbox event log to SSE clients. One poller per process
+// reads new events and fans them out, so clients cost no queries of their own
+// except for replay.
+type Hub struct {
+	pool *pgxpool.Pool
+
+	mu   sync.Mutex
+	subs map[chan Change]struct{}
+}
+
+// NewHub returns a Hub reading events from pool.
+func NewHub(pool *pgxpool.Pool) *Hub {
+	return &Hub{pool: pool, subs: map[chan Change]struct{}{}}
+}
+
+// Start polls for new events every interval until ctx is done.
+func (h *Hub) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		var last int64
+		if err := h.pool.QueryRow(ctx, `SELECT COALESCE(max(seq), 0) FROM outbox_events`).Scan(&last); err != nil {
+			log.Printf("change feed start error: %v", err)
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				changes, err := h.since(ctx, last, Filter{}, pollBatch)
+				if err != nil {
+					log.Printf("change feed poll error: %v", err)
+					continue
+				}
+				for _, c := range changes {
+					h.publish(c)
+					last = c.Seq
+				}
+			}
+		}
+	}()
+}
+
+// publish sends c to every subscriber. A subscriber whose buffer is full is
+// dropped; its client reconnects and catches up through Last-Event-ID.
+func (h *Hub) publish(c Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- c:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *Hub) subscribe() chan Change {
+	ch := make(chan Change, subBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *Hub) unsubscribe(ch chan Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// since returns up to limit events after seq that match f, oldest first.
+func (h *Hub) since(ctx context.Context, seq int64, f Filter, limit int) ([]Change, error) {
+	rows, err := h.pool.Query(ctx, `
+		SELECT seq, resource, action, record_id, site_ids, rack_ids, payload, created_at
+		FROM outbox_events
+		WHERE seq > $1
+		  AND (cardinality($2::text[]) = 0 OR resource = ANY($2))
+		  AND (cardinality($3::text[]) = 0 OR site_ids && $3)
+		  AND (cardinality($4::text[]) = 0 OR rack_ids && $4)
+		  AND ($5::text[] IS NULL OR site_ids && $5)
+		ORDER BY seq
+		LIMIT $6`, seq, keys(f.Resources), keys(f.Sites), keys(f.Racks), permitted(f), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []Change{}
+	for rows.Next() {
+		var c Change
+		var payload []byte
+		var at time.Time
+		if err := rows.Scan(&c.Seq, &c.Resource, &c.Action, &c.ID, &c.SiteIDs, &c.RackIDs, &payload, &at); err != nil {
+			return nil, err
+		}
+		if c.Diff, err = diff(payload); err != nil {
+			return nil, err
+		}
+		c.OccurredAt = at.UTC().Format(time.RFC3339)
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// diff computes the field changes of an outbox payload.
+func diff(payload []byte) ([]history.Change, error) {
+	var p struct {
+		Before json.RawMessage `json:"before"`
+		After  json.RawMessage `json:"after"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	before, err := history.Decode(p.Before)
+	if err != nil {
+		return nil, err
+	}
+	after, err := history.Decode(p.After)
+	if err != nil {
+		return nil, err
+	}
+	return history.Diff(before, after), nil
+}
+
+// Stream handles GET /events?site=&rack=&resource=devices,racks
+//
+// Server-sent events, one "change" event per matching create, update or delete,
+// with the event's seq as its id. A client reconnecting with Last-Event-ID (or
+// ?lastEventId=) first receives the changes it missed. When those are older
+// than the retained log a "reset" event tells it to reload instead. Users
+// whose roles are scoped to sites only receive the changes at those sites.
+func (h *Hub) Stream(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := Filter{Sites: set(q["site"]), Racks: set(q["rack"]), Resources: set(q["resource"])}
+	if a := rbac.FromContext(r.Context()); a != nil {
+		if s, _ := a.Scope(rbac.Feed, "read"); !s.All {
+			f.Permitted = set(s.Sites)
+		}
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = q.Get("lastEventId")
+	}
+	var last int64
+	if lastID != "" {
+		n, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "Last-Event-ID must be an event seq", http.StatusBadRequest)
+			return
+		}
+		last = n
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	rc := http.NewResponseController(w)
+
+	// Subscribe before replaying so nothing committed in between is missed;
+	// live events already replayed are skipped by seq.
+	ctx := r.Context()
+	ch := h.subscribe()
+	defer h.unsubscribe(ch)
+
+	fmt.Fprintf(w, "retry: %d\n\n", retryMillis)
+	if lastID != "" {
+		var oldest int64
+		if err := h.pool.QueryRow(ctx, `SELECT COALESCE(min(seq), 0) FROM outbox_events`).Scan(&oldest); err != nil {
+			log.Printf("change feed replay error: %v", err)
+			return
+		}
+		if oldest > last+1 {
+			writeEvent(w, "reset", 0, map[string]string{"reason": "missed events are no longer retained; reload"})
+		}
+		for {
+			changes, err := h.since(ctx, last, f, pollBatch)
+			if err != nil {
+				log.Printf("change feed replay error: %v", err)
+				return
+			}
+			for _, c := range changes {
+				writeEvent(w, "change", c.Seq, c)
+				last = c.Seq
+			}
+			if len(changes) < pollBatch {
+				break
+			}
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(pingEvery)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case c, ok := <-ch:
+			if !ok {
+				return
+			}
+			if c.Seq <= last || !f.match(c) {
+				continue
+			}
+			writeEvent(w, "change", c.Seq, c)
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, id int64, data interface{}) {
+	b, _ := json.Marshal(data)
+	if id > 0 {
+		fmt.Fprintf(w, "id: %d\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
+
+// set collects comma-separated query values.
+func set(values []string) map[string]bool {
+	out := map[string]bool{}
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out[s] = true
+			}
+		}
+	}
+	return out
+}
+
+// permitted returns the Permitted sites of f as a query argument, nil for all.
+func permitted(f Filter) []string {
+	if f.Permitted == nil {
+		return nil
+	}
+	return keys(f.Permitted)
+}
+
+func keys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
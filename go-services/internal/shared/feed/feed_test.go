@@ -0,0 +1,62 @@
+package feed
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	c := Change{Resource: "devices", SiteIDs: []string{"s1"}, RackIDs: []string{"r1", "r2"}}
+	unsited := Change{Resource: "device_types"}
+	tests := []struct {
+		name   string
+		filter Filter
+		change Change
+		want   bool
+	}{
+		{"empty filter", Filter{}, c, true},
+		{"resource", Filter{Resources: set([]string{"devices,racks"})}, c, true},
+		{"other resource", Filter{Resources: set([]string{"racks"})}, c, false},
+		{"site", Filter{Sites: set([]string{"s1"})}, c, true},
+		{"other site", Filter{Sites: set([]string{"s2"})}, c, false},
+		{"any rack", Filter{Racks: set([]string{"r2"})}, c, true},
+		{"other rack", Filter{Racks: set([]string{"r3"})}, c, false},
+		{"all of them", Filter{Sites: set([]string{"s1"}), Racks: set([]string{"r1"}), Resources: set([]string{"devices"})}, c, true},
+		{"one of them fails", Filter{Sites: set([]string{"s1"}), Racks: set([]string{"r3"})}, c, false},
+		{"permitted site", Filter{Permitted: map[string]bool{"s1": true}}, c, true},
+		{"not permitted", Filter{Permitted: map[string]bool{"s2": true}}, c, false},
+		{"no sites permitted", Filter{Permitted: map[string]bool{}}, c, false},
+		{"site filter cannot widen permitted", Filter{Sites: set([]string{"s1"}), Permitted: map[string]bool{"s2": true}}, c, false},
+		{"unsited change, unscoped", Filter{}, unsited, true},
+		{"unsited change, scoped", Filter{Permitted: map[string]bool{"s1": true}}, unsited, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(tt.change); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermitted(t *testing.T) {
+	tests := []struct {
+		name      string
+		permitted map[string]bool
+		want      []string
+	}{
+		{"unscoped", nil, nil},
+		{"no sites", map[string]bool{}, []string{}},
+		{"sites", map[string]bool{"s2": true, "s1": true}, []string{"s1", "s2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := permitted(Filter{Permitted: tt.permitted})
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("permitted = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
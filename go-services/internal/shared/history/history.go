@@ -0,0 +1,380 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Entry is one audit log entry of a record with the fields it changed.
+type Entry struct {
+	ID        string   `json:"id"`
+	Action    string   `json:"action"`
+	UserID    *string  `json:"userId"`
+	Reason    *string  `json:"reason"`
+	CreatedAt string   `json:"createdAt"`
+	Changes   []Change `json:"changes"`
+
+	at     time.Time
+	before map[string]interface{}
+	after  map[string]interface{}
+}
+
+// Change is one field changed by an entry. A field the record gained has a
+// null Before, one it lost a null After.
+type Change struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Store rebuilds past states of records from audit_logs.
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+// New returns a Store reading audit_logs from pool.
+func New(pool *pgxpool.Pool) *Store {
+	return &Store{pool: pool}
+}
+
+// ParseAsOf returns the ?asOf= time of r. set is false when the parameter is absent.
+func ParseAsOf(r *http.Request) (t time.Time, set bool, err error) {
+	s := r.URL.Query().Get("asOf")
+	if s == "" {
+		return time.Time{}, false, nil
+	}
+	t, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, true, errors.New("asOf must be an RFC 3339 timestamp")
+	}
+	return t, true, nil
+}
+
+// AsOf returns a wrapper for the GET route of a single row of table addressed
+// by the {id} path value. With ?asOf=2026-06-01T00:00:00Z the row is served as
+// it was at that time, rebuilt from audit_logs, instead of by the wrapped
+// handler. A row that did not exist then is answered with 404.
+func (s *Store) AsOf(table string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t, set, err := ParseAsOf(r)
+			if !set {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				response.BadRequest(w, err.Error())
+				return
+			}
+			id := r.PathValue("id")
+			state, ok, err := s.State(r.Context(), table, id, t)
+			if err != nil {
+				log.Printf("history asOf error [%s]: %v", table, err)
+				response.InternalError(w, "database error")
+				return
+			}
+			if !ok {
+				response.Error(w, fmt.Sprintf("%s did not exist at %s", id, t.UTC().Format(time.RFC3339)), http.StatusNotFound)
+				return
+			}
+			response.OK(w, state)
+		})
+	}
+}
+
+// State returns the row of table with id as it was at t. ok is false when the
+// row did not exist then or its state then was never logged. A row without
+// audit entries has not changed since logging began, so its current state is
+// its state at any time after its creation.
+func (s *Store) State(ctx context.Context, table, id string, t time.Time) (state map[string]interface{}, ok bool, err error) {
+	entries, err := s.Entries(ctx, table, []string{id})
+	if err != nil {
+		return nil, false, err
+	}
+	if es := entries[id]; len(es) > 0 {
+		state, ok = At(es, t)
+		return state, ok && existed(state, t), nil
+	}
+	cur, err := s.current(ctx, table, []string{id}, t)
+	if err != nil {
+		return nil, false, err
+	}
+	state, ok = cur[id]
+	return state, ok && existed(state, t), nil
+}
+
+// Children returns the rows of table whose field referenced one of parents at
+// t, as they were then, e.g. the devices of a set of racks. column is the
+// current column of field. Candidates are the rows referencing a parent now or
+// in any state logged up to t.
+func (s *Store) Children(ctx context.Context, table, field, column string, parents []string, t time.Time) ([]map[string]interface{}, error) {
+	out := []map[string]interface{}{}
+	if len(parents) == 0 {
+		return out, nil
+	}
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+		SELECT record_id FROM audit_logs
+		WHERE table_name = $1 AND created_at <= $4
+		  AND (changes_after->>$2 = ANY($3) OR changes_before->>$2 = ANY($3))
+		UNION
+		SELECT id FROM %s WHERE %s = ANY($3)`, table, column), table, field, parents, t)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	entries, err := s.Entries(ctx, table, ids)
+	if err != nil {
+		return nil, err
+	}
+	var unlogged []string
+	for _, id := range ids {
+		if len(entries[id]) == 0 {
+			unlogged = append(unlogged, id)
+		}
+	}
+	cur, err := s.current(ctx, table, unlogged, t)
+	if err != nil {
+		return nil, err
+	}
+
+	in := make(map[string]bool, len(parents))
+	for _, p := range parents {
+		in[p] = true
+	}
+	for _, id := range ids {
+		state, ok := cur[id]
+		if es := entries[id]; len(es) > 0 {
+			state, ok = At(es, t)
+		}
+		if ok && existed(state, t) && in[fmt.Sprint(state[field])] {
+			out = append(out, state)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ni, nj := fmt.Sprint(out[i]["name"]), fmt.Sprint(out[j]["name"])
+		if ni != nj {
+			return ni < nj
+		}
+		return fmt.Sprint(out[i]["id"]) < fmt.Sprint(out[j]["id"])
+	})
+	return out, nil
+}
+
+// Entries returns the audit entries of the rows ids of table, oldest first,
+// keyed by record id. Changes is not set; see Replay.
+func (s *Store) Entries(ctx context.Context, table string, ids []string) (map[string][]Entry, error) {
+	out := map[string][]Entry{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, record_id, action, user_id, reason, changes_before, changes_after, created_at
+		FROM audit_logs
+		WHERE table_name = $1 AND record_id = ANY($2)
+		ORDER BY record_id, created_at, id`, table, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e Entry
+		var recordID string
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &recordID, &e.Action, &e.UserID, &e.Reason, &before, &after, &e.at); err != nil {
+			return nil, err
+		}
+		if e.before, err = Decode(before); err != nil {
+			return nil, err
+		}
+		if e.after, err = Decode(after); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = e.at.UTC().Format(time.RFC3339)
+		out[recordID] = append(out[recordID], e)
+	}
+	return out, rows.Err()
+}
+
+// current returns the rows ids of table in API form, keyed by id, leaving out
+// rows deleted by t.
+func (s *Store) current(ctx context.Context, table string, ids []string, t time.Time) (map[string]map[string]interface{}, error) {
+	out := map[string]map[string]interface{}{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	// deleted_at is read through the JSON row so tables without soft delete work too.
+	rows, err := s.pool.Query(ctx, fmt.Sprintf(`
+		SELECT t.id, to_jsonb(t) FROM %s t
+		WHERE t.id = ANY($1)
+		  AND (to_jsonb(t)->>'deleted_at' IS NULL OR (to_jsonb(t)->>'deleted_at')::timestamptz > $2)`, table), ids, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var raw []byte
+		if err := rows.Scan(&id, &raw); err != nil {
+			return nil, err
+		}
+		obj, err := Decode(raw)
+		if err != nil {
+			return nil, err
+		}
+		out[id] = obj
+	}
+	return out, rows.Err()
+}
+
+// Replay applies entries in order and returns the record's state after the
+// last one, or nil when it was deleted. Each entry's Changes is set against
+// the state before it; for the first entry that is its logged before-state.
+func Replay(entries []Entry) map[string]interface{} {
+	var state map[string]interface{}
+	for i := range entries {
+		e := &entries[i]
+		prev := state
+		if i == 0 {
+			prev = e.before
+		}
+		state = apply(prev, e)
+		e.Changes = Diff(prev, state)
+	}
+	return state
+}
+
+// At returns the state of a record at t from its entries: the replay of those
+// logged up to t, or the before-state of the first entry when t precedes them
+// all. ok is false when the record did not exist at t or its state then was
+// never logged.
+func At(entries []Entry, t time.Time) (state map[string]interface{}, ok bool) {
+	n := sort.Search(len(entries), func(i int) bool { return entries[i].at.After(t) })
+	if n == 0 {
+		if len(entries) == 0 || entries[0].Action == "create" || entries[0].before == nil {
+			return nil, false
+		}
+		return clone(entries[0].before), true
+	}
+	state = Replay(entries[:n])
+	return state, state != nil
+}
+
+// apply returns the state of a record after e, given the state before it.
+func apply(state map[string]interface{}, e *Entry) map[string]interface{} {
+	switch e.Action {
+	case "delete", "purge":
+		return nil
+	case "create", "restore":
+		if e.after != nil {
+			return clone(e.after)
+		}
+		return state
+	}
+	// Updates log the whole row, but cascade detaches and entries such as
+	// sync_components log only what they touched, so an entry can only change
+	// fields the record already has.
+	if state == nil {
+		return clone(e.after)
+	}
+	next := clone(state)
+	for k, v := range e.after {
+		if _, ok := next[k]; ok {
+			next[k] = v
+		}
+	}
+	return next
+}
+
+// Diff lists the fields that differ between two states, sorted by name.
+func Diff(before, after map[string]interface{}) []Change {
+	fields := map[string]bool{}
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+	changes := []Change{}
+	for k := range fields {
+		if !reflect.DeepEqual(before[k], after[k]) {
+			changes = append(changes, Change{Field: k, Before: before[k], After: after[k]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// existed reports whether a state was created by t, as far as its createdAt says.
+func existed(state map[string]interface{}, t time.Time) bool {
+	s, _ := state["createdAt"].(string)
+	created, err := time.Parse(time.RFC3339, s)
+	return err != nil || !created.After(t)
+}
+
+// Decode decodes a logged state. Rows logged with row_to_json are converted to
+// the API shape the handlers log: camelCase keys, timestamps as RFC 3339 in
+// UTC, no deleted_at. Anything but a JSON object decodes to nil.
+func Decode(raw []byte) (map[string]interface{}, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	row, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	obj := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		if k == "deleted_at" {
+			continue
+		}
+		if s, ok := v.(string); ok && strings.HasSuffix(k, "_at") {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				v = t.UTC().Format(time.RFC3339)
+			}
+		}
+		obj[response.Camel(k)] = v
+	}
+	return obj, nil
+}
+
+func clone(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+package history
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type row = map[string]interface{}
+
+var t0 = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func entry(action string, minutes int, before, after row) Entry {
+	return Entry{Action: action, at: t0.Add(time.Duration(minutes) * time.Minute), before: before, after: after}
+}
+
+// lifecycle is a rack created, renamed, detached from its tenant by a cascade
+// that logs only that field, deleted and restored.
+func lifecycle() []Entry {
+	created := row{"id": "r1", "name": "A1", "tenantId": "t1"}
+	renamed := row{"id": "r1", "name": "A2", "tenantId": "t1"}
+	detached := row{"id": "r1", "name": "A2", "tenantId": nil}
+	return []Entry{
+		entry("create", 0, nil, created),
+		entry("update", 10, created, renamed),
+		entry("detach", 20, row{"tenantId": "t1"}, row{"tenantId": nil}),
+		entry("delete", 30, detached, nil),
+		entry("restore", 40, nil, detached),
+	}
+}
+
+func TestReplay(t *testing.T) {
+	renamed := row{"id": "r1", "name": "A2", "tenantId": "t1"}
+	detached := row{"id": "r1", "name": "A2", "tenantId": nil}
+	tests := []struct {
+		name    string
+		entries []Entry
+		want    row
+		changes [][]Change
+	}{
+		{"none", nil, nil, nil},
+		{"create and update", lifecycle()[:2], renamed, [][]Change{
+			{{Field: "id", After: "r1"}, {Field: "name", After: "A1"}, {Field: "tenantId", After: "t1"}},
+			{{Field: "name", Before: "A1", After: "A2"}},
+		}},
+		{"partial entry", lifecycle()[:3], detached, [][]Change{
+			nil, nil,
+			{{Field: "tenantId", Before: "t1"}},
+		}},
+		{"deleted", lifecycle()[:4], nil, [][]Change{
+			nil, nil, nil,
+			{{Field: "id", Before: "r1"}, {Field: "name", Before: "A2"}},
+		}},
+		{"restored", lifecycle(), detached, [][]Change{
+			nil, nil, nil, nil,
+			{{Field: "id", After: "r1"}, {Field: "name", After: "A2"}},
+		}},
+		{"history starting with an update", lifecycle()[1:2], renamed, [][]Change{
+			{{Field: "name", Before: "A1", After: "A2"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := tt.entries
+			got := Replay(entries)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Replay = %v, want %v", got, tt.want)
+			}
+			for i, want := range tt.changes {
+				if want != nil && !reflect.DeepEqual(entries[i].Changes, want) {
+					t.Errorf("entry %d Changes = %+v, want %+v", i, entries[i].Changes, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAt(t *testing.T) {
+	updatedOnly := []Entry{entry("update", 10, row{"name": "A1"}, row{"name": "A2"})}
+	tests := []struct {
+		name    string
+		entries []Entry
+		minutes int
+		want    row
+		ok      bool
+	}{
+		{"no history", nil, 0, nil, false},
+		{"before it was created", lifecycle(), -1, nil, false},
+		{"at creation", lifecycle(), 0, row{"id": "r1", "name": "A1", "tenantId": "t1"}, true},
+		{"between entries", lifecycle(), 15, row{"id": "r1", "name": "A2", "tenantId": "t1"}, true},
+		{"after a partial entry", lifecycle(), 25, row{"id": "r1", "name": "A2", "tenantId": nil}, true},
+		{"while deleted", lifecycle(), 35, nil, false},
+		{"after the restore", lifecycle(), 50, row{"id": "r1", "name": "A2", "tenantId": nil}, true},
+		{"before the first logged update", updatedOnly, 5, row{"name": "A1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := At(tt.entries, t0.Add(time.Duration(tt.minutes)*time.Minute))
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("At = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAtDoesNotAlias(t *testing.T) {
+	entries := []Entry{entry("update", 10, row{"name": "A1"}, row{"name": "A2"})}
+	state, _ := At(entries, t0)
+	state["name"] = "changed"
+	if entries[0].before["name"] != "A1" {
+		t.Error("At returned the logged before-state itself")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want row
+	}{
+		{"null", "null", nil},
+		{"not an object", `[1]`, nil},
+		{"api shape", `{"id":"r1","uHeight":42}`, row{"id": "r1", "uHeight": json.Number("42")}},
+		{"row_to_json", `{"id":"r1","u_height":42,"created_at":"2026-03-01T13:00:00.123456+01:00","deleted_at":null}`,
+			row{"id": "r1", "uHeight": json.Number("42"), "createdAt": "2026-03-01T12:00:00Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode([]byte(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dcim/go-services/internal/shared/audit"
+)
+
+// Actor returns a middleware that puts the identity the BFF forwards into the
+// request context for audit.LogEntry: the x-user-id, x-user-ip and x-user-agent
+// headers and an optional x-audit-reason. Without the forwarded headers the
+// user agent of the request itself and the address proxies.ClientIP finds are
+// used.
+func Actor(proxies Proxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			a := audit.Actor{
+				UserID:     r.Header.Get("x-user-id"),
+				IP:         r.Header.Get("x-user-ip"),
+				UserAgent:  r.Header.Get("x-user-agent"),
+				Reason:     r.Header.Get("x-audit-reason"),
+				ActionType: "api_call",
+			}
+			if a.IP == "" {
+				a.IP = proxies.ClientIP(r)
+			}
+			if a.UserAgent == "" {
+				a.UserAgent = r.UserAgent()
+			}
+			next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), a)))
+		})
+	}
+}
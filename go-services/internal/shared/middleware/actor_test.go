@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcim/go-services/internal/shared/audit"
+)
+
+func TestActor(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, remote string
+		headers      map[string]string
+		want         audit.Actor
+	}{
+		{"forwarded by the BFF", "10.0.0.5:5000",
+			map[string]string{"x-user-id": "u1", "x-user-ip": "198.51.100.1", "x-user-agent": "browser", "x-audit-reason": "move"},
+			audit.Actor{UserID: "u1", IP: "198.51.100.1", UserAgent: "browser", Reason: "move", ActionType: "api_call"}},
+		{"through a trusted proxy", "10.0.0.5:5000",
+			map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1", "User-Agent": "curl"},
+			audit.Actor{IP: "198.51.100.1", UserAgent: "curl", ActionType: "api_call"}},
+		{"forged XFF", "203.0.113.7:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1", "User-Agent": "curl"},
+			audit.Actor{IP: "203.0.113.7", UserAgent: "curl", ActionType: "api_call"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got audit.Actor
+			h := Actor(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = audit.ActorFrom(r.Context())
+			}))
+			r := httptest.NewRequest("GET", "/devices", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("actor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,16 @@ package middleware
 
 import (
 	"crypto/subtle"
+	"errors"
+	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/dcim/go-services/internal/shared/apitoken"
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/rbac"
 )
 
 // InternalSecret returns a middleware that validates the x-internal-secret header.
@@ -19,3 +28,140 @@ func InternalSecret(secret string) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// Auth returns a middleware that admits the BFF by the x-internal-secret
+// header, like InternalSecret, and other clients by an API token sent as
+// "Authorization: Bearer dcim_...". A token must have a scope for the first
+// path segment of the request, e.g. "devices:read" for GET /devices/{id};
+// methods other than GET and HEAD need write. Token requests are audited as
+// the token's owner, whatever identity headers they send, from the address
+// proxies.ClientIP finds.
+//
+// Either way the request is then authorized by the roles of its user, see
+// Authorize, so a token never grants more than its owner may do.
+func Auth(secret string, tokens *apitoken.Store, roles *rbac.Store, proxies Proxies) func(http.Handler) http.Handler {
+	bff := InternalSecret(secret)
+	authorize := Authorize(roles)
+	return func(next http.Handler) http.Handler {
+		authorized := authorize(next)
+		internal := bff(authorized)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || r.Header.Get("x-internal-secret") != "" {
+				internal.ServeHTTP(w, r)
+				return
+			}
+
+			ip := proxies.ClientIP(r)
+			t, err := tokens.Authenticate(r.Context(), strings.TrimSpace(bearer), ip)
+			switch {
+			case errors.Is(err, apitoken.ErrInvalid), errors.Is(err, apitoken.ErrExpired):
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			case errors.Is(err, apitoken.ErrIP):
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			case err != nil:
+				log.Printf("api token error: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			resource := resourceOf(r)
+			write := r.Method != http.MethodGet && r.Method != http.MethodHead
+			if !t.Allows(resource, write) {
+				access := "read"
+				if write {
+					access = "write"
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+resource+":"+access+`"`)
+				http.Error(w, "API token lacks scope "+resource+":"+access, http.StatusForbidden)
+				return
+			}
+
+			a := audit.ActorFrom(r.Context())
+			a.UserID = t.OwnerID
+			a.IP = ip
+			a.UserAgent = r.UserAgent()
+			a.ActionType = "api_call"
+			ctx := apitoken.WithToken(audit.WithActor(r.Context(), a), t)
+			authorized.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// resourceOf returns the first path segment of r, the resource a token scope
+// or role permission names.
+func resourceOf(r *http.Request) string {
+	first, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return first
+}
+
+// Proxies are the networks of the reverse proxies in front of a service, whose
+// X-Forwarded-For hops are believed.
+type Proxies []netip.Prefix
+
+// ParseProxies parses a comma-separated list of CIDRs and addresses, as set in
+// TRUSTED_PROXIES. An empty list trusts no proxy.
+func ParseProxies(list string) (Proxies, error) {
+	var p Proxies
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			p = append(p, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, prefix.Masked())
+	}
+	return p, nil
+}
+
+func (p Proxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address a request came from: the peer address, unless
+// the peer is a trusted proxy, in which case X-Forwarded-For is walked from the
+// nearest hop back to the first address that is not a trusted proxy. Hops
+// further out were chosen by the client and are never used.
+func (p Proxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !p.trusts(peer) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(hop)
+		if err != nil || !p.trusts(addr) {
+			return hop
+		}
+		peer = addr
+	}
+	return peer.Unmap().String()
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseProxies(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{" , ", 0, false},
+		{"10.0.0.0/8", 1, false},
+		{"10.0.0.0/8, 192.0.2.1 ,2001:db8::/32", 3, false},
+		{"10.0.0.0/33", 0, true},
+		{"proxy.internal", 0, true},
+	}
+	for _, tt := range tests {
+		p, err := ParseProxies(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseProxies(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if len(p) != tt.want {
+			t.Errorf("ParseProxies(%q) = %v, want %d prefixes", tt.list, p, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		proxies Proxies
+		remote  string
+		xff     []string
+		want    string
+	}{
+		{"direct", proxies, "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer sends XFF", proxies, "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"no proxies configured", nil, "10.0.0.5:5000", []string{"198.51.100.1"}, "10.0.0.5"},
+		{"one proxy", proxies, "10.0.0.5:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"client-chosen hop ignored", proxies, "10.0.0.5:5000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", proxies, "10.0.0.5:5000", []string{"1.2.3.4, 198.51.100.1, 192.0.2.1"}, "198.51.100.1"},
+		{"repeated headers", proxies, "10.0.0.5:5000", []string{"1.2.3.4", "198.51.100.1", "10.1.1.1"}, "198.51.100.1"},
+		{"only proxies", proxies, "10.0.0.5:5000", []string{"10.2.2.2"}, "10.2.2.2"},
+		{"proxy without XFF", proxies, "10.0.0.5:5000", nil, "10.0.0.5"},
+		{"garbage hop", proxies, "10.0.0.5:5000", []string{"198.51.100.1, bogus"}, "bogus"},
+		{"mapped peer", proxies, "[::ffff:10.0.0.5]:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"ipv6 client", proxies, "10.0.0.5:5000", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/devices", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := tt.proxies.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,7 +9,8 @@ func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, x-internal-secret")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, x-internal-secret, If-Match, If-None-Match, Last-Event-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Link, X-Total-Count")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
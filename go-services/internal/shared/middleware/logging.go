@@ -17,6 +17,16 @@ func (rc *responseCapture) WriteHeader(code int) {
 	rc.ResponseWriter.WriteHeader(code)
 }
 
+// Flush lets streaming handlers such as the SSE feeds flush through the capture.
+func (rc *responseCapture) Flush() {
+	_ = http.NewResponseController(rc.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController.
+func (rc *responseCapture) Unwrap() http.ResponseWriter {
+	return rc.ResponseWriter
+}
+
 // Logging returns a middleware that logs each request with method, path, status, and duration.
 func Logging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/rbac"
+)
+
+// Roles loads the access of users and places rows at sites and tenants;
+// *rbac.Store implements it.
+type Roles interface {
+	Load(ctx context.Context, userID string) (*rbac.Access, error)
+	PermitsRow(ctx context.Context, resource, id string, s rbac.Scope) (bool, error)
+	PermitsWrite(ctx context.Context, resource, id string, refs rbac.Refs, s rbac.Scope) (bool, error)
+}
+
+// Authorize returns a middleware that enforces the roles of the request's
+// user: the x-user-id the BFF forwards, or the owner of its API token. The
+// user needs a permission for the request's resource, its first path segment,
+// and action: GET reads, POST creates, PATCH updates and DELETE deletes.
+//
+// A permission scoped to sites or tenants covers only the rows placed there.
+// The row of an {id} route is checked here, as are the siteId, locationId,
+// rackId, deviceId and tenantId a write body places a row with; list routes
+// filter their rows through crud.ParseQuery. Other routes of a resource need
+// an unscoped permission. The user's access is put in the request context.
+func Authorize(roles Roles) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			userID := audit.ActorFrom(ctx).UserID
+			if userID == "" {
+				http.Error(w, "Forbidden: no user to authorize", http.StatusForbidden)
+				return
+			}
+			access, err := roles.Load(ctx, userID)
+			if err != nil {
+				log.Printf("rbac load error: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			resource, action := resourceOf(r), rbac.Action(r.Method)
+			denied := "Forbidden: " + action + " " + resource + " is not permitted"
+			s, ok := access.Scope(resource, action)
+			if !ok {
+				http.Error(w, denied, http.StatusForbidden)
+				return
+			}
+
+			if !s.All && resource != rbac.Feed {
+				id := r.PathValue("id")
+				var permitted bool
+				switch {
+				case action == "read" && id == "":
+					permitted = isList(r, resource)
+				case action == "read" || action == "delete":
+					permitted, err = roles.PermitsRow(ctx, resource, id, s)
+				default:
+					var refs rbac.Refs
+					if refs, err = peekRefs(r); err == nil && (id != "" || refs != rbac.Refs{}) {
+						permitted, err = roles.PermitsWrite(ctx, resource, id, refs, s)
+					}
+				}
+				if err != nil {
+					log.Printf("rbac check error: %v", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				if !permitted {
+					http.Error(w, denied+" here", http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(rbac.WithAccess(ctx, access)))
+		})
+	}
+}
+
+// isList reports whether r is routed to a list of resource, which filters its
+// rows by the user's scope: GET /resource or GET /resource/trash.
+func isList(r *http.Request, resource string) bool {
+	_, path, _ := strings.Cut(r.Pattern, " ")
+	return path == "/"+resource || path == "/"+resource+"/trash"
+}
+
+// peekRefs reads the references of a JSON object body and leaves the body
+// for the handler. Bodies that are not JSON objects have none.
+func peekRefs(r *http.Request) (rbac.Refs, error) {
+	if r.Body == nil {
+		return rbac.Refs{}, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return rbac.Refs{}, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var refs struct {
+		SiteID     string `json:"siteId"`
+		LocationID string `json:"locationId"`
+		RackID     string `json:"rackId"`
+		DeviceID   string `json:"deviceId"`
+		TenantID   string `json:"tenantId"`
+	}
+	_ = json.Unmarshal(body, &refs)
+	return rbac.Refs(refs), nil
+}
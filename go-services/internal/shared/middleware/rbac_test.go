@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/rbac"
+)
+
+// fakeRoles places device d1 at site s1 and d2 at s2, and rack r2 at s2.
+type fakeRoles struct {
+	access map[string]*rbac.Access
+}
+
+var fakeSites = map[string]string{"d1": "s1", "d2": "s2", "r2": "s2"}
+
+func (f fakeRoles) Load(ctx context.Context, userID string) (*rbac.Access, error) {
+	a, ok := f.access[userID]
+	if !ok {
+		return nil, errors.New("database down")
+	}
+	return a, nil
+}
+
+func (f fakeRoles) PermitsRow(ctx context.Context, resource, id string, s rbac.Scope) (bool, error) {
+	return s.All || slices.Contains(s.Sites, fakeSites[id]), nil
+}
+
+func (f fakeRoles) PermitsWrite(ctx context.Context, resource, id string, refs rbac.Refs, s rbac.Scope) (bool, error) {
+	if id != "" && !slices.Contains(s.Sites, fakeSites[id]) {
+		return false, nil
+	}
+	return refs.RackID == "" || slices.Contains(s.Sites, fakeSites[refs.RackID]), nil
+}
+
+func TestAuthorize(t *testing.T) {
+	viewer := &rbac.Access{UserID: "viewer"}
+	for _, p := range rbac.Builtin["viewer"] {
+		viewer.Grant(p, "", "")
+	}
+	scoped := &rbac.Access{UserID: "scoped"}
+	scoped.Grant("devices:*", "s1", "")
+	scoped.Grant("events:read", "s1", "")
+	roles := fakeRoles{access: map[string]*rbac.Access{"viewer": viewer, "scoped": scoped}}
+
+	var seen *rbac.Access
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = rbac.FromContext(r.Context())
+	})
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"GET /devices", "GET /devices/trash", "GET /devices/summary", "GET /devices/{id}",
+		"POST /devices", "PATCH /devices/{id}", "DELETE /devices/{id}", "GET /events", "GET /sites"} {
+		mux.Handle(pattern, Authorize(roles)(ok))
+	}
+
+	tests := []struct {
+		name, user, method, path, body string
+		want                           int
+	}{
+		{"no user", "", "GET", "/devices", "", http.StatusForbidden},
+		{"load error", "ghost", "GET", "/devices", "", http.StatusInternalServerError},
+		{"viewer reads", "viewer", "GET", "/devices/d2", "", http.StatusOK},
+		{"viewer cannot create", "viewer", "POST", "/devices", `{"rackId":"r2"}`, http.StatusForbidden},
+		{"scoped list", "scoped", "GET", "/devices", "", http.StatusOK},
+		{"scoped trash list", "scoped", "GET", "/devices/trash", "", http.StatusOK},
+		{"scoped non-list route", "scoped", "GET", "/devices/summary", "", http.StatusForbidden},
+		{"scoped row at its site", "scoped", "GET", "/devices/d1", "", http.StatusOK},
+		{"scoped row elsewhere", "scoped", "GET", "/devices/d2", "", http.StatusForbidden},
+		{"scoped delete elsewhere", "scoped", "DELETE", "/devices/d2", "", http.StatusForbidden},
+		{"scoped update at its site", "scoped", "PATCH", "/devices/d1", `{"name":"x"}`, http.StatusOK},
+		{"scoped move to another site", "scoped", "PATCH", "/devices/d1", `{"rackId":"r2"}`, http.StatusForbidden},
+		{"scoped create unplaced", "scoped", "POST", "/devices", `{"name":"x"}`, http.StatusForbidden},
+		{"scoped create elsewhere", "scoped", "POST", "/devices", `{"rackId":"r2"}`, http.StatusForbidden},
+		{"scoped feed", "scoped", "GET", "/events", "", http.StatusOK},
+		{"scoped other resource", "scoped", "GET", "/sites", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r = r.WithContext(audit.WithActor(r.Context(), audit.Actor{UserID: tt.user}))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusOK && (seen == nil || seen.UserID != tt.user) {
+				t.Errorf("handler saw access %+v, want that of %s", seen, tt.user)
+			}
+		})
+	}
+}
+
+func TestIsList(t *testing.T) {
+	tests := []struct {
+		pattern, resource string
+		want              bool
+	}{
+		{"GET /devices", "devices", true},
+		{"GET /devices/trash", "devices", true},
+		{"GET /devices/{id}", "devices", false},
+		{"GET /devices/summary", "devices", false},
+		{"GET /device-types", "devices", false},
+		{"", "devices", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Pattern = tt.pattern
+		if got := isList(r, tt.resource); got != tt.want {
+			t.Errorf("isList(%q, %q) = %v, want %v", tt.pattern, tt.resource, got, tt.want)
+		}
+	}
+}
+
+func TestPeekRefs(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       rbac.Refs
+	}{
+		{"refs", `{"name":"x","siteId":"s1","rackId":"r1","tenantId":"t1"}`, rbac.Refs{SiteID: "s1", RackID: "r1", TenantID: "t1"}},
+		{"device and location", `{"deviceId":"d1","locationId":"l1"}`, rbac.Refs{DeviceID: "d1", LocationID: "l1"}},
+		{"no refs", `{"name":"x"}`, rbac.Refs{}},
+		{"array", `[{"siteId":"s1"}]`, rbac.Refs{}},
+		{"not JSON", `siteId=s1`, rbac.Refs{}},
+		{"empty", ``, rbac.Refs{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/devices", strings.NewReader(tt.body))
+			got, err := peekRefs(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("peekRefs = %+v, want %+v", got, tt.want)
+			}
+			rest, _ := io.ReadAll(r.Body)
+			if string(rest) != tt.body {
+				t.Errorf("body left for the handler = %q, want %q", rest, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Delivery defaults.
+const (
+	MaxAttempts = 10               // failed attempts before a delivery is dead-lettered
+	BaseDelay   = 30 * time.Second // delay after the first failure, doubled after each further one
+	MaxDelay    = 6 * time.Hour
+	BatchSize   = 50              // deliveries claimed per poll
+	lease       = 2 * time.Minute // a claimed delivery is retried after this if its dispatcher dies
+	respLimit   = 512             // bytes of a failed response kept in last_error
+)
+
+// Dispatcher delivers pending webhook deliveries. Several dispatchers, e.g.
+// one per service instance, can poll the same database: each claims its own
+// batch.
+type Dispatcher struct {
+	pool   *pgxpool.Pool
+	client *http.Client
+}
+
+// NewDispatcher returns a Dispatcher delivering from pool.
+func NewDispatcher(pool *pgxpool.Pool) *Dispatcher {
+	return &Dispatcher{pool: pool, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Start polls for due deliveries every interval until ctx is done.
+func (d *Dispatcher) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Drain full batches before waiting for the next tick.
+				for {
+					n, err := d.RunOnce(ctx)
+					if err != nil {
+						log.Printf("webhook dispatch error: %v", err)
+					}
+					if err != nil || n < BatchSize {
+						break
+					}
+				}
+			}
+		}
+	}()
+}
+
+type delivery struct {
+	id         string
+	attempts   int
+	url        string
+	secret     string
+	eventID    string
+	resource   string
+	action     string
+	recordID   string
+	data       json.RawMessage
+	occurredAt time.Time
+}
+
+// message is the body POSTed to a webhook.
+type message struct {
+	ID         string          `json:"id"` // event id; the same across retries and replays
+	DeliveryID string          `json:"deliveryId"`
+	Event      string          `json:"event"` // "<resource>.<action>", e.g. "devices.update"
+	Resource   string          `json:"resource"`
+	Action     string          `json:"action"`
+	RecordID   string          `json:"recordId"`
+	OccurredAt string          `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// RunOnce claims a batch of due deliveries and attempts each, returning how
+// many were claimed.
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	rows, err := d.pool.Query(ctx, `
+		WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		), claimed AS (
+			UPDATE webhook_deliveries wd SET next_attempt_at = now() + make_interval(secs => $2)
+			FROM due WHERE wd.id = due.id
+			RETURNING wd.id, wd.attempts, wd.webhook_id, wd.event_id
+		)
+		SELECT c.id, c.attempts, w.url, w.secret, e.id, e.resource, e.action, e.record_id, e.payload, e.created_at
+		FROM claimed c
+		JOIN webhooks w ON w.id = c.webhook_id
+		JOIN outbox_events e ON e.id = c.event_id`, BatchSize, lease.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	var batch []delivery
+	for rows.Next() {
+		var dl delivery
+		if err := rows.Scan(&dl.id, &dl.attempts, &dl.url, &dl.secret, &dl.eventID, &dl.resource,
+			&dl.action, &dl.recordID, &dl.data, &dl.occurredAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, dl)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, dl := range batch {
+		status, err := d.send(ctx, dl)
+		if err := d.record(ctx, dl, status, err); err != nil {
+			return len(batch), err
+		}
+	}
+	return len(batch), nil
+}
+
+// send POSTs one delivery, returning the response status. A non-2xx status is an error.
+func (d *Dispatcher) send(ctx context.Context, dl delivery) (int, error) {
+	body, err := json.Marshal(message{
+		ID:         dl.eventID,
+		DeliveryID: dl.id,
+		Event:      dl.resource + "." + dl.action,
+		Resource:   dl.resource,
+		Action:     dl.action,
+		RecordID:   dl.recordID,
+		OccurredAt: dl.occurredAt.UTC().Format(time.RFC3339),
+		Data:       dl.data,
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", dl.resource+"."+dl.action)
+	req.Header.Set("X-Webhook-Delivery", dl.id)
+	req.Header.Set("X-Webhook-Signature", Sign(dl.secret, time.Now().Unix(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, respLimit))
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+	return resp.StatusCode, nil
+}
+
+// record stores the outcome of an attempt: delivered, retried after a backoff,
+// or dead-lettered after MaxAttempts failures.
+func (d *Dispatcher) record(ctx context.Context, dl delivery, status int, sendErr error) error {
+	code := nullableStatus(status)
+	if sendErr == nil {
+		_, err := d.pool.Exec(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'delivered', attempts = attempts + 1, last_status_code = $2, last_error = NULL,
+			    delivered_at = now(), updated_at = now()
+			WHERE id = $1`, dl.id, code)
+		return err
+	}
+	attempts := dl.attempts + 1
+	next := "pending"
+	if attempts >= MaxAttempts {
+		next = "dead"
+		log.Printf("webhook delivery %s dead after %d attempts: %v", dl.id, attempts, sendErr)
+	}
+	_, err := d.pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, last_status_code = $4, last_error = $5,
+		    next_attempt_at = now() + make_interval(secs => $6), updated_at = now()
+		WHERE id = $1`, dl.id, next, attempts, code, sendErr.Error(), Backoff(attempts).Seconds())
+	return err
+}
+
+// Backoff returns the delay before the attempt following the given number of
+// failed ones: BaseDelay doubled per failure, capped at MaxDelay.
+func Backoff(failures int) time.Duration {
+	delay := BaseDelay
+	for i := 1; i < failures && delay < MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxDelay {
+		delay = MaxDelay
+	}
+	return delay
+}
+
+func nullableStatus(status int) interface{} {
+	if status == 0 {
+		return nil
+	}
+	return status
+}
@@ -0,0 +1,167 @@
+package outbox
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Retention is how long events are kept for change feed replay. Events with
+// deliveries still pending or dead-lettered are kept until those are resolved.
+const Retention = 7 * 24 * time.Hour
+
+// internal lists tables whose changes are not published: webhook
+// subscriptions carry their signing secrets.
+var internal = map[string]bool{"webhooks": true}
+
+// Event is an audited change to publish.
+type Event struct {
+	Resource   string // table name, e.g. "devices"
+	Action     string // audit action: "create", "update", "delete", ...
+	RecordID   string
+	AuditLogID string
+	ActorID    *string
+	Before     *string // JSON, nil when absent
+	After      *string
+	Reason     *string
+}
+
+// payload is the "data" member of a delivered event.
+type payload struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+	Actor  *string         `json:"actor"`
+	Reason *string         `json:"reason"`
+}
+
+// Enqueue writes e to outbox_events within tx, together with a pending
+// delivery for every active webhook subscribed to its resource and action.
+func Enqueue(ctx context.Context, tx pgx.Tx, e Event) error {
+	if internal[e.Resource] {
+		return nil
+	}
+	body, err := json.Marshal(payload{Before: raw(e.Before), After: raw(e.After), Actor: e.ActorID, Reason: e.Reason})
+	if err != nil {
+		return err
+	}
+	// The sites and racks the change touched, before and after it. Sites are
+	// resolved through locations and racks for rows that only reference those.
+	b, a := scopeOf(e.Resource, e.RecordID, e.Before), scopeOf(e.Resource, e.RecordID, e.After)
+	var id string
+	err = tx.QueryRow(ctx, `
+		WITH refs (site_id, location_id, rack_id) AS (
+			VALUES ($7::text, $8::text, $9::text), ($10::text, $11::text, $12::text)
+		)
+		INSERT INTO outbox_events (id, resource, action, record_id, audit_log_id, actor_id, payload, site_ids, rack_ids)
+		SELECT gen_random_uuid()::text, $1, $2, $3, $4, $5, $6,
+			ARRAY(SELECT DISTINCT s FROM (
+				SELECT COALESCE(r.site_id,
+					(SELECT l.site_id FROM locations l WHERE l.id = r.location_id),
+					(SELECT l.site_id FROM racks k JOIN locations l ON l.id = k.location_id WHERE k.id = r.rack_id)) AS s
+				FROM refs r) x WHERE s IS NOT NULL),
+			ARRAY(SELECT DISTINCT rack_id FROM refs WHERE rack_id IS NOT NULL)
+		RETURNING id`,
+		e.Resource, e.Action, e.RecordID, e.AuditLogID, e.ActorID, body,
+		b.site, b.location, b.rack, a.site, a.location, a.rack).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("insert outbox event: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO webhook_deliveries (id, webhook_id, event_id)
+		SELECT gen_random_uuid()::text, w.id, $1 FROM webhooks w
+		WHERE w.active`+Matches("w", "$2", "$3"), id, e.Resource, e.Action)
+	if err != nil {
+		return fmt.Errorf("insert webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// StartPruner deletes events older than retention every interval until ctx is done.
+func StartPruner(ctx context.Context, pool *pgxpool.Pool, retention, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				tag, err := pool.Exec(ctx, `
+					DELETE FROM outbox_events e
+					WHERE e.created_at < now() - make_interval(secs => $1)
+					  AND NOT EXISTS (
+						SELECT 1 FROM webhook_deliveries d WHERE d.event_id = e.id AND d.status <> 'delivered')`,
+					retention.Seconds())
+				if err != nil {
+					log.Printf("outbox prune error: %v", err)
+				} else if n := tag.RowsAffected(); n > 0 {
+					log.Printf("outbox prune: %d events deleted", n)
+				}
+			}
+		}
+	}()
+}
+
+// Matches returns the SQL condition, starting with AND, that the webhook
+// aliased w subscribes to the resource and action placeholders. An empty
+// resources or actions list subscribes to all.
+func Matches(w, resource, action string) string {
+	return fmt.Sprintf(`
+		  AND (jsonb_array_length(%[1]s.resources) = 0 OR %[1]s.resources ? %[2]s)
+		  AND (jsonb_array_length(%[1]s.actions) = 0 OR %[1]s.actions ? %[3]s)`, w, resource, action)
+}
+
+// Sign returns the X-Webhook-Signature value for a body sent at unix time ts:
+// "t=<ts>,v1=<hex HMAC-SHA256 of "<ts>.<body>" keyed with the webhook secret>".
+// Receivers recompute it and should reject stale timestamps.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// scope holds the site, location and rack a state belongs to, each nil when unknown.
+type scope struct {
+	site, location, rack *string
+}
+
+// scopeOf reads the scope of a logged state of a resource row, in API or
+// row_to_json form. A site, location or rack is its own scope.
+func scopeOf(resource, recordID string, state *string) scope {
+	var row map[string]interface{}
+	if state != nil {
+		_ = json.Unmarshal([]byte(*state), &row)
+	}
+	ref := func(self string, keys ...string) *string {
+		if resource == self {
+			return &recordID
+		}
+		for _, k := range keys {
+			if s, ok := row[k].(string); ok && s != "" {
+				return &s
+			}
+		}
+		return nil
+	}
+	return scope{
+		site:     ref("sites", "siteId", "site_id"),
+		location: ref("locations", "locationId", "location_id"),
+		rack:     ref("racks", "rackId", "rack_id"),
+	}
+}
+
+func raw(s *string) json.RawMessage {
+	if s == nil {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(*s)
+}
@@ -0,0 +1,372 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/dcim/go-services/internal/shared/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Permissions are "<resource>:<action>" strings. The resource is the first
+// path segment of a route, as for API token scopes ("devices", "audit-logs"),
+// and the action one of read, create, update and delete; "*" matches any.
+var permissionPattern = regexp.MustCompile(`^(\*|[a-z][a-z0-9-]*):(\*|read|create|update|delete)$`)
+
+// CheckPermission returns an error unless p is a valid permission.
+func CheckPermission(p string) error {
+	if !permissionPattern.MatchString(p) {
+		return fmt.Errorf("%q is not <resource>:<read|create|update|delete|*>", p)
+	}
+	return nil
+}
+
+// Action returns the action a request method performs.
+func Action(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "read"
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut, http.MethodPatch:
+		return "update"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return method
+}
+
+// Built-in roles, granted everywhere to the users whose users.role names them.
+// They follow the permission matrix of the web app (lib/auth/rbac.ts);
+// tenant-scoped role assignments can grant tenant_viewer users more.
+var (
+	inventory = []string{"sites", "regions", "locations", "racks", "devices", "device-types", "manufacturers",
+		"tenants", "custom-fields", "dashboard", "events", "cables", "interfaces", "console-ports", "front-ports",
+		"rear-ports", "access-logs", "equipment-movements", "alerts", "reports", "panels", "feeds", "readings",
+		"sse", "summary", "export"}
+	operated = []string{"locations", "racks", "devices", "cables", "interfaces", "console-ports", "front-ports",
+		"rear-ports", "access-logs", "equipment-movements"}
+
+	Builtin = map[string][]string{
+		"admin": {"*:*"},
+		"operator": join(
+			each(inventory, "read"), each(operated, "*"), each([]string{"audit-logs"}, "read"),
+			[]string{"alerts:create", "alerts:update", "reports:create", "import:create", "api-tokens:*"}),
+		"viewer":        join(each(inventory, "read"), []string{"reports:create", "api-tokens:*"}),
+		"tenant_viewer": each([]string{"sites", "racks", "devices", "readings", "reports", "alerts"}, "read"),
+	}
+)
+
+func each(resources []string, action string) []string {
+	out := make([]string, len(resources))
+	for i, r := range resources {
+		out[i] = r + ":" + action
+	}
+	return out
+}
+
+func join(lists ...[]string) []string {
+	var out []string
+	for _, l := range lists {
+		out = append(out, l...)
+	}
+	return out
+}
+
+// table is how the rows of a site-aware resource belong to sites and
+// tenants: SQL expressions of the site and tenant id of a row, given the
+// table's name or alias as %[1]s. Either may be empty when rows have none.
+type table struct {
+	name, site, tenant string
+}
+
+func viaLocation(col string) string {
+	return `(SELECT l.site_id FROM locations l WHERE l.id = ` + col + `)`
+}
+
+func viaRack(col string) string {
+	return `(SELECT l.site_id FROM racks k JOIN locations l ON l.id = k.location_id WHERE k.id = ` + col + `)`
+}
+
+func viaDevice(col string) string {
+	return `(SELECT l.site_id FROM devices d JOIN racks k ON k.id = d.rack_id JOIN locations l ON l.id = k.location_id WHERE d.id = ` + col + `)`
+}
+
+const (
+	siteTenant   = `(SELECT s.tenant_id FROM sites s WHERE s.id = %[1]s.site_id)`
+	deviceTenant = `(SELECT d.tenant_id FROM devices d WHERE d.id = %[1]s.device_id)`
+)
+
+// tables lists the site-aware resources. Site- and tenant-scoped grants only
+// apply to these; other resources need a grant without scope.
+var tables = map[string]table{
+	"sites":               {"sites", `%[1]s.id`, `%[1]s.tenant_id`},
+	"locations":           {"locations", `%[1]s.site_id`, `%[1]s.tenant_id`},
+	"racks":               {"racks", viaLocation(`%[1]s.location_id`), `%[1]s.tenant_id`},
+	"devices":             {"devices", viaRack(`%[1]s.rack_id`), `%[1]s.tenant_id`},
+	"interfaces":          {"interfaces", viaDevice(`%[1]s.device_id`), deviceTenant},
+	"console-ports":       {"console_ports", viaDevice(`%[1]s.device_id`), deviceTenant},
+	"front-ports":         {"front_ports", viaDevice(`%[1]s.device_id`), deviceTenant},
+	"rear-ports":          {"rear_ports", viaDevice(`%[1]s.device_id`), deviceTenant},
+	"cables":              {"cables", "", `%[1]s.tenant_id`},
+	"access-logs":         {"access_logs", `%[1]s.site_id`, siteTenant},
+	"equipment-movements": {"equipment_movements", `%[1]s.site_id`, siteTenant},
+	"panels":              {"power_panels", `%[1]s.site_id`, siteTenant},
+	"feeds":               {"power_feeds", viaRack(`%[1]s.rack_id`), `(SELECT k.tenant_id FROM racks k WHERE k.id = %[1]s.rack_id)`},
+}
+
+// Feed is the site-aware resource without a table: the change feed, whose
+// events carry the sites they touched.
+const Feed = "events"
+
+// grant is one permission, everywhere or only at a site or for a tenant.
+type grant struct {
+	resource, action string
+	siteID, tenantID string // both empty: everywhere
+}
+
+// Access is what a user may do: the grants of their built-in role and of
+// their role assignments.
+type Access struct {
+	UserID string
+	Role   string // users.role
+	grants []grant
+}
+
+// Scope is where a user may perform an action on a resource: everywhere, or
+// at the rows of some sites and tenants.
+type Scope struct {
+	All     bool
+	Sites   []string
+	Tenants []string
+}
+
+// Scope returns where a may perform action on resource. ok is false when it
+// may not at all. Scoped grants count only for site-aware resources.
+func (a *Access) Scope(resource, action string) (s Scope, ok bool) {
+	_, aware := tables[resource]
+	aware = aware || resource == Feed
+	for _, g := range a.grants {
+		if (g.resource != "*" && g.resource != resource) || (g.action != "*" && g.action != action) {
+			continue
+		}
+		switch {
+		case g.siteID == "" && g.tenantID == "":
+			return Scope{All: true}, true
+		case !aware:
+		case g.siteID != "":
+			s.Sites = append(s.Sites, g.siteID)
+			ok = true
+		default:
+			s.Tenants = append(s.Tenants, g.tenantID)
+			ok = true
+		}
+	}
+	return s, ok
+}
+
+// Everywhere reports whether a may perform action on resource without scope.
+func (a *Access) Everywhere(resource, action string) bool {
+	s, _ := a.Scope(resource, action)
+	return s.All
+}
+
+func (s Scope) permits(site, tenant string) bool {
+	return s.All || (site != "" && contains(s.Sites, site)) || (tenant != "" && contains(s.Tenants, tenant))
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Store loads access from users, roles and role_assignments.
+type Store struct {
+	pool *pgxpool.Pool
+}
+
+// NewStore returns a Store reading from pool.
+func NewStore(pool *pgxpool.Pool) *Store {
+	return &Store{pool: pool}
+}
+
+// Load returns the access of userID. A user ID that names no user has none.
+func (s *Store) Load(ctx context.Context, userID string) (*Access, error) {
+	a := &Access{UserID: userID}
+	err := s.pool.QueryRow(ctx, `SELECT role::text FROM users WHERE id = $1`, userID).Scan(&a.Role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range Builtin[a.Role] {
+		a.Grant(p, "", "")
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT r.permissions, COALESCE(a.site_id, ''), COALESCE(a.tenant_id, '')
+		FROM role_assignments a JOIN roles r ON r.id = a.role_id
+		WHERE a.user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var perms []string
+		var site, tenant string
+		if err := rows.Scan(&perms, &site, &tenant); err != nil {
+			return nil, err
+		}
+		for _, p := range perms {
+			a.Grant(p, site, tenant)
+		}
+	}
+	return a, rows.Err()
+}
+
+// Grant adds permission p to a, at site or for tenant when one is given and
+// everywhere otherwise.
+func (a *Access) Grant(p, site, tenant string) {
+	resource, action, _ := strings.Cut(p, ":")
+	a.grants = append(a.grants, grant{resource: resource, action: action, siteID: site, tenantID: tenant})
+}
+
+// Refs are the references of a request body that place a row at a site or
+// with a tenant, e.g. the rackId of a device. Empty when absent.
+type Refs struct {
+	SiteID, LocationID, RackID, DeviceID, TenantID string
+}
+
+// placed reports whether r places a row at a site.
+func (r Refs) placed() bool {
+	return r.SiteID != "" || r.LocationID != "" || r.RackID != "" || r.DeviceID != ""
+}
+
+// PermitsRow reports whether s covers the row id of resource. A row that
+// does not exist is not covered.
+func (st *Store) PermitsRow(ctx context.Context, resource, id string, s Scope) (bool, error) {
+	if s.All {
+		return true, nil
+	}
+	site, tenant, err := st.row(ctx, resource, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return s.permits(site, tenant), nil
+}
+
+// PermitsWrite reports whether s covers a write of resource with body refs:
+// a create, or an update of row id that may move it to another site or
+// tenant. The row must be covered before the write and after it.
+func (st *Store) PermitsWrite(ctx context.Context, resource, id string, refs Refs, s Scope) (bool, error) {
+	if s.All {
+		return true, nil
+	}
+	var site, tenant string
+	if id != "" {
+		var err error
+		site, tenant, err = st.row(ctx, resource, id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !s.permits(site, tenant) {
+			return false, nil
+		}
+	}
+	if refs.placed() {
+		if err := st.pool.QueryRow(ctx, `
+			SELECT COALESCE($1::text,
+				(SELECT site_id FROM locations WHERE id = $2),
+				`+viaRack("$3")+`,
+				`+viaDevice("$4")+`, '')`,
+			db.NullIfEmpty(refs.SiteID), refs.LocationID, refs.RackID, refs.DeviceID).Scan(&site); err != nil {
+			return false, err
+		}
+	}
+	if refs.TenantID != "" {
+		tenant = refs.TenantID
+	}
+	return s.permits(site, tenant), nil
+}
+
+// row returns the site and tenant of row id of a site-aware resource.
+func (st *Store) row(ctx context.Context, resource, id string) (site, tenant string, err error) {
+	t, ok := tables[resource]
+	if !ok {
+		return "", "", pgx.ErrNoRows
+	}
+	err = st.pool.QueryRow(ctx, fmt.Sprintf(`SELECT COALESCE(%s, ''), COALESCE(%s, '') FROM %s WHERE id = $1`,
+		expr(t.site, t.name), expr(t.tenant, t.name), t.name), id).Scan(&site, &tenant)
+	return site, tenant, err
+}
+
+// Filter returns the condition, starting with AND, that limits a list of
+// table, named or aliased ref, to the rows the access of ctx may read, with
+// its arguments from placeholder next on. It is empty when no limit applies.
+func Filter(ctx context.Context, tableName, ref string, next int) (string, []interface{}) {
+	a := FromContext(ctx)
+	if a == nil {
+		return "", nil
+	}
+	for resource, t := range tables {
+		if t.name != tableName {
+			continue
+		}
+		s, _ := a.Scope(resource, "read")
+		if s.All {
+			return "", nil
+		}
+		var conds []string
+		var args []interface{}
+		if t.site != "" && len(s.Sites) > 0 {
+			args = append(args, s.Sites)
+			conds = append(conds, fmt.Sprintf("%s = ANY($%d)", expr(t.site, ref), next))
+		}
+		if t.tenant != "" && len(s.Tenants) > 0 {
+			args = append(args, s.Tenants)
+			conds = append(conds, fmt.Sprintf("%s = ANY($%d)", expr(t.tenant, ref), next+len(args)-1))
+		}
+		if len(conds) == 0 {
+			return " AND false", nil
+		}
+		return " AND (" + strings.Join(conds, " OR ") + ")", args
+	}
+	return "", nil
+}
+
+// expr formats a site or tenant expression for ref, or NULL when there is none.
+func expr(e, ref string) string {
+	if e == "" {
+		return "NULL"
+	}
+	return fmt.Sprintf(e, ref)
+}
+
+type accessKey struct{}
+
+// WithAccess returns ctx carrying the access of the request's user.
+func WithAccess(ctx context.Context, a *Access) context.Context {
+	return context.WithValue(ctx, accessKey{}, a)
+}
+
+// FromContext returns the access of ctx, or nil when none was loaded.
+func FromContext(ctx context.Context) *Access {
+	a, _ := ctx.Value(accessKey{}).(*Access)
+	return a
+}
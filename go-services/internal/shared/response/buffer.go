@@ -0,0 +1,32 @@
+package response
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// Buffer holds a handler's response so middleware can inspect or rewrite it
+// before it is sent.
+type Buffer struct {
+	Status int
+	Body   bytes.Buffer
+	header http.Header
+}
+
+// NewBuffer returns an empty Buffer with status 200.
+func NewBuffer() *Buffer {
+	return &Buffer{Status: http.StatusOK, header: http.Header{}}
+}
+
+func (b *Buffer) Header() http.Header         { return b.header }
+func (b *Buffer) Write(p []byte) (int, error) { return b.Body.Write(p) }
+func (b *Buffer) WriteHeader(status int)      { b.Status = status }
+
+// Flush sends the buffered response to w.
+func (b *Buffer) Flush(w http.ResponseWriter) {
+	for k, vs := range b.header {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(b.Status)
+	w.Write(b.Body.Bytes()) //nolint:errcheck
+}
@@ -0,0 +1,168 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// dbErrorWrapper wraps a translated database error with the offending field
+// and constraint. On 422 the issues repeat the field in the shape of ValidationError.
+type dbErrorWrapper struct {
+	Error      string              `json:"error"`
+	Field      string              `json:"field,omitempty"`
+	Constraint string              `json:"constraint,omitempty"`
+	Issues     []map[string]string `json:"issues,omitempty"`
+}
+
+// DBFailure is a database error translated to its HTTP response.
+type DBFailure struct {
+	Status     int
+	Message    string
+	Field      string // camelCase JSON name of the offending column, if known
+	Constraint string
+}
+
+// Issues returns a 422 failure as validation issues, or nil when it is not
+// one or no field is known.
+func (f DBFailure) Issues() []map[string]string {
+	if f.Status != http.StatusUnprocessableEntity || f.Field == "" {
+		return nil
+	}
+	return []map[string]string{{"path": f.Field, "message": f.Message}}
+}
+
+// keyDetail matches the detail of unique and foreign key violations:
+// Key (slug)=(dc-1) already exists.
+// Key (site_id)=(…) is not present in table "sites".
+// Key (id)=(…) is still referenced from table "racks".
+var keyDetail = regexp.MustCompile(`^Key \((.+?)\)=\((.*)\) (.*?)(?: table "(.+)")?\.$`)
+
+// TranslateDB maps a query error on resource to its HTTP response:
+//
+//	no rows                              404 "<resource> not found"
+//	unique violation                     409
+//	foreign key to a missing row         422
+//	row still referenced by another      409
+//	check, not-null, invalid value       422
+//
+// ok is false for any other error.
+func TranslateDB(err error, resource string) (f DBFailure, ok bool) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DBFailure{Status: http.StatusNotFound, Message: resource + " not found"}, true
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return DBFailure{}, false
+	}
+	f = DBFailure{Status: http.StatusUnprocessableEntity, Constraint: pgErr.ConstraintName}
+	cols, value, table := "", "", ""
+	if m := keyDetail.FindStringSubmatch(pgErr.Detail); m != nil {
+		cols, value, table = m[1], m[2], m[4]
+		f.Field = camelColumns(cols)
+	}
+
+	switch {
+	case pgErr.Code == "23505": // unique_violation
+		f.Status = http.StatusConflict
+		if f.Field == "" {
+			f.Message = fmt.Sprintf("%s already exists", resource)
+			break
+		}
+		f.Message = fmt.Sprintf("%s with %s %q already exists", resource, f.Field, value)
+	case pgErr.Code == "23503" && strings.Contains(pgErr.Detail, "still referenced"): // foreign_key_violation on delete
+		f.Status, f.Field = http.StatusConflict, ""
+		f.Message = fmt.Sprintf("%s is still referenced by %s", resource, orDefault(table, "another record"))
+	case pgErr.Code == "23503": // foreign_key_violation on insert or update
+		f.Message = fmt.Sprintf("does not reference an existing %s row", table)
+	case pgErr.Code == "23502": // not_null_violation
+		f.Field = Camel(pgErr.ColumnName)
+		f.Message = "is required"
+		if f.Field == "" {
+			f.Message = "a required field is missing"
+		}
+	case pgErr.Code == "23514": // check_violation
+		f.Field = checkedField(pgErr)
+		f.Message = fmt.Sprintf("violates check constraint %q", pgErr.ConstraintName)
+	case strings.HasPrefix(pgErr.Code, "22"):
+		// Data exceptions: an invalid enum label or uuid, a value too long or out of range.
+		f.Field = Camel(pgErr.ColumnName)
+		f.Message = pgErr.Message
+	default:
+		return DBFailure{}, false
+	}
+	return f, true
+}
+
+// DBError writes the response for an error returned by a query on resource,
+// translated by TranslateDB. Untranslated errors are logged and answered with
+// a generic 500.
+func DBError(w http.ResponseWriter, err error, resource string) {
+	f, ok := TranslateDB(err, resource)
+	if !ok {
+		log.Printf("%s database error: %v", strings.ToLower(resource), err)
+		InternalError(w, "database error")
+		return
+	}
+	if f.Status == http.StatusNotFound {
+		NotFound(w, resource)
+		return
+	}
+	// Field errors read like those of request validation; the rest keep their message.
+	msg := f.Message
+	if f.Status == http.StatusUnprocessableEntity && f.Field != "" {
+		msg = "Validation failed"
+	}
+	JSON(w, dbErrorWrapper{Error: msg, Field: f.Field, Constraint: f.Constraint, Issues: f.Issues()}, f.Status)
+}
+
+// checkedField guesses the column of a check constraint named the Postgres way,
+// <table>_<column>_check.
+func checkedField(e *pgconn.PgError) string {
+	name := strings.TrimSuffix(e.ConstraintName, "_check")
+	if e.TableName == "" || name == e.ConstraintName || !strings.HasPrefix(name, e.TableName+"_") {
+		return ""
+	}
+	return Camel(strings.TrimPrefix(name, e.TableName+"_"))
+}
+
+// camelColumns turns a key column list such as "site_id, name" into JSON names.
+func camelColumns(cols string) string {
+	parts := strings.Split(cols, ", ")
+	for i, p := range parts {
+		parts[i] = Camel(strings.Trim(p, `"`))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Camel turns a snake_case column name into its camelCase JSON name.
+func Camel(s string) string {
+	var b strings.Builder
+	up := false
+	for _, c := range s {
+		if c == '_' {
+			up = true
+			continue
+		}
+		if up {
+			c = unicode.ToUpper(c)
+			up = false
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
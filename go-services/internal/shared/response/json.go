@@ -21,6 +21,12 @@ type validationErrorWrapper struct {
 	Issues []map[string]string `json:"issues,omitempty"`
 }
 
+// conflictWrapper wraps a conflict error with the conflicting resource.
+type conflictWrapper struct {
+	Error    string      `json:"error"`
+	Conflict interface{} `json:"conflict,omitempty"`
+}
+
 // JSON writes a JSON response with the given status code.
 func JSON(w http.ResponseWriter, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -43,6 +49,11 @@ func ValidationError(w http.ResponseWriter, message string, issues []map[string]
 	JSON(w, validationErrorWrapper{Error: message, Issues: issues}, http.StatusUnprocessableEntity)
 }
 
+// Conflict writes a 409 error response, optionally including the conflicting resource.
+func Conflict(w http.ResponseWriter, message string, conflict interface{}) {
+	JSON(w, conflictWrapper{Error: message, Conflict: conflict}, http.StatusConflict)
+}
+
 // Created writes a 201 response with { "data": ... }.
 func Created(w http.ResponseWriter, data interface{}) {
 	Success(w, data, http.StatusCreated)
@@ -0,0 +1,381 @@
+package trash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dcim/go-services/internal/shared/audit"
+	"github.com/dcim/go-services/internal/shared/crud"
+	"github.com/dcim/go-services/internal/shared/response"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Parent is a foreign key that must point at a live row before a child can be restored.
+type Parent struct {
+	Column string // FK column on the resource table
+	Table  string // referenced table
+}
+
+// Resource describes a soft-deletable table exposed through the trash endpoints.
+type Resource struct {
+	Path    string   // URL prefix, e.g. "/racks"
+	Table   string   // e.g. "racks"
+	Name    string   // singular display name for errors, e.g. "Rack"
+	Label   string   // SQL expression naming a row, e.g. "name"
+	Parents []Parent // checked on restore
+	// PurgeFirst holds statements (with $1 = id) that remove rows which only exist
+	// for this record, such as history, so a purge is not blocked by them.
+	PurgeFirst []string
+	// CheckRestore, when set, runs in the restore transaction after the parents
+	// are checked, for rules the row may have stopped meeting while it was
+	// trashed. It reports client errors as *crud.OpError or *crud.ValidationError.
+	CheckRestore func(ctx context.Context, tx pgx.Tx, id string) error
+}
+
+// Config lists the resources of a service. Resources are purged in order, so
+// children should come before their parents.
+type Config struct {
+	Resources []Resource
+	Retention time.Duration
+}
+
+// DefaultRetentionDays applies when TRASH_RETENTION_DAYS is unset.
+const DefaultRetentionDays = 30
+
+// RetentionFromEnv reads TRASH_RETENTION_DAYS.
+func RetentionFromEnv() time.Duration {
+	days := DefaultRetentionDays
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			days = n
+		} else {
+			log.Printf("invalid TRASH_RETENTION_DAYS %q, using %d", v, DefaultRetentionDays)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// RegisterRoutes registers, for every resource:
+//
+//	GET    /prefix/trash          list soft-deleted rows
+//	POST   /prefix/{id}/restore   undo a delete
+//	DELETE /prefix/{id}/purge     permanently delete a trashed row
+//
+// and POST /trash/purge, which purges everything past the retention period.
+func RegisterRoutes(mux *http.ServeMux, wrap func(http.Handler) http.Handler, pool *pgxpool.Pool, cfg Config) {
+	h := &handler{cfg: cfg, pool: pool}
+	for _, res := range cfg.Resources {
+		mux.Handle("GET "+res.Path+"/trash", wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { h.list(w, r, res) })))
+		mux.Handle("POST "+res.Path+"/{id}/restore", wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { h.restore(w, r, res) })))
+		mux.Handle("DELETE "+res.Path+"/{id}/purge", wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { h.purge(w, r, res) })))
+	}
+	mux.Handle("POST /trash/purge", wrap(http.HandlerFunc(h.purgeExpired)))
+}
+
+// StartPurger purges expired rows every interval until ctx is done.
+func StartPurger(ctx context.Context, pool *pgxpool.Pool, cfg Config, interval time.Duration) {
+	h := &handler{cfg: cfg, pool: pool}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if res, err := h.purgeOlderThan(ctx, cfg.Retention); err != nil {
+					log.Printf("trash purge error: %v", err)
+				} else if res.Purged > 0 || res.Skipped > 0 {
+					log.Printf("trash purge: %d purged, %d skipped", res.Purged, res.Skipped)
+				}
+			}
+		}
+	}()
+}
+
+type handler struct {
+	cfg  Config
+	pool *pgxpool.Pool
+}
+
+type trashItem struct {
+	ID         string          `json:"id"`
+	Label      *string         `json:"label"`
+	DeletedAt  string          `json:"deletedAt"`
+	PurgeAfter string          `json:"purgeAfter"`
+	Record     json.RawMessage `json:"record"`
+}
+
+// list handles GET /prefix/trash?limit=&cursor=&deletedAt__gte=
+// page= still selects an offset page when no cursor is given.
+func (h *handler) list(w http.ResponseWriter, r *http.Request, res Resource) {
+	q, err := crud.ParseQuery(r, crud.Config{
+		Table:    res.Table,
+		OrderBy:  "t.deleted_at DESC",
+		PageSize: crud.DefaultLimit,
+		Filters:  []crud.FilterDef{{QueryParam: "deletedAt", Column: "t.deleted_at", Type: "timestamp"}},
+	}, 1)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	from := fmt.Sprintf(` FROM %s t WHERE t.deleted_at IS NOT NULL`, res.Table) + q.Where
+	if !crud.Total(w, r, h.pool, q, from, q.Args) {
+		return
+	}
+	offset := 0
+	if r.URL.Query().Get("cursor") == "" {
+		offset = crud.ParsePagination(r).Offset
+	}
+	seek, args := q.Seek(q.Args)
+	rows, err := h.pool.Query(r.Context(), fmt.Sprintf(`SELECT t.id, (%s)::text, t.deleted_at, row_to_json(t)%s%s ORDER BY %s%s OFFSET %d`,
+		res.Label, from, seek, q.OrderBy, q.LimitClause(), offset), args...)
+	if err != nil {
+		log.Printf("trash list error [%s]: %v", res.Table, err)
+		response.InternalError(w, "database error")
+		return
+	}
+	defer rows.Close()
+	results := []trashItem{}
+	for rows.Next() {
+		var it trashItem
+		var deletedAt time.Time
+		if err := rows.Scan(&it.ID, &it.Label, &deletedAt, &it.Record); err != nil {
+			log.Printf("trash scan error [%s]: %v", res.Table, err)
+			continue
+		}
+		it.DeletedAt = deletedAt.UTC().Format(time.RFC3339)
+		it.PurgeAfter = deletedAt.Add(h.cfg.Retention).UTC().Format(time.RFC3339)
+		results = append(results, it)
+	}
+	crud.List(w, r, h.pool, q, results)
+}
+
+type parentConflict struct {
+	Table string `json:"table"`
+	ID    string `json:"id"`
+}
+
+// restore handles POST /prefix/{id}/restore
+func (h *handler) restore(w http.ResponseWriter, r *http.Request, res Resource) {
+	id := r.PathValue("id")
+	ctx := r.Context()
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		response.InternalError(w, "database error")
+		return
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if _, err := lockTrashed(ctx, tx, res, id); errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, res.Name)
+		return
+	} else if err != nil {
+		log.Printf("trash restore error [%s]: %v", res.Table, err)
+		response.InternalError(w, "database error")
+		return
+	}
+
+	for _, p := range res.Parents {
+		var parentID string
+		var live bool
+		err := tx.QueryRow(ctx, fmt.Sprintf(`
+			SELECT c.%[1]s, COALESCE(p.deleted_at IS NULL, false)
+			FROM %[2]s c
+			LEFT JOIN %[3]s p ON p.id = c.%[1]s
+			WHERE c.id = $1 AND c.%[1]s IS NOT NULL`, p.Column, res.Table, p.Table), id).Scan(&parentID, &live)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		} else if err != nil {
+			log.Printf("trash restore parent check error [%s]: %v", res.Table, err)
+			response.InternalError(w, "database error")
+			return
+		}
+		if !live {
+			response.Conflict(w, fmt.Sprintf("%s %s is deleted; restore it first", p.Table, parentID),
+				parentConflict{Table: p.Table, ID: parentID})
+			return
+		}
+	}
+
+	if res.CheckRestore != nil {
+		if err := res.CheckRestore(ctx, tx, id); err != nil {
+			writeCheckError(w, res, err)
+			return
+		}
+	}
+
+	var after json.RawMessage
+	err = tx.QueryRow(ctx, fmt.Sprintf(`
+		UPDATE %[1]s t SET deleted_at = NULL, updated_at = $2
+		WHERE t.id = $1
+		RETURNING row_to_json(t)`, res.Table), id, time.Now().UTC()).Scan(&after)
+	if err != nil {
+		writeRestoreError(w, res, err)
+		return
+	}
+	if err := audit.LogEntry(ctx, tx, "restore", res.Table, id, nil, after); err != nil {
+		log.Printf("trash restore audit error [%s]: %v", res.Table, err)
+		response.InternalError(w, "audit log failed")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		response.InternalError(w, "commit failed")
+		return
+	}
+	response.OK(w, after)
+}
+
+// writeRestoreError reports a failed restore UPDATE. Constraint violations,
+// such as a live row that took the trashed row's unique name, are answered
+// like those of a write, e.g. 409 for a unique violation.
+func writeRestoreError(w http.ResponseWriter, res Resource, err error) {
+	if _, ok := response.TranslateDB(err, res.Name); ok {
+		response.DBError(w, err, res.Name)
+		return
+	}
+	log.Printf("trash restore error [%s]: %v", res.Table, err)
+	response.InternalError(w, "restore failed")
+}
+
+// writeCheckError reports an error of Resource.CheckRestore.
+func writeCheckError(w http.ResponseWriter, res Resource, err error) {
+	var oe *crud.OpError
+	var ve *crud.ValidationError
+	switch {
+	case errors.As(err, &ve):
+		response.ValidationError(w, "Validation failed", ve.Issues)
+	case errors.As(err, &oe) && oe.Status == http.StatusConflict:
+		response.Conflict(w, oe.Msg, oe.Conflict)
+	case errors.As(err, &oe):
+		response.Error(w, oe.Msg, oe.Status)
+	default:
+		log.Printf("trash restore check error [%s]: %v", res.Table, err)
+		response.InternalError(w, "database error")
+	}
+}
+
+// purge handles DELETE /prefix/{id}/purge
+func (h *handler) purge(w http.ResponseWriter, r *http.Request, res Resource) {
+	id := r.PathValue("id")
+	err := h.purgeOne(r.Context(), res, id)
+	var pgErr *pgconn.PgError
+	switch {
+	case err == nil:
+		response.Message(w, res.Name+" purged", http.StatusOK)
+	case errors.Is(err, pgx.ErrNoRows):
+		response.NotFound(w, res.Name)
+	case errors.As(err, &pgErr) && pgErr.Code == "23503":
+		response.Conflict(w, fmt.Sprintf("%s is still referenced by %s", res.Name, referencingTable(pgErr)), nil)
+	default:
+		log.Printf("trash purge error [%s]: %v", res.Table, err)
+		response.InternalError(w, "purge failed")
+	}
+}
+
+// purgeOne hard-deletes a trashed row and records it in the audit log.
+func (h *handler) purgeOne(ctx context.Context, res Resource, id string) error {
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	before, err := lockTrashed(ctx, tx, res, id)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range res.PurgeFirst {
+		if _, err := tx.Exec(ctx, stmt, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, res.Table), id); err != nil {
+		return err
+	}
+	if err := audit.LogEntry(ctx, tx, "purge", res.Table, id, before, nil); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+type purgeResult struct {
+	Purged  int            `json:"purged"`
+	Skipped int            `json:"skipped"`
+	ByTable map[string]int `json:"byTable"`
+}
+
+// purgeExpired handles POST /trash/purge?olderThanDays=
+// Rows still referenced by other rows are skipped and retried on the next run.
+func (h *handler) purgeExpired(w http.ResponseWriter, r *http.Request) {
+	retention := h.cfg.Retention
+	if v := r.URL.Query().Get("olderThanDays"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			response.BadRequest(w, "olderThanDays must be a non-negative integer")
+			return
+		}
+		retention = time.Duration(n) * 24 * time.Hour
+	}
+	res, err := h.purgeOlderThan(r.Context(), retention)
+	if err != nil {
+		log.Printf("trash purge error: %v", err)
+		response.InternalError(w, "purge failed")
+		return
+	}
+	response.OK(w, res)
+}
+
+func (h *handler) purgeOlderThan(ctx context.Context, retention time.Duration) (purgeResult, error) {
+	out := purgeResult{ByTable: map[string]int{}}
+	cutoff := time.Now().UTC().Add(-retention)
+	for _, res := range h.cfg.Resources {
+		rows, err := h.pool.Query(ctx, fmt.Sprintf(`SELECT id FROM %s WHERE deleted_at < $1 ORDER BY deleted_at`, res.Table), cutoff)
+		if err != nil {
+			return out, err
+		}
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return out, err
+		}
+		for _, id := range ids {
+			var pgErr *pgconn.PgError
+			if err := h.purgeOne(ctx, res, id); errors.As(err, &pgErr) && pgErr.Code == "23503" {
+				out.Skipped++
+				continue
+			} else if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return out, err
+			} else if err == nil {
+				out.Purged++
+				out.ByTable[res.Table]++
+			}
+		}
+	}
+	return out, nil
+}
+
+// lockTrashed locks a soft-deleted row and returns it as JSON.
+func lockTrashed(ctx context.Context, tx pgx.Tx, res Resource, id string) (json.RawMessage, error) {
+	var row json.RawMessage
+	err := tx.QueryRow(ctx, fmt.Sprintf(`
+		SELECT row_to_json(t) FROM %s t
+		WHERE t.id = $1 AND t.deleted_at IS NOT NULL
+		FOR UPDATE`, res.Table), id).Scan(&row)
+	return row, err
+}
+
+func referencingTable(e *pgconn.PgError) string {
+	if e.TableName != "" {
+		return e.TableName
+	}
+	return "another record"
+}
@@ -0,0 +1,45 @@
+package trash
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestWriteRestoreError(t *testing.T) {
+	racks := Resource{Table: "racks", Name: "Rack"}
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		msg    string
+	}{
+		{"name taken while trashed", &pgconn.PgError{Code: "23505", ConstraintName: "racks_location_id_name_key",
+			Detail: "Key (location_id, name)=(l1, A1) already exists."},
+			http.StatusConflict, `Rack with locationId, name "l1, A1" already exists`},
+		{"unique violation without detail", &pgconn.PgError{Code: "23505"}, http.StatusConflict, "Rack already exists"},
+		{"other error", errors.New("connection reset"), http.StatusInternalServerError, "restore failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeRestoreError(w, racks, tt.err)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error != tt.msg {
+				t.Errorf("error = %q, want %q", body.Error, tt.msg)
+			}
+		})
+	}
+}
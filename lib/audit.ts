@@ -1,5 +1,84 @@
+import { createHash } from "node:crypto";
+import { sql } from "drizzle-orm";
 import { db } from "@/db";
-import { auditLogs } from "@/db/schema/audit";
+
+// Advisory lock key serializing appends to the audit hash chain; the same key
+// as chainLock in go-services/internal/shared/audit.
+const CHAIN_LOCK = 0x617564697463;
+
+type AuditEntry = {
+    userId: string | null;
+    action: string;
+    actionType: string;
+    tableName: string;
+    recordId: string;
+    before?: Record<string, unknown> | null;
+    after?: Record<string, unknown> | null;
+    reason?: string | null;
+    ipAddress?: string | null;
+    userAgent?: string | null;
+};
+
+// Go's encoding/json escapes these characters inside strings; the hash has to
+// be computed over the same bytes as audit.Link.sum.
+function goJSON(value: unknown): string {
+    return JSON.stringify(value).replace(
+        /[<>&\u2028\u2029]/g,
+        (c) => "\\u" + c.charCodeAt(0).toString(16).padStart(4, "0"),
+    );
+}
+
+// Appends an entry to the audit hash chain, as audit.LogEntry does: under the
+// chain lock, with the next chain_seq and a hash over the entry as Postgres
+// stores it and the hash of the entry before.
+async function appendEntry(e: AuditEntry) {
+    const before = e.before ? JSON.stringify(e.before) : null;
+    const after = e.after ? JSON.stringify(e.after) : null;
+    const reason = e.reason ?? null;
+    const ipAddress = e.ipAddress ?? null;
+    const userAgent = e.userAgent ?? null;
+
+    await db.transaction(async (tx) => {
+        await tx.execute(sql`SELECT pg_advisory_xact_lock(${CHAIN_LOCK}::bigint)`);
+        const [prev] = await tx.execute<{ seq: string; hash: string }>(sql`
+            SELECT chain_seq::text AS seq, hash FROM audit_logs
+            WHERE chain_seq IS NOT NULL ORDER BY chain_seq DESC LIMIT 1`);
+        const seq = prev ? Number(prev.seq) + 1 : 1;
+        const prevHash = prev ? prev.hash : null;
+
+        // jsonb normalizes the changes, and the user ID is NULL when it names no user.
+        const [row] = await tx.execute<{
+            id: string;
+            user_id: string | null;
+            changes_before: string | null;
+            changes_after: string | null;
+            created_at: string;
+        }>(sql`
+            SELECT gen_random_uuid()::text AS id,
+                   (SELECT id FROM users WHERE id = ${e.userId}::text) AS user_id,
+                   ${before}::jsonb::text AS changes_before,
+                   ${after}::jsonb::text AS changes_after,
+                   to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US') AS created_at`);
+        // RFC 3339 with trailing zeros of the fraction dropped, as Go formats it.
+        const createdAt = row.created_at.replace(/\.?0+$/, "") + "Z";
+
+        const hash = createHash("sha256")
+            .update(
+                goJSON([
+                    seq, prevHash, row.id, row.user_id, e.action, e.actionType, e.tableName, e.recordId,
+                    row.changes_before, row.changes_after, reason, ipAddress, userAgent, createdAt,
+                ]),
+            )
+            .digest("hex");
+
+        await tx.execute(sql`
+            INSERT INTO audit_logs (id, user_id, action, action_type, table_name, record_id, changes_before, changes_after,
+                                    reason, ip_address, user_agent, created_at, chain_seq, prev_hash, hash)
+            VALUES (${row.id}, ${row.user_id}, ${e.action}, ${e.actionType}::audit_action_type, ${e.tableName}, ${e.recordId},
+                    ${row.changes_before}::jsonb, ${row.changes_after}::jsonb, ${reason}, ${ipAddress}, ${userAgent},
+                    ${createdAt}::timestamptz, ${seq}, ${prevHash}, ${hash})`);
+    });
+}
 
 export async function logAudit(
     userId: string | null,
@@ -10,15 +89,15 @@ export async function logAudit(
     after?: Record<string, unknown> | null,
     reason?: string,
 ) {
-    await db.insert(auditLogs).values({
+    await appendEntry({
         userId,
         action,
         actionType: "api_call",
         tableName,
         recordId,
-        changesBefore: before ?? null,
-        changesAfter: after ?? null,
-        reason: reason ?? null,
+        before,
+        after,
+        reason,
     });
 }
 
@@ -28,14 +107,14 @@ export async function logLoginEvent(
     ipAddress?: string,
     userAgent?: string,
 ) {
-    await db.insert(auditLogs).values({
+    await appendEntry({
         userId,
         action: success ? "login_success" : "login_failed",
         actionType: "login",
         tableName: "users",
         recordId: userId ?? "unknown",
-        ipAddress: ipAddress ?? null,
-        userAgent: userAgent ?? null,
+        ipAddress,
+        userAgent,
     });
 }
 
@@ -44,12 +123,12 @@ export async function logExportEvent(
     exportType: string,
     filters?: Record<string, unknown>,
 ) {
-    await db.insert(auditLogs).values({
+    await appendEntry({
         userId,
         action: "export",
         actionType: "export",
         tableName: exportType,
         recordId: "bulk",
-        changesAfter: filters ?? null,
+        after: filters,
     });
 }
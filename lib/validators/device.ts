@@ -5,7 +5,7 @@ export const deviceCreateSchema = z.object({
     deviceTypeId: z.string().min(1, "Device type is required"),
     rackId: z.string().nullable().optional(),
     tenantId: z.string().nullable().optional(),
-    status: z.enum(["active", "planned", "staged", "failed", "decommissioning", "decommissioned"]).optional(),
+    status: z.enum(["active", "planned", "staged", "failed", "decommissioning", "decommissioned", "offline", "retired"]).optional(),
     face: z.enum(["front", "rear"]).optional(),
     position: z.number().int().min(1).nullable().optional(),
     serialNumber: z.string().nullable().optional(),
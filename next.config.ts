@@ -27,7 +27,11 @@ const nextConfig: NextConfig = {
             { source: "/api/manufacturers", destination: `${coreApiUrl}/manufacturers` },
             { source: "/api/tenants/:path*", destination: `${coreApiUrl}/tenants/:path*` },
             { source: "/api/tenants", destination: `${coreApiUrl}/tenants` },
+            { source: "/api/import/devices", destination: `${coreApiUrl}/import/devices` },
             { source: "/api/dashboard/:path*", destination: `${coreApiUrl}/dashboard/:path*` },
+            { source: "/api/custom-fields/:path*", destination: `${coreApiUrl}/custom-fields/:path*` },
+            { source: "/api/custom-fields", destination: `${coreApiUrl}/custom-fields` },
+            { source: "/api/events", destination: `${coreApiUrl}/events` },
             // Power Service
             { source: "/api/power/readings", destination: `${powerServiceUrl}/readings` },
             { source: "/api/power/sse", destination: `${powerServiceUrl}/sse` },
@@ -55,8 +59,17 @@ const nextConfig: NextConfig = {
             { source: "/api/equipment-movements", destination: `${netopsUrl}/equipment-movements` },
             { source: "/api/alerts/:path*", destination: `${netopsUrl}/alerts/:path*` },
             { source: "/api/reports/:path*", destination: `${netopsUrl}/reports/:path*` },
+            { source: "/api/audit-logs/:path*", destination: `${netopsUrl}/audit-logs/:path*` },
             { source: "/api/audit-logs", destination: `${netopsUrl}/audit-logs` },
             { source: "/api/import/:path*", destination: `${netopsUrl}/import/:path*` },
+            { source: "/api/webhooks/:path*", destination: `${netopsUrl}/webhooks/:path*` },
+            { source: "/api/webhooks", destination: `${netopsUrl}/webhooks` },
+            { source: "/api/api-tokens/:path*", destination: `${netopsUrl}/api-tokens/:path*` },
+            { source: "/api/api-tokens", destination: `${netopsUrl}/api-tokens` },
+            { source: "/api/roles/:path*", destination: `${netopsUrl}/roles/:path*` },
+            { source: "/api/roles", destination: `${netopsUrl}/roles` },
+            { source: "/api/role-assignments/:path*", destination: `${netopsUrl}/role-assignments/:path*` },
+            { source: "/api/role-assignments", destination: `${netopsUrl}/role-assignments` },
         ];
         return { fallback: routes };
     },
@@ -1,6 +1,16 @@
 import { NextResponse } from "next/server";
 import { auth } from "@/auth";
 
+// Reverse proxies in front of the app, each appending the address it saw to
+// X-Forwarded-For. The client's address is the entry the outermost of them
+// appended; anything before it was sent by the client and is not trusted.
+const trustedProxyHops = Math.max(1, Number(process.env.TRUSTED_PROXY_HOPS) || 1);
+
+function clientIp(headers: Headers): string {
+    const hops = (headers.get("x-forwarded-for") ?? "").split(",").map((h) => h.trim()).filter(Boolean);
+    return hops[hops.length - trustedProxyHops] ?? hops[0] ?? headers.get("x-real-ip") ?? "";
+}
+
 export default auth((req) => {
     const isLoggedIn = !!req.auth;
     const { pathname } = req.nextUrl;
@@ -24,15 +34,20 @@ export default auth((req) => {
     const goServicePaths = [
         "/api/sites", "/api/regions", "/api/locations", "/api/racks",
         "/api/devices", "/api/device-types", "/api/manufacturers", "/api/tenants",
-        "/api/dashboard",
+        "/api/custom-fields", "/api/dashboard", "/api/events",
         "/api/power", "/api/export",
         "/api/cables", "/api/interfaces", "/api/console-ports", "/api/front-ports",
         "/api/rear-ports", "/api/access-logs", "/api/equipment-movements",
-        "/api/alerts", "/api/reports", "/api/audit-logs", "/api/import",
+        "/api/alerts", "/api/reports", "/api/audit-logs", "/api/import", "/api/webhooks",
+        "/api/api-tokens", "/api/roles", "/api/role-assignments",
     ];
     if (isLoggedIn && goServicePaths.some((p) => pathname.startsWith(p))) {
         const headers = new Headers(req.headers);
         headers.set("x-internal-secret", process.env.X_INTERNAL_SECRET ?? "");
+        // Identity for the Go services' audit log; never trust client-sent values.
+        headers.set("x-user-id", req.auth?.user?.id ?? "");
+        headers.set("x-user-ip", clientIp(req.headers));
+        headers.set("x-user-agent", req.headers.get("user-agent") ?? "");
         return NextResponse.next({ request: { headers } });
     }
 
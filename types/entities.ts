@@ -134,5 +134,14 @@ export interface SiteSummary {
     siteSlug: string;
     rackCount: number;
     deviceCount: number;
+    totalU: number;
+    usedU: number;
+    uOccupancyPercent: number;
+    ratedKw: number;
+    measuredKw: number;
     powerUtilizationPercent: number;
+    openAlerts: number;
+    criticalAlerts: number;
+    activeVisitors: number;
+    pendingMovements: number;
 }